@@ -0,0 +1,26 @@
+// Command backfill-ratings recomputes the rating, review count and sub-ratings
+// of every accommodation from its reviews. Run it once after deploying rating
+// aggregation, or any time the stored aggregates are suspected to be off.
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"roam.io/db"
+	"roam.io/routes"
+)
+
+func main() {
+	gormDb, err := db.Connect()
+	if err != nil {
+		log.Fatal("Failed to connect to the database:", err)
+	}
+	db.MigrateDB(gormDb)
+
+	updated, err := routes.RecalculateAllRatings(gormDb)
+	if err != nil {
+		log.Fatalf("Backfill stopped after %d accommodations: %v", updated, err)
+	}
+	fmt.Printf("Recalculated ratings for %d accommodations\n", updated)
+}
@@ -0,0 +1,59 @@
+// Package config reads the server's settings from environment variables
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds the settings the server needs to start
+type Config struct {
+	PaymentGateway       string // PAYMENT_GATEWAY: the provider charging bookings, defaults to the built in "fake" gateway
+	PaymentWebhookSecret string // PAYMENT_WEBHOOK_SECRET: optional, signs the provider's webhooks
+	PaymentsRequired     bool   // PAYMENTS_REQUIRED: reject bookings without a payment token, off until the web client sends them
+	APIBaseURL           string // API_BASE_URL: where browsers reach the API, e.g. "https://api.roam.io"; used in image links
+	WebBaseURL           string // WEB_BASE_URL: where the web app is served, e.g. "https://roam.io"; used for its preset avatars
+	ReviewWordListFile   string // REVIEW_WORDLIST_FILE: optional file of words that hold a review for moderation
+}
+
+// Load reads the config from the environment, falling back to local defaults for unset settings
+func Load() (*Config, error) {
+	setting := func(name, fallback string) string {
+		if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+			return value
+		}
+		return fallback
+	}
+	var invalid, invalidBools []string
+	baseURL := func(name, fallback string) string {
+		value := strings.TrimSuffix(setting(name, fallback), "/")
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid = append(invalid, name)
+		}
+		return value
+	}
+
+	paymentsRequired, err := strconv.ParseBool(setting("PAYMENTS_REQUIRED", "false"))
+	if err != nil {
+		invalidBools = append(invalidBools, "PAYMENTS_REQUIRED")
+	}
+
+	cfg := Config{
+		PaymentGateway:       setting("PAYMENT_GATEWAY", "fake"),
+		PaymentWebhookSecret: setting("PAYMENT_WEBHOOK_SECRET", ""),
+		PaymentsRequired:     paymentsRequired,
+		APIBaseURL:           baseURL("API_BASE_URL", "http://localhost:8080"),
+		WebBaseURL:           baseURL("WEB_BASE_URL", "http://localhost:5173"),
+		ReviewWordListFile:   setting("REVIEW_WORDLIST_FILE", ""),
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("settings must be absolute http(s) URLs: %s", strings.Join(invalid, ", "))
+	}
+	if len(invalidBools) > 0 {
+		return nil, fmt.Errorf("settings must be true or false: %s", strings.Join(invalidBools, ", "))
+	}
+	return &cfg, nil
+}
@@ -12,7 +12,8 @@ import (
 // Connect establishes and returns a database connection
 func Connect() (*gorm.DB, error) {
 	dsn := "host=host.docker.internal user=postgres password=postgres dbname=mydb port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError turns constraint violations into gorm.ErrDuplicatedKey and gorm.ErrForeignKeyViolated
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		panic("Failed to connect to database!")
 	}
@@ -21,7 +22,7 @@ func Connect() (*gorm.DB, error) {
 }
 
 func MigrateDB(db *gorm.DB) {
-	err := db.AutoMigrate(&models.User{}, &models.Accommodation{}, &models.Booking{}, models.Owner{}, models.Event{}, models.Organizer{}, models.EventBooking{}, models.Review{})
+	err := db.AutoMigrate(&models.User{}, &models.Accommodation{}, &models.Booking{}, models.Owner{}, models.Event{}, models.Organizer{}, models.EventBooking{}, models.Review{}, models.Notification{}, models.PaymentIntent{}, models.ReviewRevision{}, models.ReviewResponse{}, models.ReviewReport{}, models.ReviewVote{}, models.Media{}, models.Trip{}, models.TripMember{}, models.CalendarSource{}, models.BlockedDateRange{}, models.RoomType{}, models.TicketTier{}, models.Seat{}, schemaMigration{})
 	if err != nil {
 		panic("Failed to migrate database")
 	}
@@ -42,5 +43,27 @@ func MigrateDB(db *gorm.DB) {
 		}
 	}
 
+	// Rows created before statuses existed are active events and confirmed bookings
+	if err := db.Exec("UPDATE events SET status = ? WHERE status IS NULL OR status = ''", models.EventStatusActive).Error; err != nil {
+		fmt.Println("Error backfilling event status:", err)
+	}
+	if err := db.Exec("UPDATE bookings SET status = ? WHERE status IS NULL OR status = ''", models.BookingStatusConfirmed).Error; err != nil {
+		fmt.Println("Error backfilling booking status:", err)
+	}
+	if err := db.Exec("UPDATE event_bookings SET status = ? WHERE status IS NULL OR status = ''", models.BookingStatusConfirmed).Error; err != nil {
+		fmt.Println("Error backfilling event booking status:", err)
+	}
+
+	// Reviews posted before moderation existed were already live
+	if err := db.Exec("UPDATE reviews SET moderation_status = ? WHERE moderation_status IS NULL OR moderation_status = ''", models.ReviewStatusApproved).Error; err != nil {
+		fmt.Println("Error backfilling review moderation status:", err)
+	}
+
+	for _, m := range migrations {
+		if err := runOnce(db, m.version, m.migrate); err != nil {
+			fmt.Printf("Error applying migration %s: %v\n", m.version, err)
+		}
+	}
+
 	fmt.Println("Database migrated successfully")
 }
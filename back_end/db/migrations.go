@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// schemaMigration records a one-time data migration that has been applied
+type schemaMigration struct {
+	Version   string `gorm:"primaryKey;size:100"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// migrations run once each, in order, after AutoMigrate. Append new ones; never edit or reorder applied ones.
+var migrations = []struct {
+	version string
+	migrate func(tx *gorm.DB) error
+}{
+	// Birth dates used to be stored as sent, so a date picked at local midnight east of UTC landed on the evening
+	// before. Read at UTC+14, the latest offset in use, the stored instant falls on the date as written for every
+	// offset from -09:59 to +14:00. Rows already at midnight UTC were written as plain dates and are left alone.
+	{"20261019_normalize_user_dob", func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE users SET dob = ((dob AT TIME ZONE INTERVAL '+14:00')::date)::timestamp AT TIME ZONE 'UTC' WHERE (dob AT TIME ZONE 'UTC')::time <> '00:00'").Error
+	}},
+}
+
+// runOnce applies migrate and records version in the same transaction, unless version is already recorded.
+// A concurrent startup waits on the other's insert and then skips.
+func runOnce(db *gorm.DB, version string, migrate func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&schemaMigration{Version: version, AppliedAt: time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return migrate(tx)
+	})
+}
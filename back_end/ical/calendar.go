@@ -0,0 +1,156 @@
+// Package ical writes and reads iCalendar (RFC 5545) files, for sharing bookings with calendar apps and other platforms.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID identifies Roam.io as the writer of a calendar
+const ProdID = "-//Roam.io//Bookings//EN"
+
+// ContentType is the MIME type of an .ics file
+const ContentType = "text/calendar; charset=utf-8"
+
+// Event statuses
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// maxLineLength is the longest a content line may be, in octets, before it is folded
+const maxLineLength = 75
+
+// Calendar is a set of events
+type Calendar struct {
+	Name   string // shown by calendar apps for subscribed calendars
+	Events []Event
+}
+
+// Event is a VEVENT
+type Event struct {
+	UID         string // stays the same across exports so apps update their copy
+	Summary     string
+	Description string
+	Location    string
+	Geo         *Geo
+	Start       time.Time
+	End         time.Time
+	// AllDay events span whole days; End is the day after the last day, as RFC 5545 expects
+	AllDay bool
+	// Floating times are wall clock times with no time zone, shown as written wherever the calendar is
+	Floating     bool
+	Status       string
+	Sequence     int       // incremented each time the event changes
+	LastModified time.Time // when the event last changed, left out if zero
+	Stamp        time.Time // when this copy of the event was written
+}
+
+// Geo is a latitude and longitude in degrees
+type Geo struct {
+	Lat float64
+	Lon float64
+}
+
+// ParseGeo reads coordinates written as "lat, lon"
+func ParseGeo(coordinates string) (*Geo, bool) {
+	lat, lon, found := strings.Cut(coordinates, ",")
+	if !found {
+		return nil, false
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, false
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, false
+	}
+	return &Geo{Lat: latitude, Lon: longitude}, true
+}
+
+// Marshal writes the calendar as an .ics file
+func Marshal(cal Calendar) []byte {
+	var buf bytes.Buffer
+	line := func(name, value string) { writeLine(&buf, name+":"+value) }
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if cal.Name != "" {
+		line("X-WR-CALNAME", escapeText(cal.Name))
+	}
+	for _, event := range cal.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escapeText(event.UID))
+		line("DTSTAMP", formatUTC(event.Stamp))
+		if event.AllDay {
+			line("DTSTART;VALUE=DATE", event.Start.Format("20060102"))
+			line("DTEND;VALUE=DATE", event.End.Format("20060102"))
+		} else if event.Floating {
+			line("DTSTART", event.Start.Format("20060102T150405"))
+			line("DTEND", event.End.Format("20060102T150405"))
+		} else {
+			line("DTSTART", formatUTC(event.Start))
+			line("DTEND", formatUTC(event.End))
+		}
+		line("SUMMARY", escapeText(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", escapeText(event.Description))
+		}
+		if event.Location != "" {
+			line("LOCATION", escapeText(event.Location))
+		}
+		if event.Geo != nil {
+			line("GEO", fmt.Sprintf("%s;%s", formatDegrees(event.Geo.Lat), formatDegrees(event.Geo.Lon)))
+		}
+		if event.Status != "" {
+			line("STATUS", event.Status)
+		}
+		line("SEQUENCE", strconv.Itoa(event.Sequence))
+		if !event.LastModified.IsZero() {
+			line("LAST-MODIFIED", formatUTC(event.LastModified))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+func formatUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func formatDegrees(degrees float64) string {
+	return strconv.FormatFloat(degrees, 'f', 6, 64)
+}
+
+// escapeText escapes the characters that are special in TEXT values
+func escapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(text)
+}
+
+// writeLine writes a content line, folding it onto continuation lines that start with a space
+// so no line is longer than 75 octets. Lines are only split between characters.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		limit = maxLineLength - 1 // the leading space counts towards the limit
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,215 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCalendar is returned for files that aren't iCalendar data or have events that can't be read
+var ErrInvalidCalendar = errors.New("invalid iCalendar file")
+
+// property is one unfolded content line, such as DTSTART;VALUE=DATE:20250601
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the events of an iCalendar file. Times with a TZID are read in that time zone if it's known,
+// and times without one are read as floating times. Components nested in events, such as alarms, are skipped.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("%w: missing BEGIN:VCALENDAR", ErrInvalidCalendar)
+	}
+
+	events := []Event{}
+	var event *Event
+	var hasEnd bool
+	var duration time.Duration
+	nested := 0 // depth of components inside the current event
+	for _, line := range lines {
+		prop, ok := parseProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT") && event == nil:
+			event, hasEnd, duration = &Event{}, false, 0
+		case event == nil:
+		case prop.name == "BEGIN":
+			nested++
+		case prop.name == "END" && nested > 0:
+			nested--
+		case nested > 0:
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("%w: event %q has no DTSTART", ErrInvalidCalendar, event.UID)
+			}
+			if !hasEnd {
+				// RFC 5545 3.6.1: without an end, a date lasts the day and a date-time is an instant
+				event.End = event.Start.Add(duration)
+				if event.AllDay && duration == 0 {
+					event.End = event.Start.AddDate(0, 0, 1)
+				}
+			}
+			events = append(events, *event)
+			event = nil
+		default:
+			if err := setEventProperty(event, prop, &hasEnd, &duration); err != nil {
+				return nil, fmt.Errorf("%w: event %q: %v", ErrInvalidCalendar, event.UID, err)
+			}
+		}
+	}
+	return events, nil
+}
+
+// setEventProperty copies a property of a VEVENT onto the event
+func setEventProperty(event *Event, prop property, hasEnd *bool, duration *time.Duration) error {
+	var err error
+	switch prop.name {
+	case "UID":
+		event.UID = unescapeText(prop.value)
+	case "SUMMARY":
+		event.Summary = unescapeText(prop.value)
+	case "DESCRIPTION":
+		event.Description = unescapeText(prop.value)
+	case "LOCATION":
+		event.Location = unescapeText(prop.value)
+	case "STATUS":
+		event.Status = strings.ToUpper(prop.value)
+	case "SEQUENCE":
+		event.Sequence, _ = strconv.Atoi(prop.value)
+	case "DTSTAMP":
+		event.Stamp, _, _, _ = parseTime(prop)
+	case "LAST-MODIFIED":
+		event.LastModified, _, _, _ = parseTime(prop)
+	case "GEO":
+		if lat, lon, found := strings.Cut(prop.value, ";"); found {
+			event.Geo, _ = ParseGeo(lat + "," + lon)
+		}
+	case "DTSTART":
+		event.Start, event.AllDay, event.Floating, err = parseTime(prop)
+		if err != nil {
+			return fmt.Errorf("DTSTART: %v", err)
+		}
+	case "DTEND":
+		event.End, _, _, err = parseTime(prop)
+		if err != nil {
+			return fmt.Errorf("DTEND: %v", err)
+		}
+		*hasEnd = true
+	case "DURATION":
+		*duration, err = parseDuration(prop.value)
+		if err != nil {
+			return fmt.Errorf("DURATION: %v", err)
+		}
+	}
+	return nil
+}
+
+// unfold reads the content lines of the file, joining folded lines back together.
+// Bare LF line endings are accepted as well as CRLF.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalendar, err)
+	}
+	return lines, nil
+}
+
+// parseProperty splits a content line into its name, parameters and value.
+// Colons and semicolons inside quoted parameter values don't count as separators.
+func parseProperty(line string) (property, bool) {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ';', ':':
+			if quoted {
+				continue
+			}
+			parts = append(parts, line[start:i])
+			start = i + 1
+			if line[i] == ':' {
+				prop := property{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[start:]}
+				for _, param := range parts[1:] {
+					key, value, _ := strings.Cut(param, "=")
+					prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+				}
+				return prop, true
+			}
+		}
+	}
+	return property{}, false
+}
+
+// parseTime reads a DATE or DATE-TIME value, reporting whether it was a date and whether it was floating
+func parseTime(prop property) (t time.Time, allDay, floating bool, err error) {
+	value := prop.value
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len("20060102") {
+		t, err = time.Parse("20060102", value)
+		return t, true, false, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, false, err
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if location, loadErr := time.LoadLocation(tzid); loadErr == nil {
+			t, err = time.ParseInLocation("20060102T150405", value, location)
+			return t, false, false, err
+		}
+	}
+	t, err = time.Parse("20060102T150405", value)
+	return t, false, true, err
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration reads a DURATION value such as P1D or PT2H30M
+func parseDuration(value string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var total time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+2] != "" {
+			n, _ := strconv.Atoi(match[i+2])
+			total += time.Duration(n) * unit
+		}
+	}
+	if match[1] == "-" {
+		total = -total
+	}
+	return total, nil
+}
+
+// unescapeText reverses escapeText
+func unescapeText(text string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(text)
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// CompleteFinishedBookings marks confirmed stays completed once their checkout date has passed,
+// and confirmed event bookings completed once the event day is over
+func CompleteFinishedBookings(db *gorm.DB, now time.Time) error {
+	stays := db.Model(&models.Booking{}).
+		Where("status = ? AND checkout_date <= ?", models.BookingStatusConfirmed, now).
+		Updates(map[string]interface{}{"status": models.BookingStatusCompleted, "completed_at": now})
+	if stays.Error != nil {
+		return stays.Error
+	}
+
+	// Event dates are stored as YYYY-MM-DD strings, which sort in date order
+	finishedEvents := db.Model(&models.Event{}).Select("id").Where("date < ?", now.Format("2006-01-02"))
+	events := db.Model(&models.EventBooking{}).
+		Where("status = ? AND event_id IN (?)", models.BookingStatusConfirmed, finishedEvents).
+		Updates(map[string]interface{}{"status": models.BookingStatusCompleted, "completed_at": now})
+	if events.Error != nil {
+		return events.Error
+	}
+
+	if stays.RowsAffected > 0 || events.RowsAffected > 0 {
+		fmt.Printf("Completed %d stays and %d event bookings\n", stays.RowsAffected, events.RowsAffected)
+	}
+	return nil
+}
+
+// ExpireBookingRequests expires pending booking requests whose hold has run out, releasing their dates
+func ExpireBookingRequests(db *gorm.DB, now time.Time) error {
+	var requests []models.Booking
+	if err := db.Where("status = ? AND expires_at <= ?", models.BookingStatusPending, now).Find(&requests).Error; err != nil {
+		return err
+	}
+	for i := range requests {
+		if err := routes.ExpireBookingRequest(&requests[i], now, db); err != nil {
+			fmt.Printf("Failed to expire booking request %d: %v\n", requests[i].ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// SyncExternalCalendars refreshes the dates blocked by every calendar registered by URL.
+// A calendar that fails to sync keeps its previous dates and doesn't stop the others.
+func SyncExternalCalendars(db *gorm.DB, now time.Time) error {
+	var sources []models.CalendarSource
+	if err := db.Where("url <> ''").Find(&sources).Error; err != nil {
+		return err
+	}
+	for i := range sources {
+		if err := routes.SyncCalendarSource(&sources[i], now, db); err != nil {
+			fmt.Printf("Failed to sync calendar %d: %v\n", sources[i].ID, err)
+		}
+	}
+	return nil
+}
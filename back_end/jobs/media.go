@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// DeleteAbandonedUploads removes uploads nobody attached within routes.UnattachedMediaTTL, with their blobs.
+// An upload that fails doesn't stop the others.
+func DeleteAbandonedUploads(db *gorm.DB, now time.Time) error {
+	var uploads []models.Media
+	err := db.Where("accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL AND created_at <= ?", now.Add(-routes.UnattachedMediaTTL)).
+		Find(&uploads).Error
+	if err != nil {
+		return err
+	}
+	for i := range uploads {
+		if err := routes.DeleteUnattachedMedia(uploads[i], db); err != nil {
+			fmt.Printf("Failed to delete abandoned upload %d: %v\n", uploads[i].ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// closedBookingStatuses are the statuses whose payment should be voided or refunded
+var closedBookingStatuses = []string{models.BookingStatusCancelled, models.BookingStatusDeclined, models.BookingStatusExpired}
+
+// unsettledPayment matches intents still holding money their booking no longer owes: an authorization
+// that was never voided, or a charge refunded by less than the booking's refund amount
+const unsettledPayment = "payment_intents.status = ? OR (payment_intents.status IN ? AND payment_intents.refunded_amount < %s.refund_amount)"
+
+// SettleCancelledPayments retries voiding and refunding payments for closed bookings. Bookings are
+// cancelled before their money goes back, so a refund the gateway failed is picked up here.
+// It first confirms pending bookings that were paid for, which only happens when the confirmation
+// failed after the capture and the charge couldn't be refunded.
+func SettleCancelledPayments(db *gorm.DB, now time.Time) error {
+	if err := confirmPaidBookings(db, now); err != nil {
+		return err
+	}
+	captured := []string{models.PaymentStatusSucceeded, models.PaymentStatusPartiallyRefunded}
+
+	var stays []models.Booking
+	err := db.Where("status IN ? AND id IN (?)", closedBookingStatuses,
+		db.Model(&models.PaymentIntent{}).Select("payment_intents.booking_id").
+			Joins("JOIN bookings ON bookings.id = payment_intents.booking_id").
+			Where(fmt.Sprintf(unsettledPayment, "bookings"), models.PaymentStatusRequiresCapture, captured)).
+		Find(&stays).Error
+	if err != nil {
+		return err
+	}
+	for i := range stays {
+		if err := routes.SettleBookingPayment(&stays[i], db); err != nil {
+			fmt.Printf("Failed to settle payment for booking %d: %v\n", stays[i].ID, err)
+		}
+	}
+
+	var tickets []models.EventBooking
+	err = db.Where("status IN ? AND id IN (?)", closedBookingStatuses,
+		db.Model(&models.PaymentIntent{}).Select("payment_intents.event_booking_id").
+			Joins("JOIN event_bookings ON event_bookings.id = payment_intents.event_booking_id").
+			Where(fmt.Sprintf(unsettledPayment, "event_bookings"), models.PaymentStatusRequiresCapture, captured)).
+		Find(&tickets).Error
+	if err != nil {
+		return err
+	}
+	for i := range tickets {
+		if err := routes.SettleEventBookingPayment(&tickets[i], db); err != nil {
+			fmt.Printf("Failed to settle payment for event booking %d: %v\n", tickets[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// confirmPaidBookings confirms pending bookings with a captured payment. Requests whose hold ran out
+// are left to expire instead, which refunds them in full.
+func confirmPaidBookings(db *gorm.DB, now time.Time) error {
+	paid := func(column string) *gorm.DB {
+		return db.Model(&models.PaymentIntent{}).Select(column).Where("status = ?", models.PaymentStatusSucceeded)
+	}
+
+	stays := db.Model(&models.Booking{}).
+		Where("status = ? AND (expires_at IS NULL OR expires_at > ?) AND id IN (?)", models.BookingStatusPending, now, paid("booking_id")).
+		Updates(map[string]interface{}{"status": models.BookingStatusConfirmed, "confirmed_at": now, "expires_at": nil})
+	if stays.Error != nil {
+		return stays.Error
+	}
+	events := db.Model(&models.EventBooking{}).
+		Where("status = ? AND id IN (?)", models.BookingStatusPending, paid("event_booking_id")).
+		Updates(map[string]interface{}{"status": models.BookingStatusConfirmed, "confirmed_at": now})
+	if events.Error != nil {
+		return events.Error
+	}
+
+	if stays.RowsAffected > 0 || events.RowsAffected > 0 {
+		fmt.Printf("Confirmed %d paid stays and %d paid event bookings\n", stays.RowsAffected, events.RowsAffected)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// ApplyPriceSuggestions refreshes the date prices of every accommodation whose owner opted in to suggested prices.
+// An accommodation that fails doesn't stop the others.
+func ApplyPriceSuggestions(db *gorm.DB, now time.Time) error {
+	var accommodations []models.Accommodation
+	if err := db.Where("price_suggestion_auto_apply = ?", true).Find(&accommodations).Error; err != nil {
+		return err
+	}
+	for i := range accommodations {
+		suggestions, err := routes.PriceSuggestions(accommodations[i], now, db)
+		if err == nil {
+			err = routes.ApplyPriceSuggestions(&accommodations[i], suggestions, now, db)
+		}
+		if err != nil {
+			fmt.Printf("Failed to apply price suggestions to accommodation %d: %v\n", accommodations[i].ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job is a unit of background work run periodically against the database
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(db *gorm.DB, now time.Time) error
+}
+
+// DefaultJobs are the jobs started with the server
+var DefaultJobs = []Job{
+	{Name: "complete-finished-bookings", Interval: time.Hour, Run: CompleteFinishedBookings},
+	{Name: "expire-booking-requests", Interval: 5 * time.Minute, Run: ExpireBookingRequests},
+	{Name: "sync-external-calendars", Interval: 30 * time.Minute, Run: SyncExternalCalendars},
+	{Name: "apply-price-suggestions", Interval: 24 * time.Hour, Run: ApplyPriceSuggestions},
+	{Name: "release-expired-seat-holds", Interval: time.Minute, Run: ReleaseExpiredSeatHolds},
+	{Name: "settle-cancelled-payments", Interval: 15 * time.Minute, Run: SettleCancelledPayments},
+	{Name: "delete-abandoned-uploads", Interval: time.Hour, Run: DeleteAbandonedUploads},
+}
+
+// Start runs every default job on its own ticker until the process exits
+func Start(db *gorm.DB) {
+	for _, job := range DefaultJobs {
+		go runEvery(db, job)
+	}
+}
+
+func runEvery(db *gorm.DB, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		if err := job.Run(db, time.Now()); err != nil {
+			fmt.Printf("Job %s failed: %v\n", job.Name, err)
+		}
+		<-ticker.C
+	}
+}
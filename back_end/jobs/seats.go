@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// ReleaseExpiredSeatHolds frees seats whose hold has run out without being booked.
+// Expired holds don't block anyone meanwhile; this keeps the seat map tidy.
+func ReleaseExpiredSeatHolds(db *gorm.DB, now time.Time) error {
+	result := db.Model(&models.Seat{}).
+		Where("event_booking_id IS NULL AND held_until <= ?", now).
+		Updates(map[string]interface{}{"held_by": nil, "held_until": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		fmt.Printf("Released %d expired seat holds\n", result.RowsAffected)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// Limits on uploaded images
+const (
+	MaxUploadSize = 10 << 20   // bytes
+	MaxPixels     = 40_000_000 // width x height, so a small file can't decode into a huge image
+	ThumbnailSize = 320        // longest side of a thumbnail, in pixels
+)
+
+var (
+	// ErrTooLarge is returned for uploads over MaxUploadSize bytes or MaxPixels pixels
+	ErrTooLarge = errors.New("image is too large")
+	// ErrUnsupportedType is returned when the upload isn't a JPEG, PNG or GIF
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are supported")
+	// ErrInvalidImage is returned when the upload claims to be an image but can't be decoded
+	ErrInvalidImage = errors.New("image could not be decoded")
+)
+
+// Encoded is an image ready to be stored
+type Encoded struct {
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Ext returns the file extension for the image's content type
+func (e Encoded) Ext() string {
+	if e.ContentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Processed is a cleaned-up upload and its thumbnail
+type Processed struct {
+	Original  Encoded
+	Thumbnail Encoded
+}
+
+// Process reads an uploaded image, checks its type from its content rather than its name,
+// and re-encodes it so metadata such as EXIF location data is dropped.
+// JPEGs stay JPEGs; PNGs and GIFs become PNGs. Only the first frame of an animated GIF is kept.
+func Process(r io.Reader) (*Processed, error) {
+	img, contentType, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := Encode(img, contentType)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := Encode(Fit(img, ThumbnailSize, ThumbnailSize), contentType)
+	if err != nil {
+		return nil, err
+	}
+	return &Processed{Original: *original, Thumbnail: *thumbnail}, nil
+}
+
+// Decode reads an uploaded image within the size limits. It returns the image with the
+// content type it should be stored as: image/jpeg for JPEGs, image/png for PNGs and GIFs.
+func Decode(r io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > MaxUploadSize {
+		return nil, "", ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch contentType {
+	case "image/jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "image/png":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	case "image/gif":
+		decode, decodeConfig = gif.Decode, gif.DecodeConfig
+		contentType = "image/png"
+	default:
+		return nil, "", ErrUnsupportedType
+	}
+
+	// Check the dimensions before decoding the pixels
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, contentType, nil
+}
+
+// Encode writes img as a JPEG or PNG
+func Encode(img image.Image, contentType string) (*Encoded, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	return &Encoded{ContentType: contentType, Width: bounds.Dx(), Height: bounds.Dy(), Data: buf.Bytes()}, nil
+}
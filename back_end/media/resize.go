@@ -0,0 +1,80 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Fit scales img down to fit inside maxWidth x maxHeight, keeping its aspect ratio.
+// Images that already fit are returned unscaled.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+
+	// Scale by whichever side overflows more
+	dw, dh := maxWidth, h*maxWidth/w
+	if dh > maxHeight {
+		dw, dh = w*maxHeight/h, maxHeight
+	}
+	return resize(img, max(dw, 1), max(dh, 1))
+}
+
+// Square crops the middle of img to a square and scales it to size x size
+func Square(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	cropped := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(cropped, cropped.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	if side == size {
+		return cropped
+	}
+	return resize(cropped, size, size)
+}
+
+// resize scales img to exactly width x height by averaging the source pixels under each
+// destination pixel, which keeps downscaled photos free of aliasing
+func resize(img image.Image, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// toRGBA copies img into an RGBA image whose bounds start at the origin
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
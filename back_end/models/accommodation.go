@@ -1,6 +1,18 @@
 package models
 
-import "github.com/lib/pq"
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"roam.io/pricing"
+)
+
+// Booking modes for an accommodation
+const (
+	BookingModeInstant = "instant" // bookings are confirmed straight away
+	BookingModeRequest = "request" // bookings wait for the owner's approval
+)
 
 // Review represents a user review
 type Review struct {
@@ -11,6 +23,110 @@ type Review struct {
 	Rating          float64 `json:"Rating"`
 	Date            string  `json:"Date"` // Consider using time.Time for better date handling
 	Comment         string  `gorm:"type:text" json:"Comment"`
+
+	// BookingID is the completed stay being reviewed. Reviews written before stays were checked have none.
+	BookingID    *uint `gorm:"uniqueIndex" json:"BookingID,omitempty"`
+	VerifiedStay bool  `json:"VerifiedStay"`
+
+	// Optional sub-ratings from 1 to 5; 0 means the reviewer skipped it
+	CleanlinessRating float64 `json:"CleanlinessRating,omitempty"`
+	LocationRating    float64 `json:"LocationRating,omitempty"`
+	ValueRating       float64 `json:"ValueRating,omitempty"`
+
+	// HelpfulCount is how many travelers found the review useful, kept in step with its votes
+	HelpfulCount uint `json:"HelpfulCount"`
+
+	EditedAt *time.Time      `json:"EditedAt,omitempty"`
+	Response *ReviewResponse `gorm:"foreignKey:ReviewID" json:"Response,omitempty"` // the owner's public reply
+	Photos   []Media         `gorm:"foreignKey:ReviewID" json:"Photos,omitempty"`
+
+	// Only approved reviews are listed or counted in ratings
+	ModerationStatus string    `gorm:"size:20;index" json:"ModerationStatus"`
+	ModerationReason string    `gorm:"size:100" json:"ModerationReason,omitempty"`
+	CreatedAt        time.Time `json:"CreatedAt"`
+	// A deleted review is kept so its stay can't be reviewed again
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Review moderation statuses
+const (
+	ReviewStatusPending  = "pending"  // held by screening or reports, waiting for a moderator
+	ReviewStatusApproved = "approved" // published
+	ReviewStatusRejected = "rejected" // never published
+	ReviewStatusHidden   = "hidden"   // taken down after being published
+)
+
+// IsValidReviewModerationTransition reports whether a moderator may move a review from one status to another
+func IsValidReviewModerationTransition(from, to string) bool {
+	switch to {
+	case ReviewStatusApproved:
+		return from == ReviewStatusPending || from == ReviewStatusHidden
+	case ReviewStatusRejected:
+		return from == ReviewStatusPending
+	case ReviewStatusHidden:
+		return from == ReviewStatusApproved || from == ReviewStatusPending
+	}
+	return false
+}
+
+// ReviewReport is a user's complaint about a review. A user can report a review once.
+type ReviewReport struct {
+	ID         uint       `gorm:"primaryKey" json:"ID"`
+	ReviewID   uint       `gorm:"not null;uniqueIndex:idx_review_reporter" json:"ReviewID"`
+	UserID     uint       `gorm:"not null;uniqueIndex:idx_review_reporter" json:"UserID"`
+	Reason     string     `gorm:"type:text" json:"Reason"`
+	CreatedAt  time.Time  `json:"CreatedAt"`
+	ResolvedAt *time.Time `json:"ResolvedAt,omitempty"`
+}
+
+// ReviewVote marks a review as helpful to a user. A user votes on a review once.
+type ReviewVote struct {
+	ID        uint      `gorm:"primaryKey" json:"ID"`
+	ReviewID  uint      `gorm:"not null;uniqueIndex:idx_review_voter" json:"ReviewID"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_review_voter" json:"UserID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+// Reasons a review revision was recorded
+const (
+	ReviewRevisionEdited  = "edited"
+	ReviewRevisionDeleted = "deleted"
+)
+
+// ReviewRevision keeps a review's content as it was before an edit or deletion
+type ReviewRevision struct {
+	ID                uint   `gorm:"primaryKey"`
+	ReviewID          uint   `gorm:"not null;index"`
+	UserID            uint   `gorm:"not null"`
+	Reason            string `gorm:"size:20"`
+	Rating            float64
+	CleanlinessRating float64
+	LocationRating    float64
+	ValueRating       float64
+	Comment           string `gorm:"type:text"`
+	CreatedAt         time.Time
+}
+
+// NewReviewRevision snapshots the review's current content
+func NewReviewRevision(review Review, reason string) ReviewRevision {
+	return ReviewRevision{
+		ReviewID:          review.ID,
+		UserID:            review.UserID,
+		Reason:            reason,
+		Rating:            review.Rating,
+		CleanlinessRating: review.CleanlinessRating,
+		LocationRating:    review.LocationRating,
+		ValueRating:       review.ValueRating,
+		Comment:           review.Comment,
+	}
+}
+
+// ReviewResponse is the accommodation owner's public reply to a review. Each review gets at most one.
+type ReviewResponse struct {
+	ID        uint      `gorm:"primaryKey" json:"ID"`
+	ReviewID  uint      `gorm:"not null;uniqueIndex" json:"ReviewID"`
+	Comment   string    `gorm:"type:text" json:"Comment"`
+	CreatedAt time.Time `json:"CreatedAt"`
 }
 
 // Accommodation represents an accommodation object in the system
@@ -21,10 +137,61 @@ type Accommodation struct {
 	Description   string         `gorm:"type:text" json:"Description"`
 	Facilities    pq.StringArray `gorm:"type:text[]" json:"Facilities"`
 	ImageUrls     pq.StringArray `gorm:"type:text[]" json:"ImageUrls"`
-	UserReviews   []Review       `gorm:"foreignKey:AccommodationID" json:"UserReviews"` // Define the foreign key relationship
-	OwnerID       uint           `gorm:"not null" json:"OwnerID"`                       // Foreign key linking to the Owner
+	UserReviews   []Review       `gorm:"foreignKey:AccommodationID" json:"UserReviews,omitempty"` // published reviews, loaded by GET /accommodations/{id} only
+	OwnerID       uint           `gorm:"not null" json:"OwnerID"`                                 // Foreign key linking to the Owner
 	PricePerNight float64        `json:"PricePerNight"`
-	Rating        float64        `json:"Rating"` // Consider calculating this based on Reviews
+	Rating        float64        `json:"Rating"` // average of the review ratings, kept up to date by the review handlers
 	Owner         Owner          `gorm:"foreignKey:OwnerID" json:"Owner"`
 	Coordinates   string         `gorm:"type:text" json:"Coordinates"`
+	BookingMode   string         `gorm:"size:20" json:"BookingMode"`
+
+	CancellationPolicy string      `gorm:"size:20" json:"CancellationPolicy"`
+	CancellationTiers  RefundTiers `gorm:"type:jsonb" json:"CancellationTiers,omitempty"` // only used by custom policies
+
+	AvailabilityRules AvailabilityRules `gorm:"type:jsonb" json:"AvailabilityRules"`
+	GuestPolicy       GuestPolicy       `gorm:"embedded" json:"GuestPolicy"`
+	// PricingRules adjust PricePerNight for seasons, weekdays, longer stays and extra guests
+	PricingRules pricing.Rules  `gorm:"type:jsonb" json:"PricingRules"`
+	PriceQuote   *pricing.Quote `gorm:"-" json:"PriceQuote,omitempty"` // filled in when searching for dates
+	// PriceSuggestions bound the prices suggested to the owner, who may have them applied automatically
+	PriceSuggestions pricing.SuggestionSettings `gorm:"embedded;embeddedPrefix:price_suggestion_" json:"-"`
+
+	// Review aggregates; sub-rating averages only count reviews that gave that sub-rating
+	ReviewCount       uint    `gorm:"not null;default:0" json:"ReviewCount"`
+	CleanlinessRating float64 `json:"CleanlinessRating"`
+	LocationRating    float64 `json:"LocationRating"`
+	ValueRating       float64 `json:"ValueRating"`
+
+	ReviewSummary *ReviewSummary `gorm:"-" json:"ReviewSummary,omitempty"` // filled in when a single accommodation is fetched
+}
+
+// ReviewSummary breaks an accommodation's published reviews down by star rating
+type ReviewSummary struct {
+	ReviewCount int64         `json:"ReviewCount"`
+	Histogram   map[int]int64 `json:"Histogram"` // reviews per star, 1 to 5, half stars rounded up
+}
+
+// RequiresApproval reports whether bookings must be approved by the owner.
+// Listings created before booking modes existed book instantly.
+func (a Accommodation) RequiresApproval() bool {
+	return a.BookingMode == BookingModeRequest
+}
+
+// Quote prices a stay at the accommodation
+func (a Accommodation) Quote(checkIn, checkOut time.Time, guests GuestComposition) (*pricing.Quote, error) {
+	return a.PricingRules.QuoteParty(a.PricePerNight, checkIn, checkOut, guests.Party())
+}
+
+// QuoteRoom prices a stay in one of the accommodation's room types. The room's price replaces PricePerNight;
+// seasons and date prices, which are set for the listing's price, apply in proportion to the room's price,
+// and weekday adjustments, stay discounts and fees apply as they are. Listings without a price of their
+// own have nothing to scale seasons and date prices against, so rooms there are priced without them.
+func (a Accommodation) QuoteRoom(roomType RoomType, checkIn, checkOut time.Time, guests GuestComposition) (*pricing.Quote, error) {
+	rules := a.PricingRules.Scaled(a.PricePerNight, roomType.PricePerNight)
+	return rules.QuoteParty(roomType.PricePerNight, checkIn, checkOut, guests.Party())
+}
+
+// IsValidBookingMode reports whether mode is a known booking mode; empty means instant
+func IsValidBookingMode(mode string) bool {
+	return mode == "" || mode == BookingModeInstant || mode == BookingModeRequest
 }
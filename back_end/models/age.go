@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// MaxMinimumAge is the highest minimum age an event or accommodation can set
+const MaxMinimumAge = 99
+
+// AgeOn is how old someone born on dob is, in whole years, on the calendar date of on.
+// Only dates are compared, so on is read in its own location: an event's local date, not UTC.
+// dob is read in UTC, where it's stored at midnight. Someone born on 29 February turns a year
+// older on 1 March in other years.
+func AgeOn(dob, on time.Time) int {
+	dob = dob.UTC()
+	age := on.Year() - dob.Year()
+	if on.Month() < dob.Month() || (on.Month() == dob.Month() && on.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+// CheckMinimumAge returns a StayError if someone born on dob is younger than minimum on the date of on.
+// A minimum of 0 is no limit.
+func CheckMinimumAge(minimum uint, dob, on time.Time) error {
+	if minimum > 0 && AgeOn(dob, on) < int(minimum) {
+		return stayError(StayErrorUnderage, "You must be at least %d to book", minimum)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Codes for the ways a stay can break an accommodation's availability rules
+const (
+	StayErrorInvalidDates  = "invalid_dates"     // check-out isn't after check-in, or check-in has passed
+	StayErrorTooShort      = "stay_too_short"    // fewer nights than the minimum stay
+	StayErrorTooLong       = "stay_too_long"     // more nights than the maximum stay
+	StayErrorCheckInDay    = "check_in_day"      // check-in falls on a day it isn't allowed
+	StayErrorCheckOutDay   = "check_out_day"     // check-out falls on a day it isn't allowed
+	StayErrorLeadTime      = "lead_time"         // check-in is sooner than the owner needs notice for
+	StayErrorHorizon       = "booking_horizon"   // check-in is further ahead than bookings are open
+	StayErrorBlackout      = "blackout_dates"    // the owner closed some of the nights
+	StayErrorUnavailable   = "dates_unavailable" // some of the nights are already booked
+	StayErrorPriceChanged  = "price_changed"     // the total the guest was shown is no longer the price
+	StayErrorInvalidGuests = "invalid_guests"    // no adults, or more guests than any booking can be for
+	StayErrorTooManyGuests = "too_many_guests"   // more guests than the accommodation or room takes
+	StayErrorChildren      = "children_not_allowed"
+	StayErrorPets          = "pets_not_allowed"  // pets, or that many pets, aren't allowed
+	StayErrorUnderage      = "under_minimum_age" // the booker is younger than the minimum age
+)
+
+// StayError explains why a stay can't be booked. Code is one of the StayError constants.
+type StayError struct {
+	Code    string
+	Message string
+}
+
+func (e *StayError) Error() string {
+	return e.Message
+}
+
+func stayError(code, format string, args ...interface{}) *StayError {
+	return &StayError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// AvailabilityRules are an owner's limits on which stays can be booked. Zero values and empty lists mean no limit.
+type AvailabilityRules struct {
+	MinNights int `json:"min_nights"`
+	MaxNights int `json:"max_nights"`
+	// WeekendMinNights is the minimum stay for stays including a Friday or Saturday night
+	WeekendMinNights int `json:"weekend_min_nights"`
+	// CheckInDays and CheckOutDays list the weekdays, such as "saturday", guests may arrive and leave on
+	CheckInDays  []string `json:"check_in_days,omitempty"`
+	CheckOutDays []string `json:"check_out_days,omitempty"`
+	// LeadDays is how many days' notice the owner needs before check-in; 0 allows same-day bookings
+	LeadDays int `json:"lead_days"`
+	// HorizonDays is how many days ahead check-in can be
+	HorizonDays int `json:"horizon_days"`
+}
+
+// Value implements driver.Valuer. Accommodations without rules store NULL.
+func (r AvailabilityRules) Value() (driver.Value, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (r *AvailabilityRules) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = AvailabilityRules{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("cannot scan %T into AvailabilityRules", value)
+}
+
+// IsZero reports whether the rules don't limit stays at all
+func (r AvailabilityRules) IsZero() bool {
+	return r.MinNights == 0 && r.MaxNights == 0 && r.WeekendMinNights == 0 && len(r.CheckInDays) == 0 &&
+		len(r.CheckOutDays) == 0 && r.LeadDays == 0 && r.HorizonDays == 0
+}
+
+// weekdays maps the lowercase English name of each day to its time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// Validate checks the rules an owner set, lowercasing weekday names
+func (r *AvailabilityRules) Validate() error {
+	for _, n := range []int{r.MinNights, r.MaxNights, r.WeekendMinNights, r.LeadDays, r.HorizonDays} {
+		if n < 0 {
+			return errors.New("availability rules cannot be negative")
+		}
+	}
+	if r.MaxNights > 0 && r.MinNights > r.MaxNights {
+		return errors.New("min_nights cannot be more than max_nights")
+	}
+	if r.MaxNights > 0 && r.WeekendMinNights > r.MaxNights {
+		return errors.New("weekend_min_nights cannot be more than max_nights")
+	}
+	if r.HorizonDays > 0 && r.LeadDays > r.HorizonDays {
+		return errors.New("lead_days cannot be more than horizon_days")
+	}
+	for _, days := range [][]string{r.CheckInDays, r.CheckOutDays} {
+		for i, name := range days {
+			days[i] = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := weekdays[days[i]]; !ok {
+				return fmt.Errorf("unknown weekday %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckStay reports why a stay from checkIn to checkOut breaks the rules, or nil if it doesn't.
+// Dates are calendar days; now decides which day is today.
+func (r AvailabilityRules) CheckStay(checkIn, checkOut, now time.Time) error {
+	checkIn, checkOut, today := CalendarDay(checkIn), CalendarDay(checkOut), CalendarDay(now)
+	if !checkOut.After(checkIn) {
+		return stayError(StayErrorInvalidDates, "Check-out must be after check-in")
+	}
+	if checkIn.Before(today) {
+		return stayError(StayErrorInvalidDates, "Check-in cannot be in the past")
+	}
+
+	daysAhead := daysBetween(today, checkIn)
+	if daysAhead < r.LeadDays {
+		return stayError(StayErrorLeadTime, "Check-in must be at least %d days from today", r.LeadDays)
+	}
+	if r.HorizonDays > 0 && daysAhead > r.HorizonDays {
+		return stayError(StayErrorHorizon, "Check-in can be at most %d days from today", r.HorizonDays)
+	}
+
+	nights := daysBetween(checkIn, checkOut)
+	if nights < r.MinNights {
+		return stayError(StayErrorTooShort, "Stays must be at least %d nights", r.MinNights)
+	}
+	if r.WeekendMinNights > 0 && nights < r.WeekendMinNights && includesWeekendNight(checkIn, nights) {
+		return stayError(StayErrorTooShort, "Stays over a weekend must be at least %d nights", r.WeekendMinNights)
+	}
+	if r.MaxNights > 0 && nights > r.MaxNights {
+		return stayError(StayErrorTooLong, "Stays can be at most %d nights", r.MaxNights)
+	}
+
+	if !allowsWeekday(r.CheckInDays, checkIn.Weekday()) {
+		return stayError(StayErrorCheckInDay, "Check-in is only allowed on %s", strings.Join(r.CheckInDays, ", "))
+	}
+	if !allowsWeekday(r.CheckOutDays, checkOut.Weekday()) {
+		return stayError(StayErrorCheckOutDay, "Check-out is only allowed on %s", strings.Join(r.CheckOutDays, ", "))
+	}
+	return nil
+}
+
+// daysBetween counts the calendar days from one date to a later one
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// includesWeekendNight reports whether any of the nights starting at checkIn is a Friday or Saturday night
+func includesWeekendNight(checkIn time.Time, nights int) bool {
+	for i := 0; i < nights && i < 7; i++ {
+		if day := checkIn.AddDate(0, 0, i).Weekday(); day == time.Friday || day == time.Saturday {
+			return true
+		}
+	}
+	return false
+}
+
+func allowsWeekday(names []string, day time.Weekday) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if weekdays[name] == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package models
+
+// DefaultAvatarID is the preset new users start with
+const DefaultAvatarID = "Marshmallow"
+
+// AvatarUploaded marks a user whose avatar is an image they uploaded rather than a preset
+const AvatarUploaded = "uploaded"
+
+// AvatarPresets are the avatar IDs a user can pick from. The images are served by the web app under /avatars/.
+var AvatarPresets = []string{
+	"Bluey", "Marshmallow", "Mocha", "Nugget", "Pearl", "Pebbles",
+	"Pip", "Rusty", "Sirius", "Snuffles", "Stripe", "Thumper",
+}
+
+// AvatarSizes are the square sizes, in pixels, an uploaded avatar is stored at
+var AvatarSizes = []int{64, 128, 256}
+
+// IsAvatarPreset reports whether id is one of the preset avatars
+func IsAvatarPreset(id string) bool {
+	for _, preset := range AvatarPresets {
+		if preset == id {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,25 @@ type Booking struct {
 	AccommodationID uint `gorm:"not null"`
 	CheckinDate     time.Time
 	CheckoutDate    time.Time
-	Guests          uint `gorm:"not null"`
-	TotalCost       uint `gorm:"not null"`
+	Guests          uint       `gorm:"not null"`
+	TotalCost       uint       `gorm:"not null"`
+	Status          string     `gorm:"size:20;index"`
+	ExpiresAt       *time.Time // when an unanswered booking request releases its dates
+	RefundAmount    uint       // refunded on cancellation, out of TotalCost
+	TripID          *uint      `gorm:"index"` // the trip the stay is planned under, if any
+	RoomTypeID      *uint      `gorm:"index"` // the room booked at accommodations with room types
+
+	// Composition breaks the booking's guests down; Guests counts its adults and children
+	Composition GuestComposition `gorm:"embedded;embeddedPrefix:guest_"`
+	BookingTimestamps
+}
+
+// Transition moves the booking to a new status, enforcing the allowed transitions
+func (b *Booking) Transition(to string, at time.Time) error {
+	return transitionBooking(&b.Status, &b.BookingTimestamps, to, at)
+}
+
+// IsActive reports whether the booking still holds its dates
+func (b Booking) IsActive() bool {
+	return IsActiveBookingStatus(b.Status)
 }
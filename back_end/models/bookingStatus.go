@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Booking statuses shared by accommodation and event bookings
+const (
+	BookingStatusPending   = "pending"
+	BookingStatusConfirmed = "confirmed"
+	BookingStatusCancelled = "cancelled"
+	BookingStatusCompleted = "completed"
+	BookingStatusNoShow    = "no_show"  // guest never arrived for a confirmed stay
+	BookingStatusDeclined  = "declined" // booking request turned down by the owner
+	BookingStatusExpired   = "expired"  // booking request not answered in time
+)
+
+// ActiveBookingStatuses are the statuses in which a booking still holds its dates or seats
+var ActiveBookingStatuses = []string{BookingStatusPending, BookingStatusConfirmed}
+
+// ErrInvalidBookingTransition is returned when a booking can't move to the requested status
+var ErrInvalidBookingTransition = errors.New("invalid booking status transition")
+
+// bookingTransitions lists the statuses a booking may move to from each status.
+// Cancelled, completed, no-show, declined and expired are final.
+var bookingTransitions = map[string][]string{
+	BookingStatusPending:   {BookingStatusConfirmed, BookingStatusCancelled, BookingStatusDeclined, BookingStatusExpired},
+	BookingStatusConfirmed: {BookingStatusCancelled, BookingStatusCompleted, BookingStatusNoShow},
+}
+
+// normalizeBookingStatus treats rows created before statuses existed as confirmed
+func normalizeBookingStatus(status string) string {
+	if status == "" {
+		return BookingStatusConfirmed
+	}
+	return status
+}
+
+// CanTransitionBooking reports whether a booking in status from may move to status to
+func CanTransitionBooking(from, to string) bool {
+	for _, allowed := range bookingTransitions[normalizeBookingStatus(from)] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActiveBookingStatus reports whether a booking in this status still holds its dates or seats
+func IsActiveBookingStatus(status string) bool {
+	status = normalizeBookingStatus(status)
+	return status == BookingStatusPending || status == BookingStatusConfirmed
+}
+
+// BookingTimestamps records when a booking entered each status
+type BookingTimestamps struct {
+	CreatedAt   time.Time
+	UpdatedAt   time.Time // bumped by every change, so calendar apps can tell when to refresh their copy
+	ConfirmedAt *time.Time
+	CancelledAt *time.Time
+	CompletedAt *time.Time
+	NoShowAt    *time.Time
+	DeclinedAt  *time.Time
+	ExpiredAt   *time.Time
+}
+
+// stamp sets the timestamp matching status
+func (t *BookingTimestamps) stamp(status string, at time.Time) {
+	switch status {
+	case BookingStatusConfirmed:
+		t.ConfirmedAt = &at
+	case BookingStatusCancelled:
+		t.CancelledAt = &at
+	case BookingStatusCompleted:
+		t.CompletedAt = &at
+	case BookingStatusNoShow:
+		t.NoShowAt = &at
+	case BookingStatusDeclined:
+		t.DeclinedAt = &at
+	case BookingStatusExpired:
+		t.ExpiredAt = &at
+	}
+}
+
+// transitionBooking moves *status to the target status and stamps the time, or fails if the move isn't allowed
+func transitionBooking(status *string, timestamps *BookingTimestamps, to string, at time.Time) error {
+	if !CanTransitionBooking(*status, to) {
+		return ErrInvalidBookingTransition
+	}
+	*status = to
+	timestamps.stamp(to, at)
+	return nil
+}
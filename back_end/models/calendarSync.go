@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// CalendarSource is another platform's calendar for an accommodation, whose bookings block dates here.
+// It is either fetched from a URL on a schedule or uploaded once as a file.
+type CalendarSource struct {
+	ID              uint   `gorm:"primaryKey"`
+	AccommodationID uint   `gorm:"not null;index"`
+	Name            string `gorm:"size:100"`
+	URL             string `gorm:"type:text"` // empty for uploaded files, which aren't synced again
+	LastSyncedAt    *time.Time
+	LastSyncError   string `gorm:"type:text"` // why the last sync failed; empty if it worked
+	CreatedAt       time.Time
+}
+
+// BlockedDateRange is a stay booked through a CalendarSource, or blackout dates the owner closed the
+// accommodation for. The nights from StartDate up to, but not including, EndDate can't be booked.
+type BlockedDateRange struct {
+	ID              uint      `gorm:"primaryKey"`
+	AccommodationID uint      `gorm:"not null;index"`
+	SourceID        uint      `gorm:"not null;index"` // 0 for blackout dates
+	UID             string    `gorm:"size:255"`       // the event's UID in the source calendar
+	Summary         string    `gorm:"size:255"`
+	StartDate       time.Time `gorm:"type:date;not null"`
+	EndDate         time.Time `gorm:"type:date;not null"`
+}
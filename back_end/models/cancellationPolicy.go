@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Cancellation policies an accommodation or event can use
+const (
+	CancellationPolicyFlexible = "flexible"
+	CancellationPolicyModerate = "moderate"
+	CancellationPolicyStrict   = "strict"
+	CancellationPolicyCustom   = "custom"
+)
+
+// RefundTier refunds RefundPercent of the cost when cancelling at least DaysBefore days before the start
+type RefundTier struct {
+	DaysBefore    int `json:"days_before"`
+	RefundPercent int `json:"refund_percent"`
+}
+
+// RefundTiers is stored as JSON so custom policies can carry any number of tiers
+type RefundTiers []RefundTier
+
+// Value implements driver.Valuer
+func (t RefundTiers) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (t *RefundTiers) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	}
+	return fmt.Errorf("cannot scan %T into RefundTiers", value)
+}
+
+// presetRefundTiers are the tiers behind the named policies
+var presetRefundTiers = map[string]RefundTiers{
+	CancellationPolicyFlexible: {{DaysBefore: 1, RefundPercent: 100}},
+	CancellationPolicyModerate: {{DaysBefore: 5, RefundPercent: 100}, {DaysBefore: 1, RefundPercent: 50}},
+	CancellationPolicyStrict:   {{DaysBefore: 14, RefundPercent: 100}, {DaysBefore: 7, RefundPercent: 50}},
+}
+
+// ValidateCancellationPolicy checks a policy name and, for custom policies, its tiers.
+// An empty policy means flexible.
+func ValidateCancellationPolicy(policy string, tiers RefundTiers) error {
+	switch policy {
+	case "", CancellationPolicyFlexible, CancellationPolicyModerate, CancellationPolicyStrict:
+		return nil
+	case CancellationPolicyCustom:
+	default:
+		return fmt.Errorf("unknown cancellation policy %q", policy)
+	}
+
+	if len(tiers) == 0 {
+		return errors.New("custom cancellation policy needs at least one tier")
+	}
+	seen := map[int]bool{}
+	for _, tier := range tiers {
+		if tier.DaysBefore < 0 {
+			return errors.New("days_before cannot be negative")
+		}
+		if tier.RefundPercent < 0 || tier.RefundPercent > 100 {
+			return errors.New("refund_percent must be between 0 and 100")
+		}
+		if seen[tier.DaysBefore] {
+			return fmt.Errorf("duplicate tier for %d days before", tier.DaysBefore)
+		}
+		seen[tier.DaysBefore] = true
+	}
+	return nil
+}
+
+// RefundPercent returns the share of the cost refunded when cancelling at cancelledAt for something starting at startsAt
+func RefundPercent(policy string, tiers RefundTiers, startsAt, cancelledAt time.Time) int {
+	if policy != CancellationPolicyCustom {
+		tiers = presetRefundTiers[policy]
+		if tiers == nil {
+			tiers = presetRefundTiers[CancellationPolicyFlexible]
+		}
+	}
+
+	// Whole days left before the start; cancelling after the start leaves a negative count
+	daysBefore := int(math.Floor(startsAt.Sub(cancelledAt).Hours() / 24))
+
+	sorted := append(RefundTiers(nil), tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DaysBefore > sorted[j].DaysBefore })
+	for _, tier := range sorted {
+		if daysBefore >= tier.DaysBefore {
+			return tier.RefundPercent
+		}
+	}
+	return 0
+}
+
+// CalculateRefund applies the policy to totalCost, rounding the refund down to whole currency units
+func CalculateRefund(policy string, tiers RefundTiers, totalCost uint, startsAt, cancelledAt time.Time) (uint, int) {
+	percent := RefundPercent(policy, tiers, startsAt, cancelledAt)
+	return totalCost * uint(percent) / 100, percent
+}
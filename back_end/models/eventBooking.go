@@ -1,10 +1,28 @@
 package models
 
+import "time"
+
 // User represents a user object in the system
 type EventBooking struct {
-	ID        uint `gorm:"primaryKey"`
-	UserID    uint `gorm:"not null"`
-	EventId   uint `gorm:"not null"`
-	Guests    uint `gorm:"not null"`
-	TotalCost uint `gorm:"not null"`
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null"`
+	EventId   uint   `gorm:"not null"`
+	Guests    uint   `gorm:"not null"`
+	TotalCost uint   `gorm:"not null"`
+	Status    string `gorm:"size:20;index"`
+	// Refunded on cancellation, out of TotalCost
+	RefundAmount uint
+	TripID       *uint `gorm:"index"` // the trip the event is planned under, if any
+	TicketTierID *uint // the tier booked at events with ticket tiers
+	BookingTimestamps
+}
+
+// Transition moves the event booking to a new status, enforcing the allowed transitions
+func (b *EventBooking) Transition(to string, at time.Time) error {
+	return transitionBooking(&b.Status, &b.BookingTimestamps, to, at)
+}
+
+// IsActive reports whether the booking still holds its seats
+func (b EventBooking) IsActive() bool {
+	return IsActiveBookingStatus(b.Status)
 }
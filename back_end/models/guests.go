@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"roam.io/pricing"
+)
+
+// MaxGuestsPerBooking caps the people on any one booking, whatever the listing allows
+const MaxGuestsPerBooking = 50
+
+// Child policies of an accommodation
+const (
+	ChildPolicyWelcome    = "welcome"     // children and infants can stay
+	ChildPolicyNoInfants  = "no_infants"  // children can stay, but not infants
+	ChildPolicyAdultsOnly = "adults_only" // only adults can stay
+)
+
+// GuestComposition is who a booking is for. Children are 2 to 17 and infants under 2.
+// Infants and pets don't count towards occupancy.
+type GuestComposition struct {
+	Adults   uint `json:"adults"`
+	Children uint `json:"children"`
+	Infants  uint `json:"infants"`
+	Pets     uint `json:"pets"`
+}
+
+// Occupancy counts the adults and children
+func (g GuestComposition) Occupancy() uint {
+	return g.Adults + g.Children
+}
+
+// Party is the composition as priced by the accommodation's pricing rules
+func (g GuestComposition) Party() pricing.Party {
+	return pricing.Party{Adults: int(g.Adults), Children: int(g.Children), Infants: int(g.Infants), Pets: int(g.Pets)}
+}
+
+// Validate returns a StayError if no booking could be for these guests
+func (g GuestComposition) Validate() error {
+	if g.Adults == 0 {
+		return stayError(StayErrorInvalidGuests, "At least one adult must be staying")
+	}
+	if g.Occupancy()+g.Infants > MaxGuestsPerBooking || g.Pets > MaxGuestsPerBooking {
+		return stayError(StayErrorInvalidGuests, "A booking can be for at most %d guests", MaxGuestsPerBooking)
+	}
+	return nil
+}
+
+// GuestPolicy is who an accommodation takes. The zero value takes any number of guests, welcomes
+// children and doesn't allow pets.
+type GuestPolicy struct {
+	MaxGuests   uint   `json:"MaxGuests"` // adults and children; 0 for no limit
+	ChildPolicy string `gorm:"size:20" json:"ChildPolicy"`
+	PetsAllowed bool   `json:"PetsAllowed"`
+	MaxPets     uint   `json:"MaxPets"`    // 0 for no limit when pets are allowed
+	MinimumAge  uint   `json:"MinimumAge"` // the booker's age on check-in; 0 for none
+}
+
+// Validate checks a policy set by an owner
+func (p GuestPolicy) Validate() error {
+	switch p.ChildPolicy {
+	case "", ChildPolicyWelcome, ChildPolicyNoInfants, ChildPolicyAdultsOnly:
+	default:
+		return fmt.Errorf("unknown child policy %q", p.ChildPolicy)
+	}
+	if p.MaxGuests > MaxGuestsPerBooking {
+		return fmt.Errorf("max guests cannot be over %d", MaxGuestsPerBooking)
+	}
+	if p.MinimumAge > MaxMinimumAge {
+		return fmt.Errorf("minimum age cannot be over %d", MaxMinimumAge)
+	}
+	if p.MaxPets > 0 && !p.PetsAllowed {
+		return errors.New("max pets needs pets to be allowed")
+	}
+	return nil
+}
+
+// CheckGuests returns a StayError if the accommodation doesn't take these guests
+func (p GuestPolicy) CheckGuests(g GuestComposition) error {
+	if p.MaxGuests > 0 && g.Occupancy() > p.MaxGuests {
+		return stayError(StayErrorTooManyGuests, "The accommodation takes at most %d guests", p.MaxGuests)
+	}
+	if p.ChildPolicy == ChildPolicyAdultsOnly && g.Children+g.Infants > 0 {
+		return stayError(StayErrorChildren, "The accommodation is for adults only")
+	}
+	if p.ChildPolicy == ChildPolicyNoInfants && g.Infants > 0 {
+		return stayError(StayErrorChildren, "The accommodation isn't suitable for infants")
+	}
+	if g.Pets > 0 && !p.PetsAllowed {
+		return stayError(StayErrorPets, "Pets aren't allowed")
+	}
+	if p.MaxPets > 0 && g.Pets > p.MaxPets {
+		return stayError(StayErrorPets, "The accommodation takes at most %d pets", p.MaxPets)
+	}
+	return nil
+}
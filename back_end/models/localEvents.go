@@ -1,9 +1,21 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/lib/pq"
 )
 
+// Event statuses
+const (
+	EventStatusActive    = "active"
+	EventStatusCancelled = "cancelled"
+)
+
+// ErrEventSoldOut is returned when too few of an event's seats are left for a booking
+var ErrEventSoldOut = errors.New("not enough seats are left for this event")
+
 type Event struct {
 	ID             uint   `gorm:"primaryKey"`
 	EventName      string `gorm:"size:100"`
@@ -15,9 +27,54 @@ type Event struct {
 	Price          string         `gorm:"type:text"`
 	AvailableSeats uint
 	TotalSeats     uint
-	OfficialLink   string `gorm:type:text"`
+	OfficialLink   string `gorm:"type:text"`
 	OrganizerID    uint   `gorm:"not null"`
-	Coordinates    string `gorm:type:text`
+	Coordinates    string `gorm:"type:text"`
+	Status         string `gorm:"size:20"`
+	CancelledAt    *time.Time
+	MinimumAge     uint // attendees' age on the event date; 0 for none
+
+	CancellationPolicy string      `gorm:"size:20"`
+	CancellationTiers  RefundTiers `gorm:"type:jsonb"` // only used by custom policies
+
+	ReservedSeating bool // guests pick seats from the event's seat map
+
+	UpdatedAt time.Time // bumped by every change, such as a new date or a cancellation
+}
+
+// Layouts of an event's Date and Time strings
+const (
+	EventDateLayout = "2006-01-02"
+	EventTimeLayout = "15:04"
+)
+
+// StartsAt parses the event's date and time strings, e.g. "2025-04-15" and "18:00".
+// The time is optional and defaults to midnight.
+func (e Event) StartsAt() (time.Time, error) {
+	if e.Time != "" {
+		if start, err := time.Parse(EventDateLayout+" "+EventTimeLayout, e.Date+" "+e.Time); err == nil {
+			return start, nil
+		}
+	}
+	return time.Parse(EventDateLayout, e.Date)
+}
+
+// ValidateSchedule checks the event's date, and its time if it has one, are in the layouts StartsAt reads
+func (e Event) ValidateSchedule() error {
+	if _, err := time.Parse(EventDateLayout, e.Date); err != nil {
+		return errors.New("date must be formatted as YYYY-MM-DD")
+	}
+	if e.Time != "" {
+		if _, err := time.Parse(EventTimeLayout, e.Time); err != nil {
+			return errors.New("time must be formatted as HH:MM")
+		}
+	}
+	return nil
+}
+
+// IsCancelled reports whether the organizer has cancelled the event
+func (e Event) IsCancelled() bool {
+	return e.Status == EventStatusCancelled
 }
 
 type EventResponse struct {
@@ -33,5 +90,13 @@ type EventResponse struct {
 	TotalSeats     uint
 	OfficialLink   string    `gorm:"type:text"`
 	Organizer      Organizer `gorm:"type:json"`
-	Coordinates    string    `gorm:text"`
+	Coordinates    string    `gorm:"type:text"`
+	Status         string
+	MinimumAge     uint
+
+	CancellationPolicy string
+	CancellationTiers  RefundTiers `json:",omitempty"`
+
+	TicketTiers     []TicketTierAvailability `json:",omitempty"` // empty when the event sells one kind of ticket
+	ReservedSeating bool
 }
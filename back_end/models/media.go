@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Media is an uploaded image. It stays private to the user who uploaded it until it is
+// attached to one accommodation, event or review.
+type Media struct {
+	ID           uint   `gorm:"primaryKey" json:"ID"`
+	UserID       uint   `gorm:"not null;index" json:"UserID"` // uploader
+	ContentType  string `gorm:"size:50" json:"ContentType"`
+	Size         int    `json:"Size"` // bytes, after re-encoding
+	Width        int    `json:"Width"`
+	Height       int    `json:"Height"`
+	Key          string `gorm:"size:255" json:"-"` // blob store keys
+	ThumbnailKey string `gorm:"size:255" json:"-"`
+
+	AccommodationID *uint `gorm:"index" json:"AccommodationID,omitempty"`
+	EventID         *uint `gorm:"index" json:"EventID,omitempty"`
+	ReviewID        *uint `gorm:"index" json:"ReviewID,omitempty"`
+
+	CreatedAt time.Time `json:"CreatedAt"`
+
+	URL          string `gorm:"-" json:"URL"`
+	ThumbnailURL string `gorm:"-" json:"ThumbnailURL"`
+}
+
+// TableName keeps gorm from singularizing the table to "medium"
+func (Media) TableName() string {
+	return "media"
+}
+
+// SetURLs fills in the URLs the image and its thumbnail are served from by the API at baseURL
+func (m *Media) SetURLs(baseURL string) {
+	m.URL = fmt.Sprintf("%s/media/%d", baseURL, m.ID)
+	m.ThumbnailURL = fmt.Sprintf("%s/media/%d/thumbnail", baseURL, m.ID)
+}
+
+// IsAttached reports whether the image has been attached to a listing or review
+func (m Media) IsAttached() bool {
+	return m.AccommodationID != nil || m.EventID != nil || m.ReviewID != nil
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Notification statuses
+const (
+	NotificationStatusQueued = "queued"
+	NotificationStatusSent   = "sent"
+)
+
+// Notification is a message queued for delivery to a user
+type Notification struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Type      string `gorm:"size:50"`
+	Subject   string `gorm:"size:200"`
+	Message   string `gorm:"type:text"`
+	Status    string `gorm:"size:20;index"`
+	CreatedAt time.Time
+	SentAt    *time.Time
+}
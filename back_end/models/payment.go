@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Payment intent statuses
+const (
+	PaymentStatusRequiresCapture   = "requires_capture" // authorized, funds held
+	PaymentStatusSucceeded         = "succeeded"        // captured
+	PaymentStatusFailed            = "failed"
+	PaymentStatusCancelled         = "cancelled" // authorization voided before capture
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+)
+
+// paymentTransitions lists the statuses a payment intent may move to from each status
+var paymentTransitions = map[string][]string{
+	PaymentStatusRequiresCapture:   {PaymentStatusSucceeded, PaymentStatusFailed, PaymentStatusCancelled},
+	PaymentStatusSucceeded:         {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+	PaymentStatusPartiallyRefunded: {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+}
+
+// CanTransitionPayment reports whether a payment intent in status from may move to status to
+func CanTransitionPayment(from, to string) bool {
+	for _, allowed := range paymentTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentIntent tracks the payment for a single booking or event booking through the gateway
+type PaymentIntent struct {
+	ID              uint   `gorm:"primaryKey"`
+	BookingID       *uint  `gorm:"index"`
+	EventBookingID  *uint  `gorm:"index"`
+	UserID          uint   `gorm:"not null"`
+	Amount          uint   `gorm:"not null"`
+	Currency        string `gorm:"size:3"`
+	Status          string `gorm:"size:30;index"`
+	Gateway         string `gorm:"size:30"`
+	AuthorizationID string `gorm:"size:100;index"`
+	ChargeID        string `gorm:"size:100;index"`
+	RefundedAmount  uint
+	FailureReason   string `gorm:"type:text"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
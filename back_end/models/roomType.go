@@ -0,0 +1,49 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RoomType is a kind of identical room at an accommodation, such as a small hotel's doubles.
+// Accommodations without room types are booked as a single unit.
+type RoomType struct {
+	ID              uint           `gorm:"primaryKey" json:"ID"`
+	AccommodationID uint           `gorm:"not null;index" json:"AccommodationID"`
+	Name            string         `gorm:"size:100" json:"Name"`
+	Description     string         `gorm:"type:text" json:"Description"`
+	Quantity        uint           `gorm:"not null" json:"Quantity"`     // how many of these rooms can be booked for the same night
+	MaxOccupancy    uint           `gorm:"not null" json:"MaxOccupancy"` // guests per room
+	PricePerNight   float64        `json:"PricePerNight"`
+	Facilities      pq.StringArray `gorm:"type:text[]" json:"Facilities"`
+	CreatedAt       time.Time      `json:"CreatedAt"`
+}
+
+// Validate checks a room type set by an owner, trimming its name
+func (r *RoomType) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Quantity == 0 {
+		return errors.New("quantity must be at least 1")
+	}
+	if r.MaxOccupancy == 0 {
+		return errors.New("max occupancy must be at least 1")
+	}
+	if r.PricePerNight <= 0 {
+		return errors.New("price per night must be positive")
+	}
+	return nil
+}
+
+// CheckGuests returns a StayError if the room doesn't take that many guests
+func (r RoomType) CheckGuests(guests GuestComposition) error {
+	if guests.Occupancy() > r.MaxOccupancy {
+		return stayError(StayErrorTooManyGuests, "%s rooms take at most %d guests", r.Name, r.MaxOccupancy)
+	}
+	return nil
+}
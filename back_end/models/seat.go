@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// SeatHoldDuration is how long seats picked by a guest are kept for them while they pay
+const SeatHoldDuration = 10 * time.Minute
+
+// MaxSeatsPerEvent caps the seats in one event's seat map
+const MaxSeatsPerEvent = 5000
+
+// Seat statuses as shown to guests
+const (
+	SeatStatusAvailable = "available"
+	SeatStatusHeld      = "held"   // picked by a guest who hasn't finished booking
+	SeatStatusBooked    = "booked" // taken by an active booking
+)
+
+// ErrSeatsUnavailable is returned when some of the seats picked are booked or held by someone else
+var ErrSeatsUnavailable = errors.New("some of the selected seats are no longer available")
+
+// Seat is a place in an event's seat map. A seat is booked while EventBookingID is set,
+// and held for a guest until HeldUntil.
+type Seat struct {
+	ID         uint   `gorm:"primaryKey" json:"ID"`
+	EventID    uint   `gorm:"not null;index" json:"EventID"`
+	Section    string `gorm:"size:100" json:"Section"` // matches the Section of the ticket tier selling it, if any
+	Row        string `gorm:"size:20" json:"Row"`
+	Label      string `gorm:"size:20" json:"Label"`
+	Wheelchair bool   `json:"Wheelchair"` // a wheelchair space
+	Companion  bool   `json:"Companion"`  // next to a wheelchair space, for a companion
+
+	EventBookingID *uint      `gorm:"index" json:"-"`
+	HeldBy         *uint      `json:"-"` // the user holding the seat
+	HeldUntil      *time.Time `json:"-"`
+}
+
+// SeatAvailability is a seat as shown to guests
+type SeatAvailability struct {
+	ID         uint
+	Section    string
+	Row        string
+	Label      string
+	Wheelchair bool
+	Companion  bool
+	Status     string
+}
+
+// Status is whether the seat can be picked at now
+func (s Seat) Status(now time.Time) string {
+	if s.EventBookingID != nil {
+		return SeatStatusBooked
+	}
+	if s.HeldUntil != nil && now.Before(*s.HeldUntil) {
+		return SeatStatusHeld
+	}
+	return SeatStatusAvailable
+}
+
+// Availability is the seat as shown to guests at now
+func (s Seat) Availability(now time.Time) SeatAvailability {
+	return SeatAvailability{
+		ID:         s.ID,
+		Section:    s.Section,
+		Row:        s.Row,
+		Label:      s.Label,
+		Wheelchair: s.Wheelchair,
+		Companion:  s.Companion,
+		Status:     s.Status(now),
+	}
+}
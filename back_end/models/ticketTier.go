@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors returned when a ticket tier can't be booked
+var (
+	ErrTierNotOnSale = errors.New("tickets in this tier are not on sale")
+	ErrTierSoldOut   = errors.New("not enough tickets are left in this tier")
+)
+
+// TicketTier is a kind of ticket to an event, such as General Admission, VIP or Early Bird.
+// Each tier caps its own sales; all tiers share the event's seats.
+type TicketTier struct {
+	ID          uint       `gorm:"primaryKey" json:"ID"`
+	EventID     uint       `gorm:"not null;index" json:"EventID"`
+	Name        string     `gorm:"size:100" json:"Name"`
+	Description string     `gorm:"type:text" json:"Description"`
+	Section     string     `gorm:"size:100" json:"Section"` // where the tier's seats are, such as Balcony; empty for anywhere
+	Price       uint       `gorm:"not null" json:"Price"`   // per ticket, in whole units like booking totals
+	Capacity    uint       `gorm:"not null" json:"Capacity"`
+	Sold        uint       `gorm:"not null;default:0" json:"Sold"` // tickets held by active bookings
+	SalesStart  *time.Time `json:"SalesStart"`                     // nil for on sale straight away
+	SalesEnd    *time.Time `json:"SalesEnd"`                       // nil for on sale until the event
+	CreatedAt   time.Time  `json:"CreatedAt"`
+}
+
+// TicketTierAvailability is a tier as shown to guests
+type TicketTierAvailability struct {
+	ID         uint
+	Name       string
+	Section    string `json:",omitempty"`
+	Price      uint
+	Available  uint       // tickets left in the tier
+	OnSale     bool       // whether the tier can be booked now
+	SalesStart *time.Time `json:",omitempty"`
+	SalesEnd   *time.Time `json:",omitempty"`
+}
+
+// Validate checks a tier set by an organizer, trimming its name
+func (t *TicketTier) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if t.Capacity == 0 {
+		return errors.New("capacity must be at least 1")
+	}
+	if t.Capacity < t.Sold {
+		return errors.New("capacity cannot be lower than tickets already sold")
+	}
+	if t.SalesStart != nil && t.SalesEnd != nil && !t.SalesEnd.After(*t.SalesStart) {
+		return errors.New("sales end must be after sales start")
+	}
+	return nil
+}
+
+// Available is how many tickets are left in the tier
+func (t TicketTier) Available() uint {
+	if t.Sold >= t.Capacity {
+		return 0
+	}
+	return t.Capacity - t.Sold
+}
+
+// OnSale reports whether the tier's sale window is open at now
+func (t TicketTier) OnSale(now time.Time) bool {
+	if t.SalesStart != nil && now.Before(*t.SalesStart) {
+		return false
+	}
+	return t.SalesEnd == nil || now.Before(*t.SalesEnd)
+}
+
+// CheckSale returns ErrTierNotOnSale or ErrTierSoldOut if tickets for guests can't be sold at now
+func (t TicketTier) CheckSale(guests uint, now time.Time) error {
+	if !t.OnSale(now) {
+		return ErrTierNotOnSale
+	}
+	if guests > t.Available() {
+		return ErrTierSoldOut
+	}
+	return nil
+}
+
+// Availability is the tier as shown to guests at now
+func (t TicketTier) Availability(now time.Time) TicketTierAvailability {
+	return TicketTierAvailability{
+		ID:         t.ID,
+		Name:       t.Name,
+		Section:    t.Section,
+		Price:      t.Price,
+		Available:  t.Available(),
+		OnSale:     t.OnSale(now),
+		SalesStart: t.SalesStart,
+		SalesEnd:   t.SalesEnd,
+	}
+}
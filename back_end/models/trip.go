@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Trip groups a user's stays and event bookings into one itinerary
+type Trip struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"not null;index"` // the user who planned the trip
+	Name        string    `gorm:"size:100"`
+	Destination string    `gorm:"size:100"`
+	StartDate   time.Time `gorm:"type:date;not null"`
+	EndDate     time.Time `gorm:"type:date;not null"`
+	CreatedAt   time.Time
+
+	Role string `gorm:"-" json:",omitempty"` // the requesting user's role, filled in when trips are listed
+}
+
+// Covers reports whether day falls between the trip's first and last day
+func (t Trip) Covers(day time.Time) bool {
+	day = CalendarDay(day)
+	return !day.Before(CalendarDay(t.StartDate)) && !day.After(CalendarDay(t.EndDate))
+}
+
+// CalendarDay drops the time of day, keeping the date as written so dates read back
+// from the database compare equal to dates parsed from requests
+func CalendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Roles on a shared trip. The user who planned the trip is its owner; others are invited as editors or viewers.
+const (
+	TripRoleOwner  = "owner"
+	TripRoleEditor = "editor" // can add their bookings to the trip
+	TripRoleViewer = "viewer"
+)
+
+// tripRoleRanks orders the roles so that each one can do everything the ones below it can
+var tripRoleRanks = map[string]int{TripRoleViewer: 1, TripRoleEditor: 2, TripRoleOwner: 3}
+
+// TripRoleAllows reports whether a member with role may do what needs the required role
+func TripRoleAllows(role, required string) bool {
+	return tripRoleRanks[role] > 0 && tripRoleRanks[role] >= tripRoleRanks[required]
+}
+
+// IsInvitableTripRole reports whether users can be invited to a trip with role
+func IsInvitableTripRole(role string) bool {
+	return role == TripRoleEditor || role == TripRoleViewer
+}
+
+// TripMember is someone invited to share a trip. Invitations go to an email address, so the invitee
+// needn't be registered yet; the member is linked to their account when they accept.
+type TripMember struct {
+	ID         uint       `gorm:"primaryKey"`
+	TripID     uint       `gorm:"not null;uniqueIndex:idx_trip_member_email"`
+	Email      string     `gorm:"size:255;not null;uniqueIndex:idx_trip_member_email"`
+	UserID     *uint      `gorm:"index"` // set on acceptance
+	Role       string     `gorm:"size:20;not null"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex" json:"-"` // SHA-256 of the invitation token, which only the invitee is given
+	InvitedBy  uint       `gorm:"not null"`
+	AcceptedAt *time.Time // nil while the invitation is pending
+	CreatedAt  time.Time
+}
+
+// IsAccepted reports whether the invitee has joined the trip
+func (m TripMember) IsAccepted() bool {
+	return m.AcceptedAt != nil
+}
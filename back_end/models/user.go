@@ -10,5 +10,10 @@ type User struct {
 	Email    string    `gorm:"uniqueIndex"`
 	Dob      time.Time `gorm:"not null" json:"dob"` // date of birth cannot be null
 	Password string
-	AvatarID string `gorm:"default:Marshmallow:`
+	AvatarID string `gorm:"default:Marshmallow"` // a preset, or AvatarUploaded
+	// AvatarKey is the blob store prefix of the user's uploaded avatar, one image per size under it
+	AvatarKey string `gorm:"size:255" json:"-"`
+	IsAdmin   bool   `gorm:"not null;default:false" json:"-"` // admins moderate reviews; granted directly in the database
+	// CalendarTokenHash is the SHA-256 of the secret in the user's calendar feed URL
+	CalendarTokenHash string `gorm:"size:64;index" json:"-"`
 }
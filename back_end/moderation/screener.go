@@ -0,0 +1,168 @@
+// Package moderation screens user generated text before it is published.
+package moderation
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Reasons a submission is held for review
+const (
+	ReasonProfanity    = "profanity"
+	ReasonLinks        = "links"
+	ReasonRepeatedText = "repeated_text"
+	ReasonDuplicate    = "duplicate"
+	ReasonBurst        = "burst"
+)
+
+//go:embed wordlist.txt
+var defaultWordList string
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// Submission is a piece of text to screen along with the author's recent history
+type Submission struct {
+	Text string
+	// Recent holds what the same author posted within the screener's burst window
+	Recent []string
+}
+
+// Verdict is the outcome of screening; anything flagged should wait for a moderator
+type Verdict struct {
+	Flagged bool
+	Reasons []string
+}
+
+func (v *Verdict) flag(reason string) {
+	v.Flagged = true
+	v.Reasons = append(v.Reasons, reason)
+}
+
+// Screener flags text containing listed words or that looks like spam
+type Screener struct {
+	words map[string]bool
+	// MaxLinks is how many links a submission may contain
+	MaxLinks int
+	// BurstLimit is how many earlier submissions within the burst window are allowed before posting looks automated
+	BurstLimit int
+	// MinRepeatWords is the shortest text checked for a single word making up most of it
+	MinRepeatWords int
+}
+
+// NewScreener returns a screener that flags the given words
+func NewScreener(words []string) *Screener {
+	s := &Screener{words: map[string]bool{}, MaxLinks: 0, BurstLimit: 3, MinRepeatWords: 6}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			s.words[word] = true
+		}
+	}
+	return s
+}
+
+// DefaultScreener returns a screener using the built in word list
+func DefaultScreener() *Screener {
+	words, _ := LoadWordList(strings.NewReader(defaultWordList))
+	return NewScreener(words)
+}
+
+// LoadWordListFile reads a word list from the file at path
+func LoadWordListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadWordList(f)
+}
+
+// LoadWordList reads one word per line, skipping blank lines and # comments
+func LoadWordList(r io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+// normalize makes near identical texts compare equal
+func normalize(text string) string {
+	return strings.Join(tokenize(text), " ")
+}
+
+// Screen checks a submission against the word list and the spam heuristics
+func (s *Screener) Screen(sub Submission) Verdict {
+	var verdict Verdict
+	tokens := tokenize(sub.Text)
+
+	for _, token := range tokens {
+		if s.words[token] {
+			verdict.flag(ReasonProfanity)
+			break
+		}
+	}
+
+	if len(linkPattern.FindAllString(sub.Text, -1)) > s.MaxLinks {
+		verdict.flag(ReasonLinks)
+	}
+
+	if isRepetitive(sub.Text, tokens, s.MinRepeatWords) {
+		verdict.flag(ReasonRepeatedText)
+	}
+
+	normalized := normalize(sub.Text)
+	for _, previous := range sub.Recent {
+		if normalized != "" && normalize(previous) == normalized {
+			verdict.flag(ReasonDuplicate)
+			break
+		}
+	}
+
+	if len(sub.Recent) >= s.BurstLimit {
+		verdict.flag(ReasonBurst)
+	}
+	return verdict
+}
+
+// isRepetitive reports whether one word makes up more than half of a longer text,
+// or a character is repeated ten or more times in a row
+func isRepetitive(text string, tokens []string, minWords int) bool {
+	if len(tokens) >= minWords {
+		counts := map[string]int{}
+		for _, token := range tokens {
+			counts[token]++
+			if counts[token]*2 > len(tokens) {
+				return true
+			}
+		}
+	}
+
+	run, last := 0, rune(0)
+	for _, r := range text {
+		if r == last && !unicode.IsSpace(r) {
+			run++
+			if run >= 10 {
+				return true
+			}
+		} else {
+			run, last = 1, r
+		}
+	}
+	return false
+}
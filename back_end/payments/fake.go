@@ -0,0 +1,139 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Payment tokens that make the fake gateway fail, so tests can exercise error paths
+const (
+	FakeTokenDecline     = "tok_decline"      // authorization is declined
+	FakeTokenCaptureFail = "tok_capture_fail" // authorization succeeds but capture is declined
+)
+
+// FakeGateway is a deterministic in-process gateway for tests and local runs. IDs are sequential and
+// every token other than the Fake* failure tokens succeeds. Payments are only kept in memory.
+type FakeGateway struct {
+	mu             sync.Mutex
+	secret         []byte
+	next           int
+	authorizations map[string]*fakeAuthorization
+	charges        map[string]*fakeCharge
+}
+
+type fakeAuthorization struct {
+	amount   uint
+	token    string
+	captured bool
+	voided   bool
+}
+
+type fakeCharge struct {
+	amount   uint
+	refunded uint
+}
+
+// NewFakeGateway returns a fake gateway that signs webhooks with secret
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{
+		secret:         []byte(secret),
+		authorizations: map[string]*fakeAuthorization{},
+		charges:        map[string]*fakeCharge{},
+	}
+}
+
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+func (g *FakeGateway) nextID(prefix string) string {
+	g.next++
+	return fmt.Sprintf("%s_%d", prefix, g.next)
+}
+
+func (g *FakeGateway) Authorize(req AuthorizeRequest) (*Authorization, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req.PaymentToken == "" || req.PaymentToken == FakeTokenDecline {
+		return nil, ErrPaymentDeclined
+	}
+	id := g.nextID("auth")
+	g.authorizations[id] = &fakeAuthorization{amount: req.Amount, token: req.PaymentToken}
+	return &Authorization{ID: id, Amount: req.Amount}, nil
+}
+
+func (g *FakeGateway) Capture(authorizationID string, amount uint) (*Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.authorizations[authorizationID]
+	if !ok {
+		return nil, ErrUnknownPayment
+	}
+	if auth.voided || auth.captured {
+		return nil, fmt.Errorf("authorization %s can no longer be captured", authorizationID)
+	}
+	if amount > auth.amount {
+		return nil, fmt.Errorf("cannot capture %d, only %d was authorized", amount, auth.amount)
+	}
+	if auth.token == FakeTokenCaptureFail {
+		return nil, ErrPaymentDeclined
+	}
+	auth.captured = true
+	id := g.nextID("ch")
+	g.charges[id] = &fakeCharge{amount: amount}
+	return &Charge{ID: id, Amount: amount}, nil
+}
+
+func (g *FakeGateway) Void(authorizationID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.authorizations[authorizationID]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if auth.captured {
+		return fmt.Errorf("authorization %s was already captured", authorizationID)
+	}
+	auth.voided = true
+	return nil
+}
+
+func (g *FakeGateway) Refund(chargeID string, amount uint) (*Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	charge, ok := g.charges[chargeID]
+	if !ok {
+		return nil, ErrUnknownPayment
+	}
+	if charge.refunded+amount > charge.amount {
+		return nil, fmt.Errorf("cannot refund %d, only %d left on charge %s", amount, charge.amount-charge.refunded, chargeID)
+	}
+	charge.refunded += amount
+	return &Refund{ID: g.nextID("re"), ChargeID: chargeID, Amount: amount}, nil
+}
+
+// Sign returns the signature the fake gateway expects on a webhook payload
+func (g *FakeGateway) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *FakeGateway) VerifyWebhook(payload []byte, signature string) (*WebhookEvent, error) {
+	if !hmac.Equal([]byte(g.Sign(payload)), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,178 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// newIntent builds an unsaved intent for amount; authorize saves it once the gateway has answered
+func newIntent(userID, amount uint, gateway PaymentGateway) *models.PaymentIntent {
+	intent := models.PaymentIntent{
+		UserID:   userID,
+		Amount:   amount,
+		Currency: Currency,
+		Status:   models.PaymentStatusRequiresCapture,
+		Gateway:  gateway.Name(),
+	}
+	return &intent
+}
+
+// authorize holds the intent's amount on the payment method and records the outcome, declined or not
+func authorize(intent *models.PaymentIntent, token, reference string, gateway PaymentGateway, db *gorm.DB) error {
+	var auth *Authorization
+	authErr := ErrMissingToken
+	if token != "" {
+		auth, authErr = gateway.Authorize(AuthorizeRequest{Amount: intent.Amount, Currency: intent.Currency, PaymentToken: token, Reference: reference})
+	}
+	if authErr != nil {
+		intent.Status = models.PaymentStatusFailed
+		intent.FailureReason = authErr.Error()
+	} else {
+		intent.AuthorizationID = auth.ID
+	}
+
+	if err := db.Create(intent).Error; err != nil {
+		return err
+	}
+	if authErr != nil {
+		return fmt.Errorf("%w: %w", ErrPaymentFailed, authErr)
+	}
+	return nil
+}
+
+// AuthorizeBooking holds the booking's total without charging it, as used for booking requests
+func AuthorizeBooking(booking *models.Booking, token string, gateway PaymentGateway, db *gorm.DB) (*models.PaymentIntent, error) {
+	intent := newIntent(booking.UserID, booking.TotalCost, gateway)
+	intent.BookingID = &booking.ID
+	return intent, authorize(intent, token, fmt.Sprintf("booking-%d", booking.ID), gateway, db)
+}
+
+// AuthorizeEventBooking holds the event booking's total until it is captured
+func AuthorizeEventBooking(booking *models.EventBooking, token string, gateway PaymentGateway, db *gorm.DB) (*models.PaymentIntent, error) {
+	intent := newIntent(booking.UserID, booking.TotalCost, gateway)
+	intent.EventBookingID = &booking.ID
+	return intent, authorize(intent, token, fmt.Sprintf("event-booking-%d", booking.ID), gateway, db)
+}
+
+// CaptureIntent charges the authorized amount
+func CaptureIntent(intent *models.PaymentIntent, gateway PaymentGateway, db *gorm.DB) error {
+	if intent.Status != models.PaymentStatusRequiresCapture {
+		return fmt.Errorf("%w: payment intent %d is %s, not awaiting capture", ErrPaymentFailed, intent.ID, intent.Status)
+	}
+
+	charge, err := gateway.Capture(intent.AuthorizationID, intent.Amount)
+	if err != nil {
+		intent.Status = models.PaymentStatusFailed
+		intent.FailureReason = err.Error()
+		if saveErr := db.Save(intent).Error; saveErr != nil {
+			fmt.Println("Error saving failed payment intent:", saveErr)
+		}
+		return fmt.Errorf("%w: %w", ErrPaymentFailed, err)
+	}
+
+	intent.ChargeID = charge.ID
+	intent.Status = models.PaymentStatusSucceeded
+	return db.Save(intent).Error
+}
+
+// VoidIntent releases an authorization that will never be captured
+func VoidIntent(intent *models.PaymentIntent, gateway PaymentGateway, db *gorm.DB) error {
+	if intent.Status != models.PaymentStatusRequiresCapture {
+		return nil
+	}
+	if err := gateway.Void(intent.AuthorizationID); err != nil {
+		return err
+	}
+	intent.Status = models.PaymentStatusCancelled
+	return db.Save(intent).Error
+}
+
+// RefundIntent returns amount of a captured payment to the customer
+func RefundIntent(intent *models.PaymentIntent, amount uint, gateway PaymentGateway, db *gorm.DB) error {
+	if amount == 0 {
+		return nil
+	}
+	if intent.ChargeID == "" {
+		return fmt.Errorf("payment intent %d was never captured", intent.ID)
+	}
+	if _, err := gateway.Refund(intent.ChargeID, amount); err != nil {
+		return err
+	}
+
+	intent.RefundedAmount += amount
+	intent.Status = models.PaymentStatusPartiallyRefunded
+	if intent.RefundedAmount >= intent.Amount {
+		intent.Status = models.PaymentStatusRefunded
+	}
+	return db.Save(intent).Error
+}
+
+// FindBookingIntent returns the latest payment intent for a booking, or nil for bookings made before payments existed
+func FindBookingIntent(bookingID uint, db *gorm.DB) (*models.PaymentIntent, error) {
+	return findIntent(db.Where("booking_id = ?", bookingID))
+}
+
+// FindEventBookingIntent returns the latest payment intent for an event booking, or nil if there is none
+func FindEventBookingIntent(eventBookingID uint, db *gorm.DB) (*models.PaymentIntent, error) {
+	return findIntent(db.Where("event_booking_id = ?", eventBookingID))
+}
+
+func findIntent(query *gorm.DB) (*models.PaymentIntent, error) {
+	var intent models.PaymentIntent
+	err := query.Order("id desc").First(&intent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// ApplyWebhook updates the payment intent a verified gateway event refers to
+// Events must name the authorization: declined payments are stored without one, so an empty ID would match them.
+// Events arrive out of order and can be replayed, so ones that would move the intent backwards are ignored.
+func ApplyWebhook(event *WebhookEvent, db *gorm.DB) error {
+	if event.AuthorizationID == "" {
+		return errors.New("webhook event has no authorization_id")
+	}
+	var intent models.PaymentIntent
+	if err := db.Where("authorization_id = ?", event.AuthorizationID).First(&intent).Error; err != nil {
+		return err
+	}
+	if event.ChargeID != "" && intent.ChargeID != "" && event.ChargeID != intent.ChargeID {
+		return fmt.Errorf("charge %s does not belong to authorization %s", event.ChargeID, event.AuthorizationID)
+	}
+
+	var status string
+	switch event.Type {
+	case WebhookChargeSucceeded:
+		status = models.PaymentStatusSucceeded
+	case WebhookChargeFailed:
+		status = models.PaymentStatusFailed
+	case WebhookChargeRefunded:
+		status = models.PaymentStatusPartiallyRefunded
+		if event.Amount >= intent.Amount {
+			status = models.PaymentStatusRefunded
+		}
+	default:
+		return fmt.Errorf("unsupported webhook event %q", event.Type)
+	}
+	if !models.CanTransitionPayment(intent.Status, status) ||
+		(event.Type == WebhookChargeRefunded && event.Amount <= intent.RefundedAmount) {
+		fmt.Printf("Ignoring %s event for payment intent %d, which is %s\n", event.Type, intent.ID, intent.Status)
+		return nil
+	}
+
+	intent.Status = status
+	if event.Type == WebhookChargeSucceeded && event.ChargeID != "" {
+		intent.ChargeID = event.ChargeID
+	}
+	if event.Type == WebhookChargeRefunded {
+		intent.RefundedAmount = event.Amount
+	}
+	return db.Save(&intent).Error
+}
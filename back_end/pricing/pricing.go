@@ -0,0 +1,347 @@
+// Package pricing works out what a stay costs, night by night, from an accommodation's price and pricing rules.
+package pricing
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidStay is returned when check-out isn't after check-in
+var ErrInvalidStay = errors.New("check-out must be after check-in")
+
+const dateLayout = "2006-01-02"
+
+// Rules adjust an accommodation's price per night. The zero value leaves every night at the listing's price.
+type Rules struct {
+	// DatePrices set the price per night of single dates (YYYY-MM-DD), over any season or weekday adjustment. Auto-applied price suggestions are kept here.
+	DatePrices map[string]float64 `json:"date_prices,omitempty"`
+	// Seasons set a different price per night for date ranges; when seasons overlap, the one listed first wins
+	Seasons []Season `json:"seasons,omitempty"`
+	// WeekdayPercents raise or lower the price of nights starting on a weekday, such as {"friday": 20, "saturday": 20}
+	WeekdayPercents map[string]int `json:"weekday_percents,omitempty"`
+	// StayDiscounts take a percentage off the nights of longer stays; the largest one the stay qualifies for applies
+	StayDiscounts []StayDiscount `json:"stay_discounts,omitempty"`
+	// IncludedGuests are covered by the price per night; each further guest pays ExtraGuestFee a night. 0 includes everyone.
+	// Infants aren't counted, and adults take the included places first.
+	IncludedGuests int     `json:"included_guests,omitempty"`
+	ExtraGuestFee  float64 `json:"extra_guest_fee,omitempty"`
+	// ChildDiscountPercent takes a percentage off the extra guest fee of children
+	ChildDiscountPercent int `json:"child_discount_percent,omitempty"`
+	// PetFee is charged once per pet per stay
+	PetFee float64 `json:"pet_fee,omitempty"`
+	// CleaningFee is charged once per stay
+	CleaningFee float64 `json:"cleaning_fee,omitempty"`
+}
+
+// Season is a price per night for the nights from Start to End, both included
+type Season struct {
+	Name  string `json:"name"`
+	Start string `json:"start"` // YYYY-MM-DD
+	End   string `json:"end"`   // YYYY-MM-DD
+	// Yearly seasons repeat every year, ignoring the year in Start and End. They may wrap over the new year.
+	Yearly        bool    `json:"yearly,omitempty"`
+	PricePerNight float64 `json:"price_per_night"`
+}
+
+// StayDiscount takes Percent off the nights of stays of at least MinNights
+type StayDiscount struct {
+	MinNights int `json:"min_nights"`
+	Percent   int `json:"percent"`
+}
+
+// Party is who a stay is priced for
+type Party struct {
+	Adults   int
+	Children int
+	Infants  int
+	Pets     int
+}
+
+// Guests counts the adults and children; infants stay free
+func (p Party) Guests() int {
+	return p.Adults + p.Children
+}
+
+// Night is the price of one night of a stay
+type Night struct {
+	Date           time.Time `json:"date"`
+	Season         string    `json:"season,omitempty"`          // the season whose price was used, if any
+	Rate           float64   `json:"rate"`                      // the listing's or season's price per night
+	WeekdayPercent int       `json:"weekday_percent,omitempty"` // weekday adjustment applied to the rate
+	ExtraGuestFee  float64   `json:"extra_guest_fee,omitempty"` // for the adults and children beyond those included
+	Price          float64   `json:"price"`
+}
+
+// Quote is the cost of a stay. Amounts are in currency units, rounded to cents.
+type Quote struct {
+	CheckIn  time.Time `json:"check_in"`
+	CheckOut time.Time `json:"check_out"`
+	Guests   int       `json:"guests"` // adults and children
+	Pets     int       `json:"pets,omitempty"`
+	Nights   []Night   `json:"nights"`
+	// Subtotal is the sum of the nights, before the stay discount
+	Subtotal        float64 `json:"subtotal"`
+	DiscountPercent int     `json:"discount_percent,omitempty"`
+	Discount        float64 `json:"discount,omitempty"`
+	CleaningFee     float64 `json:"cleaning_fee,omitempty"`
+	PetFee          float64 `json:"pet_fee,omitempty"` // for all the pets
+	Total           float64 `json:"total"`
+}
+
+// TotalCost is the total in whole currency units, the way bookings are charged
+func (q Quote) TotalCost() uint {
+	return uint(math.Round(q.Total))
+}
+
+// Quote prices a stay from checkIn to checkOut for guests adults at basePrice a night, the listing's price.
+// Only the dates of checkIn and checkOut are used.
+func (r Rules) Quote(basePrice float64, checkIn, checkOut time.Time, guests int) (*Quote, error) {
+	return r.QuoteParty(basePrice, checkIn, checkOut, Party{Adults: guests})
+}
+
+// QuoteParty prices a stay for a party of adults, children, infants and pets
+func (r Rules) QuoteParty(basePrice float64, checkIn, checkOut time.Time, party Party) (*Quote, error) {
+	checkIn, checkOut = calendarDay(checkIn), calendarDay(checkOut)
+	if !checkOut.After(checkIn) {
+		return nil, ErrInvalidStay
+	}
+	if party.Guests() < 1 {
+		party.Adults = 1
+	}
+
+	// Children fill the places beyond those included before adults do, as adults take the included places first
+	extraAdults, extraChildren := 0, 0
+	if r.IncludedGuests > 0 && party.Guests() > r.IncludedGuests {
+		extra := party.Guests() - r.IncludedGuests
+		extraChildren = min(extra, party.Children)
+		extraAdults = extra - extraChildren
+	}
+	extraGuestCents := toCents(r.ExtraGuestFee)*int64(extraAdults) +
+		percentOf(toCents(r.ExtraGuestFee), 100-r.ChildDiscountPercent)*int64(extraChildren)
+
+	quote := &Quote{CheckIn: checkIn, CheckOut: checkOut, Guests: party.Guests(), Pets: party.Pets, Nights: []Night{}}
+	var subtotal int64
+	for date := checkIn; date.Before(checkOut); date = date.AddDate(0, 0, 1) {
+		night := Night{Date: date, Rate: basePrice}
+		if price, ok := r.DatePrices[date.Format(dateLayout)]; ok {
+			// A date's own price is final; weekday adjustments don't apply to it
+			night.Rate = price
+		} else {
+			if season, ok := r.seasonOn(date); ok {
+				night.Season, night.Rate = season.Name, season.PricePerNight
+			}
+			night.WeekdayPercent = r.WeekdayPercents[strings.ToLower(date.Weekday().String())]
+		}
+
+		cents := percentOf(toCents(night.Rate), 100+night.WeekdayPercent) + extraGuestCents
+		night.ExtraGuestFee = fromCents(extraGuestCents)
+		night.Price = fromCents(cents)
+		quote.Nights = append(quote.Nights, night)
+		subtotal += cents
+	}
+
+	quote.DiscountPercent = r.stayDiscount(len(quote.Nights))
+	discount := percentOf(subtotal, quote.DiscountPercent)
+	cleaning := toCents(r.CleaningFee)
+	pets := toCents(r.PetFee) * int64(party.Pets)
+	quote.Subtotal = fromCents(subtotal)
+	quote.Discount = fromCents(discount)
+	quote.CleaningFee = fromCents(cleaning)
+	quote.PetFee = fromCents(pets)
+	quote.Total = fromCents(subtotal - discount + cleaning + pets)
+	return quote, nil
+}
+
+// seasonOn finds the first season covering the night starting on date
+func (r Rules) seasonOn(date time.Time) (Season, bool) {
+	for _, season := range r.Seasons {
+		start, startErr := time.Parse(dateLayout, season.Start)
+		end, endErr := time.Parse(dateLayout, season.End)
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		if season.Yearly {
+			day, first, last := monthDay(date), monthDay(start), monthDay(end)
+			if (first <= last && day >= first && day <= last) || (first > last && (day >= first || day <= last)) {
+				return season, true
+			}
+			continue
+		}
+		if !date.Before(start) && !date.After(end) {
+			return season, true
+		}
+	}
+	return Season{}, false
+}
+
+// stayDiscount is the percentage off for a stay of the given number of nights
+func (r Rules) stayDiscount(nights int) int {
+	best, percent := 0, 0
+	for _, discount := range r.StayDiscounts {
+		if nights >= discount.MinNights && discount.MinNights > best {
+			best, percent = discount.MinNights, discount.Percent
+		}
+	}
+	return percent
+}
+
+// Validate checks rules set by an owner, lowercasing weekday names
+func (r *Rules) Validate() error {
+	for date, price := range r.DatePrices {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("date price %q: dates must be in YYYY-MM-DD format", date)
+		}
+		if price <= 0 {
+			return fmt.Errorf("date price %s: price must be positive", date)
+		}
+	}
+	for i, season := range r.Seasons {
+		start, startErr := time.Parse(dateLayout, season.Start)
+		end, endErr := time.Parse(dateLayout, season.End)
+		if startErr != nil || endErr != nil {
+			return fmt.Errorf("season %d: start and end must be dates in YYYY-MM-DD format", i+1)
+		}
+		if !season.Yearly && end.Before(start) {
+			return fmt.Errorf("season %d: end cannot be before start", i+1)
+		}
+		if season.PricePerNight <= 0 {
+			return fmt.Errorf("season %d: price_per_night must be positive", i+1)
+		}
+	}
+
+	percents := make(map[string]int, len(r.WeekdayPercents))
+	for name, percent := range r.WeekdayPercents {
+		day := strings.ToLower(strings.TrimSpace(name))
+		if !isWeekday(day) {
+			return fmt.Errorf("unknown weekday %q", name)
+		}
+		if percent <= -100 {
+			return fmt.Errorf("%s cannot be discounted by 100%% or more", day)
+		}
+		percents[day] = percent
+	}
+	if len(percents) > 0 {
+		r.WeekdayPercents = percents
+	}
+
+	seen := map[int]bool{}
+	for _, discount := range r.StayDiscounts {
+		if discount.MinNights < 2 {
+			return errors.New("stay discounts need min_nights of at least 2")
+		}
+		if discount.Percent <= 0 || discount.Percent > 100 {
+			return errors.New("stay discount percent must be between 1 and 100")
+		}
+		if seen[discount.MinNights] {
+			return fmt.Errorf("duplicate stay discount for %d nights", discount.MinNights)
+		}
+		seen[discount.MinNights] = true
+	}
+	sort.Slice(r.StayDiscounts, func(i, j int) bool { return r.StayDiscounts[i].MinNights < r.StayDiscounts[j].MinNights })
+
+	if r.IncludedGuests < 0 || r.ExtraGuestFee < 0 || r.CleaningFee < 0 || r.PetFee < 0 {
+		return errors.New("guests and fees cannot be negative")
+	}
+	if r.ChildDiscountPercent < 0 || r.ChildDiscountPercent > 100 {
+		return errors.New("child discount percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// Scaled returns the rules for another base price: season and date prices, which are set against
+// basePrice, are scaled by price/basePrice, so a season 50% over the listing's price is 50% over the
+// new price too. Other rules are relative or per stay and are kept as they are. Without a basePrice to
+// compare against, seasons and date prices are dropped.
+func (r Rules) Scaled(basePrice, price float64) Rules {
+	if basePrice <= 0 {
+		r.Seasons, r.DatePrices = nil, nil
+		return r
+	}
+	scale := func(amount float64) float64 {
+		return fromCents(int64(math.Round(float64(toCents(amount)) * price / basePrice)))
+	}
+	if len(r.Seasons) > 0 {
+		seasons := make([]Season, len(r.Seasons))
+		for i, season := range r.Seasons {
+			season.PricePerNight = scale(season.PricePerNight)
+			seasons[i] = season
+		}
+		r.Seasons = seasons
+	}
+	if len(r.DatePrices) > 0 {
+		prices := make(map[string]float64, len(r.DatePrices))
+		for date, amount := range r.DatePrices {
+			prices[date] = scale(amount)
+		}
+		r.DatePrices = prices
+	}
+	return r
+}
+
+// IsZero reports whether the rules leave every night at the listing's price
+func (r Rules) IsZero() bool {
+	return len(r.DatePrices) == 0 && len(r.Seasons) == 0 && len(r.WeekdayPercents) == 0 && len(r.StayDiscounts) == 0 &&
+		r.IncludedGuests == 0 && r.ExtraGuestFee == 0 && r.ChildDiscountPercent == 0 && r.CleaningFee == 0 && r.PetFee == 0
+}
+
+// Value implements driver.Valuer. Accommodations without rules store NULL.
+func (r Rules) Value() (driver.Value, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (r *Rules) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = Rules{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("cannot scan %T into pricing.Rules", value)
+}
+
+func isWeekday(name string) bool {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.ToLower(day.String()) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// monthDay orders days within a year, ignoring the year
+func monthDay(t time.Time) int {
+	return int(t.Month())*100 + t.Day()
+}
+
+// Amounts are added up in cents so rounding doesn't drift over long stays
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// percentOf takes percent of cents, rounding halves away from zero
+func percentOf(cents int64, percent int) int64 {
+	return int64(math.Round(float64(cents) * float64(percent) / 100))
+}
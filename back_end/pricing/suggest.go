@@ -0,0 +1,159 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	// SuggestionDays is how many nights ahead prices are suggested for
+	SuggestionDays = 90
+	// demandWindow is how many nights either side of a night count towards the listing's own occupancy
+	demandWindow = 3
+)
+
+// SuggestionSettings are an owner's bounds on suggested prices
+type SuggestionSettings struct {
+	Floor   float64 `json:"floor"`   // lowest price to suggest, 0 for none
+	Ceiling float64 `json:"ceiling"` // highest price to suggest, 0 for none
+	// AutoApply keeps the listing's DatePrices set to the suggestions, refreshed every day
+	AutoApply bool `json:"auto_apply"`
+}
+
+// Validate checks settings set by an owner
+func (s SuggestionSettings) Validate() error {
+	if s.Floor < 0 || s.Ceiling < 0 {
+		return errors.New("floor and ceiling cannot be negative")
+	}
+	if s.Ceiling > 0 && s.Ceiling < s.Floor {
+		return errors.New("ceiling cannot be below the floor")
+	}
+	return nil
+}
+
+// Stay is a booked date range; the check-out night isn't booked
+type Stay struct {
+	CheckIn  time.Time
+	CheckOut time.Time
+}
+
+// Demand is how booked a night is, from 0 to 1
+type Demand struct {
+	// Occupancy is the share of the listing's nights booked in the week around the night
+	Occupancy float64
+	// MarketOccupancy is the share of Comparables, listings in the same location, booked that night
+	MarketOccupancy float64
+	Comparables     int
+}
+
+// Suggestion is a proposed price for one night
+type Suggestion struct {
+	Date            time.Time `json:"date"`
+	CurrentPrice    float64   `json:"current_price"` // what the night costs now
+	SuggestedPrice  float64   `json:"suggested_price"`
+	Occupancy       float64   `json:"occupancy"`
+	MarketOccupancy float64   `json:"market_occupancy"`
+	// Booked nights are already sold, so their price isn't changed when suggestions are applied
+	Booked bool `json:"booked"`
+}
+
+// SuggestPrice raises listPrice by up to 30% when demand is high and lowers it by up to 20% when it's low.
+// Nights more than two weeks away are lowered by half as much, as they still have time to fill.
+// The price is rounded to whole currency units and kept within the owner's floor and ceiling.
+func SuggestPrice(listPrice float64, demand Demand, daysAhead int, settings SuggestionSettings) float64 {
+	score := demand.Occupancy
+	if demand.Comparables > 0 {
+		score = 0.4*demand.Occupancy + 0.6*demand.MarketOccupancy
+	}
+
+	adjustment := (score - 0.5) * 0.6
+	if score < 0.5 {
+		adjustment = (score - 0.5) * 0.4
+		if daysAhead > 14 {
+			adjustment /= 2
+		}
+	}
+
+	price := math.Round(listPrice * (1 + adjustment))
+	if settings.Floor > 0 && price < settings.Floor {
+		price = settings.Floor
+	}
+	if settings.Ceiling > 0 && price > settings.Ceiling {
+		price = settings.Ceiling
+	}
+	return price
+}
+
+// Suggest proposes a price for each of the SuggestionDays nights from the day of from.
+// bookings are the listing's own; market holds the bookings of each of the comparables listings in the same
+// location that have any. Suggestions start from the price the rules give without DatePrices, so applied
+// suggestions don't compound.
+func (r Rules) Suggest(basePrice float64, from time.Time, bookings []Stay, market map[uint][]Stay, comparables int, settings SuggestionSettings) []Suggestion {
+	from = calendarDay(from)
+	start, end := from.AddDate(0, 0, -demandWindow), from.AddDate(0, 0, SuggestionDays+demandWindow)
+
+	booked := bookedNights(bookings, start, end)
+	marketBooked := map[string]int{}
+	for _, stays := range market {
+		for night := range bookedNights(stays, start, end) {
+			marketBooked[night]++
+		}
+	}
+
+	listRules := r
+	listRules.DatePrices = nil
+	suggestions := make([]Suggestion, 0, SuggestionDays)
+	for i := 0; i < SuggestionDays; i++ {
+		date := from.AddDate(0, 0, i)
+		key := date.Format(dateLayout)
+
+		nearby := 0
+		for d := -demandWindow; d <= demandWindow; d++ {
+			if booked[date.AddDate(0, 0, d).Format(dateLayout)] {
+				nearby++
+			}
+		}
+		demand := Demand{Occupancy: float64(nearby) / float64(2*demandWindow+1), Comparables: comparables}
+		if comparables > 0 {
+			demand.MarketOccupancy = float64(marketBooked[key]) / float64(comparables)
+		}
+
+		suggestions = append(suggestions, Suggestion{
+			Date:            date,
+			CurrentPrice:    r.nightPrice(basePrice, date),
+			SuggestedPrice:  SuggestPrice(listRules.nightPrice(basePrice, date), demand, i, settings),
+			Occupancy:       math.Round(demand.Occupancy*100) / 100,
+			MarketOccupancy: math.Round(demand.MarketOccupancy*100) / 100,
+			Booked:          booked[key],
+		})
+	}
+	return suggestions
+}
+
+// nightPrice is the price of the night starting on date for one guest, before fees and discounts
+func (r Rules) nightPrice(basePrice float64, date time.Time) float64 {
+	quote, err := r.Quote(basePrice, date, date.AddDate(0, 0, 1), 1)
+	if err != nil {
+		return basePrice
+	}
+	return quote.Nights[0].Price - quote.Nights[0].ExtraGuestFee
+}
+
+// bookedNights lists the nights of stays between start and end
+func bookedNights(stays []Stay, start, end time.Time) map[string]bool {
+	nights := map[string]bool{}
+	for _, stay := range stays {
+		first, last := calendarDay(stay.CheckIn), calendarDay(stay.CheckOut)
+		if first.Before(start) {
+			first = start
+		}
+		if last.After(end) {
+			last = end
+		}
+		for date := first; date.Before(last); date = date.AddDate(0, 0, 1) {
+			nights[date.Format(dateLayout)] = true
+		}
+	}
+	return nights
+}
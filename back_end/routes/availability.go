@@ -0,0 +1,238 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// BlackoutRequest closes an accommodation for a range of dates
+type BlackoutRequest struct {
+	StartDate string `json:"StartDate"` // YYYY-MM-DD, the first night closed
+	EndDate   string `json:"EndDate"`   // YYYY-MM-DD, the first night open again
+	Reason    string `json:"Reason"`    // shown to the owner only, such as "Maintenance"
+}
+
+// StayErrorResponse is the body returned when a stay breaks the accommodation's availability rules
+type StayErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeStayError sends a StayError with a status matching its code
+func writeStayError(w http.ResponseWriter, err *models.StayError) {
+	status := http.StatusUnprocessableEntity
+	switch err.Code {
+	case models.StayErrorInvalidDates, models.StayErrorInvalidGuests:
+		status = http.StatusBadRequest
+	case models.StayErrorBlackout, models.StayErrorUnavailable, models.StayErrorPriceChanged:
+		status = http.StatusConflict
+	case models.StayErrorUnderage:
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, StayErrorResponse{Code: err.Code, Message: err.Message})
+}
+
+// CheckStayAvailability reports why a stay can't be booked at the accommodation, as a *models.StayError,
+// checking its availability rules, then its blackout dates and bookings. roomType is the room booked at
+// accommodations with room types, nil for the whole accommodation. Other errors are database failures.
+func CheckStayAvailability(accommodation models.Accommodation, roomType *models.RoomType, checkIn, checkOut, now time.Time, db *gorm.DB) error {
+	if err := accommodation.AvailabilityRules.CheckStay(checkIn, checkOut, now); err != nil {
+		return err
+	}
+
+	var overlapping bool
+	var err error
+	if roomType != nil {
+		overlapping, err = IsRoomTypeFull(*roomType, checkIn, checkOut, now, db)
+	} else {
+		overlapping, err = HasOverlappingBooking(accommodation.ID, checkIn, checkOut, now, db)
+	}
+	if err != nil || !overlapping {
+		return err
+	}
+	// Only tell blackouts apart once the dates are known to be taken, so free dates cost no extra query
+	var blackouts int64
+	if err := overlappingBlackouts(db, accommodation.ID, checkIn, checkOut).Count(&blackouts).Error; err != nil {
+		return err
+	}
+	if blackouts > 0 {
+		return &models.StayError{Code: models.StayErrorBlackout, Message: "The accommodation is closed for some of the selected dates"}
+	}
+	if roomType != nil {
+		return &models.StayError{Code: models.StayErrorUnavailable, Message: "No " + roomType.Name + " rooms are left for the selected dates"}
+	}
+	return &models.StayError{Code: models.StayErrorUnavailable, Message: "Accommodation is not available for the selected dates"}
+}
+
+// overlappingBlackouts selects the owner's blackout dates overlapping a stay
+func overlappingBlackouts(db *gorm.DB, accommodationID uint, checkIn, checkOut time.Time) *gorm.DB {
+	return db.Model(&models.BlockedDateRange{}).
+		Where("accommodation_id = ? AND source_id = 0 AND start_date < ? AND end_date > ?",
+			accommodationID, models.CalendarDay(checkOut), models.CalendarDay(checkIn))
+}
+
+// UpdateAvailabilityRules lets an owner limit which stays can be booked
+// @Summary Update availability rules
+// @Description Set minimum and maximum stays, a longer minimum for stays over a weekend, the weekdays guests can check in and out on, how much notice is needed and how far ahead bookings open. Zero values and empty lists mean no limit.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param rules body models.AvailabilityRules true "Availability rules"
+// @Success 200 {object} models.AvailabilityRules "Rules updated"
+// @Failure 400 {object} map[string]string "Invalid rules"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/availability-rules [put]
+func UpdateAvailabilityRules(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var rules models.AvailabilityRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := rules.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		accommodation.AvailabilityRules = rules
+		if err := db.Model(accommodation).Select("AvailabilityRules").Updates(accommodation).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update availability rules")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+
+// ListBlackouts lists the dates an owner closed their accommodation for
+// @Summary List blackout dates
+// @Description List the date ranges you closed the accommodation for, including past ones
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Success 200 {array} models.BlockedDateRange "Blackout dates"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/blackouts [get]
+func ListBlackouts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		blackouts := []models.BlockedDateRange{}
+		err := db.Where("accommodation_id = ? AND source_id = 0", accommodation.ID).Order("start_date").Find(&blackouts).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch blackout dates")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, blackouts)
+	}
+}
+
+// AddBlackout closes an accommodation for a range of dates
+// @Summary Add blackout dates
+// @Description Close the accommodation for maintenance or personal use. Existing bookings aren't affected; new bookings can't include the closed nights.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param blackout body BlackoutRequest true "Dates to close"
+// @Success 201 {object} models.BlockedDateRange "Dates closed"
+// @Failure 400 {object} map[string]string "Invalid dates"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/blackouts [post]
+func AddBlackout(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var req BlackoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		start, startErr := time.Parse("2006-01-02", req.StartDate)
+		end, endErr := time.Parse("2006-01-02", req.EndDate)
+		if startErr != nil || endErr != nil {
+			writeMessage(w, http.StatusBadRequest, "StartDate and EndDate must be dates in YYYY-MM-DD format")
+			return
+		}
+		if !end.After(start) {
+			writeMessage(w, http.StatusBadRequest, "EndDate must be after StartDate")
+			return
+		}
+
+		blackout := models.BlockedDateRange{
+			AccommodationID: accommodation.ID,
+			Summary:         truncate(req.Reason, 255),
+			StartDate:       start,
+			EndDate:         end,
+		}
+		if err := db.Create(&blackout).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to add blackout dates")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, blackout)
+	}
+}
+
+// DeleteBlackout reopens dates an owner closed
+// @Summary Remove blackout dates
+// @Description Reopen a date range you closed, so it can be booked again
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param blackoutId path int true "Blackout ID"
+// @Success 200 {object} map[string]string "Dates reopened"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation or blackout not found"
+// @Router /owner/accommodations/{id}/blackouts/{blackoutId} [delete]
+func DeleteBlackout(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		blackoutID, ok := pathID(w, r, "blackoutId")
+		if !ok {
+			return
+		}
+		// Dates blocked by synced calendars can't be removed here; they come back on the next sync
+		result := db.Where("accommodation_id = ? AND source_id = 0", accommodation.ID).
+			Delete(&models.BlockedDateRange{}, blackoutID)
+		if result.Error != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to remove blackout dates")
+			fmt.Println(result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			writeMessage(w, http.StatusNotFound, "Blackout not found")
+			return
+		}
+		writeMessage(w, http.StatusOK, "Dates reopened")
+	}
+}
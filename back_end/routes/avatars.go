@@ -0,0 +1,215 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"gorm.io/gorm"
+	"roam.io/media"
+	"roam.io/models"
+)
+
+// DefaultAvatarSize is the size served when none is asked for
+const DefaultAvatarSize = 128
+
+// AvatarURL returns where the user's avatar can be loaded from: the uploaded image, or the preset
+// image served by the web app. The uploaded avatar's URL changes with each upload so caches refresh.
+func AvatarURL(user models.User) string {
+	if user.AvatarID == models.AvatarUploaded && user.AvatarKey != "" {
+		return fmt.Sprintf("%s/users/%d/avatar?v=%s", apiBaseURL, user.ID, path.Base(user.AvatarKey))
+	}
+	return presetAvatarURL(user.AvatarID)
+}
+
+// presetAvatarURL returns the web app's image for a preset, falling back to the default preset
+func presetAvatarURL(avatarID string) string {
+	if !models.IsAvatarPreset(avatarID) {
+		avatarID = models.DefaultAvatarID
+	}
+	return webBaseURL + "/avatars/" + avatarID + ".png"
+}
+
+// avatarImageKey is the blob key of one size of an uploaded avatar
+func avatarImageKey(prefix string, size int) string {
+	return fmt.Sprintf("%s/%d.png", prefix, size)
+}
+
+// deleteAvatarImages removes every size of a replaced avatar. Failures only leave unused blobs behind.
+func deleteAvatarImages(prefix string) {
+	if prefix == "" {
+		return
+	}
+	for _, size := range models.AvatarSizes {
+		if err := mediaStore.Delete(avatarImageKey(prefix, size)); err != nil {
+			fmt.Println("Error deleting avatar image:", err)
+		}
+	}
+}
+
+// UploadUserAvatarHandler replaces the user's avatar with an uploaded image
+// @Summary Upload avatar
+// @Description Upload a JPEG, PNG or GIF of up to 10 MB as the "file" form field. It is cropped to a square and stored at 64, 128 and 256 pixels.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Avatar image"
+// @Success 200 {object} map[string]string "Avatar updated successfully, with its avatar_url"
+// @Failure 400 {object} map[string]string "Missing or unreadable image"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 413 {object} map[string]string "Image too large"
+// @Failure 415 {object} map[string]string "Not a supported image type"
+// @Router /users/avatar [post]
+func UploadUserAvatarHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			writeMessage(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, media.MaxUploadSize+1<<20)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeMessage(w, http.StatusRequestEntityTooLarge, media.ErrTooLarge.Error())
+				return
+			}
+			writeMessage(w, http.StatusBadRequest, "Missing file")
+			return
+		}
+		defer file.Close()
+
+		img, _, err := media.Decode(file)
+		if err != nil {
+			switch {
+			case errors.Is(err, media.ErrTooLarge):
+				writeMessage(w, http.StatusRequestEntityTooLarge, err.Error())
+			case errors.Is(err, media.ErrUnsupportedType):
+				writeMessage(w, http.StatusUnsupportedMediaType, err.Error())
+			case errors.Is(err, media.ErrInvalidImage):
+				writeMessage(w, http.StatusBadRequest, err.Error())
+			default:
+				writeMessage(w, http.StatusInternalServerError, "Failed to process image")
+				fmt.Println(err)
+			}
+			return
+		}
+
+		// A fresh prefix per upload gives the avatar a new URL
+		prefix, err := newMediaKey()
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to store avatar")
+			fmt.Println(err)
+			return
+		}
+		prefix = fmt.Sprintf("avatars/%d/%s", userID, path.Base(prefix))
+
+		// Avatars are always PNGs so transparent images keep their transparency
+		for _, size := range models.AvatarSizes {
+			encoded, err := media.Encode(media.Square(img, size), "image/png")
+			if err == nil {
+				err = mediaStore.Put(avatarImageKey(prefix, size), bytes.NewReader(encoded.Data))
+			}
+			if err != nil {
+				deleteAvatarImages(prefix)
+				writeMessage(w, http.StatusInternalServerError, "Failed to store avatar")
+				fmt.Println(err)
+				return
+			}
+		}
+
+		previousKey := user.AvatarKey
+		user.AvatarID = models.AvatarUploaded
+		user.AvatarKey = prefix
+		if err := db.Model(&user).Select("AvatarID", "AvatarKey").Updates(&user).Error; err != nil {
+			deleteAvatarImages(prefix)
+			writeMessage(w, http.StatusInternalServerError, "Failed to update avatar")
+			fmt.Println(err)
+			return
+		}
+		deleteAvatarImages(previousKey)
+
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Avatar updated successfully", "avatar_url": AvatarURL(user)})
+	}
+}
+
+// GetUserAvatarHandler serves a user's avatar
+// @Summary Get user avatar
+// @Description Get a user's uploaded avatar at 64, 128 or 256 pixels. Users with a preset avatar are redirected to the preset image.
+// @Tags users
+// @Produce image/png
+// @Param id path int true "User ID"
+// @Param size query int false "64, 128 or 256" default(128)
+// @Success 200 {file} binary "Avatar"
+// @Success 302 {string} string "Redirect to the preset avatar"
+// @Failure 400 {object} map[string]string "Unsupported size"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /users/{id}/avatar [get]
+func GetUserAvatarHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := DefaultAvatarSize
+		if value := r.URL.Query().Get("size"); value != "" {
+			size, _ = strconv.Atoi(value)
+		}
+		supported := false
+		for _, s := range models.AvatarSizes {
+			supported = supported || s == size
+		}
+		if !supported {
+			writeMessage(w, http.StatusBadRequest, "size must be 64, 128 or 256")
+			return
+		}
+
+		var user models.User
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Select("id", "avatar_id", "avatar_key").First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "User not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch user")
+			fmt.Println(err)
+			return
+		}
+
+		if user.AvatarID != models.AvatarUploaded || user.AvatarKey == "" {
+			http.Redirect(w, r, presetAvatarURL(user.AvatarID), http.StatusFound)
+			return
+		}
+
+		blob, err := mediaStore.Open(avatarImageKey(user.AvatarKey, size))
+		if err != nil {
+			if errors.Is(err, media.ErrBlobNotFound) {
+				http.Redirect(w, r, presetAvatarURL(models.DefaultAvatarID), http.StatusFound)
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to read avatar")
+			fmt.Println(err)
+			return
+		}
+		defer blob.Close()
+
+		// The avatar URL changes with every upload, so a day's caching is safe
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, blob)
+	}
+}
@@ -0,0 +1,319 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/ical"
+	"roam.io/models"
+)
+
+// CalendarFeedResponse is where the user's calendar feed can be subscribed to
+type CalendarFeedResponse struct {
+	URL string `json:"url"`
+}
+
+// calendarStatus maps a booking status to the event status calendar apps show.
+// Requests waiting on the owner are tentative; bookings that won't happen are cancelled.
+func calendarStatus(status string) string {
+	switch status {
+	case models.BookingStatusPending:
+		return ical.StatusTentative
+	case models.BookingStatusCancelled, models.BookingStatusDeclined, models.BookingStatusExpired, models.BookingStatusNoShow:
+		return ical.StatusCancelled
+	}
+	return ical.StatusConfirmed
+}
+
+// calendarRevision returns when a booking's calendar entry last changed, the latest of the rows it is built from,
+// and its SEQUENCE: the seconds from the booking being made to that change, so each change numbers higher than
+// the last and calendar apps replace their copy
+func calendarRevision(created time.Time, updated ...time.Time) (time.Time, int) {
+	modified := created
+	for _, at := range updated {
+		if at.After(modified) {
+			modified = at
+		}
+	}
+	return modified, int(modified.Sub(created) / time.Second)
+}
+
+// stayCalendarEvent is a stay as an all-day event from check-in to check-out
+func stayCalendarEvent(booking models.Booking, accommodation models.Accommodation, now time.Time) ical.Event {
+	checkIn, checkOut := models.CalendarDay(booking.CheckinDate), models.CalendarDay(booking.CheckoutDate)
+	modified, sequence := calendarRevision(booking.CreatedAt, booking.UpdatedAt)
+	event := ical.Event{
+		UID:     fmt.Sprintf("booking-%d@roam.io", booking.ID),
+		Summary: "Stay at " + accommodation.Name,
+		Description: fmt.Sprintf("Check in %s, check out %s. %d guests.",
+			checkIn.Format("Mon 2 Jan 2006"), checkOut.Format("Mon 2 Jan 2006"), booking.Guests),
+		Location:     accommodation.Location,
+		Start:        checkIn,
+		End:          checkOut,
+		AllDay:       true,
+		Status:       calendarStatus(booking.Status),
+		Sequence:     sequence,
+		LastModified: modified,
+		Stamp:        now,
+	}
+	// The check-out day is left off, as the end date is exclusive, unless the stay is a single day
+	if !checkOut.After(checkIn) {
+		event.End = checkIn.AddDate(0, 0, 1)
+	}
+	if geo, ok := ical.ParseGeo(accommodation.Coordinates); ok {
+		event.Geo = geo
+	}
+	return event
+}
+
+// eventCalendarEvent is an event booking at the event's local time, lasting EventDuration.
+// Events without a time are all-day. It returns false if the event's date can't be read.
+func eventCalendarEvent(booking models.EventBooking, event models.Event, now time.Time) (ical.Event, bool) {
+	start, err := event.StartsAt()
+	if err != nil {
+		return ical.Event{}, false
+	}
+	status := calendarStatus(booking.Status)
+	if event.IsCancelled() {
+		status = ical.StatusCancelled
+	}
+	// Cancelling or moving the event changes the entry as much as changing the booking does
+	modified, sequence := calendarRevision(booking.CreatedAt, booking.UpdatedAt, event.UpdatedAt)
+	entry := ical.Event{
+		UID:         fmt.Sprintf("event-booking-%d@roam.io", booking.ID),
+		Summary:     event.EventName,
+		Description: fmt.Sprintf("%d tickets.", booking.Guests),
+		Location:    event.Location,
+		Start:       start,
+		End:         start.Add(EventDuration),
+		// Event times are the venue's local time, which isn't recorded, so they are left floating
+		Floating:     true,
+		Status:       status,
+		Sequence:     sequence,
+		LastModified: modified,
+		Stamp:        now,
+	}
+	if event.Time == "" {
+		entry.AllDay = true
+		entry.End = start.AddDate(0, 0, 1)
+	}
+	if event.OfficialLink != "" {
+		entry.Description += " " + event.OfficialLink
+	}
+	if geo, ok := ical.ParseGeo(event.Coordinates); ok {
+		entry.Geo = geo
+	}
+	return entry, true
+}
+
+// writeCalendar sends the calendar as an .ics file. A filename makes browsers download it rather than show it.
+func writeCalendar(w http.ResponseWriter, cal ical.Calendar, filename string) {
+	w.Header().Set("Content-Type", ical.ContentType)
+	if filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(ical.Marshal(cal))
+}
+
+// GetBookingCalendar exports a stay as an .ics file
+// @Summary Download stay calendar entry
+// @Description Download one of your accommodation bookings as an iCalendar file, as an all-day event from check-in to check-out
+// @Tags accommodations
+// @Produce text/calendar
+// @Param id path int true "Booking ID"
+// @Success 200 {file} binary "iCalendar file"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Booking not found"
+// @Router /accommodations/bookings/{id}/calendar.ics [get]
+func GetBookingCalendar(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.Booking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Where("user_id = ?", userID).First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch booking")
+			fmt.Println(err)
+			return
+		}
+		var accommodation models.Accommodation
+		if err := db.First(&accommodation, booking.AccommodationID).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+
+		cal := ical.Calendar{Events: []ical.Event{stayCalendarEvent(booking, accommodation, time.Now())}}
+		writeCalendar(w, cal, fmt.Sprintf("booking-%d.ics", booking.ID))
+	}
+}
+
+// GetEventBookingCalendar exports an event booking as an .ics file
+// @Summary Download event calendar entry
+// @Description Download one of your event bookings as an iCalendar file, with the venue's location and coordinates
+// @Tags events
+// @Produce text/calendar
+// @Param id path int true "Event booking ID"
+// @Success 200 {file} binary "iCalendar file"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Event booking not found"
+// @Failure 422 {object} map[string]string "Event has no readable date"
+// @Router /events/bookings/{id}/calendar.ics [get]
+func GetEventBookingCalendar(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.EventBooking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Where("user_id = ?", userID).First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event booking")
+			fmt.Println(err)
+			return
+		}
+		var event models.Event
+		if err := db.First(&event, booking.EventId).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
+			fmt.Println(err)
+			return
+		}
+
+		entry, ok := eventCalendarEvent(booking, event, time.Now())
+		if !ok {
+			writeMessage(w, http.StatusUnprocessableEntity, "The event has no date that can be added to a calendar")
+			return
+		}
+		writeCalendar(w, ical.Calendar{Events: []ical.Event{entry}}, fmt.Sprintf("event-booking-%d.ics", booking.ID))
+	}
+}
+
+// CreateCalendarFeed issues the secret URL of the user's calendar feed
+// @Summary Create calendar feed URL
+// @Description Get a secret URL that calendar apps can subscribe to for all your stays and events. Creating a new URL stops the previous one from working.
+// @Tags users
+// @Produce json
+// @Success 200 {object} CalendarFeedResponse "Feed URL"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Router /users/calendar-feed [post]
+func CreateCalendarFeed(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		token, err := newToken()
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to create calendar feed")
+			fmt.Println(err)
+			return
+		}
+		result := db.Model(&models.User{}).Where("id = ?", userID).Update("calendar_token_hash", hashToken(token))
+		if result.Error != nil || result.RowsAffected == 0 {
+			writeMessage(w, http.StatusInternalServerError, "Failed to create calendar feed")
+			fmt.Println(result.Error)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, CalendarFeedResponse{URL: apiBaseURL + "/users/calendar.ics?token=" + token})
+	}
+}
+
+// GetUserCalendarFeed serves a user's stays and events to calendar apps
+// @Summary Calendar feed
+// @Description Subscribe to all of a user's stays and event bookings. Calendar apps can't sign in, so the feed is found by the secret token in its URL. Cancelled bookings stay in the feed marked as cancelled so apps remove them.
+// @Tags users
+// @Produce text/calendar
+// @Param token query string true "Feed token"
+// @Success 200 {file} binary "iCalendar feed"
+// @Failure 404 {object} map[string]string "Unknown or replaced token"
+// @Router /users/calendar.ics [get]
+func GetUserCalendarFeed(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			writeMessage(w, http.StatusNotFound, "Calendar not found")
+			return
+		}
+		var user models.User
+		if err := db.Where("calendar_token_hash = ?", hashToken(token)).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Calendar not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch calendar")
+			fmt.Println(err)
+			return
+		}
+
+		cal, err := userCalendar(user, time.Now(), db)
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch calendar")
+			fmt.Println(err)
+			return
+		}
+		writeCalendar(w, *cal, "")
+	}
+}
+
+// userCalendar gathers every stay and event the user has booked, cancelled ones included
+func userCalendar(user models.User, now time.Time, db *gorm.DB) (*ical.Calendar, error) {
+	var bookings []models.Booking
+	if err := db.Where("user_id = ?", user.ID).Order("checkin_date").Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+	var eventBookings []models.EventBooking
+	if err := db.Where("user_id = ?", user.ID).Order("id").Find(&eventBookings).Error; err != nil {
+		return nil, err
+	}
+
+	accommodations, err := bookedAccommodations(bookings, db)
+	if err != nil {
+		return nil, err
+	}
+	events, err := bookedEvents(eventBookings, db)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := ical.Calendar{Name: "Roam.io trips", Events: []ical.Event{}}
+	for _, booking := range bookings {
+		cal.Events = append(cal.Events, stayCalendarEvent(booking, accommodations[booking.AccommodationID], now))
+	}
+	for _, booking := range eventBookings {
+		if entry, ok := eventCalendarEvent(booking, events[booking.EventId], now); ok {
+			cal.Events = append(cal.Events, entry)
+		}
+	}
+	return &cal, nil
+}
@@ -0,0 +1,467 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/ical"
+	"roam.io/models"
+)
+
+// MaxCalendarSize caps how large an imported .ics file can be
+const MaxCalendarSize = 5 << 20
+
+// CalendarSourceRequest registers another platform's calendar by URL
+type CalendarSourceRequest struct {
+	Name string `json:"Name"`
+	URL  string `json:"URL"` // http, https or webcal
+}
+
+var (
+	errPrivateAddress   = errors.New("calendar URLs must point to a public address")
+	errCalendarTooLarge = fmt.Errorf("calendar is larger than %d bytes", MaxCalendarSize)
+)
+
+// calendarErrorMessage says why a calendar couldn't be fetched in terms safe to show its owner. Connection and
+// HTTP errors, including refused private addresses, all read the same so they reveal nothing about our network.
+func calendarErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errCalendarTooLarge), errors.Is(err, ical.ErrInvalidCalendar):
+		return err.Error()
+	default:
+		return "calendar could not be downloaded; check the URL is public and reachable"
+	}
+}
+
+// calendarClient fetches external calendars
+var calendarClient = NewCalendarClient()
+
+// NewCalendarClient returns a client for fetching external calendars. Owners choose the URLs, so it refuses
+// to connect to loopback, private and link-local addresses, checked after DNS lookup and on every redirect.
+// It never uses a proxy, which would make the connection on its behalf unchecked.
+func NewCalendarClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressesOnly}).DialContext,
+		},
+	}
+}
+
+// SetCalendarClient replaces the client external calendars are fetched with
+func SetCalendarClient(client *http.Client) {
+	calendarClient = client
+}
+
+// publicAddressesOnly stops the dialer connecting to addresses inside our own network
+func publicAddressesOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// calendarURL checks a calendar URL, rewriting webcal:// links as the https:// address they stand for
+func calendarURL(raw string) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.Host == "" {
+		return "", false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "webcal":
+		parsed.Scheme = "https"
+	case "http", "https":
+	default:
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// fetchCalendar downloads and parses an external calendar
+func fetchCalendar(calendarURL string) ([]ical.Event, error) {
+	req, err := http.NewRequest(http.MethodGet, calendarURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	res, err := calendarClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar server responded %s", res.Status)
+	}
+	return readCalendar(res.Body)
+}
+
+// readCalendar parses an .ics file, refusing files over MaxCalendarSize
+func readCalendar(r io.Reader) ([]ical.Event, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxCalendarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxCalendarSize {
+		return nil, errCalendarTooLarge
+	}
+	return ical.Parse(bytes.NewReader(data))
+}
+
+// blockedRanges turns a calendar's events into the nights they block. Cancelled events and
+// stays that are already over are skipped; events shorter than a night block the night they start.
+func blockedRanges(source models.CalendarSource, events []ical.Event, now time.Time) []models.BlockedDateRange {
+	today := models.CalendarDay(now)
+	ranges := []models.BlockedDateRange{}
+	for _, event := range events {
+		if event.Status == ical.StatusCancelled {
+			continue
+		}
+		start, end := models.CalendarDay(event.Start), models.CalendarDay(event.End)
+		if !end.After(start) {
+			end = start.AddDate(0, 0, 1)
+		}
+		if !end.After(today) {
+			continue
+		}
+		ranges = append(ranges, models.BlockedDateRange{
+			AccommodationID: source.AccommodationID,
+			SourceID:        source.ID,
+			UID:             truncate(event.UID, 255),
+			Summary:         truncate(event.Summary, 255),
+			StartDate:       start,
+			EndDate:         end,
+		})
+	}
+	return ranges
+}
+
+// truncate shortens text to at most n bytes without splitting a character
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && text[n]&0xC0 == 0x80 {
+		n--
+	}
+	return text[:n]
+}
+
+// importCalendar replaces the source's blocked dates with the calendar's events. Run it in a transaction.
+func importCalendar(source *models.CalendarSource, events []ical.Event, now time.Time, tx *gorm.DB) error {
+	if err := tx.Where("source_id = ?", source.ID).Delete(&models.BlockedDateRange{}).Error; err != nil {
+		return err
+	}
+	if ranges := blockedRanges(*source, events, now); len(ranges) > 0 {
+		if err := tx.Create(&ranges).Error; err != nil {
+			return err
+		}
+	}
+	source.LastSyncedAt = &now
+	source.LastSyncError = ""
+	return tx.Model(source).Select("LastSyncedAt", "LastSyncError").Updates(source).Error
+}
+
+// SyncCalendarSource fetches a calendar registered by URL and replaces its blocked dates.
+// If the calendar can't be fetched or read, the error is recorded and the previous dates stay blocked.
+// The owner sees a safe summary of a failed fetch; the error returned has the details.
+func SyncCalendarSource(source *models.CalendarSource, now time.Time, db *gorm.DB) error {
+	events, err := fetchCalendar(source.URL)
+	if err != nil {
+		source.LastSyncError = calendarErrorMessage(err)
+		if saveErr := db.Model(source).Update("last_sync_error", source.LastSyncError).Error; saveErr != nil {
+			return saveErr
+		}
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return importCalendar(source, events, now, tx)
+	})
+}
+
+// GetAvailabilityCalendar exports the nights an accommodation is booked
+// @Summary Availability calendar
+// @Description Subscribe to the nights an accommodation is booked here, so other platforms can block them. Each booking is an all-day event from check-in to check-out; guest details are left out. The owner's blackout dates are included too. Dates imported from other platforms aren't, so calendars synced both ways don't echo each other.
+// @Tags accommodations
+// @Produce text/calendar
+// @Param id path int true "Accommodation ID"
+// @Success 200 {file} binary "iCalendar feed"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /accommodations/{id}/availability.ics [get]
+func GetAvailabilityCalendar(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var accommodation models.Accommodation
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&accommodation, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+
+		now := time.Now()
+		var bookings []models.Booking
+		err := db.Where("accommodation_id = ? AND status IN ? AND checkout_date >= ?",
+			accommodation.ID, models.ActiveBookingStatuses, models.CalendarDay(now)).
+			Where("expires_at IS NULL OR expires_at > ?", now).
+			Order("checkin_date").Find(&bookings).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch bookings")
+			fmt.Println(err)
+			return
+		}
+		var blackouts []models.BlockedDateRange
+		err = db.Where("accommodation_id = ? AND source_id = 0 AND end_date > ?", accommodation.ID, models.CalendarDay(now)).
+			Order("start_date").Find(&blackouts).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch blackout dates")
+			fmt.Println(err)
+			return
+		}
+
+		cal := ical.Calendar{Name: accommodation.Name + " availability", Events: []ical.Event{}}
+		for _, booking := range bookings {
+			event := stayCalendarEvent(booking, accommodation, now)
+			cal.Events = append(cal.Events, ical.Event{
+				UID:      fmt.Sprintf("availability-booking-%d@roam.io", booking.ID),
+				Summary:  "Reserved",
+				Start:    event.Start,
+				End:      event.End,
+				AllDay:   true,
+				Status:   ical.StatusConfirmed,
+				Sequence: event.Sequence,
+				Stamp:    now,
+			})
+		}
+		for _, blackout := range blackouts {
+			cal.Events = append(cal.Events, ical.Event{
+				UID:     fmt.Sprintf("availability-blackout-%d@roam.io", blackout.ID),
+				Summary: "Not available",
+				Start:   models.CalendarDay(blackout.StartDate),
+				End:     models.CalendarDay(blackout.EndDate),
+				AllDay:  true,
+				Status:  ical.StatusConfirmed,
+				Stamp:   now,
+			})
+		}
+		writeCalendar(w, cal, "")
+	}
+}
+
+// ListCalendarSources lists the external calendars blocking an accommodation's dates
+// @Summary List synced calendars
+// @Description List the calendars from other platforms that block dates on your accommodation, with when each last synced and why it failed if it did
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Success 200 {array} models.CalendarSource "Calendars"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/calendars [get]
+func ListCalendarSources(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		sources := []models.CalendarSource{}
+		if err := db.Where("accommodation_id = ?", accommodation.ID).Order("id").Find(&sources).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch calendars")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sources)
+	}
+}
+
+// AddCalendarSource registers another platform's calendar by URL
+// @Summary Sync calendar from URL
+// @Description Block the dates booked on another platform by registering its .ics export URL. The calendar is read straight away and then synced in the background.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param calendar body CalendarSourceRequest true "Calendar URL"
+// @Success 201 {object} models.CalendarSource "Calendar added"
+// @Failure 400 {object} map[string]string "Invalid URL"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Failure 422 {object} map[string]string "Calendar could not be fetched or read"
+// @Router /owner/accommodations/{id}/calendars [post]
+func AddCalendarSource(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var req CalendarSourceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		address, ok := calendarURL(req.URL)
+		if !ok {
+			writeMessage(w, http.StatusBadRequest, "URL must be an http, https or webcal address")
+			return
+		}
+
+		// Read the calendar before saving it, so a mistyped URL is reported rather than synced forever
+		events, err := fetchCalendar(address)
+		if err != nil {
+			writeMessage(w, http.StatusUnprocessableEntity, "Could not read the calendar: "+calendarErrorMessage(err))
+			fmt.Printf("Error fetching calendar %s: %v\n", address, err)
+			return
+		}
+		source := models.CalendarSource{AccommodationID: accommodation.ID, Name: truncate(req.Name, 100), URL: address}
+		if err := saveCalendarSource(&source, events, time.Now(), db); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to save calendar")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, source)
+	}
+}
+
+// UploadCalendarSource imports an .ics file exported from another platform
+// @Summary Import calendar file
+// @Description Block the dates in an .ics file exported from another platform. Uploaded files are read once; upload a new file, or register a URL, to keep dates up to date.
+// @Tags owner
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param file formData file true "iCalendar file"
+// @Param name formData string false "Name shown for the calendar"
+// @Success 201 {object} models.CalendarSource "Calendar imported"
+// @Failure 400 {object} map[string]string "Missing or unreadable file"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Failure 413 {object} map[string]string "File too large"
+// @Router /owner/accommodations/{id}/calendars/upload [post]
+func UploadCalendarSource(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		// Leave room for the multipart framing around the file
+		r.Body = http.MaxBytesReader(w, r.Body, MaxCalendarSize+1<<20)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeMessage(w, http.StatusRequestEntityTooLarge, errCalendarTooLarge.Error())
+				return
+			}
+			writeMessage(w, http.StatusBadRequest, "Missing file")
+			return
+		}
+		defer file.Close()
+
+		events, err := readCalendar(file)
+		if err != nil {
+			if errors.Is(err, errCalendarTooLarge) {
+				writeMessage(w, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		name := r.FormValue("name")
+		if name == "" {
+			name = header.Filename
+		}
+		source := models.CalendarSource{AccommodationID: accommodation.ID, Name: truncate(name, 100)}
+		if err := saveCalendarSource(&source, events, time.Now(), db); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to save calendar")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, source)
+	}
+}
+
+// saveCalendarSource records a new calendar along with the dates it blocks
+func saveCalendarSource(source *models.CalendarSource, events []ical.Event, now time.Time, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(source).Error; err != nil {
+			return err
+		}
+		return importCalendar(source, events, now, tx)
+	})
+}
+
+// DeleteCalendarSource stops syncing a calendar and frees the dates it blocked
+// @Summary Remove synced calendar
+// @Description Stop syncing a calendar from another platform. The dates it blocked can be booked again.
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param calendarId path int true "Calendar ID"
+// @Success 200 {object} map[string]string "Calendar removed"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation or calendar not found"
+// @Router /owner/accommodations/{id}/calendars/{calendarId} [delete]
+func DeleteCalendarSource(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var source models.CalendarSource
+		calendarID, ok := pathID(w, r, "calendarId")
+		if !ok {
+			return
+		}
+		if err := db.Where("accommodation_id = ?", accommodation.ID).First(&source, calendarID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Calendar not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch calendar")
+			fmt.Println(err)
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("source_id = ?", source.ID).Delete(&models.BlockedDateRange{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&source).Error
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to remove calendar")
+			fmt.Println(err)
+			return
+		}
+		writeMessage(w, http.StatusOK, "Calendar removed")
+	}
+}
@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// parseGuests reads adults, children, infants and pets from the query string. Clients that don't break their
+// guests down send guests, which is read as the number of adults; with neither, one adult is assumed.
+// A *models.StayError is returned if the guests can't be read or no booking could be for them.
+func parseGuests(query url.Values) (models.GuestComposition, error) {
+	var guests models.GuestComposition
+	adults := query.Get("adults")
+	if adults == "" {
+		adults = query.Get("guests")
+	}
+	if adults == "" {
+		adults = "1"
+	}
+
+	counts := []struct {
+		name  string
+		value string
+		count *uint
+	}{
+		{"adults", adults, &guests.Adults},
+		{"children", query.Get("children"), &guests.Children},
+		{"infants", query.Get("infants"), &guests.Infants},
+		{"pets", query.Get("pets"), &guests.Pets},
+	}
+	for _, c := range counts {
+		if c.value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(c.value, 10, 32)
+		if err != nil {
+			return guests, &models.StayError{Code: models.StayErrorInvalidGuests, Message: c.name + " must be a whole number"}
+		}
+		*c.count = uint(n)
+	}
+	return guests, guests.Validate()
+}
+
+// checkMinimumAge returns a *models.StayError if the user is younger than minimum on the date of on.
+// The user is only loaded when there is a minimum.
+func checkMinimumAge(db *gorm.DB, userID, minimum uint, on time.Time) error {
+	if minimum == 0 {
+		return nil
+	}
+	var user models.User
+	if err := db.Select("dob").First(&user, userID).Error; err != nil {
+		return err
+	}
+	return models.CheckMinimumAge(minimum, user.Dob, on)
+}
+
+// UpdateGuestPolicy lets an owner limit who can stay
+// @Summary Update guest policy
+// @Description Set the most guests the accommodation takes, counting adults and children but not infants, whether children and infants can stay, and whether pets are allowed and how many, and how old the booker must be on check-in.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param policy body models.GuestPolicy true "Guest policy"
+// @Success 200 {object} models.GuestPolicy "Policy updated"
+// @Failure 400 {object} map[string]string "Invalid policy"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/guest-policy [put]
+func UpdateGuestPolicy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var policy models.GuestPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := policy.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		accommodation.GuestPolicy = policy
+		err := db.Model(accommodation).
+			Select("max_guests", "child_policy", "pets_allowed", "max_pets", "minimum_age").
+			Updates(accommodation).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update guest policy")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+	}
+}
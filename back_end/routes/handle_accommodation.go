@@ -11,7 +11,9 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"roam.io/models"
+	"roam.io/payments"
 )
 
 func FetchAccommodations(db *gorm.DB) http.HandlerFunc {
@@ -19,12 +21,33 @@ func FetchAccommodations(db *gorm.DB) http.HandlerFunc {
 		{
 			queryParams := r.URL.Query()
 			location := queryParams.Get("location")
+			checkIn, checkOut, guests, withDates, err := parseStayQuery(r)
+			var stayErr *models.StayError
+			if errors.As(err, &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
 			accommodations, err := GetAccommodationsByLocation(location, db)
 			if err != nil {
 				http.Error(w, "Failed to fetch accommodations", http.StatusInternalServerError)
 				fmt.Println(err)
 				return
 			}
+			// Searching for dates prices the stay at each accommodation that takes the guests
+			if withDates {
+				hosting := accommodations[:0]
+				for _, accommodation := range accommodations {
+					if accommodation.GuestPolicy.CheckGuests(guests) != nil {
+						continue
+					}
+					if accommodation.PriceQuote, err = accommodation.Quote(checkIn, checkOut, guests); err != nil {
+						writeStayError(w, &models.StayError{Code: models.StayErrorInvalidDates, Message: err.Error()})
+						return
+					}
+					hosting = append(hosting, accommodation)
+				}
+				accommodations = hosting
+			}
 			// Return response with the new user ID
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -48,6 +71,12 @@ func FetchAccommodationById(db *gorm.DB) http.HandlerFunc {
 				fmt.Println(err)
 				return
 			}
+			result.ReviewSummary, err = SummarizeReviews(result.ID, db)
+			if err != nil {
+				http.Error(w, "Failed to fetch reviews", http.StatusInternalServerError)
+				fmt.Println(err)
+				return
+			}
 			// Return response with the new user ID
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -83,8 +112,24 @@ func CreateAccommodation(db *gorm.DB) http.HandlerFunc {
 			Facilities:    payload.Facilities,
 			OwnerID:       payload.OwnerID,
 			PricePerNight: payload.PricePerNight,
-			Rating:        payload.Rating, // Initial rating, could be updated based on reviews later
 			Coordinates:   payload.Coordinates,
+			BookingMode:   payload.BookingMode,
+
+			CancellationPolicy: policyName(payload.CancellationPolicy),
+		}
+		if err := models.ValidateCancellationPolicy(payload.CancellationPolicy, payload.CancellationTiers); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if accommodation.CancellationPolicy == models.CancellationPolicyCustom {
+			accommodation.CancellationTiers = payload.CancellationTiers
+		}
+		if !models.IsValidBookingMode(accommodation.BookingMode) {
+			http.Error(w, "BookingMode must be instant or request", http.StatusBadRequest)
+			return
+		}
+		if accommodation.BookingMode == "" {
+			accommodation.BookingMode = models.BookingModeInstant
 		}
 
 		result := db.Create(&accommodation)
@@ -123,25 +168,21 @@ func AddBooking(db *gorm.DB) http.HandlerFunc {
 		checking_date := queryParams.Get("check_in_date")
 		checkout_date := queryParams.Get("check_out_date")
 		guests := queryParams.Get("guests")
+		adults := queryParams.Get("adults")
 		total_cost := queryParams.Get("total_cost")
 		layout := "2006-01-02" // Date format (YYYY-MM-DD)
-		if accommodation_id == "" || checking_date == "" || checkout_date == "" || guests == "" || total_cost == "" {
+		// total_cost is optional; when sent, it must match the quoted price so the guest isn't charged a price they didn't see
+		if accommodation_id == "" || checking_date == "" || checkout_date == "" || (guests == "" && adults == "") {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Invalid JSON format:"})
 			return
 		}
 		// Convert string to time.Time
-		checkInDate, err := time.Parse(layout, checking_date)
-		if err != nil {
-			fmt.Println("Error parsing date:", err)
-			return
-		}
-
-		// Convert string to time.Time
-		checkOutDate, err := time.Parse(layout, checkout_date)
-		if err != nil {
-			fmt.Println("Error parsing date:", err)
+		checkInDate, checkInErr := time.Parse(layout, checking_date)
+		checkOutDate, checkOutErr := time.Parse(layout, checkout_date)
+		if checkInErr != nil || checkOutErr != nil {
+			writeStayError(w, &models.StayError{Code: models.StayErrorInvalidDates, Message: "Dates must be in YYYY-MM-DD format"})
 			return
 		}
 		// accommodation, err := GetAccommodationsByID(accommodation_id, db)
@@ -170,19 +211,21 @@ func AddBooking(db *gorm.DB) http.HandlerFunc {
 		}
 
 		uintValue := uint(u)
-		ui, err := strconv.ParseUint(guests, 10, 32) // base 10, uint32 max bits
-		if err != nil {
-			fmt.Println("Error:", err)
+		party, err := parseGuests(queryParams)
+		var stayErr *models.StayError
+		if errors.As(err, &stayErr) {
+			writeStayError(w, stayErr)
 			return
 		}
-		guestsUintValue := uint(ui)
 
-		uit, err := strconv.ParseUint(total_cost, 10, 32) // base 10, uint32 max bits
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
+		var expectedCost uint64
+		if total_cost != "" {
+			expectedCost, err = strconv.ParseUint(total_cost, 10, 32) // base 10, uint32 max bits
+			if err != nil {
+				writeMessage(w, http.StatusBadRequest, "total_cost must be a whole number")
+				return
+			}
 		}
-		totalcostUintValue := uint(uit)
 		bookings, err := GetBookingByUserID(int(userID), db)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
@@ -193,7 +236,7 @@ func AddBooking(db *gorm.DB) http.HandlerFunc {
 		}
 
 		for _, booking := range bookings {
-			if booking.AccommodationID == uintValue {
+			if booking.AccommodationID == uintValue && booking.IsActive() {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusConflict)
 				json.NewEncoder(w).Encode(map[string]string{"message": "Booking Already exists for user"})
@@ -201,8 +244,99 @@ func AddBooking(db *gorm.DB) http.HandlerFunc {
 			}
 		}
 
-		bookingID, err := CreateBooking(userID, uintValue, checkInDate, checkOutDate, guestsUintValue, totalcostUintValue, db)
+		var accommodation models.Accommodation
+		if err := db.First(&accommodation, uintValue).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Internal server error")
+			fmt.Println(err)
+			return
+		}
+
+		if errors.As(accommodation.GuestPolicy.CheckGuests(party), &stayErr) {
+			writeStayError(w, stayErr)
+			return
+		}
+		if err := checkMinimumAge(db, userID, accommodation.GuestPolicy.MinimumAge, checkInDate); err != nil {
+			if errors.As(err, &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Internal server error")
+			fmt.Println(err)
+			return
+		}
+
+		// Accommodations with room types are booked by the room
+		var roomType *models.RoomType
+		var roomTypeID *uint
+		if room_type_id := queryParams.Get("room_type_id"); room_type_id != "" {
+			roomType, err = findRoomType(db, accommodation.ID, room_type_id)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					writeMessage(w, http.StatusNotFound, "Room type not found")
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Internal server error")
+				fmt.Println(err)
+				return
+			}
+			if errors.As(roomType.CheckGuests(party), &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
+			roomTypeID = &roomType.ID
+		} else if byRoom, err := hasRoomTypes(db, accommodation.ID); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Internal server error")
+			fmt.Println(err)
+			return
+		} else if byRoom {
+			writeMessage(w, http.StatusBadRequest, "room_type_id is required for this accommodation")
+			return
+		}
+
+		paymentToken := queryParams.Get("payment_token")
+		now := time.Now()
+		var booking *models.Booking
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Bookings of an accommodation are made one at a time, so two guests can't both take
+			// its last room, or a room and the whole accommodation, between the check and the insert
+			if err := lockAccommodation(accommodation.ID, tx); err != nil {
+				return err
+			}
+			if err := CheckStayAvailability(accommodation, roomType, checkInDate, checkOutDate, now, tx); err != nil {
+				return err
+			}
+
+			quote, err := accommodation.Quote(checkInDate, checkOutDate, party)
+			if roomType != nil {
+				quote, err = accommodation.QuoteRoom(*roomType, checkInDate, checkOutDate, party)
+			}
+			if err != nil {
+				return &models.StayError{Code: models.StayErrorInvalidDates, Message: err.Error()}
+			}
+			totalcostUintValue := quote.TotalCost()
+			if total_cost != "" && uint(expectedCost) != totalcostUintValue {
+				return &models.StayError{
+					Code:    models.StayErrorPriceChanged,
+					Message: fmt.Sprintf("The price for these dates is now %d", totalcostUintValue),
+				}
+			}
+
+			if accommodation.RequiresApproval() {
+				booking, err = CreateBookingRequest(userID, uintValue, roomTypeID, checkInDate, checkOutDate, party, totalcostUintValue, now, tx)
+			} else {
+				booking, err = CreateBooking(userID, uintValue, roomTypeID, checkInDate, checkOutDate, party, totalcostUintValue, tx)
+			}
+			return err
+		})
 		if err != nil {
+			if errors.As(err, &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Failed to Create booking"})
@@ -210,21 +344,91 @@ func AddBooking(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		if accommodation.RequiresApproval() {
+			// The payment is only held until the owner answers
+			if err := AuthorizeBookingRequest(booking, paymentToken, now, db); err != nil {
+				fmt.Println(err)
+				if errors.Is(err, payments.ErrPaymentFailed) {
+					writeMessage(w, http.StatusPaymentRequired, "Payment could not be authorized")
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Failed to Create booking")
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]interface{}{
+				"id":         booking.ID,
+				"status":     booking.Status,
+				"expires_at": booking.ExpiresAt,
+			})
+			return
+		}
+
+		if err := ChargeAndConfirmBooking(booking, paymentToken, now, db); err != nil {
+			fmt.Println(err)
+			if errors.Is(err, payments.ErrPaymentFailed) {
+				writeMessage(w, http.StatusPaymentRequired, "Payment failed")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to confirm booking")
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]int{"id": bookingID})
+		json.NewEncoder(w).Encode(map[string]int{"id": int(booking.ID)})
 	}
 }
 
-func CreateBooking(userID, accommodationID uint, checkinDate, checkoutDate time.Time, guests uint, total_cost uint, db *gorm.DB) (id int, err error) {
-	booking := models.Booking{UserID: userID, AccommodationID: accommodationID, CheckinDate: checkinDate, CheckoutDate: checkoutDate, Guests: guests, TotalCost: total_cost}
+// BookingRequestHoldDuration is how long a booking request holds its dates while waiting for the owner
+var BookingRequestHoldDuration = 24 * time.Hour
+
+// HasOverlappingBooking reports whether any booking still holding its dates overlaps the stay,
+// or the dates are blocked by a stay booked on another platform.
+// Pending requests only hold dates until they expire.
+func HasOverlappingBooking(accommodationID uint, checkinDate, checkoutDate, now time.Time, db *gorm.DB) (bool, error) {
+	var count int64
+	result := db.Model(&models.Booking{}).
+		Where("accommodation_id = ? AND status IN ? AND checkin_date < ? AND checkout_date > ?",
+			accommodationID, models.ActiveBookingStatuses, checkoutDate, checkinDate).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Count(&count)
+	if result.Error != nil || count > 0 {
+		return count > 0, result.Error
+	}
+	return hasBlockedDates(accommodationID, checkinDate, checkoutDate, db)
+}
+
+// hasBlockedDates checks whether a stay includes nights blocked by another platform's calendar or closed by the owner
+func hasBlockedDates(accommodationID uint, checkinDate, checkoutDate time.Time, db *gorm.DB) (bool, error) {
+	var count int64
+	result := db.Model(&models.BlockedDateRange{}).
+		Where("accommodation_id = ? AND start_date < ? AND end_date > ?",
+			accommodationID, models.CalendarDay(checkoutDate), models.CalendarDay(checkinDate)).
+		Count(&count)
+	return count > 0, result.Error
+}
+
+// CreateBooking creates a pending booking. It is confirmed once the payment has been captured.
+func CreateBooking(userID, accommodationID uint, roomTypeID *uint, checkinDate, checkoutDate time.Time, guests models.GuestComposition, total_cost uint, db *gorm.DB) (*models.Booking, error) {
+	booking := models.Booking{UserID: userID, AccommodationID: accommodationID, RoomTypeID: roomTypeID, CheckinDate: checkinDate, CheckoutDate: checkoutDate, Guests: guests.Occupancy(), Composition: guests, TotalCost: total_cost, Status: models.BookingStatusPending}
 	result := db.Create(&booking)
 	if result.Error != nil {
-		return 0, result.Error
+		return nil, result.Error
 	} else {
 		fmt.Println("Booking created successfully:", booking)
-		return int(booking.ID), nil
+		return &booking, nil
+	}
+}
+
+// CreateBookingRequest creates a pending booking that holds the dates until the owner answers or the hold expires
+func CreateBookingRequest(userID, accommodationID uint, roomTypeID *uint, checkinDate, checkoutDate time.Time, guests models.GuestComposition, total_cost uint, now time.Time, db *gorm.DB) (*models.Booking, error) {
+	expiresAt := now.Add(BookingRequestHoldDuration)
+	booking := models.Booking{UserID: userID, AccommodationID: accommodationID, RoomTypeID: roomTypeID, CheckinDate: checkinDate, CheckoutDate: checkoutDate, Guests: guests.Occupancy(), Composition: guests, TotalCost: total_cost, Status: models.BookingStatusPending, ExpiresAt: &expiresAt}
+	if err := db.Create(&booking).Error; err != nil {
+		return nil, err
 	}
+	fmt.Println("Booking request created successfully:", booking)
+	return &booking, nil
 }
 
 func GetBookingByUserID(userID int, db *gorm.DB) ([]models.Booking, error) {
@@ -238,9 +442,13 @@ func GetBookingByUserID(userID int, db *gorm.DB) ([]models.Booking, error) {
 }
 
 func GetAccommodationsByID(id string, db *gorm.DB) (*models.Accommodation, error) {
+	accommodationID, err := lookupID(id)
+	if err != nil {
+		return nil, err
+	}
 	var accommodation models.Accommodation
-	// Use Preload to fetch associated Owner and UserReviews
-	result := db.Preload("Owner").Preload("UserReviews").First(&accommodation, id)
+	// Use Preload to fetch the associated Owner and the published reviews
+	result := preloadPublishedReviews(db.Preload("Owner")).First(&accommodation, accommodationID)
 	if result.Error != nil {
 		fmt.Printf("Error fetching accommodation %s by ID: %v\n", id, result.Error)
 		return nil, result.Error
@@ -256,14 +464,12 @@ func GetAccommodationsByID(id string, db *gorm.DB) (*models.Accommodation, error
 		fmt.Printf("Successfully preloaded owner %d for accommodation %d\n", accommodation.OwnerID, accommodation.ID)
 	}
 
-	fmt.Printf("Successfully preloaded %d reviews for accommodation %d\n", len(accommodation.UserReviews), accommodation.ID)
-
 	return &accommodation, nil
 }
 
 func GetAccommodationsByLocation(location string, db *gorm.DB) ([]models.Accommodation, error) {
 	var accommodations []models.Accommodation
-	query := db.Preload("Owner").Preload("UserReviews") // Preload Owner and UserReviews
+	query := db.Preload("Owner") // Reviews are listed separately, the stored aggregates summarize them
 
 	var result *gorm.DB
 	if location == "" {
@@ -295,9 +501,13 @@ func GetAccommodationsByLocation(location string, db *gorm.DB) ([]models.Accommo
 }
 
 func GetAccommodationsById(id string, db *gorm.DB) (*models.Accommodation, error) {
+	accommodationID, err := lookupID(id)
+	if err != nil {
+		return nil, err
+	}
 	var accommodation models.Accommodation
-	// Use Preload here as well
-	result := db.Preload("Owner").Preload("UserReviews").First(&accommodation, id)
+	// Use Preload here as well, the detail page shows the published reviews
+	result := preloadPublishedReviews(db.Preload("Owner")).First(&accommodation, accommodationID)
 
 	if result.Error != nil {
 		fmt.Printf("Error fetching accommodation %s: %v\n", id, result.Error)
@@ -316,58 +526,85 @@ func GetAccommodationsById(id string, db *gorm.DB) (*models.Accommodation, error
 		}
 	}
 
-	fmt.Printf("Successfully preloaded %d reviews for accommodation %d\n", len(accommodation.UserReviews), accommodation.ID)
-
 	return &accommodation, nil
 }
 
-func RemoveBookingByBookingID(bookingID int, db *gorm.DB) error {
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// CancelBookingByID marks one of the user's bookings cancelled and refunds what is due under the accommodation's policy.
+// The row is kept so the booking history is preserved. It is locked while cancelling, so two requests can't both cancel it.
+func CancelBookingByID(bookingID int, userID uint, db *gorm.DB) (*models.Booking, error) {
+	var booking models.Booking
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&booking, bookingID).Error; err != nil {
+			return err
 		}
-	}()
 
-	result := tx.Where("id = ?", bookingID).Delete(&models.Booking{})
+		var accommodation models.Accommodation
+		if err := tx.First(&accommodation, booking.AccommodationID).Error; err != nil {
+			return err
+		}
 
-	if result.Error != nil {
-		tx.Rollback()
-		return result.Error
+		// The refund is worked out from the status before cancelling, since only confirmed stays were paid for
+		now := time.Now()
+		refund := QuoteBookingRefund(booking, accommodation, now)
+		if err := booking.Transition(models.BookingStatusCancelled, now); err != nil {
+			return err
+		}
+		booking.RefundAmount = refund.RefundAmount
+		return tx.Model(&booking).Select("Status", "CancelledAt", "RefundAmount").Updates(&booking).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if result.RowsAffected == 0 {
-		tx.Rollback()
-		return errors.New("error removing booking")
+	// The money only goes back once the cancellation is committed. A refund that fails here is
+	// retried by the settle-cancelled-payments job, which refunds up to RefundAmount.
+	if err := SettleBookingPayment(&booking, db); err != nil {
+		fmt.Printf("Error settling payment for cancelled booking %d: %v\n", booking.ID, err)
 	}
-
-	return tx.Commit().Error
-
+	return &booking, nil
 }
 
 func RemoveBooking(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "Unauthorized, no session found")
+			return
+		}
+
 		// Parse the JSON body
 		queryParams := r.URL.Query()
 		booking_id := queryParams.Get("booking_id")
 
 		u, err := strconv.ParseUint(booking_id, 10, 32) // base 10, uint32 max bits
 		if err != nil {
-			fmt.Println("Error:", err)
+			http.Error(w, "Invalid booking_id", http.StatusBadRequest)
 			return
 		}
 
 		uintValue := uint(u)
 
-		err = RemoveBookingByBookingID(int(uintValue), db)
+		// Other users' bookings are reported as not found
+		booking, err := CancelBookingByID(int(uintValue), userID, db)
 		if err != nil {
-			http.Error(w, "Booking not found", http.StatusNotFound)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				http.Error(w, "Booking not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, models.ErrInvalidBookingTransition) {
+				http.Error(w, "Booking can no longer be cancelled", http.StatusConflict)
+				return
+			}
+			fmt.Println(err)
+			http.Error(w, "Failed to cancel booking", http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode("Booking removed")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":       "Booking cancelled",
+			"refund_amount": booking.RefundAmount,
+		})
 	}
 }
 
@@ -418,6 +655,10 @@ func AddReview(db *gorm.DB) http.HandlerFunc {
 			Rating:          reviewPayload.Rating,
 			Date:            time.Now().Format("2006-01-02"), // Set current date
 			Comment:         reviewPayload.Comment,
+
+			CleanlinessRating: reviewPayload.CleanlinessRating,
+			LocationRating:    reviewPayload.LocationRating,
+			ValueRating:       reviewPayload.ValueRating,
 		}
 
 		// Basic Validation
@@ -429,24 +670,60 @@ func AddReview(db *gorm.DB) http.HandlerFunc {
 			http.Error(w, "Comment cannot be empty", http.StatusBadRequest)
 			return
 		}
+		if !isValidSubRating(review.CleanlinessRating) || !isValidSubRating(review.LocationRating) || !isValidSubRating(review.ValueRating) {
+			http.Error(w, "Sub-ratings must be between 1 and 5", http.StatusBadRequest)
+			return
+		}
 
-		// 6. Save the review to the database
-		result := db.Create(&review)
-		if result.Error != nil {
-			// Check if the error is due to foreign key constraint (invalid AccommodationID)
-			// Note: GORM might return a generic error, check the underlying error type if needed.
-			// Example check (may vary based on DB driver):
-			var pgErr *pq.Error
-			if errors.As(result.Error, &pgErr) && pgErr.Code == "23503" { // Foreign key violation
-				http.Error(w, "Invalid AccommodationID provided", http.StatusBadRequest)
-				return
+		// Only guests who stayed can review, once per stay
+		booking, err := FindReviewableBooking(userID, review.AccommodationID, time.Now(), db)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNoCompletedStay), errors.Is(err, ErrReviewWindowClosed):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case errors.Is(err, ErrStayAlreadyReviewed):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				fmt.Printf("Error checking stays for review: %v\n", err)
+				http.Error(w, "Failed to create review", http.StatusInternalServerError)
 			}
-			fmt.Printf("Error creating review: %v\n", result.Error)
+			return
+		}
+		review.BookingID = &booking.ID
+		review.VerifiedStay = true
+
+		// Reviews that trip the screener wait for a moderator instead of going live
+		review.ModerationStatus = models.ReviewStatusApproved
+		if _, err := ScreenReview(&review, time.Now(), db); err != nil {
+			fmt.Printf("Error screening review: %v\n", err)
 			http.Error(w, "Failed to create review", http.StatusInternalServerError)
 			return
 		}
 
-		// Optional: Update Accommodation's average rating (more complex, involves calculating average)
+		// 6. Save the review and update the accommodation's rating in one transaction
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAccommodation(review.AccommodationID, tx); err != nil {
+				return err
+			}
+			if err := tx.Create(&review).Error; err != nil {
+				return err
+			}
+			_, err := RecalculateAccommodationRating(review.AccommodationID, tx)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				http.Error(w, "Accommodation not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, gorm.ErrDuplicatedKey) { // the stay was reviewed concurrently
+				http.Error(w, ErrStayAlreadyReviewed.Error(), http.StatusConflict)
+				return
+			}
+			fmt.Printf("Error creating review: %v\n", err)
+			http.Error(w, "Failed to create review", http.StatusInternalServerError)
+			return
+		}
 
 		// 7. Return success response
 		w.Header().Set("Content-Type", "application/json")
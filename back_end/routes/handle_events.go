@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"roam.io/models"
+	"roam.io/payments"
 )
 
 func FetchEvents(db *gorm.DB) http.HandlerFunc {
@@ -20,13 +23,23 @@ func FetchEvents(db *gorm.DB) http.HandlerFunc {
 			location := queryParams.Get("location")
 			events, err := GetEventsByLocation(location, db)
 			response := []models.EventResponse{}
+			if err != nil {
+				http.Error(w, "Failed to fetch events", http.StatusInternalServerError)
+				fmt.Println(err)
+				return
+			}
+			eventIDs := make([]uint, len(events))
+			for i, event := range events {
+				eventIDs[i] = event.ID
+			}
+			tiers, err := ticketTierAvailability(db, time.Now(), eventIDs...)
 			for _, event := range events {
 				organizer, _ := GetOrganizerByID(event.OrganizerID, db)
-				currEvent := models.EventResponse{ID: event.ID, Name: event.EventName, Location: event.Location, Images: pq.StringArray(event.Images), Description: event.Description, Date: event.Date, Time: event.Time, Price: event.Price, AvailableSeats: event.AvailableSeats, TotalSeats: event.TotalSeats, Coordinates: event.Coordinates, OfficialLink: event.OfficialLink, Organizer: *organizer}
+				currEvent := models.EventResponse{ID: event.ID, Name: event.EventName, Location: event.Location, Images: pq.StringArray(event.Images), Description: event.Description, Date: event.Date, Time: event.Time, Price: event.Price, AvailableSeats: event.AvailableSeats, TotalSeats: event.TotalSeats, Coordinates: event.Coordinates, OfficialLink: event.OfficialLink, Organizer: *organizer, Status: event.Status, MinimumAge: event.MinimumAge, ReservedSeating: event.ReservedSeating, CancellationPolicy: policyName(event.CancellationPolicy), CancellationTiers: event.CancellationTiers, TicketTiers: tiers[event.ID]}
 				response = append(response, currEvent)
 			}
 			if err != nil {
-				http.Error(w, "Failed to fetch accommodations", http.StatusInternalServerError)
+				http.Error(w, "Failed to fetch events", http.StatusInternalServerError)
 				fmt.Println(err)
 				return
 			}
@@ -54,7 +67,13 @@ func FetchEventById(db *gorm.DB) http.HandlerFunc {
 				return
 			}
 			organizer, _ := GetOrganizerByID(result.OrganizerID, db)
-			event := models.EventResponse{ID: result.ID, Name: result.EventName, Location: result.Location, Images: pq.StringArray(result.Images), Description: result.Description, Date: result.Date, Time: result.Time, Price: result.Price, AvailableSeats: result.AvailableSeats, TotalSeats: result.TotalSeats, Coordinates: result.Coordinates, OfficialLink: result.OfficialLink, Organizer: *organizer}
+			tiers, err := ticketTierAvailability(db, time.Now(), result.ID)
+			if err != nil {
+				http.Error(w, "Failed to fetch event", http.StatusInternalServerError)
+				fmt.Println(err)
+				return
+			}
+			event := models.EventResponse{ID: result.ID, Name: result.EventName, Location: result.Location, Images: pq.StringArray(result.Images), Description: result.Description, Date: result.Date, Time: result.Time, Price: result.Price, AvailableSeats: result.AvailableSeats, TotalSeats: result.TotalSeats, Coordinates: result.Coordinates, OfficialLink: result.OfficialLink, Organizer: *organizer, Status: result.Status, MinimumAge: result.MinimumAge, ReservedSeating: result.ReservedSeating, CancellationPolicy: policyName(result.CancellationPolicy), CancellationTiers: result.CancellationTiers, TicketTiers: tiers[result.ID]}
 			// Return response with the new user ID
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -77,7 +96,18 @@ func CreateEvent(db *gorm.DB) http.HandlerFunc {
 			fmt.Println(err)
 			return
 		}
-		event := models.Event{EventName: payload.EventName, Location: payload.Location, Images: pq.StringArray(payload.Images), Description: payload.Description, Date: payload.Date, Time: payload.Time, Price: payload.Price, AvailableSeats: payload.TotalSeats, TotalSeats: payload.TotalSeats, Coordinates: payload.Coordinates, OfficialLink: payload.OfficialLink, OrganizerID: payload.OrganizerID}
+		if err := models.ValidateCancellationPolicy(payload.CancellationPolicy, payload.CancellationTiers); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if payload.MinimumAge > models.MaxMinimumAge {
+			http.Error(w, fmt.Sprintf("minimum age cannot be over %d", models.MaxMinimumAge), http.StatusBadRequest)
+			return
+		}
+		event := models.Event{EventName: payload.EventName, Location: payload.Location, Images: pq.StringArray(payload.Images), Description: payload.Description, Date: payload.Date, Time: payload.Time, Price: payload.Price, AvailableSeats: payload.TotalSeats, TotalSeats: payload.TotalSeats, Coordinates: payload.Coordinates, OfficialLink: payload.OfficialLink, OrganizerID: payload.OrganizerID, Status: models.EventStatusActive, MinimumAge: payload.MinimumAge, CancellationPolicy: policyName(payload.CancellationPolicy)}
+		if event.CancellationPolicy == models.CancellationPolicyCustom {
+			event.CancellationTiers = payload.CancellationTiers
+		}
 		result := db.Create(&event)
 		if result.Error != nil {
 			fmt.Println(result.Error)
@@ -123,8 +153,8 @@ func AddEventBooking(db *gorm.DB) http.HandlerFunc {
 
 		uintValue := uint(u)
 		ui, err := strconv.ParseUint(guests, 10, 32) // base 10, uint32 max bits
-		if err != nil {
-			fmt.Println("Error:", err)
+		if err != nil || ui == 0 || ui > models.MaxGuestsPerBooking {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("guests must be between 1 and %d", models.MaxGuestsPerBooking))
 			return
 		}
 		guestsUintValue := uint(ui)
@@ -145,7 +175,7 @@ func AddEventBooking(db *gorm.DB) http.HandlerFunc {
 		}
 
 		for _, booking := range bookings {
-			if booking.EventId == uintValue {
+			if booking.EventId == uintValue && booking.IsActive() {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusConflict)
 				json.NewEncoder(w).Encode(map[string]string{"message": "Event Booking Already exists for user"})
@@ -160,44 +190,196 @@ func AddEventBooking(db *gorm.DB) http.HandlerFunc {
 			fmt.Println(err)
 			return
 		}
+		if event.IsCancelled() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Event has been cancelled"})
+			return
+		}
+		if event.MinimumAge > 0 {
+			startsAt, err := event.StartsAt()
+			if err != nil {
+				writeMessage(w, http.StatusInternalServerError, "Event has an invalid date")
+				fmt.Println(err)
+				return
+			}
+			var stayErr *models.StayError
+			if err := checkMinimumAge(db, userID, event.MinimumAge, startsAt); err != nil {
+				if errors.As(err, &stayErr) {
+					writeMessage(w, http.StatusForbidden, fmt.Sprintf("You must be at least %d on the event date to book", event.MinimumAge))
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Internal server error")
+				fmt.Println(err)
+				return
+			}
+		}
+
+		// Events with ticket tiers are booked by the tier, at the tier's price
+		var tier *models.TicketTier
+		var tierID *uint
+		if tier_id := queryParams.Get("tier_id"); tier_id != "" {
+			id, err := parseID(tier_id)
+			if err != nil {
+				writeMessage(w, http.StatusBadRequest, "Invalid tier_id")
+				return
+			}
+			tier, err = findTicketTier(db, event.ID, id)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					writeMessage(w, http.StatusNotFound, "Ticket tier not found")
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Internal server error")
+				fmt.Println(err)
+				return
+			}
+			if err := tier.CheckSale(guestsUintValue, time.Now()); err != nil {
+				writeMessage(w, http.StatusConflict, err.Error())
+				return
+			}
+			if price := tier.Price * guestsUintValue; totalcostUintValue != price {
+				writeMessage(w, http.StatusConflict, fmt.Sprintf("The price for these tickets is now %d", price))
+				return
+			}
+			tierID = &tier.ID
+		} else if tiered, err := hasTicketTiers(db, event.ID); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Internal server error")
+			fmt.Println(err)
+			return
+		} else if tiered {
+			writeMessage(w, http.StatusBadRequest, "tier_id is required for this event")
+			return
+		}
+
+		// Events with reserved seating are booked by the seat, one per guest, held while the booking is made
+		var seatIDs []uint
+		if event.ReservedSeating {
+			seatIDs, err = parseSeatIDs(queryParams.Get("seat_ids"))
+			if err != nil || uint(len(seatIDs)) != guestsUintValue {
+				writeMessage(w, http.StatusBadRequest, "seat_ids must list one seat per guest for this event")
+				return
+			}
+			if tier != nil {
+				if err := checkSeatSections(db, event.ID, seatIDs, *tier); err != nil {
+					if errors.Is(err, models.ErrSeatsUnavailable) && tier.Section != "" {
+						writeMessage(w, http.StatusBadRequest, fmt.Sprintf("%s tickets are for seats in the %s section", tier.Name, tier.Section))
+						return
+					}
+					if errors.Is(err, models.ErrSeatsUnavailable) {
+						writeMessage(w, http.StatusBadRequest, fmt.Sprintf("%s tickets aren't for seats sold through another tier", tier.Name))
+						return
+					}
+					writeMessage(w, http.StatusInternalServerError, "Internal server error")
+					fmt.Println(err)
+					return
+				}
+			}
+			if _, err := holdSeats(db, event.ID, userID, seatIDs, time.Now()); err != nil {
+				if errors.Is(err, models.ErrSeatsUnavailable) {
+					writeMessage(w, http.StatusConflict, err.Error())
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Internal server error")
+				fmt.Println(err)
+				return
+			}
+		}
+
+		// Seats held above are given up again if the booking isn't made
+		releaseHolds := func() {
+			if seatIDs == nil {
+				return
+			}
+			if err := releaseSeatHolds(db, event.ID, userID); err != nil {
+				fmt.Println(err)
+			}
+		}
+
 		if int(event.AvailableSeats)-int(guestsUintValue) < 0 {
+			releaseHolds()
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Seats not available"})
 			fmt.Println(err)
 			return
 		}
-		bookingID, err := CreateEventBooking(userID, uintValue, guestsUintValue, totalcostUintValue, db)
+		if tierID != nil {
+			if err := reserveTierTickets(db, *tierID, guestsUintValue); err != nil {
+				releaseHolds()
+				if errors.Is(err, models.ErrTierSoldOut) {
+					writeMessage(w, http.StatusConflict, err.Error())
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Internal server error")
+				fmt.Println(err)
+				return
+			}
+		}
+		// The booking takes its seats before it is charged, so nobody pays for seats they didn't get
+		var booking *models.EventBooking
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if booking, err = CreateEventBooking(userID, uintValue, tierID, guestsUintValue, totalcostUintValue, tx); err != nil {
+				return err
+			}
+			if seatIDs != nil {
+				if err := bookHeldSeats(tx, booking, seatIDs); err != nil {
+					return err
+				}
+			}
+			return UpdateEventSeats(event.ID, guestsUintValue, tx)
+		})
 		if err != nil {
+			if err := releaseTierTickets(db, tierID, guestsUintValue); err != nil {
+				fmt.Println(err)
+			}
+			releaseHolds()
+			if errors.Is(err, models.ErrEventSoldOut) {
+				writeMessage(w, http.StatusConflict, err.Error())
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Failed to Create booking"})
 			fmt.Println(err)
 			return
 		}
-
-		err = UpdateEventSeats(event_id, int(event.AvailableSeats)-int(guestsUintValue), db)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"message": "Error updating avaliable seats"})
+		if err := ChargeAndConfirmEventBooking(booking, queryParams.Get("payment_token"), time.Now(), db); err != nil {
 			fmt.Println(err)
+			// A booking still holding a payment that couldn't be refunded keeps its seats
+			if booking.Status == models.BookingStatusCancelled {
+				releaseErr := db.Transaction(func(tx *gorm.DB) error {
+					return releaseEventBooking(tx, booking, event.ReservedSeating)
+				})
+				if releaseErr != nil {
+					fmt.Println(releaseErr)
+				}
+			}
+			if errors.Is(err, payments.ErrPaymentFailed) {
+				writeMessage(w, http.StatusPaymentRequired, "Payment failed")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to confirm booking")
 			return
 		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]int{"id": bookingID})
+		json.NewEncoder(w).Encode(map[string]int{"id": int(booking.ID)})
 	}
 }
 
-func CreateEventBooking(userID, eventID uint, guests uint, total_cost uint, db *gorm.DB) (id int, err error) {
-	booking := models.EventBooking{UserID: userID, EventId: eventID, Guests: guests, TotalCost: total_cost}
+// CreateEventBooking creates a pending event booking. It is confirmed once the payment has been captured.
+// tierID is the ticket tier booked at events with tiers, nil otherwise.
+func CreateEventBooking(userID, eventID uint, tierID *uint, guests uint, total_cost uint, db *gorm.DB) (*models.EventBooking, error) {
+	booking := models.EventBooking{UserID: userID, EventId: eventID, TicketTierID: tierID, Guests: guests, TotalCost: total_cost, Status: models.BookingStatusPending}
 	result := db.Create(&booking)
 	if result.Error != nil {
-		return 0, result.Error
+		return nil, result.Error
 	} else {
 		fmt.Println("Event Booking created successfully:", booking)
-		return int(booking.ID), nil
+		return &booking, nil
 	}
 }
 
@@ -211,15 +393,19 @@ func GetEventBookingByUserID(userID int, db *gorm.DB) ([]models.EventBooking, er
 	}
 }
 
-func UpdateEventSeats(event_id string, availableSeats int, db *gorm.DB) error {
-	event := []models.Event{}
-	result := db.Model(&event).Where("id = ?", event_id).Update("available_seats", availableSeats)
-
+// UpdateEventSeats takes seats for guests from the event, returning models.ErrEventSoldOut if too few are left.
+// The check and update are one statement so concurrent bookings can't oversell the event.
+func UpdateEventSeats(eventID, guests uint, db *gorm.DB) error {
+	result := db.Model(&models.Event{}).
+		Where("id = ? AND available_seats >= ?", eventID, guests).
+		Update("available_seats", gorm.Expr("available_seats - ?", guests))
 	if result.Error != nil {
 		return result.Error
-	} else {
-		return nil
 	}
+	if result.RowsAffected == 0 {
+		return models.ErrEventSoldOut
+	}
+	return nil
 }
 
 func GetEventBookingByID(id string, db *gorm.DB) (*models.EventBooking, error) {
@@ -233,8 +419,12 @@ func GetEventBookingByID(id string, db *gorm.DB) (*models.EventBooking, error) {
 }
 
 func GetEventByID(event_id string, db *gorm.DB) (*models.Event, error) {
+	id, err := lookupID(event_id)
+	if err != nil {
+		return nil, err
+	}
 	var event models.Event
-	result := db.Where("id = ?", event_id).First(&event)
+	result := db.Where("id = ?", id).First(&event)
 	if result.Error != nil {
 		return nil, result.Error
 	} else {
@@ -267,71 +457,474 @@ func GetEventsByLocation(location string, db *gorm.DB) ([]models.Event, error) {
 	}
 }
 
-func RemoveEventBookingByID(id int, db *gorm.DB) error {
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// CancelEventBookingByID marks one of the user's event bookings cancelled, refunds what is due under the
+// event's policy and returns its seats to the event, its ticket tier and the seat map. The booking is locked
+// while cancelling, so its seats can't be returned twice.
+func CancelEventBookingByID(id int, userID uint, db *gorm.DB) (*models.EventBooking, error) {
+	var booking models.EventBooking
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&booking, id).Error; err != nil {
+			return err
+		}
+		var event models.Event
+		if err := tx.First(&event, booking.EventId).Error; err != nil {
+			return err
 		}
-	}()
-
-	result := tx.Where("id = ?", id).Delete(&models.EventBooking{})
 
-	if result.Error != nil {
-		tx.Rollback()
-		return result.Error
+		now := time.Now()
+		refund := QuoteEventBookingRefund(booking, event, now)
+		if err := booking.Transition(models.BookingStatusCancelled, now); err != nil {
+			return err
+		}
+		booking.RefundAmount = refund.RefundAmount
+		if err := tx.Model(&booking).Select("Status", "CancelledAt", "RefundAmount").Updates(&booking).Error; err != nil {
+			return err
+		}
+		return releaseEventBooking(tx, &booking, event.ReservedSeating)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if result.RowsAffected == 0 {
-		tx.Rollback()
-		return errors.New("error removing booking")
+	// Refund once the cancellation is committed, so a rollback can't leave the money returned
+	// on a booking that still stands. Failed refunds are retried by the settle-cancelled-payments job.
+	if err := SettleEventBookingPayment(&booking, db); err != nil {
+		fmt.Printf("Error settling payment for cancelled event booking %d: %v\n", booking.ID, err)
 	}
+	return &booking, nil
+}
 
-	return tx.Commit().Error
+// lockEvent takes a row lock on the event until the transaction ends
+func lockEvent(eventID uint, tx *gorm.DB) error {
+	var event models.Event
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&event, eventID).Error
+}
 
+// releaseEventBooking returns a booking that won't go ahead's seats to the event, its ticket tier
+// and, at events with reserved seating, the seat map
+func releaseEventBooking(tx *gorm.DB, booking *models.EventBooking, reservedSeating bool) error {
+	if err := tx.Model(&models.Event{}).Where("id = ?", booking.EventId).
+		Update("available_seats", gorm.Expr("available_seats + ?", booking.Guests)).Error; err != nil {
+		return err
+	}
+	if err := releaseTierTickets(tx, booking.TicketTierID, booking.Guests); err != nil {
+		return err
+	}
+	if reservedSeating {
+		return releaseBookingSeats(tx, booking.ID)
+	}
+	return nil
 }
 
 func RemoveEventBooking(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "Unauthorized, no session found")
+			return
+		}
+
 		// Parse the JSON body
 		queryParams := r.URL.Query()
 		booking_id := queryParams.Get("event_booking_id")
 
 		u, err := strconv.ParseUint(booking_id, 10, 32) // base 10, uint32 max bits
 		if err != nil {
-			fmt.Println("Error:", err)
+			writeMessage(w, http.StatusBadRequest, "Invalid event_booking_id")
 			return
 		}
 
 		uintValue := uint(u)
-		booking, _ := GetEventBookingByID(booking_id, db)
-		num := uint(booking.EventId)
-		str := strconv.FormatUint(uint64(num), 10)
-		event, err := GetEventByID(str, db)
+		// Other users' bookings are reported as not found
+		booking, err := CancelEventBookingByID(int(uintValue), userID, db)
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Error event booking not found"})
+				return
+			}
+			if errors.Is(err, models.ErrInvalidBookingTransition) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Event booking can no longer be cancelled"})
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"message": "Error event booking not found"})
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Error cancelling event booking"})
 			fmt.Println(err)
 			return
 		}
-		err = UpdateEventSeats(str, int(event.AvailableSeats)+int(booking.Guests), db)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":       "Event Booking cancelled",
+			"refund_amount": booking.RefundAmount,
+		})
+	}
+}
+
+// UpdateEventRequest holds the event fields an organizer can change; omitted fields are left as they are
+type UpdateEventRequest struct {
+	EventName    *string  `json:"EventName"`
+	Location     *string  `json:"Location"`
+	Date         *string  `json:"Date"`
+	Time         *string  `json:"Time"`
+	Images       []string `json:"Images"`
+	Description  *string  `json:"Description"`
+	Price        *string  `json:"Price"`
+	TotalSeats   *uint    `json:"TotalSeats"`
+	OfficialLink *string  `json:"OfficialLink"`
+	Coordinates  *string  `json:"Coordinates"`
+	MinimumAge   *uint    `json:"MinimumAge"` // 0 for none
+
+	CancellationPolicy *string            `json:"CancellationPolicy"`
+	CancellationTiers  models.RefundTiers `json:"CancellationTiers"`
+}
+
+// CancelEventRequest carries the optional reason shown to attendees
+type CancelEventRequest struct {
+	Reason string `json:"reason" example:"Venue unavailable"`
+}
+
+// ErrCapacityBelowBookings is returned when an event is resized below the seats already booked
+var ErrCapacityBelowBookings = errors.New("total seats cannot be lower than seats already booked")
+
+// AvailableSeatsForCapacity returns the seats left once the event holds totalSeats and bookedSeats are taken
+func AvailableSeatsForCapacity(totalSeats, bookedSeats uint) (uint, error) {
+	if totalSeats < bookedSeats {
+		return 0, ErrCapacityBelowBookings
+	}
+	return totalSeats - bookedSeats, nil
+}
+
+// GetBookedSeats sums the guests of every active booking on the event
+func GetBookedSeats(eventID uint, db *gorm.DB) (uint, error) {
+	var booked uint
+	result := db.Model(&models.EventBooking{}).
+		Where("event_id = ? AND status IN ?", eventID, models.ActiveBookingStatuses).
+		Select("COALESCE(SUM(guests), 0)").
+		Scan(&booked)
+	return booked, result.Error
+}
+
+// isEventOrganizer reports whether the logged in user organizes the event.
+// Organizers don't have their own login, so they are matched to users by email.
+func isEventOrganizer(userID uint, event *models.Event, db *gorm.DB) bool {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return false
+	}
+	organizer, err := GetOrganizerByID(event.OrganizerID, db)
+	if err != nil {
+		return false
+	}
+	return organizer.Email != "" && organizer.Email == user.Email
+}
+
+// notifyEventAttendees queues a notification for every user holding an active booking on the event
+func notifyEventAttendees(eventID uint, notificationType, subject, message string, db *gorm.DB) error {
+	var userIDs []uint
+	if err := db.Model(&models.EventBooking{}).
+		Where("event_id = ? AND status IN ?", eventID, models.ActiveBookingStatuses).
+		Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := QueueNotification(userID, notificationType, subject, message, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateEvent lets the organizer reschedule an event or change its details and capacity
+// @Summary Update event
+// @Description Partially update an event. Attendees are notified when the date, time or location changes.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param event body UpdateEventRequest true "Fields to update"
+// @Success 200 {object} models.Event
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not organize this event"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Failure 409 {object} map[string]string "Event cancelled or capacity below bookings"
+// @Router /events/{id} [patch]
+func UpdateEvent(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		eventID := mux.Vars(r)["id"]
+		event, err := GetEventByID(eventID, db)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"message": "Error updating avaliable seats"})
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
+			fmt.Println(err)
+			return
+		}
+
+		if !isEventOrganizer(userID, event, db) {
+			writeMessage(w, http.StatusForbidden, "Only the event organizer can update this event")
+			return
+		}
+		if event.IsCancelled() {
+			writeMessage(w, http.StatusConflict, "Cancelled events cannot be updated")
+			return
+		}
+
+		var req UpdateEventRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		rescheduled := (req.Date != nil && *req.Date != event.Date) ||
+			(req.Time != nil && *req.Time != event.Time) ||
+			(req.Location != nil && *req.Location != event.Location)
+
+		var columns []string
+		if req.EventName != nil {
+			event.EventName = *req.EventName
+			columns = append(columns, "EventName")
+		}
+		if req.Location != nil {
+			event.Location = *req.Location
+			columns = append(columns, "Location")
+		}
+		if req.Date != nil {
+			event.Date = *req.Date
+			columns = append(columns, "Date")
+		}
+		if req.Time != nil {
+			event.Time = *req.Time
+			columns = append(columns, "Time")
+		}
+		if req.Date != nil || req.Time != nil {
+			if err := event.ValidateSchedule(); err != nil {
+				writeMessage(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.Images != nil {
+			event.Images = pq.StringArray(req.Images)
+			columns = append(columns, "Images")
+		}
+		if req.Description != nil {
+			event.Description = *req.Description
+			columns = append(columns, "Description")
+		}
+		if req.Price != nil {
+			event.Price = *req.Price
+			columns = append(columns, "Price")
+		}
+		if req.OfficialLink != nil {
+			event.OfficialLink = *req.OfficialLink
+			columns = append(columns, "OfficialLink")
+		}
+		if req.Coordinates != nil {
+			event.Coordinates = *req.Coordinates
+			columns = append(columns, "Coordinates")
+		}
+		if req.MinimumAge != nil {
+			if *req.MinimumAge > models.MaxMinimumAge {
+				writeMessage(w, http.StatusBadRequest, fmt.Sprintf("minimum age cannot be over %d", models.MaxMinimumAge))
+				return
+			}
+			event.MinimumAge = *req.MinimumAge
+			columns = append(columns, "MinimumAge")
+		}
+		if req.CancellationPolicy != nil {
+			if err := models.ValidateCancellationPolicy(*req.CancellationPolicy, req.CancellationTiers); err != nil {
+				writeMessage(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			event.CancellationPolicy = policyName(*req.CancellationPolicy)
+			event.CancellationTiers = nil
+			columns = append(columns, "CancellationPolicy", "CancellationTiers")
+			if event.CancellationPolicy == models.CancellationPolicyCustom {
+				event.CancellationTiers = req.CancellationTiers
+			}
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if req.TotalSeats != nil {
+				// Bookings take seats under the same row lock, so none are missed between counting and saving
+				if err := lockEvent(event.ID, tx); err != nil {
+					return err
+				}
+				booked, err := GetBookedSeats(event.ID, tx)
+				if err != nil {
+					return err
+				}
+				available, err := AvailableSeatsForCapacity(*req.TotalSeats, booked)
+				if err != nil {
+					return err
+				}
+				event.TotalSeats = *req.TotalSeats
+				event.AvailableSeats = available
+				columns = append(columns, "TotalSeats", "AvailableSeats")
+			}
+
+			// Only the fields in the request are written, so seats booked meanwhile aren't overwritten
+			if len(columns) > 0 {
+				if err := tx.Model(event).Select(columns).Updates(event).Error; err != nil {
+					return err
+				}
+			}
+
+			if rescheduled {
+				message := fmt.Sprintf("%s has been rescheduled to %s %s at %s.", event.EventName, event.Date, event.Time, event.Location)
+				return notifyEventAttendees(event.ID, "event_updated", "Event updated: "+event.EventName, message, tx)
+			}
+			return nil
+		})
+		if err != nil {
+			if errors.Is(err, ErrCapacityBelowBookings) {
+				writeMessage(w, http.StatusConflict, "Total seats cannot be lower than seats already booked")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to update event")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, event)
+	}
+}
+
+// errEventCancelled is returned when an event was cancelled by someone else first
+var errEventCancelled = errors.New("event is already cancelled")
+
+// CancelEvent cancels an event, cancels its bookings and notifies every attendee
+// @Summary Cancel event
+// @Description Cancel an event. All active bookings are cancelled and their users notified.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param reason body CancelEventRequest false "Cancellation reason"
+// @Success 200 {object} map[string]interface{} "Event cancelled with the number of affected bookings"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not organize this event"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Failure 409 {object} map[string]string "Event already cancelled"
+// @Router /events/{id}/cancel [post]
+func CancelEvent(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		eventID := mux.Vars(r)["id"]
+		event, err := GetEventByID(eventID, db)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
 			fmt.Println(err)
 			return
 		}
 
-		err = RemoveEventBookingByID(int(uintValue), db)
+		if !isEventOrganizer(userID, event, db) {
+			writeMessage(w, http.StatusForbidden, "Only the event organizer can cancel this event")
+			return
+		}
+		if event.IsCancelled() {
+			writeMessage(w, http.StatusConflict, "Event is already cancelled")
+			return
+		}
+
+		// The reason is optional, so an empty body is fine
+		var req CancelEventRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		message := fmt.Sprintf("%s on %s has been cancelled by the organizer.", event.EventName, event.Date)
+		if req.Reason != "" {
+			message += " Reason: " + req.Reason
+		}
+
+		var cancelled int64
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Claim the event first. The update locks its row, so a second cancellation waits
+			// here and then finds it already cancelled, and every seat is freed from the row itself.
+			now := time.Now()
+			claim := tx.Model(&models.Event{}).
+				Where("id = ? AND (status IS NULL OR status <> ?)", event.ID, models.EventStatusCancelled).
+				Updates(map[string]interface{}{
+					"status":          models.EventStatusCancelled,
+					"cancelled_at":    now,
+					"available_seats": gorm.Expr("total_seats"),
+				})
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return errEventCancelled
+			}
+
+			// Notify before the bookings are cancelled so the attendees can still be found
+			if err := notifyEventAttendees(event.ID, "event_cancelled", "Event cancelled: "+event.EventName, message, tx); err != nil {
+				return err
+			}
+
+			// Only pending and confirmed bookings may move to cancelled.
+			// The organizer called it off, so confirmed bookings are refunded in full whatever the policy.
+			result := tx.Model(&models.EventBooking{}).
+				Where("event_id = ? AND status IN ?", event.ID, models.ActiveBookingStatuses).
+				Updates(map[string]interface{}{
+					"status":        models.BookingStatusCancelled,
+					"cancelled_at":  now,
+					"refund_amount": gorm.Expr("CASE WHEN status = ? THEN total_cost ELSE 0 END", models.BookingStatusConfirmed),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			cancelled = result.RowsAffected
+
+			if err := tx.Model(&models.TicketTier{}).Where("event_id = ?", event.ID).Update("sold", 0).Error; err != nil {
+				return err
+			}
+			if event.ReservedSeating {
+				err := tx.Model(&models.Seat{}).Where("event_id = ?", event.ID).
+					Updates(map[string]interface{}{"event_booking_id": nil, "held_by": nil, "held_until": nil}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errEventCancelled) {
+			writeMessage(w, http.StatusConflict, "Event is already cancelled")
+			return
+		}
 		if err != nil {
-			http.Error(w, "Event Booking not found", http.StatusNotFound)
+			writeMessage(w, http.StatusInternalServerError, "Failed to cancel event")
+			fmt.Println(err)
 			return
 		}
+		// Refunds go out once the cancellation is committed; any that fail are logged for follow up
+		RefundCancelledEvent(event.ID, db)
 
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode("Event Booking removed")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":            "Event cancelled",
+			"cancelled_bookings": cancelled,
+		})
 	}
 }
@@ -2,11 +2,14 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"gorm.io/gorm"
 	"roam.io/models" // Ensure models package is imported
+	"roam.io/payments"
 )
 
 // CreateOwner handles the creation of a new Owner (previously Host)
@@ -39,3 +42,283 @@ func CreateOwner(db *gorm.DB) http.HandlerFunc { // Renamed function
 		json.NewEncoder(w).Encode(owner) // Encode the created owner
 	}
 }
+
+// isAccommodationOwner reports whether the logged in user owns the accommodation.
+// Owners don't have their own login, so they are matched to users by email.
+func isAccommodationOwner(userID uint, accommodationID uint, db *gorm.DB) bool {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return false
+	}
+	var accommodation models.Accommodation
+	if err := db.Preload("Owner").First(&accommodation, accommodationID).Error; err != nil {
+		return false
+	}
+	return accommodation.Owner.Email != "" && accommodation.Owner.Email == user.Email
+}
+
+// loadOwnedAccommodation fetches the accommodation in the URL and checks the session user owns it.
+// It writes the error response and returns nil if they don't.
+func loadOwnedAccommodation(w http.ResponseWriter, r *http.Request, db *gorm.DB) *models.Accommodation {
+	session, _ := getSession(r, "session")
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok || userID == 0 {
+		writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+		return nil
+	}
+
+	var accommodation models.Accommodation
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return nil
+	}
+	if err := db.First(&accommodation, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeMessage(w, http.StatusNotFound, "Accommodation not found")
+			return nil
+		}
+		writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+		fmt.Println(err)
+		return nil
+	}
+	if !isAccommodationOwner(userID, accommodation.ID, db) {
+		writeMessage(w, http.StatusForbidden, "Only the accommodation owner can do this")
+		return nil
+	}
+	return &accommodation
+}
+
+// ApproveBookingRequest confirms a pending booking request on one of the owner's accommodations
+// @Summary Approve booking request
+// @Description Confirm a pending booking request. Requests past their hold can no longer be approved.
+// @Tags owner
+// @Produce json
+// @Param id path int true "Booking ID"
+// @Success 200 {object} map[string]interface{} "Booking confirmed"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Failure 404 {object} map[string]string "Booking not found"
+// @Failure 409 {object} map[string]string "Booking is not a pending request"
+// @Router /owner/bookings/{id}/approve [post]
+func ApproveBookingRequest(db *gorm.DB) http.HandlerFunc {
+	return respondToBookingRequest(db, models.BookingStatusConfirmed)
+}
+
+// DeclineBookingRequest turns down a pending booking request and releases its dates
+// @Summary Decline booking request
+// @Description Decline a pending booking request, releasing the held dates
+// @Tags owner
+// @Produce json
+// @Param id path int true "Booking ID"
+// @Success 200 {object} map[string]interface{} "Booking declined"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Failure 404 {object} map[string]string "Booking not found"
+// @Failure 409 {object} map[string]string "Booking is not a pending request"
+// @Router /owner/bookings/{id}/decline [post]
+func DeclineBookingRequest(db *gorm.DB) http.HandlerFunc {
+	return respondToBookingRequest(db, models.BookingStatusDeclined)
+}
+
+func respondToBookingRequest(db *gorm.DB, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.Booking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch booking")
+			fmt.Println(err)
+			return
+		}
+
+		if !isAccommodationOwner(userID, booking.AccommodationID, db) {
+			writeMessage(w, http.StatusForbidden, "Only the accommodation owner can respond to this booking")
+			return
+		}
+		if booking.Status != models.BookingStatusPending {
+			writeMessage(w, http.StatusConflict, "Booking is not a pending request")
+			return
+		}
+
+		now := time.Now()
+		if booking.ExpiresAt != nil && !booking.ExpiresAt.After(now) {
+			// The expiry job hasn't caught up with this request yet
+			if err := ExpireBookingRequest(&booking, now, db); err != nil {
+				fmt.Println(err)
+			}
+			writeMessage(w, http.StatusConflict, "Booking request has expired")
+			return
+		}
+
+		if err := booking.Transition(status, now); err != nil {
+			writeMessage(w, http.StatusConflict, "Booking is not a pending request")
+			return
+		}
+		// A confirmed booking keeps its dates for good
+		booking.ExpiresAt = nil
+
+		subject, message := "Booking confirmed", fmt.Sprintf("Your booking request #%d has been approved by the host.", booking.ID)
+		if status == models.BookingStatusDeclined {
+			subject, message = "Booking declined", fmt.Sprintf("Your booking request #%d was declined by the host.", booking.ID)
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := answerBookingRequest(&booking, tx, "ExpiresAt", "ConfirmedAt", "DeclinedAt"); err != nil {
+				return err
+			}
+			if err := QueueNotification(booking.UserID, "booking_"+status, subject, message, tx); err != nil {
+				return err
+			}
+			// Take the payment last, once the request is claimed; a failed capture rolls back and
+			// leaves the request pending for the guest to sort out
+			if status == models.BookingStatusConfirmed {
+				return CaptureBookingRequest(&booking, db)
+			}
+			return nil
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, errBookingNotPending):
+				writeMessage(w, http.StatusConflict, "Booking is not a pending request")
+			case errors.Is(err, payments.ErrPaymentFailed):
+				writeMessage(w, http.StatusPaymentRequired, "Payment could not be captured")
+			default:
+				writeMessage(w, http.StatusInternalServerError, "Failed to update booking")
+			}
+			fmt.Println(err)
+			return
+		}
+
+		// A release that fails here is retried by the settle-cancelled-payments job
+		if status == models.BookingStatusDeclined {
+			if err := SettleBookingPayment(&booking, db); err != nil {
+				fmt.Printf("Error releasing payment for declined booking %d: %v\n", booking.ID, err)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     booking.ID,
+			"status": booking.Status,
+		})
+	}
+}
+
+// errBookingNotPending is returned when a booking request was answered, cancelled or expired by someone else first
+var errBookingNotPending = errors.New("booking is not a pending request")
+
+// answerBookingRequest saves the booking's new status and the given timestamp columns, as long as the request
+// is still pending, so an owner's answer, the guest's cancellation and the expiry job can't overwrite each other
+func answerBookingRequest(booking *models.Booking, tx *gorm.DB, columns ...string) error {
+	result := tx.Model(booking).Where("status = ?", models.BookingStatusPending).
+		Select("Status", columns).Updates(booking)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errBookingNotPending
+	}
+	return nil
+}
+
+// ExpireBookingRequest marks a pending request expired, releasing its dates and the held payment, and tells the guest
+func ExpireBookingRequest(booking *models.Booking, now time.Time, db *gorm.DB) error {
+	if err := booking.Transition(models.BookingStatusExpired, now); err != nil {
+		return err
+	}
+	// Nothing is owed for a request that was never answered, so anything captured goes back
+	booking.RefundAmount = booking.TotalCost
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := answerBookingRequest(booking, tx, "ExpiredAt", "RefundAmount"); err != nil {
+			return err
+		}
+		message := fmt.Sprintf("Your booking request #%d expired before the host responded. The dates have been released.", booking.ID)
+		return QueueNotification(booking.UserID, "booking_expired", "Booking request expired", message, tx)
+	})
+	if err != nil {
+		return err
+	}
+	return SettleBookingPayment(booking, db)
+}
+
+// MarkBookingNoShow records that the guest of a confirmed stay never arrived. The payment is kept
+// and the rest of the stay's dates are released.
+// @Summary Mark booking as no-show
+// @Description Mark a confirmed booking whose check-in date has come as a no-show
+// @Tags owner
+// @Produce json
+// @Param id path int true "Booking ID"
+// @Success 200 {object} map[string]interface{} "Booking marked as no-show"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Failure 404 {object} map[string]string "Booking not found"
+// @Failure 409 {object} map[string]string "Booking is not confirmed or its check-in date hasn't come"
+// @Router /owner/bookings/{id}/no-show [post]
+func MarkBookingNoShow(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.Booking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch booking")
+			fmt.Println(err)
+			return
+		}
+		if !isAccommodationOwner(userID, booking.AccommodationID, db) {
+			writeMessage(w, http.StatusForbidden, "Only the accommodation owner can mark this booking")
+			return
+		}
+
+		now := time.Now()
+		if now.Before(booking.CheckinDate) {
+			writeMessage(w, http.StatusConflict, "The guest's check-in date hasn't come yet")
+			return
+		}
+		if err := booking.Transition(models.BookingStatusNoShow, now); err != nil {
+			writeMessage(w, http.StatusConflict, "Only confirmed bookings can be marked as a no-show")
+			return
+		}
+		// Only a booking that is still confirmed, so a cancellation or the completion job can't be overwritten
+		result := db.Model(&booking).Where("status = ?", models.BookingStatusConfirmed).
+			Select("Status", "NoShowAt").Updates(&booking)
+		if result.Error != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update booking")
+			fmt.Println(result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			writeMessage(w, http.StatusConflict, "Only confirmed bookings can be marked as a no-show")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     booking.ID,
+			"status": booking.Status,
+		})
+	}
+}
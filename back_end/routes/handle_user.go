@@ -62,6 +62,8 @@ func CreateUserHandler(db *gorm.DB) http.HandlerFunc {
 				return
 			}
 		}
+		// Keep the birth date as written, at midnight UTC, so ages don't shift with time zones
+		dob = time.Date(dob.Year(), dob.Month(), dob.Day(), 0, 0, 0, 0, time.UTC)
 
 		// Hash the password
 		hashedPw, err := HashPassword(userReq.Password)
@@ -103,7 +105,7 @@ func CreateUser(name, email, username, password string, dob time.Time, db *gorm.
 		Username: username,
 		Password: password,
 		Dob:      dob,
-		AvatarID: "Marshmallow", // Explicitly set the default avatar ID
+		AvatarID: models.DefaultAvatarID, // Explicitly set the default avatar ID
 	}
 
 	result := db.Create(&user)
@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"roam.io/models"
+)
+
+// Kinds of itinerary entries
+const (
+	ItineraryCheckIn  = "check_in"
+	ItineraryStay     = "stay" // a night in the middle of a stay
+	ItineraryCheckOut = "check_out"
+	ItineraryEvent    = "event"
+)
+
+// Itinerary warning codes
+const (
+	WarningOutsideTrip      = "outside_trip_dates" // a stay or event falls outside the trip's dates
+	WarningEventOutsideStay = "event_outside_stay" // an event falls on a day without a stay
+	WarningEventOverlap     = "event_overlap"
+	WarningStayOverlap      = "stay_overlap"
+	WarningUnscheduledEvent = "unscheduled_event" // the event's date can't be read
+)
+
+// Accommodations and events don't record these, so the itinerary assumes them
+var (
+	CheckInTime  = 15 * time.Hour // after midnight on the check-in day
+	CheckOutTime = 11 * time.Hour // after midnight on the check-out day
+	// EventDuration is how long an event is taken to last when looking for overlaps
+	EventDuration = 3 * time.Hour
+)
+
+// TripStay is an accommodation booking planned under a trip
+type TripStay struct {
+	Booking       models.Booking
+	Accommodation models.Accommodation
+}
+
+// TripEvent is an event booking planned under a trip
+type TripEvent struct {
+	Booking models.EventBooking
+	Event   models.Event
+}
+
+// ItineraryItem is one entry on a day of the itinerary
+type ItineraryItem struct {
+	Kind            string
+	At              time.Time
+	Title           string
+	Location        string
+	UserID          uint // who booked it, as members of a shared trip add their own bookings
+	BookingID       uint `json:",omitempty"`
+	AccommodationID uint `json:",omitempty"`
+	EventBookingID  uint `json:",omitempty"`
+	EventID         uint `json:",omitempty"`
+}
+
+// ItineraryDay lists a day's entries in the order they happen
+type ItineraryDay struct {
+	Date  string // YYYY-MM-DD
+	Items []ItineraryItem
+}
+
+// ItineraryWarning points out a likely mistake in the plan
+type ItineraryWarning struct {
+	Code            string
+	Message         string
+	BookingIDs      []uint `json:",omitempty"`
+	EventBookingIDs []uint `json:",omitempty"`
+}
+
+// Itinerary is a trip laid out day by day
+type Itinerary struct {
+	Trip     models.Trip
+	Days     []ItineraryDay
+	Warnings []ItineraryWarning
+}
+
+// BuildItinerary merges the trip's stays and events into one list per day, from the trip's first day
+// to its last, stretched to take in anything booked outside them. Cancelled and otherwise inactive
+// bookings are left out.
+func BuildItinerary(trip models.Trip, stays []TripStay, events []TripEvent) Itinerary {
+	itinerary := Itinerary{Trip: trip, Days: []ItineraryDay{}, Warnings: []ItineraryWarning{}}
+	warn := func(code, message string, bookingIDs, eventBookingIDs []uint) {
+		itinerary.Warnings = append(itinerary.Warnings, ItineraryWarning{code, message, bookingIDs, eventBookingIDs})
+	}
+	var items []ItineraryItem
+
+	var active []TripStay
+	for _, stay := range stays {
+		if !stay.Booking.IsActive() {
+			continue
+		}
+		active = append(active, stay)
+		checkIn, checkOut := models.CalendarDay(stay.Booking.CheckinDate), models.CalendarDay(stay.Booking.CheckoutDate)
+		add := func(kind string, at time.Time) {
+			items = append(items, ItineraryItem{
+				Kind:            kind,
+				At:              at,
+				Title:           stay.Accommodation.Name,
+				Location:        stay.Accommodation.Location,
+				UserID:          stay.Booking.UserID,
+				BookingID:       stay.Booking.ID,
+				AccommodationID: stay.Booking.AccommodationID,
+			})
+		}
+		add(ItineraryCheckIn, checkIn.Add(CheckInTime))
+		for day := checkIn.AddDate(0, 0, 1); day.Before(checkOut); day = day.AddDate(0, 0, 1) {
+			add(ItineraryStay, day)
+		}
+		add(ItineraryCheckOut, checkOut.Add(CheckOutTime))
+
+		if !trip.Covers(checkIn) || !trip.Covers(checkOut) {
+			warn(WarningOutsideTrip, fmt.Sprintf("Your stay at %s is outside the trip dates", stay.Accommodation.Name),
+				[]uint{stay.Booking.ID}, nil)
+		}
+	}
+
+	for i := range active {
+		for j := i + 1; j < len(active); j++ {
+			a, b := active[i].Booking, active[j].Booking
+			if models.CalendarDay(a.CheckinDate).Before(models.CalendarDay(b.CheckoutDate)) &&
+				models.CalendarDay(b.CheckinDate).Before(models.CalendarDay(a.CheckoutDate)) {
+				warn(WarningStayOverlap, fmt.Sprintf("Your stays at %s and %s overlap", active[i].Accommodation.Name, active[j].Accommodation.Name),
+					[]uint{a.ID, b.ID}, nil)
+			}
+		}
+	}
+
+	type scheduledEvent struct {
+		TripEvent
+		start time.Time
+	}
+	var scheduled []scheduledEvent
+	for _, event := range events {
+		if !event.Booking.IsActive() || event.Event.IsCancelled() {
+			continue
+		}
+		start, err := event.Event.StartsAt()
+		if err != nil {
+			warn(WarningUnscheduledEvent, fmt.Sprintf("%s has no date we can read, so it isn't on the itinerary", event.Event.EventName),
+				nil, []uint{event.Booking.ID})
+			continue
+		}
+		scheduled = append(scheduled, scheduledEvent{event, start})
+		items = append(items, ItineraryItem{
+			Kind:           ItineraryEvent,
+			At:             start,
+			Title:          event.Event.EventName,
+			Location:       event.Event.Location,
+			UserID:         event.Booking.UserID,
+			EventBookingID: event.Booking.ID,
+			EventID:        event.Event.ID,
+		})
+
+		if !trip.Covers(start) {
+			warn(WarningOutsideTrip, fmt.Sprintf("%s is outside the trip dates", event.Event.EventName), nil, []uint{event.Booking.ID})
+		} else if !duringStay(start, active) {
+			warn(WarningEventOutsideStay, fmt.Sprintf("%s is on a day you have no stay booked", event.Event.EventName), nil, []uint{event.Booking.ID})
+		}
+	}
+
+	sort.SliceStable(scheduled, func(i, j int) bool { return scheduled[i].start.Before(scheduled[j].start) })
+	for i := range scheduled {
+		for j := i + 1; j < len(scheduled) && scheduled[j].start.Before(scheduled[i].start.Add(EventDuration)); j++ {
+			warn(WarningEventOverlap, fmt.Sprintf("%s and %s overlap", scheduled[i].Event.EventName, scheduled[j].Event.EventName),
+				nil, []uint{scheduled[i].Booking.ID, scheduled[j].Booking.ID})
+		}
+	}
+
+	// Lay the entries out over every day of the trip, and any day booked outside it
+	sort.SliceStable(items, func(i, j int) bool { return items[i].At.Before(items[j].At) })
+	first, last := models.CalendarDay(trip.StartDate), models.CalendarDay(trip.EndDate)
+	if len(items) > 0 {
+		if day := models.CalendarDay(items[0].At); day.Before(first) {
+			first = day
+		}
+		if day := models.CalendarDay(items[len(items)-1].At); day.After(last) {
+			last = day
+		}
+	}
+	next := 0
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		entry := ItineraryDay{Date: day.Format("2006-01-02"), Items: []ItineraryItem{}}
+		for ; next < len(items) && models.CalendarDay(items[next].At).Equal(day); next++ {
+			entry.Items = append(entry.Items, items[next])
+		}
+		itinerary.Days = append(itinerary.Days, entry)
+	}
+	return itinerary
+}
+
+// duringStay reports whether at falls between the check-in and check-out days of one of the stays
+func duringStay(at time.Time, stays []TripStay) bool {
+	day := models.CalendarDay(at)
+	for _, stay := range stays {
+		if !day.Before(models.CalendarDay(stay.Booking.CheckinDate)) && !day.After(models.CalendarDay(stay.Booking.CheckoutDate)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,465 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"roam.io/media"
+	"roam.io/models"
+)
+
+// mediaStore keeps uploaded images
+var mediaStore media.BlobStore = media.NewLocalStore("uploads")
+
+// SetMediaStore replaces the store uploaded images are kept in
+func SetMediaStore(store media.BlobStore) {
+	mediaStore = store
+}
+
+// UnattachedMediaTTL is how long an upload is kept without being attached to anything
+const UnattachedMediaTTL = 24 * time.Hour
+
+// MaxMediaPerRequest caps how many images are attached in one request
+const MaxMediaPerRequest = 10
+
+// AttachMediaRequest lists uploaded images to attach
+type AttachMediaRequest struct {
+	MediaIDs []uint `json:"MediaIDs"`
+}
+
+// errMediaNotAttachable is returned when an image is missing, someone else's or already attached
+var errMediaNotAttachable = errors.New("media must be your own unattached uploads")
+
+// newMediaKey returns a random blob key prefix so uploads can be stored before their row exists
+func newMediaKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "media/" + hex.EncodeToString(b), nil
+}
+
+// UploadMedia stores an uploaded image and its thumbnail
+// @Summary Upload image
+// @Description Upload a JPEG, PNG or GIF of up to 10 MB as the "file" form field. Metadata such as EXIF is removed and a thumbnail is generated. Attach the returned ID to an accommodation, event or review.
+// @Tags media
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Image"
+// @Success 201 {object} models.Media "Uploaded image"
+// @Failure 400 {object} map[string]string "Missing or unreadable image"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 413 {object} map[string]string "Image too large"
+// @Failure 415 {object} map[string]string "Not a supported image type"
+// @Router /media [post]
+func UploadMedia(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		// Leave room for the multipart framing around the file
+		r.Body = http.MaxBytesReader(w, r.Body, media.MaxUploadSize+1<<20)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeMessage(w, http.StatusRequestEntityTooLarge, media.ErrTooLarge.Error())
+				return
+			}
+			writeMessage(w, http.StatusBadRequest, "Missing file")
+			return
+		}
+		defer file.Close()
+
+		processed, err := media.Process(file)
+		if err != nil {
+			switch {
+			case errors.Is(err, media.ErrTooLarge):
+				writeMessage(w, http.StatusRequestEntityTooLarge, err.Error())
+			case errors.Is(err, media.ErrUnsupportedType):
+				writeMessage(w, http.StatusUnsupportedMediaType, err.Error())
+			case errors.Is(err, media.ErrInvalidImage):
+				writeMessage(w, http.StatusBadRequest, err.Error())
+			default:
+				writeMessage(w, http.StatusInternalServerError, "Failed to process image")
+				fmt.Println(err)
+			}
+			return
+		}
+
+		prefix, err := newMediaKey()
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to store image")
+			fmt.Println(err)
+			return
+		}
+		upload := models.Media{
+			UserID:       userID,
+			ContentType:  processed.Original.ContentType,
+			Size:         len(processed.Original.Data),
+			Width:        processed.Original.Width,
+			Height:       processed.Original.Height,
+			Key:          prefix + "/original" + processed.Original.Ext(),
+			ThumbnailKey: prefix + "/thumbnail" + processed.Thumbnail.Ext(),
+		}
+
+		if err := storeMedia(&upload, processed, db); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to store image")
+			fmt.Println(err)
+			return
+		}
+
+		upload.SetURLs(apiBaseURL)
+		writeJSON(w, http.StatusCreated, upload)
+	}
+}
+
+// storeMedia writes the image and thumbnail blobs, then records them.
+// The blobs are removed again if the row can't be saved.
+func storeMedia(upload *models.Media, processed *media.Processed, db *gorm.DB) error {
+	if err := mediaStore.Put(upload.Key, bytes.NewReader(processed.Original.Data)); err != nil {
+		return err
+	}
+	if err := mediaStore.Put(upload.ThumbnailKey, bytes.NewReader(processed.Thumbnail.Data)); err != nil {
+		mediaStore.Delete(upload.Key)
+		return err
+	}
+	if err := db.Create(upload).Error; err != nil {
+		mediaStore.Delete(upload.Key)
+		mediaStore.Delete(upload.ThumbnailKey)
+		return err
+	}
+	return nil
+}
+
+// ServeMedia serves an uploaded image
+// @Summary Get image
+// @Description Download an uploaded image. Images that haven't been attached yet, and photos of reviews that aren't published, are only visible to the uploader.
+// @Tags media
+// @Produce image/jpeg,image/png
+// @Param id path int true "Media ID"
+// @Success 200 {file} binary "Image"
+// @Failure 404 {object} map[string]string "Image not found"
+// @Router /media/{id} [get]
+func ServeMedia(db *gorm.DB) http.HandlerFunc {
+	return serveMedia(db, false)
+}
+
+// ServeMediaThumbnail serves the thumbnail of an uploaded image
+// @Summary Get image thumbnail
+// @Description Download the thumbnail of an uploaded image, at most 320 pixels on its longest side
+// @Tags media
+// @Produce image/jpeg,image/png
+// @Param id path int true "Media ID"
+// @Success 200 {file} binary "Thumbnail"
+// @Failure 404 {object} map[string]string "Image not found"
+// @Router /media/{id}/thumbnail [get]
+func ServeMediaThumbnail(db *gorm.DB) http.HandlerFunc {
+	return serveMedia(db, true)
+}
+
+func serveMedia(db *gorm.DB, thumbnail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var upload models.Media
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&upload, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Image not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch image")
+			fmt.Println(err)
+			return
+		}
+		public, err := isMediaPublic(upload, db)
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch image")
+			fmt.Println(err)
+			return
+		}
+		if !public {
+			session, _ := getSession(r, "session")
+			if userID, _ := session.Values["user_id"].(uint); userID != upload.UserID {
+				writeMessage(w, http.StatusNotFound, "Image not found")
+				return
+			}
+		}
+
+		key := upload.Key
+		if thumbnail {
+			key = upload.ThumbnailKey
+		}
+		blob, err := mediaStore.Open(key)
+		if err != nil {
+			if errors.Is(err, media.ErrBlobNotFound) {
+				writeMessage(w, http.StatusNotFound, "Image not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to read image")
+			fmt.Println(err)
+			return
+		}
+		defer blob.Close()
+
+		// Images never change once uploaded, but private ones must not sit in shared caches
+		cache := "public, max-age=31536000, immutable"
+		if !public {
+			cache = "private, no-cache"
+		}
+		w.Header().Set("Content-Type", upload.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", cache)
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, blob)
+	}
+}
+
+// isMediaPublic reports whether anyone may see an image: it must be attached, and a review photo
+// only while its review is published
+func isMediaPublic(upload models.Media, db *gorm.DB) (bool, error) {
+	if !upload.IsAttached() {
+		return false, nil
+	}
+	if upload.ReviewID == nil {
+		return true, nil
+	}
+	var published int64
+	err := db.Model(&models.Review{}).
+		Where("id = ? AND moderation_status = ?", *upload.ReviewID, models.ReviewStatusApproved).
+		Count(&published).Error
+	return published > 0, err
+}
+
+// DeleteUnattachedMedia removes an upload and its blobs if it still hasn't been attached,
+// so an image being attached at the same time is left alone
+func DeleteUnattachedMedia(upload models.Media, db *gorm.DB) error {
+	result := db.Where("accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL").Delete(&upload)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	if err := mediaStore.Delete(upload.Key); err != nil {
+		return err
+	}
+	return mediaStore.Delete(upload.ThumbnailKey)
+}
+
+// attachMedia claims the user's unattached uploads for a listing or review by setting column to targetID.
+// It fails with errMediaNotAttachable unless every image could be claimed.
+func attachMedia(userID uint, ids []uint, column string, targetID uint, tx *gorm.DB) ([]models.Media, error) {
+	result := tx.Model(&models.Media{}).
+		Where("id IN ? AND user_id = ? AND accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL", ids, userID).
+		Update(column, targetID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != int64(len(ids)) {
+		return nil, errMediaNotAttachable
+	}
+
+	var attached []models.Media
+	if err := tx.Where("id IN ?", ids).Order("id").Find(&attached).Error; err != nil {
+		return nil, err
+	}
+	for i := range attached {
+		attached[i].SetURLs(apiBaseURL)
+	}
+	return attached, nil
+}
+
+// decodeAttachMediaRequest reads and checks the list of images to attach, writing an error response if it is unusable
+func decodeAttachMediaRequest(w http.ResponseWriter, r *http.Request) []uint {
+	var req AttachMediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+		return nil
+	}
+	if len(req.MediaIDs) == 0 || len(req.MediaIDs) > MaxMediaPerRequest {
+		writeMessage(w, http.StatusBadRequest, fmt.Sprintf("Attach between 1 and %d images", MaxMediaPerRequest))
+		return nil
+	}
+	seen := make(map[uint]bool, len(req.MediaIDs))
+	for _, id := range req.MediaIDs {
+		if seen[id] {
+			writeMessage(w, http.StatusBadRequest, "Each image can only be listed once")
+			return nil
+		}
+		seen[id] = true
+	}
+	return req.MediaIDs
+}
+
+// writeAttachResult reports the outcome of attaching images
+func writeAttachResult(w http.ResponseWriter, attached []models.Media, err error) {
+	if err != nil {
+		if errors.Is(err, errMediaNotAttachable) {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeMessage(w, http.StatusInternalServerError, "Failed to attach images")
+		fmt.Println(err)
+		return
+	}
+	writeJSON(w, http.StatusOK, attached)
+}
+
+// mediaURLs returns the URLs attached images are served from
+func mediaURLs(attached []models.Media) pq.StringArray {
+	urls := make(pq.StringArray, len(attached))
+	for i, m := range attached {
+		urls[i] = m.URL
+	}
+	return urls
+}
+
+// AttachAccommodationMedia adds uploaded images to an accommodation's photos
+// @Summary Attach accommodation photos
+// @Description Attach your uploaded images to one of your accommodations. Their URLs are appended to the accommodation's ImageUrls.
+// @Tags media
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param media body AttachMediaRequest true "Uploaded image IDs"
+// @Success 200 {array} models.Media "Attached images"
+// @Failure 400 {object} map[string]string "Images missing, not yours or already attached"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Router /accommodations/{id}/media [post]
+func AttachAccommodationMedia(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		accommodationID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if !isAccommodationOwner(userID, accommodationID, db) {
+			writeMessage(w, http.StatusForbidden, "Only the owner can add photos to this accommodation")
+			return
+		}
+		ids := decodeAttachMediaRequest(w, r)
+		if ids == nil {
+			return
+		}
+
+		var attached []models.Media
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if attached, err = attachMedia(userID, ids, "accommodation_id", accommodationID, tx); err != nil {
+				return err
+			}
+			return tx.Model(&models.Accommodation{}).Where("id = ?", accommodationID).
+				Update("image_urls", gorm.Expr("array_cat(image_urls, ?)", mediaURLs(attached))).Error
+		})
+		writeAttachResult(w, attached, err)
+	}
+}
+
+// AttachEventMedia adds uploaded images to an event's photos
+// @Summary Attach event photos
+// @Description Attach your uploaded images to an event you organize. Their URLs are appended to the event's Images.
+// @Tags media
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param media body AttachMediaRequest true "Uploaded image IDs"
+// @Success 200 {array} models.Media "Attached images"
+// @Failure 400 {object} map[string]string "Images missing, not yours or already attached"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not organize the event"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Router /events/{id}/media [post]
+func AttachEventMedia(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		event, err := GetEventByID(mux.Vars(r)["id"], db)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
+			fmt.Println(err)
+			return
+		}
+		if !isEventOrganizer(userID, event, db) {
+			writeMessage(w, http.StatusForbidden, "Only the event organizer can add photos to this event")
+			return
+		}
+		ids := decodeAttachMediaRequest(w, r)
+		if ids == nil {
+			return
+		}
+
+		var attached []models.Media
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if attached, err = attachMedia(userID, ids, "event_id", event.ID, tx); err != nil {
+				return err
+			}
+			return tx.Model(&models.Event{}).Where("id = ?", event.ID).
+				Update("images", gorm.Expr("array_cat(images, ?)", mediaURLs(attached))).Error
+		})
+		writeAttachResult(w, attached, err)
+	}
+}
+
+// AttachReviewMedia adds uploaded photos to the author's review
+// @Summary Attach review photos
+// @Description Attach your uploaded images to a review you wrote
+// @Tags media
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param media body AttachMediaRequest true "Uploaded image IDs"
+// @Success 200 {array} models.Media "Attached images"
+// @Failure 400 {object} map[string]string "Images missing, not yours or already attached"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User did not write the review"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Router /reviews/{id}/media [post]
+func AttachReviewMedia(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review := loadOwnReview(w, r, db)
+		if review == nil {
+			return
+		}
+		ids := decodeAttachMediaRequest(w, r)
+		if ids == nil {
+			return
+		}
+
+		var attached []models.Media
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			attached, err = attachMedia(review.UserID, ids, "review_id", review.ID, tx)
+			return err
+		})
+		writeAttachResult(w, attached, err)
+	}
+}
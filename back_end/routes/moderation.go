@@ -0,0 +1,343 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/moderation"
+)
+
+// reviewScreener pre-screens review comments before they go live
+var reviewScreener = moderation.DefaultScreener()
+
+// SetReviewScreener replaces the screener used on review comments, e.g. with a custom word list
+func SetReviewScreener(screener *moderation.Screener) {
+	reviewScreener = screener
+}
+
+// ReviewBurstWindow is how far back an author's reviews count towards burst posting and duplicate checks
+var ReviewBurstWindow = time.Hour
+
+// ReviewReportThreshold is how many open reports take a published review down until a moderator looks at it
+var ReviewReportThreshold int64 = 3
+
+// ReportReviewRequest is a user's reason for reporting a review
+type ReportReviewRequest struct {
+	Reason string `json:"Reason"`
+}
+
+// ModerationQueueItem is a review waiting on a moderator with the reports against it
+type ModerationQueueItem struct {
+	Review      models.Review `json:"Review"`
+	OpenReports int64         `json:"OpenReports"`
+}
+
+// ScreenReview runs the review's comment through the screener, comparing it with what the author posted recently.
+// It reports whether the review was held and records why. A review a moderator rejected or hid stays that way.
+func ScreenReview(review *models.Review, now time.Time, db *gorm.DB) (bool, error) {
+	if review.ModerationStatus == models.ReviewStatusRejected || review.ModerationStatus == models.ReviewStatusHidden {
+		return false, nil
+	}
+
+	var recent []string
+	err := db.Model(&models.Review{}).
+		Where("user_id = ? AND created_at > ? AND id <> ?", review.UserID, now.Add(-ReviewBurstWindow), review.ID).
+		Pluck("comment", &recent).Error
+	if err != nil {
+		return false, err
+	}
+
+	verdict := reviewScreener.Screen(moderation.Submission{Text: review.Comment, Recent: recent})
+	if verdict.Flagged {
+		review.ModerationStatus = models.ReviewStatusPending
+		review.ModerationReason = strings.Join(verdict.Reasons, ",")
+	}
+	return verdict.Flagged, nil
+}
+
+// preloadPublishedReviews loads an accommodation's approved reviews with the owner's responses
+func preloadPublishedReviews(db *gorm.DB) *gorm.DB {
+	return db.Preload("UserReviews", "moderation_status = ?", models.ReviewStatusApproved).Preload("UserReviews.Response")
+}
+
+// isAdmin reports whether the user may moderate reviews
+func isAdmin(userID uint, db *gorm.DB) bool {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.IsAdmin
+}
+
+// requireAdmin writes an error response and returns false unless the session user is an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request, db *gorm.DB) bool {
+	session, _ := getSession(r, "session")
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok || userID == 0 {
+		writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+		return false
+	}
+	if !isAdmin(userID, db) {
+		writeMessage(w, http.StatusForbidden, "Only admins can moderate reviews")
+		return false
+	}
+	return true
+}
+
+// ReportReview lets a user flag a review as inappropriate. Enough reports hold the review for a moderator.
+// @Summary Report review
+// @Description Report a review. Once enough users report it, the review is hidden until a moderator decides.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param report body ReportReviewRequest true "Why the review is inappropriate"
+// @Success 201 {object} map[string]string "Report received"
+// @Failure 400 {object} map[string]string "Cannot report own review"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Review already reported by user"
+// @Router /reviews/{id}/report [post]
+func ReportReview(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var review models.Review
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&review, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Review not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch review")
+			fmt.Println(err)
+			return
+		}
+		if review.UserID == userID {
+			writeMessage(w, http.StatusBadRequest, "You cannot report your own review")
+			return
+		}
+
+		// The reason is optional
+		var req ReportReviewRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		report := models.ReviewReport{ReviewID: review.ID, UserID: userID, Reason: strings.TrimSpace(req.Reason)}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&report).Error; err != nil {
+				return err
+			}
+			if review.ModerationStatus != models.ReviewStatusApproved {
+				return nil
+			}
+
+			var open int64
+			if err := tx.Model(&models.ReviewReport{}).Where("review_id = ? AND resolved_at IS NULL", review.ID).Count(&open).Error; err != nil {
+				return err
+			}
+			if open < ReviewReportThreshold {
+				return nil
+			}
+			return setReviewModerationStatus(&review, models.ReviewStatusPending, "reported", tx)
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				writeMessage(w, http.StatusConflict, "You have already reported this review")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to report review")
+			fmt.Println(err)
+			return
+		}
+
+		writeMessage(w, http.StatusCreated, "Report received")
+	}
+}
+
+// setReviewModerationStatus stores the review's new status and recomputes its accommodation's rating,
+// since only approved reviews count
+func setReviewModerationStatus(review *models.Review, status, reason string, tx *gorm.DB) error {
+	if err := lockAccommodation(review.AccommodationID, tx); err != nil {
+		return err
+	}
+	review.ModerationStatus = status
+	review.ModerationReason = reason
+	if err := tx.Model(review).Select("ModerationStatus", "ModerationReason").Updates(review).Error; err != nil {
+		return err
+	}
+	_, err := RecalculateAccommodationRating(review.AccommodationID, tx)
+	return err
+}
+
+// ListModerationQueue returns reviews in a moderation status, pending by default, with their open reports
+// @Summary Review moderation queue
+// @Description List reviews waiting for a moderator, or in another moderation status, with the number of open reports
+// @Tags admin
+// @Produce json
+// @Param status query string false "pending, approved, rejected or hidden" default(pending)
+// @Success 200 {array} ModerationQueueItem "Reviews"
+// @Failure 400 {object} map[string]string "Unknown status"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User is not an admin"
+// @Router /admin/reviews [get]
+func ListModerationQueue(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, db) {
+			return
+		}
+
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = models.ReviewStatusPending
+		case models.ReviewStatusPending, models.ReviewStatusApproved, models.ReviewStatusRejected, models.ReviewStatusHidden:
+		default:
+			writeMessage(w, http.StatusBadRequest, "Unknown moderation status")
+			return
+		}
+
+		var reviews []models.Review
+		if err := db.Where("moderation_status = ?", status).Order("id").Find(&reviews).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch reviews")
+			fmt.Println(err)
+			return
+		}
+
+		items := make([]ModerationQueueItem, len(reviews))
+		if len(reviews) > 0 {
+			ids := make([]uint, len(reviews))
+			for i, review := range reviews {
+				ids[i] = review.ID
+			}
+			var counts []struct {
+				ReviewID uint
+				Count    int64
+			}
+			err := db.Model(&models.ReviewReport{}).
+				Select("review_id, COUNT(*) AS count").
+				Where("review_id IN ? AND resolved_at IS NULL", ids).
+				Group("review_id").
+				Scan(&counts).Error
+			if err != nil {
+				writeMessage(w, http.StatusInternalServerError, "Failed to fetch reports")
+				fmt.Println(err)
+				return
+			}
+			open := make(map[uint]int64, len(counts))
+			for _, c := range counts {
+				open[c.ReviewID] = c.Count
+			}
+			for i, review := range reviews {
+				items[i] = ModerationQueueItem{Review: review, OpenReports: open[review.ID]}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, items)
+	}
+}
+
+// ApproveReview publishes a held or hidden review
+// @Summary Approve review
+// @Description Publish a pending or hidden review and resolve its reports
+// @Tags admin
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review "Moderated review"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User is not an admin"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Review cannot move to that status"
+// @Router /admin/reviews/{id}/approve [post]
+func ApproveReview(db *gorm.DB) http.HandlerFunc {
+	return moderateReview(db, models.ReviewStatusApproved)
+}
+
+// RejectReview keeps a held review from ever being published
+// @Summary Reject review
+// @Description Reject a pending review and resolve its reports
+// @Tags admin
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review "Moderated review"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User is not an admin"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Review cannot move to that status"
+// @Router /admin/reviews/{id}/reject [post]
+func RejectReview(db *gorm.DB) http.HandlerFunc {
+	return moderateReview(db, models.ReviewStatusRejected)
+}
+
+// HideReview takes a review down
+// @Summary Hide review
+// @Description Take down a published or pending review and resolve its reports
+// @Tags admin
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review "Moderated review"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User is not an admin"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Review cannot move to that status"
+// @Router /admin/reviews/{id}/hide [post]
+func HideReview(db *gorm.DB) http.HandlerFunc {
+	return moderateReview(db, models.ReviewStatusHidden)
+}
+
+func moderateReview(db *gorm.DB, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, db) {
+			return
+		}
+
+		var review models.Review
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&review, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Review not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch review")
+			fmt.Println(err)
+			return
+		}
+		if !models.IsValidReviewModerationTransition(review.ModerationStatus, status) {
+			writeMessage(w, http.StatusConflict, fmt.Sprintf("A %s review cannot be %s", review.ModerationStatus, status))
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := setReviewModerationStatus(&review, status, "", tx); err != nil {
+				return err
+			}
+			// The moderator's decision answers every open report
+			return tx.Model(&models.ReviewReport{}).
+				Where("review_id = ? AND resolved_at IS NULL", review.ID).
+				Update("resolved_at", time.Now()).Error
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to moderate review")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, review)
+	}
+}
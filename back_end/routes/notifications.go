@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// QueueNotification stores a notification for the user; delivery happens outside the request cycle
+func QueueNotification(userID uint, notificationType, subject, message string, db *gorm.DB) error {
+	notification := models.Notification{
+		UserID:  userID,
+		Type:    notificationType,
+		Subject: subject,
+		Message: message,
+		Status:  models.NotificationStatusQueued,
+	}
+	return db.Create(&notification).Error
+}
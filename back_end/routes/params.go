@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// parseID parses a record id taken from a request. Ids must be parsed before they reach GORM:
+// given a string, First and Delete inline it into the SQL instead of binding it.
+func parseID(value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return uint(id), nil
+}
+
+// lookupID parses an id for a lookup helper. An id that isn't a number can't match a row,
+// so it is reported as gorm.ErrRecordNotFound.
+func lookupID(value string) (uint, error) {
+	id, err := parseID(value)
+	if err != nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return id, nil
+}
+
+// pathID parses the named id from the URL path, writing a 400 response if it isn't valid
+func pathID(w http.ResponseWriter, r *http.Request, name string) (uint, bool) {
+	id, err := parseID(mux.Vars(r)[name])
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "Invalid "+name)
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/payments"
+)
+
+// paymentGateway charges bookings. It is set from the server's config at startup, or by tests.
+var paymentGateway payments.PaymentGateway
+
+// SetPaymentGateway sets the gateway used to charge bookings
+func SetPaymentGateway(gateway payments.PaymentGateway) {
+	paymentGateway = gateway
+}
+
+// paymentsRequired rejects bookings made without a payment token. It stays off until the web
+// client collects payment details, so bookings without a token are taken unpaid meanwhile.
+var paymentsRequired bool
+
+// SetPaymentsRequired sets whether bookings must come with a payment token
+func SetPaymentsRequired(required bool) {
+	paymentsRequired = required
+}
+
+// unpaid reports whether a booking made with token is taken without a payment
+func unpaid(token string) bool {
+	return token == "" && !paymentsRequired
+}
+
+// ChargeAndConfirmBooking charges a pending booking and confirms it once the payment is captured.
+// If the payment fails the booking is cancelled so it stops holding the dates.
+// Declined payments are reported as payments.ErrPaymentFailed. Unpaid bookings are confirmed straight away.
+func ChargeAndConfirmBooking(booking *models.Booking, token string, now time.Time, db *gorm.DB) error {
+	cancel := func() { cancelUnpaidBooking(booking, now, db) }
+	confirm := func(tx *gorm.DB) error {
+		if err := booking.Transition(models.BookingStatusConfirmed, now); err != nil {
+			return err
+		}
+		return tx.Model(booking).Select("Status", "ConfirmedAt").Updates(booking).Error
+	}
+	if unpaid(token) {
+		return confirmUnpaid(confirm, cancel, db)
+	}
+	intent, err := payments.AuthorizeBooking(booking, token, paymentGateway, db)
+	if err != nil {
+		cancel()
+		return err
+	}
+	return captureAndConfirm(intent, confirm, cancel, db)
+}
+
+// ChargeAndConfirmEventBooking charges a pending event booking and confirms it once the payment is captured.
+// If the payment fails the booking is cancelled. Unpaid bookings are confirmed straight away.
+func ChargeAndConfirmEventBooking(booking *models.EventBooking, token string, now time.Time, db *gorm.DB) error {
+	cancel := func() {
+		if err := booking.Transition(models.BookingStatusCancelled, now); err != nil {
+			fmt.Println("Error cancelling unpaid event booking:", err)
+			return
+		}
+		if err := db.Model(booking).Select("Status", "CancelledAt").Updates(booking).Error; err != nil {
+			fmt.Println("Error cancelling unpaid event booking:", err)
+		}
+	}
+	confirm := func(tx *gorm.DB) error {
+		if err := booking.Transition(models.BookingStatusConfirmed, now); err != nil {
+			return err
+		}
+		return tx.Model(booking).Select("Status", "ConfirmedAt").Updates(booking).Error
+	}
+	if unpaid(token) {
+		return confirmUnpaid(confirm, cancel, db)
+	}
+	intent, err := payments.AuthorizeEventBooking(booking, token, paymentGateway, db)
+	if err != nil {
+		cancel()
+		return err
+	}
+	return captureAndConfirm(intent, confirm, cancel, db)
+}
+
+func confirmUnpaid(confirm func(tx *gorm.DB) error, cancel func(), db *gorm.DB) error {
+	if err := confirm(db); err != nil {
+		cancel()
+		return err
+	}
+	return nil
+}
+
+// captureAndConfirm captures intent and confirms its booking in the same transaction, so a booking
+// is never left pending with its money taken. When the capture is declined, or the confirmation
+// can't be written and the charge is refunded, the booking is cancelled. A charge that can't be
+// refunded either stays on the pending booking, and the settle job confirms it later.
+func captureAndConfirm(intent *models.PaymentIntent, confirm func(tx *gorm.DB) error, cancel func(), db *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := payments.CaptureIntent(intent, paymentGateway, tx); err != nil {
+			return err
+		}
+		return confirm(tx)
+	})
+	if err == nil {
+		return nil
+	}
+
+	if intent.Status == models.PaymentStatusSucceeded {
+		if refundErr := payments.RefundIntent(intent, intent.Amount, paymentGateway, db); refundErr != nil {
+			fmt.Printf("Error refunding payment intent %d: %v\n", intent.ID, refundErr)
+			if saveErr := db.Save(intent).Error; saveErr != nil {
+				fmt.Println("Error saving captured payment intent:", saveErr)
+			}
+			return err
+		}
+	} else if saveErr := db.Save(intent).Error; saveErr != nil {
+		// The failed capture was rolled back with the confirmation
+		fmt.Println("Error saving failed payment intent:", saveErr)
+	}
+	cancel()
+	return err
+}
+
+// AuthorizeBookingRequest holds the payment for a booking request until the owner answers.
+// If the authorization fails the request is cancelled. Unpaid requests have nothing to hold.
+func AuthorizeBookingRequest(booking *models.Booking, token string, now time.Time, db *gorm.DB) error {
+	if unpaid(token) {
+		return nil
+	}
+	if _, err := payments.AuthorizeBooking(booking, token, paymentGateway, db); err != nil {
+		cancelUnpaidBooking(booking, now, db)
+		return err
+	}
+	return nil
+}
+
+func cancelUnpaidBooking(booking *models.Booking, now time.Time, db *gorm.DB) {
+	if err := booking.Transition(models.BookingStatusCancelled, now); err != nil {
+		fmt.Println("Error cancelling unpaid booking:", err)
+		return
+	}
+	if err := db.Model(booking).Select("Status", "CancelledAt").Updates(booking).Error; err != nil {
+		fmt.Println("Error cancelling unpaid booking:", err)
+	}
+}
+
+// CaptureBookingRequest charges the authorization held for an approved booking request.
+// Bookings made before payments existed have nothing to capture.
+func CaptureBookingRequest(booking *models.Booking, db *gorm.DB) error {
+	intent, err := payments.FindBookingIntent(booking.ID, db)
+	if err != nil || intent == nil {
+		return err
+	}
+	return payments.CaptureIntent(intent, paymentGateway, db)
+}
+
+// settlePayment reverses the payment for a cancelled booking: an uncaptured authorization is voided,
+// a captured charge is refunded until refundTotal has been given back. Anything already refunded
+// counts towards refundTotal, so settling the same booking again never refunds twice.
+func settlePayment(intent *models.PaymentIntent, refundTotal uint, db *gorm.DB) error {
+	if intent == nil {
+		return nil
+	}
+	switch intent.Status {
+	case models.PaymentStatusRequiresCapture:
+		return payments.VoidIntent(intent, paymentGateway, db)
+	case models.PaymentStatusSucceeded, models.PaymentStatusPartiallyRefunded:
+		if refundTotal <= intent.RefundedAmount {
+			return nil
+		}
+		return payments.RefundIntent(intent, refundTotal-intent.RefundedAmount, paymentGateway, db)
+	}
+	return nil
+}
+
+// SettleBookingPayment voids or refunds the payment for a cancelled, declined or expired booking
+func SettleBookingPayment(booking *models.Booking, db *gorm.DB) error {
+	intent, err := payments.FindBookingIntent(booking.ID, db)
+	if err != nil {
+		return err
+	}
+	return settlePayment(intent, booking.RefundAmount, db)
+}
+
+// SettleEventBookingPayment voids or refunds the payment for a cancelled event booking
+func SettleEventBookingPayment(booking *models.EventBooking, db *gorm.DB) error {
+	intent, err := payments.FindEventBookingIntent(booking.ID, db)
+	if err != nil {
+		return err
+	}
+	return settlePayment(intent, booking.RefundAmount, db)
+}
+
+// RefundCancelledEvent returns every payment taken for a cancelled event in full.
+// Failures are logged and skipped so one bad refund doesn't hold up the rest.
+func RefundCancelledEvent(eventID uint, db *gorm.DB) int {
+	var intents []models.PaymentIntent
+	err := db.Where("event_booking_id IN (?) AND status IN ?",
+		db.Model(&models.EventBooking{}).Select("id").Where("event_id = ?", eventID),
+		[]string{models.PaymentStatusRequiresCapture, models.PaymentStatusSucceeded, models.PaymentStatusPartiallyRefunded}).
+		Find(&intents).Error
+	if err != nil {
+		fmt.Println("Error fetching payments for cancelled event:", err)
+		return 0
+	}
+
+	settled := 0
+	for i := range intents {
+		if err := settlePayment(&intents[i], intents[i].Amount, db); err != nil {
+			fmt.Printf("Error refunding payment intent %d: %v\n", intents[i].ID, err)
+			continue
+		}
+		settled++
+	}
+	return settled
+}
+
+// PaymentWebhook applies payment status updates pushed by the gateway
+// @Summary Payment gateway webhook
+// @Description Receives signed payment events from the gateway and updates the matching payment intent
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param X-Payment-Signature header string true "Signature of the payload"
+// @Success 200 {object} map[string]string "Event applied"
+// @Failure 400 {object} map[string]string "Invalid signature or payload"
+// @Failure 404 {object} map[string]string "Unknown payment"
+// @Router /payments/webhook [post]
+func PaymentWebhook(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		signature := r.Header.Get("X-Payment-Signature")
+		if signature == "" {
+			// Stripe can't send custom headers
+			signature = r.Header.Get("Stripe-Signature")
+		}
+		event, err := paymentGateway.VerifyWebhook(payload, signature)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := payments.ApplyWebhook(event, db); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Unknown payment")
+				return
+			}
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeMessage(w, http.StatusOK, "Event applied")
+	}
+}
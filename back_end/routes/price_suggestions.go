@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/pricing"
+)
+
+// PriceSuggestionsResponse is an owner's suggestion settings with the prices suggested for the coming nights
+type PriceSuggestionsResponse struct {
+	Settings    pricing.SuggestionSettings `json:"settings"`
+	Suggestions []pricing.Suggestion       `json:"suggestions"`
+}
+
+// PriceSuggestions proposes prices for the accommodation's coming nights from how booked it is
+// and how booked the other listings in its location are
+func PriceSuggestions(accommodation models.Accommodation, now time.Time, db *gorm.DB) ([]pricing.Suggestion, error) {
+	from := models.CalendarDay(now)
+	start, end := from.AddDate(0, 0, -7), from.AddDate(0, 0, pricing.SuggestionDays+7)
+
+	var comparables []uint
+	if accommodation.Location != "" {
+		err := db.Model(&models.Accommodation{}).
+			Where("location = ? AND id <> ?", accommodation.Location, accommodation.ID).
+			Pluck("id", &comparables).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bookings []models.Booking
+	err := db.Select("accommodation_id", "checkin_date", "checkout_date").
+		Where("accommodation_id IN ? AND status IN ? AND checkin_date < ? AND checkout_date > ?",
+			append(comparables, accommodation.ID), models.ActiveBookingStatuses, end, start).
+		Find(&bookings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var own []pricing.Stay
+	market := map[uint][]pricing.Stay{}
+	for _, booking := range bookings {
+		stay := pricing.Stay{CheckIn: booking.CheckinDate, CheckOut: booking.CheckoutDate}
+		if booking.AccommodationID == accommodation.ID {
+			own = append(own, stay)
+		} else {
+			market[booking.AccommodationID] = append(market[booking.AccommodationID], stay)
+		}
+	}
+	return accommodation.PricingRules.Suggest(accommodation.PricePerNight, from, own, market, len(comparables), accommodation.PriceSuggestions), nil
+}
+
+// ApplyPriceSuggestions sets the accommodation's date prices to the suggestions for nights not yet booked.
+// Date prices for past nights are dropped. The rules are reloaded under a lock, so changes the owner made
+// since the suggestions were worked out are kept.
+func ApplyPriceSuggestions(accommodation *models.Accommodation, suggestions []pricing.Suggestion, now time.Time, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		rules, err := lockPricingRules(accommodation.ID, tx)
+		if err != nil {
+			return err
+		}
+
+		today := models.CalendarDay(now).Format("2006-01-02")
+		prices := map[string]float64{}
+		for date, price := range rules.DatePrices {
+			if date >= today {
+				prices[date] = price
+			}
+		}
+		for _, suggestion := range suggestions {
+			if !suggestion.Booked {
+				prices[suggestion.Date.Format("2006-01-02")] = suggestion.SuggestedPrice
+			}
+		}
+
+		rules.DatePrices = prices
+		accommodation.PricingRules = rules
+		return tx.Model(accommodation).Select("PricingRules").Updates(accommodation).Error
+	})
+}
+
+// GetPriceSuggestions suggests prices for an owner's accommodation
+// @Summary Get price suggestions
+// @Description Suggest a price for each of the next 90 nights. Prices go up when the accommodation and the other listings in its location are busy and down when they're quiet, within the owner's floor and ceiling. Booked nights are marked.
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Success 200 {object} PriceSuggestionsResponse "Suggestions"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/price-suggestions [get]
+func GetPriceSuggestions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		suggestions, err := PriceSuggestions(*accommodation, time.Now(), db)
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to suggest prices")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, PriceSuggestionsResponse{Settings: accommodation.PriceSuggestions, Suggestions: suggestions})
+	}
+}
+
+// UpdatePriceSuggestionSettings sets an owner's bounds on suggested prices and whether they're applied automatically
+// @Summary Update price suggestion settings
+// @Description Set the floor and ceiling of suggested prices, 0 for none. With auto_apply on, the suggestions are applied to the accommodation's date prices straight away and again every day; turning it off leaves the prices already applied in place.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param settings body pricing.SuggestionSettings true "Settings"
+// @Success 200 {object} PriceSuggestionsResponse "Settings updated"
+// @Failure 400 {object} map[string]string "Invalid settings"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/price-suggestions/settings [put]
+func UpdatePriceSuggestionSettings(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var settings pricing.SuggestionSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		accommodation.PriceSuggestions = settings
+		err := db.Model(accommodation).
+			Select("price_suggestion_floor", "price_suggestion_ceiling", "price_suggestion_auto_apply").
+			Updates(accommodation).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update price suggestion settings")
+			fmt.Println(err)
+			return
+		}
+
+		now := time.Now()
+		suggestions, err := PriceSuggestions(*accommodation, now, db)
+		if err == nil && settings.AutoApply {
+			err = ApplyPriceSuggestions(accommodation, suggestions, now, db)
+		}
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to apply price suggestions")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, PriceSuggestionsResponse{Settings: settings, Suggestions: suggestions})
+	}
+}
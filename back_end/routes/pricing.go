@@ -0,0 +1,182 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"roam.io/models"
+	"roam.io/pricing"
+)
+
+// parseStayQuery reads check_in_date and check_out_date, and the guests as parseGuests does, from the query string.
+// ok is false if no dates were given; a *models.StayError is returned if they or the guests can't be read.
+func parseStayQuery(r *http.Request) (checkIn, checkOut time.Time, guests models.GuestComposition, ok bool, err error) {
+	query := r.URL.Query()
+	if query.Get("check_in_date") == "" && query.Get("check_out_date") == "" {
+		return checkIn, checkOut, guests, false, nil
+	}
+	checkIn, checkInErr := time.Parse("2006-01-02", query.Get("check_in_date"))
+	checkOut, checkOutErr := time.Parse("2006-01-02", query.Get("check_out_date"))
+	if checkInErr != nil || checkOutErr != nil {
+		return checkIn, checkOut, guests, true, &models.StayError{Code: models.StayErrorInvalidDates, Message: "check_in_date and check_out_date must be dates in YYYY-MM-DD format"}
+	}
+	if !checkOut.After(checkIn) {
+		return checkIn, checkOut, guests, true, &models.StayError{Code: models.StayErrorInvalidDates, Message: "Check-out must be after check-in"}
+	}
+	guests, err = parseGuests(query)
+	return checkIn, checkOut, guests, true, err
+}
+
+// GetStayQuote prices a stay night by night
+// @Summary Quote a stay
+// @Description Price a stay at an accommodation, with each night's rate, seasonal and weekday adjustments, extra guest fees, any length-of-stay discount, the cleaning fee and pet fees. The dates are checked against the accommodation's availability and the guests against its guest policy.
+// @Tags accommodations
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param check_in_date query string true "Check-in date (YYYY-MM-DD)"
+// @Param check_out_date query string true "Check-out date (YYYY-MM-DD)"
+// @Param adults query int false "Number of adults, 1 if left out"
+// @Param children query int false "Number of children, 2 to 17"
+// @Param infants query int false "Number of infants, under 2; they stay free and don't count towards occupancy"
+// @Param pets query int false "Number of pets"
+// @Param guests query int false "Number of adults, for clients that don't send adults"
+// @Param room_type_id query int false "Room type, for accommodations booked by the room"
+// @Success 200 {object} pricing.Quote "Quote"
+// @Failure 400 {object} StayErrorResponse "Invalid dates or guests"
+// @Failure 404 {object} map[string]string "Accommodation or room type not found"
+// @Failure 409 {object} StayErrorResponse "Dates unavailable"
+// @Failure 422 {object} StayErrorResponse "Stay breaks the availability rules or guest policy"
+// @Router /accommodations/{id}/quote [get]
+func GetStayQuote(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checkIn, checkOut, guests, ok, err := parseStayQuery(r)
+		if !ok {
+			err = &models.StayError{Code: models.StayErrorInvalidDates, Message: "check_in_date and check_out_date are required"}
+		}
+		var stayErr *models.StayError
+		if errors.As(err, &stayErr) {
+			writeStayError(w, stayErr)
+			return
+		}
+
+		var accommodation models.Accommodation
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&accommodation, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+
+		if errors.As(accommodation.GuestPolicy.CheckGuests(guests), &stayErr) {
+			writeStayError(w, stayErr)
+			return
+		}
+		var roomType *models.RoomType
+		if id := r.URL.Query().Get("room_type_id"); id != "" {
+			if roomType, err = findRoomType(db, accommodation.ID, id); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					writeMessage(w, http.StatusNotFound, "Room type not found")
+					return
+				}
+				writeMessage(w, http.StatusInternalServerError, "Failed to fetch room type")
+				fmt.Println(err)
+				return
+			}
+			if errors.As(roomType.CheckGuests(guests), &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
+		}
+
+		if err := CheckStayAvailability(accommodation, roomType, checkIn, checkOut, time.Now(), db); err != nil {
+			if errors.As(err, &stayErr) {
+				writeStayError(w, stayErr)
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to check availability")
+			fmt.Println(err)
+			return
+		}
+		quote, err := accommodation.Quote(checkIn, checkOut, guests)
+		if roomType != nil {
+			quote, err = accommodation.QuoteRoom(*roomType, checkIn, checkOut, guests)
+		}
+		if err != nil {
+			writeStayError(w, &models.StayError{Code: models.StayErrorInvalidDates, Message: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, quote)
+	}
+}
+
+// UpdatePricingRules lets an owner set seasonal prices, weekday adjustments, discounts and fees
+// @Summary Update pricing rules
+// @Description Set seasons with their own price per night, prices for single dates, percentage adjustments for weekdays, discounts for longer stays, a fee per guest beyond those included and a cleaning fee. PricePerNight stays the price of nights no rule changes. Leaving date_prices out keeps the current ones, including applied price suggestions; an empty object clears them. Room types get the seasons and date prices in proportion to their own price.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param rules body pricing.Rules true "Pricing rules"
+// @Success 200 {object} pricing.Rules "Rules updated"
+// @Failure 400 {object} map[string]string "Invalid rules"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/pricing-rules [put]
+func UpdatePricingRules(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var rules pricing.Rules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := rules.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			current, err := lockPricingRules(accommodation.ID, tx)
+			if err != nil {
+				return err
+			}
+			// Date prices left out are kept, so saving the other rules doesn't undo applied price suggestions
+			if rules.DatePrices == nil {
+				rules.DatePrices = current.DatePrices
+			}
+			accommodation.PricingRules = rules
+			return tx.Model(accommodation).Select("PricingRules").Updates(accommodation).Error
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update pricing rules")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+
+// lockPricingRules reloads an accommodation's pricing rules under a row lock, so the owner's changes
+// and applied price suggestions are made one after another instead of overwriting each other
+func lockPricingRules(accommodationID uint, tx *gorm.DB) (pricing.Rules, error) {
+	var accommodation models.Accommodation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "pricing_rules").First(&accommodation, accommodationID).Error
+	return accommodation.PricingRules, err
+}
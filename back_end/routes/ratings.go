@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"roam.io/models"
+)
+
+// RatingAggregate is the review summary stored on an accommodation
+type RatingAggregate struct {
+	ReviewCount       uint
+	Rating            float64
+	CleanlinessRating float64
+	LocationRating    float64
+	ValueRating       float64
+}
+
+// roundRating keeps averages to two decimal places
+func roundRating(avg float64) float64 {
+	return math.Round(avg*100) / 100
+}
+
+// isValidSubRating reports whether a sub-rating is either skipped (0) or between 1 and 5
+func isValidSubRating(rating float64) bool {
+	return rating == 0 || (rating >= 1 && rating <= 5)
+}
+
+// lockAccommodation takes a row lock on the accommodation so concurrent review changes
+// recompute its aggregates one after another
+func lockAccommodation(accommodationID uint, tx *gorm.DB) error {
+	var accommodation models.Accommodation
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&accommodation, accommodationID).Error
+}
+
+// RecalculateAccommodationRating recomputes the rating, review count and sub-ratings of an accommodation
+// from its approved reviews. Call it in the same transaction as the review change.
+func RecalculateAccommodationRating(accommodationID uint, tx *gorm.DB) (*RatingAggregate, error) {
+	var aggregate RatingAggregate
+	// NULLIF skips reviews that left a sub-rating out
+	err := tx.Model(&models.Review{}).
+		Select("COUNT(*) AS review_count, COALESCE(AVG(rating), 0) AS rating, "+
+			"COALESCE(AVG(NULLIF(cleanliness_rating, 0)), 0) AS cleanliness_rating, "+
+			"COALESCE(AVG(NULLIF(location_rating, 0)), 0) AS location_rating, "+
+			"COALESCE(AVG(NULLIF(value_rating, 0)), 0) AS value_rating").
+		Where("accommodation_id = ? AND moderation_status = ?", accommodationID, models.ReviewStatusApproved).
+		Scan(&aggregate).Error
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate.Rating = roundRating(aggregate.Rating)
+	aggregate.CleanlinessRating = roundRating(aggregate.CleanlinessRating)
+	aggregate.LocationRating = roundRating(aggregate.LocationRating)
+	aggregate.ValueRating = roundRating(aggregate.ValueRating)
+
+	err = tx.Model(&models.Accommodation{}).Where("id = ?", accommodationID).Updates(map[string]interface{}{
+		"review_count":       aggregate.ReviewCount,
+		"rating":             aggregate.Rating,
+		"cleanliness_rating": aggregate.CleanlinessRating,
+		"location_rating":    aggregate.LocationRating,
+		"value_rating":       aggregate.ValueRating,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &aggregate, nil
+}
+
+// RecalculateAllRatings recomputes the aggregates of every accommodation, one transaction each.
+// It returns how many accommodations were updated.
+func RecalculateAllRatings(db *gorm.DB) (int, error) {
+	var ids []uint
+	if err := db.Model(&models.Accommodation{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAccommodation(id, tx); err != nil {
+				return err
+			}
+			_, err := RecalculateAccommodationRating(id, tx)
+			return err
+		})
+		if err != nil {
+			return i, fmt.Errorf("accommodation %d: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// RefundQuote describes what a guest gets back when cancelling a booking
+type RefundQuote struct {
+	BookingID          uint               `json:"booking_id"`
+	TotalCost          uint               `json:"total_cost"`
+	RefundPercent      int                `json:"refund_percent"`
+	RefundAmount       uint               `json:"refund_amount"`
+	CancellationPolicy string             `json:"cancellation_policy"`
+	CancellationTiers  models.RefundTiers `json:"cancellation_tiers,omitempty"`
+}
+
+// CancellationPolicyRequest sets the cancellation policy of an accommodation
+type CancellationPolicyRequest struct {
+	CancellationPolicy string             `json:"CancellationPolicy" example:"moderate"`
+	CancellationTiers  models.RefundTiers `json:"CancellationTiers"`
+}
+
+// policyName returns the policy in effect, which is flexible when none was set
+func policyName(policy string) string {
+	if policy == "" {
+		return models.CancellationPolicyFlexible
+	}
+	return policy
+}
+
+// QuoteBookingRefund applies the accommodation's policy to cancelling the booking at now.
+// Only confirmed bookings have been paid for, so anything else refunds nothing.
+func QuoteBookingRefund(booking models.Booking, accommodation models.Accommodation, now time.Time) RefundQuote {
+	quote := RefundQuote{
+		BookingID:          booking.ID,
+		TotalCost:          booking.TotalCost,
+		CancellationPolicy: policyName(accommodation.CancellationPolicy),
+	}
+	if accommodation.CancellationPolicy == models.CancellationPolicyCustom {
+		quote.CancellationTiers = accommodation.CancellationTiers
+	}
+	if booking.Status != models.BookingStatusConfirmed && booking.Status != "" {
+		return quote
+	}
+	quote.RefundAmount, quote.RefundPercent = models.CalculateRefund(accommodation.CancellationPolicy, accommodation.CancellationTiers, booking.TotalCost, booking.CheckinDate, now)
+	return quote
+}
+
+// QuoteEventBookingRefund applies the event's policy to cancelling the event booking at now.
+// When the event date can't be read there is no way to tell how close it is, so the guest gets a full refund.
+func QuoteEventBookingRefund(booking models.EventBooking, event models.Event, now time.Time) RefundQuote {
+	quote := RefundQuote{
+		BookingID:          booking.ID,
+		TotalCost:          booking.TotalCost,
+		CancellationPolicy: policyName(event.CancellationPolicy),
+	}
+	if event.CancellationPolicy == models.CancellationPolicyCustom {
+		quote.CancellationTiers = event.CancellationTiers
+	}
+	if booking.Status != models.BookingStatusConfirmed && booking.Status != "" {
+		return quote
+	}
+	startsAt, err := event.StartsAt()
+	if err != nil {
+		quote.RefundAmount, quote.RefundPercent = booking.TotalCost, 100
+		return quote
+	}
+	quote.RefundAmount, quote.RefundPercent = models.CalculateRefund(event.CancellationPolicy, event.CancellationTiers, booking.TotalCost, startsAt, now)
+	return quote
+}
+
+// PreviewBookingRefund shows the refund the guest would get by cancelling the booking now
+// @Summary Preview booking refund
+// @Description Calculate the refund for cancelling an accommodation booking now, without cancelling it
+// @Tags accommodations
+// @Produce json
+// @Param id path int true "Booking ID"
+// @Success 200 {object} RefundQuote
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Booking not found"
+// @Router /accommodations/bookings/{id}/refund-preview [get]
+func PreviewBookingRefund(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.Booking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Where("user_id = ?", userID).First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch booking")
+			fmt.Println(err)
+			return
+		}
+
+		var accommodation models.Accommodation
+		if err := db.First(&accommodation, booking.AccommodationID).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, QuoteBookingRefund(booking, accommodation, time.Now()))
+	}
+}
+
+// PreviewEventBookingRefund shows the refund the guest would get by cancelling the event booking now
+// @Summary Preview event booking refund
+// @Description Calculate the refund for cancelling an event booking now, without cancelling it
+// @Tags events
+// @Produce json
+// @Param id path int true "Event booking ID"
+// @Success 200 {object} RefundQuote
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Event booking not found"
+// @Router /events/bookings/{id}/refund-preview [get]
+func PreviewEventBookingRefund(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var booking models.EventBooking
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Where("user_id = ?", userID).First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event booking not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event booking")
+			fmt.Println(err)
+			return
+		}
+
+		var event models.Event
+		if err := db.First(&event, booking.EventId).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, QuoteEventBookingRefund(booking, event, time.Now()))
+	}
+}
+
+// UpdateCancellationPolicy lets an owner change the cancellation policy of their accommodation
+// @Summary Update accommodation cancellation policy
+// @Description Set a preset policy (flexible, moderate, strict) or custom refund tiers by days before check-in
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param policy body CancellationPolicyRequest true "Cancellation policy"
+// @Success 200 {object} map[string]interface{} "Policy updated"
+// @Failure 400 {object} map[string]string "Invalid policy"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Router /owner/accommodations/{id}/cancellation-policy [put]
+func UpdateCancellationPolicy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var accommodation models.Accommodation
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&accommodation, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+		if !isAccommodationOwner(userID, accommodation.ID, db) {
+			writeMessage(w, http.StatusForbidden, "Only the accommodation owner can change its cancellation policy")
+			return
+		}
+
+		var req CancellationPolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := models.ValidateCancellationPolicy(req.CancellationPolicy, req.CancellationTiers); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.CancellationPolicy != models.CancellationPolicyCustom {
+			req.CancellationTiers = nil
+		}
+
+		accommodation.CancellationPolicy = policyName(req.CancellationPolicy)
+		accommodation.CancellationTiers = req.CancellationTiers
+		if err := db.Model(&accommodation).Select("CancellationPolicy", "CancellationTiers").Updates(&accommodation).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update cancellation policy")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":             "Cancellation policy updated",
+			"cancellation_policy": accommodation.CancellationPolicy,
+			"cancellation_tiers":  accommodation.CancellationTiers,
+		})
+	}
+}
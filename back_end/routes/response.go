@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON encodes payload as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// writeMessage sends a {"message": ...} JSON response, the shape used for errors across the API
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
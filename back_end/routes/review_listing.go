@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// Page sizes for review listings
+const (
+	DefaultReviewPageSize = 10
+	MaxReviewPageSize     = 50
+)
+
+// Review listing sort orders
+const (
+	ReviewSortNewest  = "newest"
+	ReviewSortHighest = "highest"
+	ReviewSortLowest  = "lowest"
+	ReviewSortHelpful = "helpful"
+)
+
+// reviewOrders maps each sort order to its ORDER BY; id breaks ties so pages don't overlap
+var reviewOrders = map[string]string{
+	ReviewSortNewest:  "created_at desc, id desc",
+	ReviewSortHighest: "rating desc, id desc",
+	ReviewSortLowest:  "rating asc, id desc",
+	ReviewSortHelpful: "helpful_count desc, id desc",
+}
+
+// reviewStars buckets a rating into whole stars, rounding half stars up
+const reviewStars = "CAST(FLOOR(rating + 0.5) AS integer)"
+
+// ReviewPage is one page of an accommodation's published reviews
+type ReviewPage struct {
+	Reviews  []models.Review      `json:"Reviews"`
+	Page     int                  `json:"Page"`
+	PageSize int                  `json:"PageSize"`
+	Total    int64                `json:"Total"` // reviews matching the star filter
+	Summary  models.ReviewSummary `json:"Summary"`
+}
+
+// SummarizeReviews counts an accommodation's published reviews per star
+func SummarizeReviews(accommodationID uint, db *gorm.DB) (*models.ReviewSummary, error) {
+	var rows []struct {
+		Stars int
+		Count int64
+	}
+	err := db.Model(&models.Review{}).
+		Select(reviewStars+" AS stars, COUNT(*) AS count").
+		Where("accommodation_id = ? AND moderation_status = ?", accommodationID, models.ReviewStatusApproved).
+		Group("stars").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary := models.ReviewSummary{Histogram: map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	for _, row := range rows {
+		summary.Histogram[row.Stars] += row.Count
+		summary.ReviewCount += row.Count
+	}
+	return &summary, nil
+}
+
+// parseStarFilter reads stars=4,5 or stars=4&stars=5
+func parseStarFilter(values []string) ([]int, error) {
+	var stars []int
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			star, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || star < 1 || star > 5 {
+				return nil, fmt.Errorf("invalid star rating %q", part)
+			}
+			stars = append(stars, star)
+		}
+	}
+	return stars, nil
+}
+
+// parsePositiveInt reads an optional positive integer query parameter
+func parsePositiveInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New("must be a positive number")
+	}
+	return n, nil
+}
+
+// ListAccommodationReviews returns a page of an accommodation's published reviews
+// @Summary List accommodation reviews
+// @Description Page through an accommodation's published reviews, sorted and filtered by stars, with a rating histogram
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param page query int false "Page number, from 1" default(1)
+// @Param page_size query int false "Reviews per page, at most 50" default(10)
+// @Param sort query string false "newest, highest, lowest or helpful" default(newest)
+// @Param stars query string false "Only reviews with these star ratings, e.g. 4,5"
+// @Success 200 {object} ReviewPage "Reviews"
+// @Failure 400 {object} map[string]string "Invalid paging, sort or filter"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /accommodations/{id}/reviews [get]
+func ListAccommodationReviews(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		page, err := parsePositiveInt(query.Get("page"), 1)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "page "+err.Error())
+			return
+		}
+		pageSize, err := parsePositiveInt(query.Get("page_size"), DefaultReviewPageSize)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "page_size "+err.Error())
+			return
+		}
+		if pageSize > MaxReviewPageSize {
+			pageSize = MaxReviewPageSize
+		}
+
+		sort := query.Get("sort")
+		if sort == "" {
+			sort = ReviewSortNewest
+		}
+		order, ok := reviewOrders[sort]
+		if !ok {
+			writeMessage(w, http.StatusBadRequest, "sort must be newest, highest, lowest or helpful")
+			return
+		}
+
+		stars, err := parseStarFilter(query["stars"])
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var accommodation models.Accommodation
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.Select("id").First(&accommodation, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch accommodation")
+			fmt.Println(err)
+			return
+		}
+
+		published := func() *gorm.DB {
+			q := db.Model(&models.Review{}).
+				Where("accommodation_id = ? AND moderation_status = ?", accommodation.ID, models.ReviewStatusApproved)
+			if len(stars) > 0 {
+				q = q.Where(reviewStars+" IN ?", stars)
+			}
+			return q
+		}
+
+		result := ReviewPage{Reviews: []models.Review{}, Page: page, PageSize: pageSize}
+		if err := published().Count(&result.Total).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch reviews")
+			fmt.Println(err)
+			return
+		}
+		if result.Total > int64((page-1)*pageSize) {
+			err := published().Preload("Response").Preload("Photos").
+				Order(order).
+				Limit(pageSize).
+				Offset((page - 1) * pageSize).
+				Find(&result.Reviews).Error
+			if err != nil {
+				writeMessage(w, http.StatusInternalServerError, "Failed to fetch reviews")
+				fmt.Println(err)
+				return
+			}
+		}
+
+		for i := range result.Reviews {
+			for j := range result.Reviews[i].Photos {
+				result.Reviews[i].Photos[j].SetURLs(apiBaseURL)
+			}
+		}
+
+		summary, err := SummarizeReviews(accommodation.ID, db)
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch reviews")
+			fmt.Println(err)
+			return
+		}
+		result.Summary = *summary
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
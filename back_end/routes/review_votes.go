@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// HelpfulVoteResult is the review's vote count after a user's vote is toggled
+type HelpfulVoteResult struct {
+	ReviewID     uint `json:"ReviewID"`
+	HelpfulCount uint `json:"HelpfulCount"`
+	Voted        bool `json:"Voted"` // whether the user's vote is now counted
+}
+
+// ToggleHelpfulVote records that a review was helpful to a user, or withdraws the vote if they already gave it.
+// It returns the review's updated count and whether the user's vote now stands.
+func ToggleHelpfulVote(reviewID, userID uint, db *gorm.DB) (*HelpfulVoteResult, error) {
+	result := HelpfulVoteResult{ReviewID: reviewID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		withdrawn := tx.Where("review_id = ? AND user_id = ?", reviewID, userID).Delete(&models.ReviewVote{})
+		if withdrawn.Error != nil {
+			return withdrawn.Error
+		}
+
+		change := gorm.Expr("helpful_count + 1")
+		if withdrawn.RowsAffected > 0 {
+			change = gorm.Expr("helpful_count - 1")
+		} else {
+			if err := tx.Create(&models.ReviewVote{ReviewID: reviewID, UserID: userID}).Error; err != nil {
+				return err
+			}
+			result.Voted = true
+		}
+
+		// Counting in SQL keeps concurrent votes from overwriting each other
+		if err := tx.Model(&models.Review{}).Where("id = ?", reviewID).Update("helpful_count", change).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Review{}).Where("id = ?", reviewID).Pluck("helpful_count", &result.HelpfulCount).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// VoteReviewHelpful toggles the user's helpful vote on a review
+// @Summary Vote review helpful
+// @Description Mark a published review as helpful, or take the vote back if you already gave it. Authors cannot vote on their own reviews.
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} HelpfulVoteResult "Updated vote count"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User wrote the review"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Vote was already recorded"
+// @Router /reviews/{id}/helpful [post]
+func VoteReviewHelpful(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var review models.Review
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		err := db.Where("moderation_status = ?", models.ReviewStatusApproved).First(&review, id).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Review not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch review")
+			fmt.Println(err)
+			return
+		}
+		if review.UserID == userID {
+			writeMessage(w, http.StatusForbidden, "You cannot vote on your own review")
+			return
+		}
+
+		result, err := ToggleHelpfulVote(review.ID, userID, db)
+		if err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) { // a second request from the user raced this one
+				writeMessage(w, http.StatusConflict, "Your vote was already recorded")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to record vote")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,339 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// ReviewWindow is how long after checkout a guest can review their stay
+var ReviewWindow = 14 * 24 * time.Hour
+
+var (
+	// ErrNoCompletedStay is returned when the user hasn't finished a stay at the accommodation
+	ErrNoCompletedStay = errors.New("only guests with a completed stay can review this accommodation")
+	// ErrReviewWindowClosed is returned when every unreviewed stay checked out too long ago
+	ErrReviewWindowClosed = errors.New("the review window for this stay has closed")
+	// ErrStayAlreadyReviewed is returned when every completed stay already has a review
+	ErrStayAlreadyReviewed = errors.New("this stay has already been reviewed")
+)
+
+// FindReviewableBooking returns the most recent stay by the user at the accommodation that has
+// checked out, hasn't been reviewed yet and is still inside the review window.
+func FindReviewableBooking(userID, accommodationID uint, now time.Time, db *gorm.DB) (*models.Booking, error) {
+	// Confirmed bookings count once checkout has passed, even before the completion job has run
+	var stays []models.Booking
+	err := db.Where("user_id = ? AND accommodation_id = ? AND status IN ? AND checkout_date <= ?",
+		userID, accommodationID, []string{models.BookingStatusConfirmed, models.BookingStatusCompleted}, now).
+		Order("checkout_date desc").
+		Find(&stays).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(stays) == 0 {
+		return nil, ErrNoCompletedStay
+	}
+
+	ids := make([]uint, len(stays))
+	for i, stay := range stays {
+		ids[i] = stay.ID
+	}
+	// Deleted reviews still count, so a stay is reviewed at most once
+	var reviewedIDs []uint
+	if err := db.Unscoped().Model(&models.Review{}).Where("booking_id IN ?", ids).Pluck("booking_id", &reviewedIDs).Error; err != nil {
+		return nil, err
+	}
+	reviewed := make(map[uint]bool, len(reviewedIDs))
+	for _, id := range reviewedIDs {
+		reviewed[id] = true
+	}
+
+	result := ErrStayAlreadyReviewed
+	for i := range stays {
+		if reviewed[stays[i].ID] {
+			continue
+		}
+		if now.Sub(stays[i].CheckoutDate) > ReviewWindow {
+			result = ErrReviewWindowClosed
+			continue
+		}
+		return &stays[i], nil
+	}
+	return nil, result
+}
+
+// UpdateReviewRequest holds the fields of a review the author may change; omitted fields are left as they are
+type UpdateReviewRequest struct {
+	Rating            *float64 `json:"Rating"`
+	Comment           *string  `json:"Comment"`
+	CleanlinessRating *float64 `json:"CleanlinessRating"`
+	LocationRating    *float64 `json:"LocationRating"`
+	ValueRating       *float64 `json:"ValueRating"`
+}
+
+// ReviewResponseRequest is the owner's reply to a review
+type ReviewResponseRequest struct {
+	Comment string `json:"Comment"`
+}
+
+// loadOwnReview fetches the review in the URL and checks the session user wrote it.
+// It writes the error response and returns nil if they can't touch it.
+func loadOwnReview(w http.ResponseWriter, r *http.Request, db *gorm.DB) *models.Review {
+	session, _ := getSession(r, "session")
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok || userID == 0 {
+		writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+		return nil
+	}
+
+	var review models.Review
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return nil
+	}
+	if err := db.First(&review, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeMessage(w, http.StatusNotFound, "Review not found")
+			return nil
+		}
+		writeMessage(w, http.StatusInternalServerError, "Failed to fetch review")
+		fmt.Println(err)
+		return nil
+	}
+	if review.UserID != userID {
+		writeMessage(w, http.StatusForbidden, "Only the author can change this review")
+		return nil
+	}
+	return &review
+}
+
+// UpdateReview lets the author edit their review. The previous version is kept as a revision.
+// @Summary Edit review
+// @Description Edit the rating, sub-ratings or comment of your own review. The previous version is kept in the review's history.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param review body UpdateReviewRequest true "Fields to change"
+// @Success 200 {object} models.Review "Updated review"
+// @Failure 400 {object} map[string]string "Invalid rating or comment"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User did not write the review"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Router /reviews/{id} [patch]
+func UpdateReview(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review := loadOwnReview(w, r, db)
+		if review == nil {
+			return
+		}
+
+		var req UpdateReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		revision := models.NewReviewRevision(*review, models.ReviewRevisionEdited)
+		if req.Rating != nil {
+			review.Rating = *req.Rating
+		}
+		if req.Comment != nil {
+			review.Comment = strings.TrimSpace(*req.Comment)
+		}
+		if req.CleanlinessRating != nil {
+			review.CleanlinessRating = *req.CleanlinessRating
+		}
+		if req.LocationRating != nil {
+			review.LocationRating = *req.LocationRating
+		}
+		if req.ValueRating != nil {
+			review.ValueRating = *req.ValueRating
+		}
+
+		if review.Rating < 1 || review.Rating > 5 {
+			writeMessage(w, http.StatusBadRequest, "Rating must be between 1 and 5")
+			return
+		}
+		if review.Comment == "" {
+			writeMessage(w, http.StatusBadRequest, "Comment cannot be empty")
+			return
+		}
+		if !isValidSubRating(review.CleanlinessRating) || !isValidSubRating(review.LocationRating) || !isValidSubRating(review.ValueRating) {
+			writeMessage(w, http.StatusBadRequest, "Sub-ratings must be between 1 and 5")
+			return
+		}
+
+		now := time.Now()
+		columns := []string{"Rating", "Comment", "CleanlinessRating", "LocationRating", "ValueRating", "EditedAt"}
+		// A reworded comment goes back through the screener and may be held again. Otherwise the moderation
+		// status is left as stored, so an edit never undoes a moderator's decision.
+		if review.Comment != revision.Comment {
+			held, err := ScreenReview(review, now, db)
+			if err != nil {
+				writeMessage(w, http.StatusInternalServerError, "Failed to update review")
+				fmt.Println(err)
+				return
+			}
+			if held {
+				columns = append(columns, "ModerationStatus", "ModerationReason")
+			}
+		}
+
+		review.EditedAt = &now
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAccommodation(review.AccommodationID, tx); err != nil {
+				return err
+			}
+			if err := tx.Create(&revision).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(review).Select(columns).Updates(review).Error; err != nil {
+				return err
+			}
+			_, err := RecalculateAccommodationRating(review.AccommodationID, tx)
+			return err
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update review")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, review)
+	}
+}
+
+// DeleteReview removes the author's review along with any owner response. Its last content is kept as a revision,
+// and the review is only soft deleted so its stay can't be reviewed again.
+// @Summary Delete review
+// @Description Delete your own review. The accommodation's rating is recalculated without it.
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} map[string]string "Review deleted"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User did not write the review"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Router /reviews/{id} [delete]
+func DeleteReview(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review := loadOwnReview(w, r, db)
+		if review == nil {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAccommodation(review.AccommodationID, tx); err != nil {
+				return err
+			}
+			revision := models.NewReviewRevision(*review, models.ReviewRevisionDeleted)
+			if err := tx.Create(&revision).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("review_id = ?", review.ID).Delete(&models.ReviewResponse{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(review).Error; err != nil {
+				return err
+			}
+			_, err := RecalculateAccommodationRating(review.AccommodationID, tx)
+			return err
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to delete review")
+			fmt.Println(err)
+			return
+		}
+
+		writeMessage(w, http.StatusOK, "Review deleted")
+	}
+}
+
+// RespondToReview publishes the accommodation owner's reply to a review
+// @Summary Respond to review
+// @Description Publish the owner's public reply to a review of their accommodation. Each review can have one reply.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param response body ReviewResponseRequest true "Reply"
+// @Success 201 {object} models.ReviewResponse "Reply published"
+// @Failure 400 {object} map[string]string "Empty reply"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "User does not own the accommodation"
+// @Failure 404 {object} map[string]string "Review not found"
+// @Failure 409 {object} map[string]string "Review already has a reply"
+// @Router /reviews/{id}/response [post]
+func RespondToReview(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var review models.Review
+		id, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		if err := db.First(&review, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Review not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch review")
+			fmt.Println(err)
+			return
+		}
+
+		if !isAccommodationOwner(userID, review.AccommodationID, db) {
+			writeMessage(w, http.StatusForbidden, "Only the accommodation owner can respond to this review")
+			return
+		}
+
+		var req ReviewResponseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		req.Comment = strings.TrimSpace(req.Comment)
+		if req.Comment == "" {
+			writeMessage(w, http.StatusBadRequest, "Response cannot be empty")
+			return
+		}
+
+		var existing int64
+		if err := db.Model(&models.ReviewResponse{}).Where("review_id = ?", review.ID).Count(&existing).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to save response")
+			fmt.Println(err)
+			return
+		}
+		if existing > 0 {
+			writeMessage(w, http.StatusConflict, "This review already has a response")
+			return
+		}
+
+		response := models.ReviewResponse{ReviewID: review.ID, Comment: req.Comment}
+		if err := db.Create(&response).Error; err != nil {
+			// Two replies racing past the count above
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				writeMessage(w, http.StatusConflict, "This review already has a response")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to save response")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, response)
+	}
+}
@@ -0,0 +1,273 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// RoomTypeRequest describes a kind of room an owner offers
+type RoomTypeRequest struct {
+	Name          string   `json:"Name"`
+	Description   string   `json:"Description"`
+	Quantity      uint     `json:"Quantity"`     // how many identical rooms there are
+	MaxOccupancy  uint     `json:"MaxOccupancy"` // guests per room
+	PricePerNight float64  `json:"PricePerNight"`
+	Facilities    []string `json:"Facilities"`
+}
+
+func (req RoomTypeRequest) apply(roomType *models.RoomType) {
+	roomType.Name = req.Name
+	roomType.Description = req.Description
+	roomType.Quantity = req.Quantity
+	roomType.MaxOccupancy = req.MaxOccupancy
+	roomType.PricePerNight = req.PricePerNight
+	roomType.Facilities = req.Facilities
+}
+
+// IsRoomTypeFull checks whether every room of a type is taken on some night of a stay.
+// Bookings of the whole accommodation and dates blocked for it take every room.
+func IsRoomTypeFull(roomType models.RoomType, checkinDate, checkoutDate, now time.Time, db *gorm.DB) (bool, error) {
+	var bookings []models.Booking
+	err := db.Select("room_type_id", "checkin_date", "checkout_date").
+		Where("accommodation_id = ? AND (room_type_id = ? OR room_type_id IS NULL) AND status IN ? AND checkin_date < ? AND checkout_date > ?",
+			roomType.AccommodationID, roomType.ID, models.ActiveBookingStatuses, checkoutDate, checkinDate).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Find(&bookings).Error
+	if err != nil {
+		return false, err
+	}
+
+	// Count the rooms taken each night of the stay, as bookings overlapping the stay needn't overlap each other
+	first, last := models.CalendarDay(checkinDate), models.CalendarDay(checkoutDate)
+	taken := map[time.Time]uint{}
+	for _, booking := range bookings {
+		if booking.RoomTypeID == nil {
+			return true, nil
+		}
+		for night := models.CalendarDay(booking.CheckinDate); night.Before(models.CalendarDay(booking.CheckoutDate)); night = night.AddDate(0, 0, 1) {
+			if night.Before(first) || !night.Before(last) {
+				continue
+			}
+			taken[night]++
+			if taken[night] >= roomType.Quantity {
+				return true, nil
+			}
+		}
+	}
+	return hasBlockedDates(roomType.AccommodationID, checkinDate, checkoutDate, db)
+}
+
+// findRoomType loads a room type of an accommodation by the id in a request
+func findRoomType(db *gorm.DB, accommodationID uint, id string) (*models.RoomType, error) {
+	roomTypeID, err := lookupID(id)
+	if err != nil {
+		return nil, err
+	}
+	var roomType models.RoomType
+	if err := db.Where("accommodation_id = ?", accommodationID).First(&roomType, roomTypeID).Error; err != nil {
+		return nil, err
+	}
+	return &roomType, nil
+}
+
+// hasRoomTypes reports whether an accommodation is booked by the room rather than as a whole
+func hasRoomTypes(db *gorm.DB, accommodationID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.RoomType{}).Where("accommodation_id = ?", accommodationID).Count(&count).Error
+	return count > 0, err
+}
+
+// ListRoomTypes lists the kinds of room an accommodation offers
+// @Summary List room types
+// @Description List the room types of an accommodation, cheapest first. Accommodations without room types are booked as a whole.
+// @Tags accommodations
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Success 200 {array} models.RoomType "Room types"
+// @Failure 400 {object} map[string]string "Invalid accommodation ID"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /accommodations/{id}/room-types [get]
+func ListRoomTypes(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodationID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		// An empty list means the accommodation is booked as a whole, so it must exist
+		var accommodation models.Accommodation
+		if err := db.Select("id").First(&accommodation, accommodationID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Accommodation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch room types")
+			fmt.Println(err)
+			return
+		}
+
+		roomTypes := []models.RoomType{}
+		err := db.Where("accommodation_id = ?", accommodationID).Order("price_per_night, id").Find(&roomTypes).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch room types")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, roomTypes)
+	}
+}
+
+// AddRoomType adds a kind of room to an owner's accommodation
+// @Summary Add a room type
+// @Description Add identical rooms that guests book one at a time, such as five doubles. Once an accommodation has room types, every booking must choose one.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param roomType body RoomTypeRequest true "Room type"
+// @Success 201 {object} models.RoomType "Room type added"
+// @Failure 400 {object} map[string]string "Invalid room type"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation not found"
+// @Router /owner/accommodations/{id}/room-types [post]
+func AddRoomType(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		var req RoomTypeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		roomType := models.RoomType{AccommodationID: accommodation.ID}
+		req.apply(&roomType)
+		if err := roomType.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := db.Create(&roomType).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to add room type")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, roomType)
+	}
+}
+
+// UpdateRoomType changes a kind of room at an owner's accommodation
+// @Summary Update a room type
+// @Description Change a room type. Lowering the quantity doesn't affect existing bookings.
+// @Tags owner
+// @Accept json
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param roomTypeId path int true "Room type ID"
+// @Param roomType body RoomTypeRequest true "Room type"
+// @Success 200 {object} models.RoomType "Room type updated"
+// @Failure 400 {object} map[string]string "Invalid room type"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation or room type not found"
+// @Router /owner/accommodations/{id}/room-types/{roomTypeId} [put]
+func UpdateRoomType(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		roomType, err := findRoomType(db, accommodation.ID, mux.Vars(r)["roomTypeId"])
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Room type not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch room type")
+			fmt.Println(err)
+			return
+		}
+
+		var req RoomTypeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		req.apply(roomType)
+		if err := roomType.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := db.Save(roomType).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update room type")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, roomType)
+	}
+}
+
+// DeleteRoomType removes a kind of room from an owner's accommodation
+// @Summary Delete a room type
+// @Description Remove a room type that has no upcoming bookings
+// @Tags owner
+// @Produce json
+// @Param id path int true "Accommodation ID"
+// @Param roomTypeId path int true "Room type ID"
+// @Success 200 {object} map[string]string "Room type deleted"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the accommodation owner"
+// @Failure 404 {object} map[string]string "Accommodation or room type not found"
+// @Failure 409 {object} map[string]string "Room type has upcoming bookings"
+// @Router /owner/accommodations/{id}/room-types/{roomTypeId} [delete]
+func DeleteRoomType(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accommodation := loadOwnedAccommodation(w, r, db)
+		if accommodation == nil {
+			return
+		}
+
+		roomType, err := findRoomType(db, accommodation.ID, mux.Vars(r)["roomTypeId"])
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Room type not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch room type")
+			fmt.Println(err)
+			return
+		}
+
+		var upcoming int64
+		err = db.Model(&models.Booking{}).
+			Where("room_type_id = ? AND status IN ? AND checkout_date > ?", roomType.ID, models.ActiveBookingStatuses, time.Now()).
+			Count(&upcoming).Error
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to delete room type")
+			fmt.Println(err)
+			return
+		}
+		if upcoming > 0 {
+			writeMessage(w, http.StatusConflict, "Room type has upcoming bookings")
+			return
+		}
+
+		if err := db.Delete(roomType).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to delete room type")
+			fmt.Println(err)
+			return
+		}
+		writeMessage(w, http.StatusOK, "Room type deleted")
+	}
+}
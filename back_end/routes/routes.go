@@ -36,20 +36,89 @@ func NewRouter(db *gorm.DB) *mux.Router {
 	r.HandleFunc("/accommodations/{id}", FetchAccommodationById(db)).Methods("GET")
 	r.HandleFunc("/events", CreateEvent(db)).Methods("POST")
 	r.HandleFunc("/events/{id}", FetchEventById(db)).Methods("GET")
+	r.HandleFunc("/events/{id}", UpdateEvent(db)).Methods("PATCH")
+	r.HandleFunc("/events/{id}/cancel", CancelEvent(db)).Methods("POST")
+	r.HandleFunc("/events/{id}/ticket-tiers", ListTicketTiers(db)).Methods("GET")
+	r.HandleFunc("/events/{id}/ticket-tiers", AddTicketTier(db)).Methods("POST")
+	r.HandleFunc("/events/{id}/ticket-tiers/{tierId}", UpdateTicketTier(db)).Methods("PUT")
+	r.HandleFunc("/events/{id}/ticket-tiers/{tierId}", DeleteTicketTier(db)).Methods("DELETE")
+	r.HandleFunc("/events/{id}/seats", ListEventSeats(db)).Methods("GET")
+	r.HandleFunc("/events/{id}/seat-map", UpdateSeatMap(db)).Methods("PUT")
+	r.HandleFunc("/events/{id}/seats/hold", HoldEventSeats(db)).Methods("POST")
+	r.HandleFunc("/events/{id}/seats/hold", ReleaseEventSeats(db)).Methods("DELETE")
 	r.HandleFunc("/accommodations", CreateAccommodation(db)).Methods("POST")
 	r.HandleFunc("/accommodations", FetchAccommodations(db)).Methods("GET")
 	r.HandleFunc("/events", FetchEvents(db)).Methods("GET")
 	r.HandleFunc("/accommodations", AddBooking(db)).Methods("PUT")
 	r.HandleFunc("/events", AddEventBooking(db)).Methods("PUT")
 	r.HandleFunc("/accommodations/{id}/reviews", AddReview(db)).Methods("POST")
+	r.HandleFunc("/accommodations/{id}/reviews", ListAccommodationReviews(db)).Methods("GET")
 	r.HandleFunc("/users/reviews", GetUserReviewsHandler(db)).Methods("GET")
+	r.HandleFunc("/reviews/{id}", UpdateReview(db)).Methods("PATCH")
+	r.HandleFunc("/reviews/{id}", DeleteReview(db)).Methods("DELETE")
+	r.HandleFunc("/reviews/{id}/response", RespondToReview(db)).Methods("POST")
+	r.HandleFunc("/reviews/{id}/report", ReportReview(db)).Methods("POST")
+	r.HandleFunc("/reviews/{id}/helpful", VoteReviewHelpful(db)).Methods("POST")
+	r.HandleFunc("/reviews/{id}/media", AttachReviewMedia(db)).Methods("POST")
+	r.HandleFunc("/accommodations/{id}/media", AttachAccommodationMedia(db)).Methods("POST")
+	r.HandleFunc("/accommodations/{id}/availability.ics", GetAvailabilityCalendar(db)).Methods("GET")
+	r.HandleFunc("/accommodations/{id}/quote", GetStayQuote(db)).Methods("GET")
+	r.HandleFunc("/accommodations/{id}/room-types", ListRoomTypes(db)).Methods("GET")
+	r.HandleFunc("/events/{id}/media", AttachEventMedia(db)).Methods("POST")
+	r.HandleFunc("/media", UploadMedia(db)).Methods("POST")
+	r.HandleFunc("/media/{id}", ServeMedia(db)).Methods("GET")
+	r.HandleFunc("/media/{id}/thumbnail", ServeMediaThumbnail(db)).Methods("GET")
+	r.HandleFunc("/admin/reviews", ListModerationQueue(db)).Methods("GET")
+	r.HandleFunc("/admin/reviews/{id}/approve", ApproveReview(db)).Methods("POST")
+	r.HandleFunc("/admin/reviews/{id}/reject", RejectReview(db)).Methods("POST")
+	r.HandleFunc("/admin/reviews/{id}/hide", HideReview(db)).Methods("POST")
 	r.HandleFunc("/users/avatar", UpdateUserAvatarHandler(db)).Methods("PUT")
+	r.HandleFunc("/users/avatar", UploadUserAvatarHandler(db)).Methods("POST")
+	r.HandleFunc("/users/{id}/avatar", GetUserAvatarHandler(db)).Methods("GET")
+	r.HandleFunc("/users/calendar-feed", CreateCalendarFeed(db)).Methods("POST")
+	r.HandleFunc("/users/calendar.ics", GetUserCalendarFeed(db)).Methods("GET")
+	r.HandleFunc("/trips", CreateTrip(db)).Methods("POST")
+	r.HandleFunc("/trips", ListTrips(db)).Methods("GET")
+	r.HandleFunc("/trips/{id}", DeleteTrip(db)).Methods("DELETE")
+	r.HandleFunc("/trips/{id}/itinerary", GetTripItinerary(db)).Methods("GET")
+	r.HandleFunc("/trips/{id}/bookings", AddTripBooking(db)).Methods("POST")
+	r.HandleFunc("/trips/{id}/bookings/{bookingId}", RemoveTripBooking(db)).Methods("DELETE")
+	r.HandleFunc("/trips/{id}/event-bookings", AddTripEventBooking(db)).Methods("POST")
+	r.HandleFunc("/trips/{id}/event-bookings/{bookingId}", RemoveTripEventBooking(db)).Methods("DELETE")
+	r.HandleFunc("/trips/{id}/invitations", InviteTripMember(db)).Methods("POST")
+	r.HandleFunc("/trips/invitations/{token}/accept", AcceptTripInvitation(db)).Methods("POST")
+	r.HandleFunc("/trips/{id}/members", ListTripMembers(db)).Methods("GET")
+	r.HandleFunc("/trips/{id}/members/{memberId}", RemoveTripMember(db)).Methods("DELETE")
 
 	r.HandleFunc("/accommodations", RemoveBooking(db)).Methods("DELETE")
 	r.HandleFunc("/events", RemoveEventBooking(db)).Methods("DELETE")
+	r.HandleFunc("/accommodations/bookings/{id}/refund-preview", PreviewBookingRefund(db)).Methods("GET")
+	r.HandleFunc("/events/bookings/{id}/refund-preview", PreviewEventBookingRefund(db)).Methods("GET")
+	r.HandleFunc("/accommodations/bookings/{id}/calendar.ics", GetBookingCalendar(db)).Methods("GET")
+	r.HandleFunc("/events/bookings/{id}/calendar.ics", GetEventBookingCalendar(db)).Methods("GET")
 	r.HandleFunc("/users/profile", GetUserProfileHandler(db)).Methods("GET")
 	r.HandleFunc("/owner", CreateOwner(db)).Methods("POST")
+	r.HandleFunc("/owner/bookings/{id}/approve", ApproveBookingRequest(db)).Methods("POST")
+	r.HandleFunc("/owner/bookings/{id}/decline", DeclineBookingRequest(db)).Methods("POST")
+	r.HandleFunc("/owner/bookings/{id}/no-show", MarkBookingNoShow(db)).Methods("POST")
+	r.HandleFunc("/owner/accommodations/{id}/cancellation-policy", UpdateCancellationPolicy(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/calendars", ListCalendarSources(db)).Methods("GET")
+	r.HandleFunc("/owner/accommodations/{id}/calendars", AddCalendarSource(db)).Methods("POST")
+	r.HandleFunc("/owner/accommodations/{id}/calendars/upload", UploadCalendarSource(db)).Methods("POST")
+	r.HandleFunc("/owner/accommodations/{id}/calendars/{calendarId}", DeleteCalendarSource(db)).Methods("DELETE")
+	r.HandleFunc("/owner/accommodations/{id}/availability-rules", UpdateAvailabilityRules(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/guest-policy", UpdateGuestPolicy(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/pricing-rules", UpdatePricingRules(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/price-suggestions", GetPriceSuggestions(db)).Methods("GET")
+	r.HandleFunc("/owner/accommodations/{id}/price-suggestions/settings", UpdatePriceSuggestionSettings(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/room-types", AddRoomType(db)).Methods("POST")
+	r.HandleFunc("/owner/accommodations/{id}/room-types/{roomTypeId}", UpdateRoomType(db)).Methods("PUT")
+	r.HandleFunc("/owner/accommodations/{id}/room-types/{roomTypeId}", DeleteRoomType(db)).Methods("DELETE")
+	r.HandleFunc("/owner/accommodations/{id}/blackouts", ListBlackouts(db)).Methods("GET")
+	r.HandleFunc("/owner/accommodations/{id}/blackouts", AddBlackout(db)).Methods("POST")
+	r.HandleFunc("/owner/accommodations/{id}/blackouts/{blackoutId}", DeleteBlackout(db)).Methods("DELETE")
 	r.HandleFunc("/organizer", CreateOrganizer(db)).Methods("POST")
+	r.HandleFunc("/payments/webhook", PaymentWebhook(db)).Methods("POST")
 
 	// Handle OPTIONS requests
 	r.Use(mux.CORSMethodMiddleware(r))
@@ -67,7 +136,7 @@ func NewRouter(db *gorm.DB) *mux.Router {
 	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
 	// Change from wildcard to specific origins
 	originsOk := handlers.AllowedOrigins([]string{"http://localhost:5173"}) // Frontend URL
-	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
 	// Add credentials allowed option
 	credentialsOk := handlers.AllowCredentials()
 
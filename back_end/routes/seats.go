@@ -0,0 +1,399 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// SeatMapRequest lays out an event's seats by section and row
+type SeatMapRequest struct {
+	Sections []SeatMapSection `json:"Sections"`
+}
+
+// SeatMapSection is an area of the venue, such as Stalls or Balcony
+type SeatMapSection struct {
+	Name string       `json:"Name" example:"Balcony"`
+	Rows []SeatMapRow `json:"Rows"`
+}
+
+// SeatMapRow is a row of seats in a section
+type SeatMapRow struct {
+	Name  string        `json:"Name" example:"A"`
+	Seats []SeatMapSeat `json:"Seats"`
+}
+
+// SeatMapSeat is a seat in a row
+type SeatMapSeat struct {
+	Label      string `json:"Label" example:"12"`
+	Wheelchair bool   `json:"Wheelchair"`
+	Companion  bool   `json:"Companion"`
+}
+
+// SeatHoldRequest lists the seats a guest picked
+type SeatHoldRequest struct {
+	SeatIDs []uint `json:"SeatIDs"`
+}
+
+// SeatHoldResponse is returned once seats are held
+type SeatHoldResponse struct {
+	SeatIDs   []uint    `json:"SeatIDs"`
+	HeldUntil time.Time `json:"HeldUntil"`
+}
+
+// seats turns the map into seats of the event, checking every seat has a unique place
+func (req SeatMapRequest) seats(eventID uint) ([]models.Seat, error) {
+	var seats []models.Seat
+	places := map[string]bool{}
+	for _, section := range req.Sections {
+		for _, row := range section.Rows {
+			for _, seat := range row.Seats {
+				sectionName, rowName, label := strings.TrimSpace(section.Name), strings.TrimSpace(row.Name), strings.TrimSpace(seat.Label)
+				if label == "" {
+					return nil, errors.New("every seat needs a label")
+				}
+				place := sectionName + "\x00" + rowName + "\x00" + label
+				if places[place] {
+					return nil, fmt.Errorf("seat %s %s %s is listed twice", sectionName, rowName, label)
+				}
+				places[place] = true
+				seats = append(seats, models.Seat{
+					EventID:    eventID,
+					Section:    sectionName,
+					Row:        rowName,
+					Label:      label,
+					Wheelchair: seat.Wheelchair,
+					Companion:  seat.Companion,
+				})
+			}
+		}
+	}
+	if len(seats) > models.MaxSeatsPerEvent {
+		return nil, fmt.Errorf("a seat map can have at most %d seats", models.MaxSeatsPerEvent)
+	}
+	return seats, nil
+}
+
+// parseSeatIDs reads a comma separated list of seat ids, dropping repeats
+func parseSeatIDs(value string) ([]uint, error) {
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, errors.New("seat_ids must be a comma separated list of seat ids")
+		}
+		ids = append(ids, uint(id))
+	}
+	return uniqueSeatIDs(ids), nil
+}
+
+// uniqueSeatIDs drops repeated seat ids, keeping their order
+func uniqueSeatIDs(ids []uint) []uint {
+	var unique []uint
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// holdSeats holds seats of an event for a user for models.SeatHoldDuration, returning
+// models.ErrSeatsUnavailable unless every seat is free or already held by them. The check and
+// update are one statement, so two guests can't hold the same seat. Other seats the user held at
+// the event are released, so nobody holds more seats than one booking takes.
+func holdSeats(db *gorm.DB, eventID, userID uint, seatIDs []uint, now time.Time) (time.Time, error) {
+	heldUntil := now.Add(models.SeatHoldDuration)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Seat{}).
+			Where("event_id = ? AND held_by = ? AND event_booking_id IS NULL AND id NOT IN ?", eventID, userID, seatIDs).
+			Updates(map[string]interface{}{"held_by": nil, "held_until": nil}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.Seat{}).
+			Where("event_id = ? AND id IN ? AND event_booking_id IS NULL", eventID, seatIDs).
+			Where("held_by IS NULL OR held_by = ? OR held_until <= ?", userID, now).
+			Updates(map[string]interface{}{"held_by": userID, "held_until": heldUntil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(seatIDs)) {
+			return models.ErrSeatsUnavailable
+		}
+		return nil
+	})
+	return heldUntil, err
+}
+
+// checkSeatSections returns models.ErrSeatsUnavailable unless every seat can be booked with the tier:
+// a tier with a section sells only the seats there, and a tier for anywhere sells none of the seats in
+// sections other tiers sell
+func checkSeatSections(db *gorm.DB, eventID uint, seatIDs []uint, tier models.TicketTier) error {
+	query := db.Model(&models.Seat{}).Where("event_id = ? AND id IN ?", eventID, seatIDs)
+	if tier.Section != "" {
+		query = query.Where("section <> ?", tier.Section)
+	} else {
+		sold := db.Model(&models.TicketTier{}).Select("section").Where("event_id = ? AND section <> ''", eventID)
+		query = query.Where("section IN (?)", sold)
+	}
+	var outside int64
+	if err := query.Count(&outside).Error; err != nil {
+		return err
+	}
+	if outside > 0 {
+		return models.ErrSeatsUnavailable
+	}
+	return nil
+}
+
+// bookHeldSeats gives the seats a user holds to their booking
+func bookHeldSeats(db *gorm.DB, booking *models.EventBooking, seatIDs []uint) error {
+	result := db.Model(&models.Seat{}).
+		Where("event_id = ? AND id IN ? AND held_by = ? AND event_booking_id IS NULL", booking.EventId, seatIDs, booking.UserID).
+		Updates(map[string]interface{}{"event_booking_id": booking.ID, "held_by": nil, "held_until": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(seatIDs)) {
+		return models.ErrSeatsUnavailable
+	}
+	return nil
+}
+
+// releaseBookingSeats frees the seats of a cancelled booking
+func releaseBookingSeats(db *gorm.DB, bookingID uint) error {
+	return db.Model(&models.Seat{}).Where("event_booking_id = ?", bookingID).
+		Update("event_booking_id", nil).Error
+}
+
+// releaseSeatHolds frees the seats a user holds at an event
+func releaseSeatHolds(db *gorm.DB, eventID, userID uint) error {
+	return db.Model(&models.Seat{}).
+		Where("event_id = ? AND held_by = ? AND event_booking_id IS NULL", eventID, userID).
+		Updates(map[string]interface{}{"held_by": nil, "held_until": nil}).Error
+}
+
+// ListEventSeats shows which seats of an event can be picked
+// @Summary List event seats
+// @Description List the seats of an event with a seat map, by section and row, with whether each is available, held by a guest who is booking or booked. Pass section to list one section.
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param section query string false "Only list seats in this section"
+// @Success 200 {array} models.SeatAvailability "Seats"
+// @Failure 400 {object} map[string]string "Invalid id"
+// @Router /events/{id}/seats [get]
+func ListEventSeats(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		query := db.Where("event_id = ?", eventID)
+		if section := r.URL.Query().Get("section"); section != "" {
+			query = query.Where("section = ?", section)
+		}
+		var seats []models.Seat
+		if err := query.Order("section, row, id").Find(&seats).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch seats")
+			fmt.Println(err)
+			return
+		}
+		now := time.Now()
+		availability := []models.SeatAvailability{}
+		for _, seat := range seats {
+			availability = append(availability, seat.Availability(now))
+		}
+		writeJSON(w, http.StatusOK, availability)
+	}
+}
+
+// UpdateSeatMap replaces the seat map of an organizer's event
+// @Summary Set the seat map
+// @Description Lay out the event's seats by section and row, flagging wheelchair spaces and companion seats. Guests then pick their seats when booking. Sections named like a ticket tier's section are sold through that tier. Send no sections to remove the map. The map can't change once seats are booked or held.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param seatMap body SeatMapRequest true "Seat map"
+// @Success 200 {array} models.Seat "Seats saved"
+// @Failure 400 {object} map[string]string "Invalid seat map"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the event organizer"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Failure 409 {object} map[string]string "Seats are booked or held"
+// @Router /events/{id}/seat-map [put]
+func UpdateSeatMap(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := loadOrganizedEvent(w, r, db)
+		if event == nil {
+			return
+		}
+
+		var req SeatMapRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		seats, err := req.seats(event.ID)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if uint(len(seats)) > event.TotalSeats {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("The seat map has %d seats but the event only has %d", len(seats), event.TotalSeats))
+			return
+		}
+
+		now := time.Now()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Bookings take the same lock, so none can land between the count and the delete
+			if err := lockEvent(event.ID, tx); err != nil {
+				return err
+			}
+			var total int64
+			if err := tx.Model(&models.Seat{}).Where("event_id = ?", event.ID).Count(&total).Error; err != nil {
+				return err
+			}
+			// Holds don't take the lock, so only free seats are deleted and any that weren't mean one was taken
+			result := tx.Where("event_id = ? AND event_booking_id IS NULL AND (held_until IS NULL OR held_until <= ?)", event.ID, now).
+				Delete(&models.Seat{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != total {
+				return models.ErrSeatsUnavailable
+			}
+			if len(seats) > 0 {
+				if err := tx.CreateInBatches(&seats, 500).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(event).Update("reserved_seating", len(seats) > 0).Error
+		})
+		if err != nil {
+			if errors.Is(err, models.ErrSeatsUnavailable) {
+				writeMessage(w, http.StatusConflict, "The seat map can't change once seats are booked or held")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to save seat map")
+			fmt.Println(err)
+			return
+		}
+		if seats == nil {
+			seats = []models.Seat{}
+		}
+		writeJSON(w, http.StatusOK, seats)
+	}
+}
+
+// HoldEventSeats keeps seats for a guest while they book
+// @Summary Hold seats
+// @Description Hold the seats you picked for a few minutes while you pay, so no one else can take them. Book them by passing the same seat ids to the event booking. Any other seats you held at the event are released.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param seats body SeatHoldRequest true "Seats to hold"
+// @Success 200 {object} SeatHoldResponse "Seats held"
+// @Failure 400 {object} map[string]string "No seats picked"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Failure 409 {object} map[string]string "Seats no longer available or event cancelled"
+// @Router /events/{id}/seats/hold [post]
+func HoldEventSeats(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		eventID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		var event models.Event
+		if err := db.First(&event, eventID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Event not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to hold seats")
+			fmt.Println(err)
+			return
+		}
+		if event.IsCancelled() {
+			writeMessage(w, http.StatusConflict, "Event has been cancelled")
+			return
+		}
+		if !event.ReservedSeating {
+			writeMessage(w, http.StatusBadRequest, "This event doesn't have reserved seating")
+			return
+		}
+
+		var req SeatHoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SeatIDs) == 0 {
+			writeMessage(w, http.StatusBadRequest, "SeatIDs must list the seats to hold")
+			return
+		}
+		if len(req.SeatIDs) > models.MaxGuestsPerBooking {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("At most %d seats can be held at once", models.MaxGuestsPerBooking))
+			return
+		}
+		seatIDs := uniqueSeatIDs(req.SeatIDs)
+
+		heldUntil, err := holdSeats(db, eventID, userID, seatIDs, time.Now())
+		if err != nil {
+			if errors.Is(err, models.ErrSeatsUnavailable) {
+				writeMessage(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to hold seats")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, SeatHoldResponse{SeatIDs: seatIDs, HeldUntil: heldUntil})
+	}
+}
+
+// ReleaseEventSeats gives up the seats a guest holds
+// @Summary Release held seats
+// @Description Release every seat you hold at the event without booking them
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Success 200 {object} map[string]string "Seats released"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Router /events/{id}/seats/hold [delete]
+func ReleaseEventSeats(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		eventID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+
+		if err := releaseSeatHolds(db, eventID, userID); err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to release seats")
+			fmt.Println(err)
+			return
+		}
+		writeMessage(w, http.StatusOK, "Seats released")
+	}
+}
@@ -0,0 +1,311 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// TicketTierRequest describes a kind of ticket an organizer sells
+type TicketTierRequest struct {
+	Name        string     `json:"Name" example:"Early Bird"`
+	Description string     `json:"Description"`
+	Section     string     `json:"Section" example:"Balcony"`
+	Price       uint       `json:"Price" example:"40"`
+	Capacity    uint       `json:"Capacity" example:"100"`
+	SalesStart  *time.Time `json:"SalesStart"` // omit to put the tier on sale straight away
+	SalesEnd    *time.Time `json:"SalesEnd"`   // omit to sell until the event
+}
+
+func (req TicketTierRequest) apply(tier *models.TicketTier) {
+	tier.Name = req.Name
+	tier.Description = req.Description
+	tier.Section = req.Section
+	tier.Price = req.Price
+	tier.Capacity = req.Capacity
+	tier.SalesStart = req.SalesStart
+	tier.SalesEnd = req.SalesEnd
+}
+
+// loadOrganizedEvent loads the event in the request path if the logged in user organizes it,
+// writing the error response and returning nil otherwise
+func loadOrganizedEvent(w http.ResponseWriter, r *http.Request, db *gorm.DB) *models.Event {
+	session, _ := getSession(r, "session")
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok || userID == 0 {
+		writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+		return nil
+	}
+
+	event, err := GetEventByID(mux.Vars(r)["id"], db)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeMessage(w, http.StatusNotFound, "Event not found")
+			return nil
+		}
+		writeMessage(w, http.StatusInternalServerError, "Failed to fetch event")
+		fmt.Println(err)
+		return nil
+	}
+	if !isEventOrganizer(userID, event, db) {
+		writeMessage(w, http.StatusForbidden, "Only the event organizer can manage this event")
+		return nil
+	}
+	return event
+}
+
+// findTicketTier loads a tier of an event
+func findTicketTier(db *gorm.DB, eventID, id uint) (*models.TicketTier, error) {
+	var tier models.TicketTier
+	if err := db.Where("event_id = ? AND id = ?", eventID, id).First(&tier).Error; err != nil {
+		return nil, err
+	}
+	return &tier, nil
+}
+
+// hasTicketTiers reports whether an event's tickets are sold by tier
+func hasTicketTiers(db *gorm.DB, eventID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.TicketTier{}).Where("event_id = ?", eventID).Count(&count).Error
+	return count > 0, err
+}
+
+// ticketTierAvailability loads the tiers of the events as shown to guests at now, cheapest first
+func ticketTierAvailability(db *gorm.DB, now time.Time, eventIDs ...uint) (map[uint][]models.TicketTierAvailability, error) {
+	availability := map[uint][]models.TicketTierAvailability{}
+	if len(eventIDs) == 0 {
+		return availability, nil
+	}
+	var tiers []models.TicketTier
+	if err := db.Where("event_id IN ?", eventIDs).Order("price, id").Find(&tiers).Error; err != nil {
+		return nil, err
+	}
+	for _, tier := range tiers {
+		availability[tier.EventID] = append(availability[tier.EventID], tier.Availability(now))
+	}
+	return availability, nil
+}
+
+// reserveTierTickets takes tickets from a tier, returning models.ErrTierSoldOut if too few are left.
+// The check and update are one statement so concurrent bookings can't oversell the tier.
+func reserveTierTickets(db *gorm.DB, tierID, guests uint) error {
+	result := db.Model(&models.TicketTier{}).
+		Where("id = ? AND sold + ? <= capacity", tierID, guests).
+		Update("sold", gorm.Expr("sold + ?", guests))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrTierSoldOut
+	}
+	return nil
+}
+
+// releaseTierTickets returns a booking's tickets to its tier, if it was booked in one
+func releaseTierTickets(db *gorm.DB, tierID *uint, guests uint) error {
+	if tierID == nil {
+		return nil
+	}
+	return db.Model(&models.TicketTier{}).
+		Where("id = ? AND sold >= ?", *tierID, guests).
+		Update("sold", gorm.Expr("sold - ?", guests)).Error
+}
+
+// ListTicketTiers lists the kinds of ticket sold for an event
+// @Summary List ticket tiers
+// @Description List the ticket tiers of an event, cheapest first, with how many tickets are left and whether they're on sale. Events without tiers sell one kind of ticket.
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Success 200 {array} models.TicketTierAvailability "Ticket tiers"
+// @Failure 400 {object} map[string]string "Invalid id"
+// @Router /events/{id}/ticket-tiers [get]
+func ListTicketTiers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, ok := pathID(w, r, "id")
+		if !ok {
+			return
+		}
+		var tiers []models.TicketTier
+		if err := db.Where("event_id = ?", eventID).Order("price, id").Find(&tiers).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch ticket tiers")
+			fmt.Println(err)
+			return
+		}
+		now := time.Now()
+		availability := []models.TicketTierAvailability{}
+		for _, tier := range tiers {
+			availability = append(availability, tier.Availability(now))
+		}
+		writeJSON(w, http.StatusOK, availability)
+	}
+}
+
+// AddTicketTier adds a kind of ticket to an organizer's event
+// @Summary Add a ticket tier
+// @Description Add a kind of ticket with its own price, capacity and sale window, such as VIP or a time-limited Early Bird. Once an event has tiers, every booking must choose one. Tiers share the event's seats.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param tier body TicketTierRequest true "Ticket tier"
+// @Success 201 {object} models.TicketTier "Ticket tier added"
+// @Failure 400 {object} map[string]string "Invalid ticket tier"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the event organizer"
+// @Failure 404 {object} map[string]string "Event not found"
+// @Router /events/{id}/ticket-tiers [post]
+func AddTicketTier(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := loadOrganizedEvent(w, r, db)
+		if event == nil {
+			return
+		}
+
+		var req TicketTierRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		tier := models.TicketTier{EventID: event.ID}
+		req.apply(&tier)
+		if err := tier.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := db.Create(&tier).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to add ticket tier")
+			fmt.Println(err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, tier)
+	}
+}
+
+// UpdateTicketTier changes a kind of ticket for an organizer's event
+// @Summary Update a ticket tier
+// @Description Change a ticket tier. Price changes don't affect existing bookings, and the capacity can't drop below the tickets sold.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param tierId path int true "Ticket tier ID"
+// @Param tier body TicketTierRequest true "Ticket tier"
+// @Success 200 {object} models.TicketTier "Ticket tier updated"
+// @Failure 400 {object} map[string]string "Invalid ticket tier"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the event organizer"
+// @Failure 404 {object} map[string]string "Event or ticket tier not found"
+// @Failure 409 {object} map[string]string "Capacity lower than tickets sold meanwhile"
+// @Router /events/{id}/ticket-tiers/{tierId} [put]
+func UpdateTicketTier(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := loadOrganizedEvent(w, r, db)
+		if event == nil {
+			return
+		}
+
+		tierID, ok := pathID(w, r, "tierId")
+		if !ok {
+			return
+		}
+		tier, err := findTicketTier(db, event.ID, tierID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Ticket tier not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch ticket tier")
+			fmt.Println(err)
+			return
+		}
+
+		var req TicketTierRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		req.apply(tier)
+		if err := tier.Validate(); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Sold is left alone so bookings made meanwhile aren't lost, and the capacity is only
+		// changed while it still covers them
+		result := db.Model(tier).Where("sold <= ?", tier.Capacity).
+			Select("name", "description", "section", "price", "capacity", "sales_start", "sales_end").
+			Updates(tier)
+		if result.Error != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to update ticket tier")
+			fmt.Println(result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			writeMessage(w, http.StatusConflict, "Capacity cannot be lower than tickets already sold")
+			return
+		}
+		writeJSON(w, http.StatusOK, tier)
+	}
+}
+
+// DeleteTicketTier removes a kind of ticket from an organizer's event
+// @Summary Delete a ticket tier
+// @Description Remove a ticket tier no tickets have been sold in
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param tierId path int true "Ticket tier ID"
+// @Success 200 {object} map[string]string "Ticket tier deleted"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Not the event organizer"
+// @Failure 404 {object} map[string]string "Event or ticket tier not found"
+// @Failure 409 {object} map[string]string "Tickets have been sold in the tier"
+// @Router /events/{id}/ticket-tiers/{tierId} [delete]
+func DeleteTicketTier(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := loadOrganizedEvent(w, r, db)
+		if event == nil {
+			return
+		}
+
+		tierID, ok := pathID(w, r, "tierId")
+		if !ok {
+			return
+		}
+		tier, err := findTicketTier(db, event.ID, tierID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Ticket tier not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch ticket tier")
+			fmt.Println(err)
+			return
+		}
+		if tier.Sold > 0 {
+			writeMessage(w, http.StatusConflict, "Tickets have been sold in this tier")
+			return
+		}
+
+		// A ticket sold since the tier was read keeps it
+		result := db.Where("sold = 0").Delete(tier)
+		if result.Error != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to delete ticket tier")
+			fmt.Println(result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			writeMessage(w, http.StatusConflict, "Tickets have been sold in this tier")
+			return
+		}
+		writeMessage(w, http.StatusOK, "Ticket tier deleted")
+	}
+}
@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// TripInvitationTTL is how long an invitation can be accepted for
+var TripInvitationTTL = 14 * 24 * time.Hour
+
+// TripInvitationRequest invites someone to a trip by email
+type TripInvitationRequest struct {
+	Email string `json:"Email"`
+	Role  string `json:"Role"` // editor or viewer; viewer if left out
+}
+
+// TripInvitation is a new invitation and the token that accepts it. The token is only returned here;
+// the owner passes it on to the invitee, so it never sits in a notification or the database.
+type TripInvitation struct {
+	Member models.TripMember
+	Token  string
+}
+
+// InviteTripMember invites someone to share a trip
+// @Summary Invite to trip
+// @Description Invite someone to a trip you planned by email, as an editor who can add their bookings or a viewer who can only see the itinerary. Registered users are notified. The returned token is the only copy; pass it on to the invitee, who accepts with it once signed in.
+// @Tags trips
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param invitation body TripInvitationRequest true "Who to invite"
+// @Success 201 {object} TripInvitation "Invitation created"
+// @Failure 400 {object} map[string]string "Invalid email or role"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Only the owner can invite"
+// @Failure 404 {object} map[string]string "Trip not found"
+// @Failure 409 {object} map[string]string "Email already invited"
+// @Router /trips/{id}/invitations [post]
+func InviteTripMember(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleOwner)
+		if access == nil {
+			return
+		}
+
+		var req TripInvitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		email := strings.ToLower(strings.TrimSpace(req.Email))
+		if !strings.Contains(email, "@") {
+			writeMessage(w, http.StatusBadRequest, "A valid email is required")
+			return
+		}
+		if req.Role == "" {
+			req.Role = models.TripRoleViewer
+		}
+		if !models.IsInvitableTripRole(req.Role) {
+			writeMessage(w, http.StatusBadRequest, "Role must be editor or viewer")
+			return
+		}
+
+		var invitees []models.User
+		if err := db.Where("LOWER(email) = ?", email).Limit(1).Find(&invitees).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to create invitation")
+			fmt.Println(err)
+			return
+		}
+		if len(invitees) > 0 && invitees[0].ID == access.Trip.UserID {
+			writeMessage(w, http.StatusBadRequest, "You are already on this trip")
+			return
+		}
+
+		token, err := newToken()
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to create invitation")
+			fmt.Println(err)
+			return
+		}
+		member := models.TripMember{
+			TripID:    access.Trip.ID,
+			Email:     email,
+			Role:      req.Role,
+			TokenHash: hashToken(token),
+			InvitedBy: access.UserID,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// An expired invitation doesn't hold on to the email, so the owner can invite them again
+			expired := tx.Where("trip_id = ? AND email = ? AND accepted_at IS NULL AND created_at <= ?",
+				access.Trip.ID, email, time.Now().Add(-TripInvitationTTL))
+			if err := expired.Delete(&models.TripMember{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&member).Error; err != nil {
+				return err
+			}
+			if len(invitees) == 0 {
+				return nil
+			}
+			message := fmt.Sprintf("You've been invited to join %s, a trip to %s, with the %s role. Accept the invitation with the code the trip owner sends you.",
+				access.Trip.Name, access.Trip.Destination, req.Role)
+			return QueueNotification(invitees[0].ID, "trip_invitation", "Trip invitation: "+access.Trip.Name, message, tx)
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				writeMessage(w, http.StatusConflict, "This email has already been invited")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to create invitation")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, TripInvitation{Member: member, Token: token})
+	}
+}
+
+// AcceptTripInvitation joins the session user to the trip they were invited to
+// @Summary Accept trip invitation
+// @Description Join a trip with the token from your invitation. You must be signed in with the email address the invitation was sent to.
+// @Tags trips
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 200 {object} models.TripMember "Joined the trip"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Invitation was sent to another email"
+// @Failure 404 {object} map[string]string "Invitation not found or revoked"
+// @Failure 409 {object} map[string]string "Invitation already accepted or no longer pending"
+// @Failure 410 {object} map[string]string "Invitation expired"
+// @Router /trips/invitations/{token}/accept [post]
+func AcceptTripInvitation(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var member models.TripMember
+		if err := db.Where("token_hash = ?", hashToken(mux.Vars(r)["token"])).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Invitation not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch invitation")
+			fmt.Println(err)
+			return
+		}
+		if member.IsAccepted() {
+			writeMessage(w, http.StatusConflict, "This invitation has already been accepted")
+			return
+		}
+		now := time.Now()
+		if now.Sub(member.CreatedAt) > TripInvitationTTL {
+			writeMessage(w, http.StatusGone, "This invitation has expired")
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch user")
+			fmt.Println(err)
+			return
+		}
+		// The token alone isn't enough, so a forwarded invitation can't be used by someone else
+		if !strings.EqualFold(strings.TrimSpace(user.Email), member.Email) {
+			writeMessage(w, http.StatusForbidden, "This invitation was sent to a different email address")
+			return
+		}
+
+		// Only a pending, unexpired invitation is accepted, even if it was accepted or revoked since it was read
+		result := db.Model(&models.TripMember{}).
+			Where("id = ? AND accepted_at IS NULL AND created_at > ?", member.ID, now.Add(-TripInvitationTTL)).
+			Updates(map[string]interface{}{"user_id": userID, "accepted_at": now})
+		if result.Error != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to accept invitation")
+			fmt.Println(result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			writeMessage(w, http.StatusConflict, "This invitation is no longer pending")
+			return
+		}
+		member.UserID = &userID
+		member.AcceptedAt = &now
+
+		writeJSON(w, http.StatusOK, member)
+	}
+}
+
+// ListTripMembers lists who a trip is shared with
+// @Summary List trip members
+// @Description List the people who joined the trip. The owner also sees pending invitations.
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Success 200 {array} models.TripMember "Members"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Trip not found"
+// @Router /trips/{id}/members [get]
+func ListTripMembers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleViewer)
+		if access == nil {
+			return
+		}
+
+		members := []models.TripMember{}
+		query := db.Where("trip_id = ?", access.Trip.ID)
+		if access.Role != models.TripRoleOwner {
+			query = query.Where("accepted_at IS NOT NULL")
+		}
+		if err := query.Order("created_at").Find(&members).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch members")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, members)
+	}
+}
+
+// RemoveTripMember revokes an invitation or membership, or lets a member leave
+// @Summary Remove trip member
+// @Description The owner can revoke any invitation or membership; members can remove themselves to leave the trip. Bookings the member added are taken off the trip.
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param memberId path int true "Member ID"
+// @Success 200 {object} map[string]string "Member removed"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Only the owner can remove other members"
+// @Failure 404 {object} map[string]string "Trip or member not found"
+// @Router /trips/{id}/members/{memberId} [delete]
+func RemoveTripMember(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleViewer)
+		if access == nil {
+			return
+		}
+
+		var member models.TripMember
+		memberID, ok := pathID(w, r, "memberId")
+		if !ok {
+			return
+		}
+		if err := db.Where("trip_id = ?", access.Trip.ID).First(&member, memberID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeMessage(w, http.StatusNotFound, "Member not found")
+				return
+			}
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch member")
+			fmt.Println(err)
+			return
+		}
+		leaving := member.UserID != nil && *member.UserID == access.UserID
+		if access.Role != models.TripRoleOwner && !leaving {
+			writeMessage(w, http.StatusForbidden, "Only the trip owner can remove other members")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if member.UserID != nil {
+				where := "trip_id = ? AND user_id = ?"
+				if err := tx.Model(&models.Booking{}).Where(where, access.Trip.ID, *member.UserID).Update("trip_id", nil).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&models.EventBooking{}).Where(where, access.Trip.ID, *member.UserID).Update("trip_id", nil).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&member).Error
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to remove member")
+			fmt.Println(err)
+			return
+		}
+
+		if leaving {
+			writeMessage(w, http.StatusOK, "You left the trip")
+			return
+		}
+		writeMessage(w, http.StatusOK, "Member removed")
+	}
+}
@@ -0,0 +1,469 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"roam.io/models"
+)
+
+// TripRequest holds a trip's details; dates are YYYY-MM-DD
+type TripRequest struct {
+	Name        string `json:"Name"`
+	Destination string `json:"Destination"`
+	StartDate   string `json:"StartDate"`
+	EndDate     string `json:"EndDate"`
+}
+
+// TripBookingRequest names a booking to plan under a trip
+type TripBookingRequest struct {
+	BookingID uint `json:"BookingID"`
+}
+
+// tripAccess is what the session user may do with a trip
+type tripAccess struct {
+	Trip   models.Trip
+	UserID uint
+	Role   string
+}
+
+// tripRole returns the user's role on the trip, or "" if they haven't joined it
+func tripRole(trip *models.Trip, userID uint, db *gorm.DB) (string, error) {
+	if trip.UserID == userID {
+		return models.TripRoleOwner, nil
+	}
+	var roles []string
+	err := db.Model(&models.TripMember{}).
+		Where("trip_id = ? AND user_id = ? AND accepted_at IS NOT NULL", trip.ID, userID).
+		Limit(1).Pluck("role", &roles).Error
+	if err != nil || len(roles) == 0 {
+		return "", err
+	}
+	return roles[0], nil
+}
+
+// loadTrip fetches the trip in the URL and checks the session user has at least the required role on it.
+// It writes the error response and returns nil if they don't.
+func loadTrip(w http.ResponseWriter, r *http.Request, db *gorm.DB, required string) *tripAccess {
+	session, _ := getSession(r, "session")
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok || userID == 0 {
+		writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+		return nil
+	}
+
+	var trip models.Trip
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return nil
+	}
+	if err := db.First(&trip, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeMessage(w, http.StatusNotFound, "Trip not found")
+			return nil
+		}
+		writeMessage(w, http.StatusInternalServerError, "Failed to fetch trip")
+		fmt.Println(err)
+		return nil
+	}
+	role, err := tripRole(&trip, userID, db)
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Failed to fetch trip")
+		fmt.Println(err)
+		return nil
+	}
+	// Trips the user hasn't joined are reported missing rather than forbidden so their IDs don't leak
+	if role == "" {
+		writeMessage(w, http.StatusNotFound, "Trip not found")
+		return nil
+	}
+	if !models.TripRoleAllows(role, required) {
+		writeMessage(w, http.StatusForbidden, "Your role on this trip doesn't allow this")
+		return nil
+	}
+	return &tripAccess{Trip: trip, UserID: userID, Role: role}
+}
+
+// CreateTrip starts a new trip for the user
+// @Summary Create trip
+// @Description Plan a trip to a destination between two dates. Bookings and event bookings can then be added to it.
+// @Tags trips
+// @Accept json
+// @Produce json
+// @Param trip body TripRequest true "Trip details"
+// @Success 201 {object} models.Trip "Trip created"
+// @Failure 400 {object} map[string]string "Missing destination or invalid dates"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Router /trips [post]
+func CreateTrip(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var req TripRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		req.Destination = strings.TrimSpace(req.Destination)
+		if req.Destination == "" {
+			writeMessage(w, http.StatusBadRequest, "Destination is required")
+			return
+		}
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "StartDate must be a date like 2025-06-01")
+			return
+		}
+		endDate, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "EndDate must be a date like 2025-06-01")
+			return
+		}
+		if endDate.Before(startDate) {
+			writeMessage(w, http.StatusBadRequest, "EndDate cannot be before StartDate")
+			return
+		}
+
+		trip := models.Trip{
+			UserID:      userID,
+			Name:        strings.TrimSpace(req.Name),
+			Destination: req.Destination,
+			StartDate:   startDate,
+			EndDate:     endDate,
+		}
+		if trip.Name == "" {
+			trip.Name = trip.Destination
+		}
+		if err := db.Create(&trip).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to create trip")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, trip)
+	}
+}
+
+// ListTrips lists the trips the user planned or joined
+// @Summary List trips
+// @Description List the trips you have planned or been invited to and joined, soonest first, with your role on each
+// @Tags trips
+// @Produce json
+// @Success 200 {array} models.Trip "Trips"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Router /trips [get]
+func ListTrips(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := getSession(r, "session")
+		userID, ok := session.Values["user_id"].(uint)
+		if !ok || userID == 0 {
+			writeMessage(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+
+		var memberships []models.TripMember
+		if err := db.Where("user_id = ? AND accepted_at IS NOT NULL", userID).Find(&memberships).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch trips")
+			fmt.Println(err)
+			return
+		}
+		roles := make(map[uint]string, len(memberships))
+		joined := make([]uint, len(memberships))
+		for i, membership := range memberships {
+			roles[membership.TripID] = membership.Role
+			joined[i] = membership.TripID
+		}
+
+		trips := []models.Trip{}
+		query := db.Where("user_id = ?", userID)
+		if len(joined) > 0 {
+			query = query.Or("id IN ?", joined)
+		}
+		if err := query.Order("start_date, id").Find(&trips).Error; err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch trips")
+			fmt.Println(err)
+			return
+		}
+		for i := range trips {
+			trips[i].Role = roles[trips[i].ID]
+			if trips[i].UserID == userID {
+				trips[i].Role = models.TripRoleOwner
+			}
+		}
+
+		writeJSON(w, http.StatusOK, trips)
+	}
+}
+
+// GetTripItinerary lays out a trip day by day
+// @Summary Get trip itinerary
+// @Description Get the stays and events everyone on the trip has added, merged into one list per day, with warnings about events outside your stays, overlapping events or stays, and bookings outside the trip dates
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Success 200 {object} Itinerary "Itinerary"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 404 {object} map[string]string "Trip not found or not joined"
+// @Router /trips/{id}/itinerary [get]
+func GetTripItinerary(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleViewer)
+		if access == nil {
+			return
+		}
+
+		stays, events, err := loadTripBookings(access.Trip.ID, db)
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to fetch trip bookings")
+			fmt.Println(err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, BuildItinerary(access.Trip, stays, events))
+	}
+}
+
+// loadTripBookings fetches the bookings planned under a trip along with what they booked
+func loadTripBookings(tripID uint, db *gorm.DB) ([]TripStay, []TripEvent, error) {
+	var bookings []models.Booking
+	if err := db.Where("trip_id = ?", tripID).Find(&bookings).Error; err != nil {
+		return nil, nil, err
+	}
+	var eventBookings []models.EventBooking
+	if err := db.Where("trip_id = ?", tripID).Find(&eventBookings).Error; err != nil {
+		return nil, nil, err
+	}
+
+	accommodations, err := bookedAccommodations(bookings, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	stays := make([]TripStay, len(bookings))
+	for i, booking := range bookings {
+		stays[i] = TripStay{Booking: booking, Accommodation: accommodations[booking.AccommodationID]}
+	}
+
+	bookedEvents, err := bookedEvents(eventBookings, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make([]TripEvent, len(eventBookings))
+	for i, booking := range eventBookings {
+		events[i] = TripEvent{Booking: booking, Event: bookedEvents[booking.EventId]}
+	}
+	return stays, events, nil
+}
+
+// bookedAccommodations fetches the accommodations of the bookings, by ID
+func bookedAccommodations(bookings []models.Booking, db *gorm.DB) (map[uint]models.Accommodation, error) {
+	byID := make(map[uint]models.Accommodation)
+	if len(bookings) == 0 {
+		return byID, nil
+	}
+	ids := make([]uint, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.AccommodationID
+	}
+	var accommodations []models.Accommodation
+	if err := db.Where("id IN ?", ids).Find(&accommodations).Error; err != nil {
+		return nil, err
+	}
+	for _, accommodation := range accommodations {
+		byID[accommodation.ID] = accommodation
+	}
+	return byID, nil
+}
+
+// bookedEvents fetches the events of the event bookings, by ID
+func bookedEvents(bookings []models.EventBooking, db *gorm.DB) (map[uint]models.Event, error) {
+	byID := make(map[uint]models.Event)
+	if len(bookings) == 0 {
+		return byID, nil
+	}
+	ids := make([]uint, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.EventId
+	}
+	var events []models.Event
+	if err := db.Where("id IN ?", ids).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		byID[event.ID] = event
+	}
+	return byID, nil
+}
+
+// DeleteTrip removes a trip. Its bookings are kept, just no longer grouped under it.
+// @Summary Delete trip
+// @Description Delete a trip you planned. The bookings planned under it are not cancelled, and everyone invited loses access.
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Success 200 {object} map[string]string "Trip deleted"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Only the owner can delete the trip"
+// @Failure 404 {object} map[string]string "Trip not found"
+// @Router /trips/{id} [delete]
+func DeleteTrip(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleOwner)
+		if access == nil {
+			return
+		}
+		trip := &access.Trip
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Booking{}).Where("trip_id = ?", trip.ID).Update("trip_id", nil).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.EventBooking{}).Where("trip_id = ?", trip.ID).Update("trip_id", nil).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("trip_id = ?", trip.ID).Delete(&models.TripMember{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(trip).Error
+		})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "Failed to delete trip")
+			fmt.Println(err)
+			return
+		}
+
+		writeMessage(w, http.StatusOK, "Trip deleted")
+	}
+}
+
+// planUnderTrip puts the booking matched by query on a trip, or takes it off when tripID is nil
+func planUnderTrip(w http.ResponseWriter, query *gorm.DB, tripID *uint) {
+	result := query.Update("trip_id", tripID)
+	if result.Error != nil {
+		writeMessage(w, http.StatusInternalServerError, "Failed to update booking")
+		fmt.Println(result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeMessage(w, http.StatusNotFound, "Booking not found")
+		return
+	}
+	if tripID == nil {
+		writeMessage(w, http.StatusOK, "Booking removed from trip")
+		return
+	}
+	writeMessage(w, http.StatusOK, "Booking added to trip")
+}
+
+// addToTrip handles adding one of the user's bookings, of the kind model is, to the trip in the URL
+func addToTrip(db *gorm.DB, model interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleEditor)
+		if access == nil {
+			return
+		}
+		var req TripBookingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookingID == 0 {
+			writeMessage(w, http.StatusBadRequest, "BookingID is required")
+			return
+		}
+		planUnderTrip(w, db.Model(model).Where("id = ? AND user_id = ?", req.BookingID, access.UserID), &access.Trip.ID)
+	}
+}
+
+// removeFromTrip handles taking a booking, of the kind model is, off the trip in the URL.
+// Editors can only take off their own bookings; the owner can take off anyone's.
+func removeFromTrip(db *gorm.DB, model interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := loadTrip(w, r, db, models.TripRoleEditor)
+		if access == nil {
+			return
+		}
+		bookingID, ok := pathID(w, r, "bookingId")
+		if !ok {
+			return
+		}
+		query := db.Model(model).Where("id = ? AND trip_id = ?", bookingID, access.Trip.ID)
+		if access.Role != models.TripRoleOwner {
+			query = query.Where("user_id = ?", access.UserID)
+		}
+		planUnderTrip(w, query, nil)
+	}
+}
+
+// AddTripBooking plans one of the user's stays under a trip
+// @Summary Add stay to trip
+// @Description Add one of your accommodation bookings to a trip you own or can edit, moving it from any other trip
+// @Tags trips
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param booking body TripBookingRequest true "Booking to add"
+// @Success 200 {object} map[string]string "Booking added to trip"
+// @Failure 400 {object} map[string]string "Missing booking ID"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Viewers cannot change the trip"
+// @Failure 404 {object} map[string]string "Trip or booking not found"
+// @Router /trips/{id}/bookings [post]
+func AddTripBooking(db *gorm.DB) http.HandlerFunc {
+	return addToTrip(db, &models.Booking{})
+}
+
+// RemoveTripBooking takes a stay off a trip
+// @Summary Remove stay from trip
+// @Description Take an accommodation booking off a trip. Editors can take off their own bookings and the owner anyone's. The booking itself is kept.
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param bookingId path int true "Booking ID"
+// @Success 200 {object} map[string]string "Booking removed from trip"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Viewers cannot change the trip"
+// @Failure 404 {object} map[string]string "Trip or booking not found"
+// @Router /trips/{id}/bookings/{bookingId} [delete]
+func RemoveTripBooking(db *gorm.DB) http.HandlerFunc {
+	return removeFromTrip(db, &models.Booking{})
+}
+
+// AddTripEventBooking plans one of the user's event bookings under a trip
+// @Summary Add event to trip
+// @Description Add one of your event bookings to a trip you own or can edit, moving it from any other trip
+// @Tags trips
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param booking body TripBookingRequest true "Event booking to add"
+// @Success 200 {object} map[string]string "Booking added to trip"
+// @Failure 400 {object} map[string]string "Missing booking ID"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Viewers cannot change the trip"
+// @Failure 404 {object} map[string]string "Trip or booking not found"
+// @Router /trips/{id}/event-bookings [post]
+func AddTripEventBooking(db *gorm.DB) http.HandlerFunc {
+	return addToTrip(db, &models.EventBooking{})
+}
+
+// RemoveTripEventBooking takes an event booking off a trip
+// @Summary Remove event from trip
+// @Description Take an event booking off a trip. Editors can take off their own bookings and the owner anyone's. The booking itself is kept.
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip ID"
+// @Param bookingId path int true "Event booking ID"
+// @Success 200 {object} map[string]string "Booking removed from trip"
+// @Failure 401 {object} map[string]string "User not authenticated"
+// @Failure 403 {object} map[string]string "Viewers cannot change the trip"
+// @Failure 404 {object} map[string]string "Trip or booking not found"
+// @Router /trips/{id}/event-bookings/{bookingId} [delete]
+func RemoveTripEventBooking(db *gorm.DB) http.HandlerFunc {
+	return removeFromTrip(db, &models.EventBooking{})
+}
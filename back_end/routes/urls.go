@@ -0,0 +1,20 @@
+package routes
+
+import "strings"
+
+// apiBaseURL is where browsers reach the API. Links the API hands out, such as image URLs,
+// are built from it so they work from the web app's origin too.
+var apiBaseURL = "http://localhost:8080"
+
+// SetAPIBaseURL sets the public address of the API, such as "https://api.roam.io"
+func SetAPIBaseURL(baseURL string) {
+	apiBaseURL = strings.TrimSuffix(baseURL, "/")
+}
+
+// webBaseURL is where the web app is served from, along with static images such as the preset avatars
+var webBaseURL = "http://localhost:5173"
+
+// SetWebBaseURL sets the public address of the web app, such as "https://roam.io"
+func SetWebBaseURL(baseURL string) {
+	webBaseURL = strings.TrimSuffix(baseURL, "/")
+}
@@ -10,11 +10,15 @@ import (
 )
 
 type UserProfile struct {
-	Name          string                    `json:"name"`
-	Email         string                    `json:"email"`
-	AvatarID      string                    `json:"avatar_id"`
-	Bookings      []BookingWithDetails      `json:"bookings"`
-	EventBookings []EventBookingWithDetails `json:"event_bookings"`
+	Name              string                    `json:"name"`
+	Email             string                    `json:"email"`
+	AvatarID          string                    `json:"avatar_id"`
+	AvatarURL         string                    `json:"avatar_url"` // the preset image or the uploaded avatar
+	Bookings          []BookingWithDetails      `json:"bookings"`
+	UpcomingBookings  []BookingWithDetails      `json:"upcoming_bookings"`
+	PastBookings      []BookingWithDetails      `json:"past_bookings"`
+	CancelledBookings []BookingWithDetails      `json:"cancelled_bookings"`
+	EventBookings     []EventBookingWithDetails `json:"event_bookings"`
 }
 
 type BookingWithDetails struct {
@@ -22,12 +26,14 @@ type BookingWithDetails struct {
 	CheckinDate   time.Time            `json:"checkin_date"`
 	CheckoutDate  time.Time            `json:"checkout_date"`
 	Guests        uint                 `json:"guests"`
+	Status        string               `json:"status"`
 	Accommodation AccommodationDetails `json:"accommodation"`
 }
 
 type EventBookingWithDetails struct {
 	ID     uint         `json:"id"`
 	Guests uint         `json:"guests"`
+	Status string       `json:"status"`
 	Event  EventDetails `json:"event"`
 }
 
@@ -51,13 +57,35 @@ type UserReviewDetails struct {
 	ReviewDate        string  `json:"review_date"`
 }
 
+// Groups a booking can fall into on the user profile
+const (
+	BookingGroupUpcoming  = "upcoming"
+	BookingGroupPast      = "past"
+	BookingGroupCancelled = "cancelled"
+)
+
+// ClassifyBooking places a booking in the upcoming, past or cancelled group as of now.
+// A stay that is in progress counts as upcoming until its checkout date.
+func ClassifyBooking(booking models.Booking, now time.Time) string {
+	switch booking.Status {
+	case models.BookingStatusCancelled, models.BookingStatusDeclined, models.BookingStatusExpired:
+		return BookingGroupCancelled
+	case models.BookingStatusCompleted, models.BookingStatusNoShow:
+		return BookingGroupPast
+	}
+	if booking.CheckoutDate.Before(now) {
+		return BookingGroupPast
+	}
+	return BookingGroupUpcoming
+}
+
 type UpdateAvatarRequest struct {
 	AvatarID string `json:"avatar_id" example:"Marshmallow"`
 }
 
 // GetUserProfileHandler retrieves the user profile information
 // @Summary Get user profile
-// @Description Retrieves user profile information including personal details, bookings, and event bookings
+// @Description Retrieves user profile information including personal details, bookings split into upcoming, past and cancelled, and event bookings
 // @Tags users
 // @Produce json
 // @Success 200 {object} UserProfile "User profile data including bookings"
@@ -106,13 +134,19 @@ func GetUserProfileHandler(db *gorm.DB) http.HandlerFunc {
 		}
 
 		profile := UserProfile{
-			Name:          user.Name,
-			Email:         user.Email,
-			AvatarID:      user.AvatarID,
-			Bookings:      make([]BookingWithDetails, 0, len(bookings)),
-			EventBookings: make([]EventBookingWithDetails, 0, len(eventBookings)),
+			Name:              user.Name,
+			Email:             user.Email,
+			AvatarID:          user.AvatarID,
+			AvatarURL:         AvatarURL(user),
+			Bookings:          make([]BookingWithDetails, 0, len(bookings)),
+			UpcomingBookings:  []BookingWithDetails{},
+			PastBookings:      []BookingWithDetails{},
+			CancelledBookings: []BookingWithDetails{},
+			EventBookings:     make([]EventBookingWithDetails, 0, len(eventBookings)),
 		}
 
+		now := time.Now()
+
 		for _, booking := range bookings {
 			var accommodation models.Accommodation
 			if result := db.First(&accommodation, booking.AccommodationID); result.Error != nil {
@@ -129,6 +163,7 @@ func GetUserProfileHandler(db *gorm.DB) http.HandlerFunc {
 				CheckinDate:  booking.CheckinDate,
 				CheckoutDate: booking.CheckoutDate,
 				Guests:       booking.Guests,
+				Status:       booking.Status,
 				Accommodation: AccommodationDetails{
 					ID:       accommodation.ID,
 					Name:     accommodation.Name,
@@ -138,6 +173,14 @@ func GetUserProfileHandler(db *gorm.DB) http.HandlerFunc {
 			}
 
 			profile.Bookings = append(profile.Bookings, bookingDetails)
+			switch ClassifyBooking(booking, now) {
+			case BookingGroupCancelled:
+				profile.CancelledBookings = append(profile.CancelledBookings, bookingDetails)
+			case BookingGroupPast:
+				profile.PastBookings = append(profile.PastBookings, bookingDetails)
+			default:
+				profile.UpcomingBookings = append(profile.UpcomingBookings, bookingDetails)
+			}
 		}
 
 		for _, eventBooking := range eventBookings {
@@ -154,6 +197,7 @@ func GetUserProfileHandler(db *gorm.DB) http.HandlerFunc {
 			eventBookingDetails := EventBookingWithDetails{
 				ID:     eventBooking.ID,
 				Guests: eventBooking.Guests,
+				Status: eventBooking.Status,
 				Event: EventDetails{
 					ID:        event.ID,
 					EventName: event.EventName,
@@ -234,13 +278,13 @@ func GetUserReviewsHandler(db *gorm.DB) http.HandlerFunc {
 
 // UpdateUserAvatarHandler updates the user's avatar
 // @Summary Update user avatar
-// @Description Change the user's avatar image by setting a new avatar ID
+// @Description Change the user's avatar to one of the presets. This replaces any uploaded avatar.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param avatar body UpdateAvatarRequest true "New avatar ID"
-// @Success 200 {object} map[string]string "Avatar updated successfully"
-// @Failure 400 {object} map[string]string "Invalid request format"
+// @Success 200 {object} map[string]string "Avatar updated successfully, with its avatar_url"
+// @Failure 400 {object} map[string]string "Invalid request format or unknown avatar"
 // @Failure 401 {object} map[string]string "User not authenticated"
 // @Failure 500 {object} map[string]string "Failed to update avatar"
 // @Router /users/avatar [put]
@@ -268,18 +312,36 @@ func UpdateUserAvatarHandler(db *gorm.DB) http.HandlerFunc {
 			json.NewEncoder(w).Encode(map[string]string{"message": "Invalid request format"})
 			return
 		}
+		if !models.IsAvatarPreset(updateRequest.AvatarID) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Unknown avatar"})
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, userID); result.Error != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Failed to update avatar"})
+			return
+		}
+		previousKey := user.AvatarKey
 
-		// Update the avatar_id in the database
-		if result := db.Model(&models.User{}).Where("id = ?", userID).Update("avatar_id", updateRequest.AvatarID); result.Error != nil {
+		// Update the avatar_id in the database, dropping any uploaded avatar
+		user.AvatarID = updateRequest.AvatarID
+		user.AvatarKey = ""
+		if result := db.Model(&user).Select("AvatarID", "AvatarKey").Updates(&user); result.Error != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Failed to update avatar"})
 			return
 		}
+		deleteAvatarImages(previousKey)
 
 		// Return success response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Avatar updated successfully"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "Avatar updated successfully", "avatar_url": AvatarURL(user)})
 	}
 }
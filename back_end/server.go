@@ -3,8 +3,12 @@ package main
 import (
 	"log"
 
+	"roam.io/config"
 	"roam.io/db"
 	_ "roam.io/docs" // Import generated docs
+	"roam.io/jobs"
+	"roam.io/moderation"
+	"roam.io/payments"
 	"roam.io/routes"
 )
 
@@ -24,6 +28,29 @@ import (
 // @BasePath /
 // @schemes http
 func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	gateway, err := payments.NewGateway(cfg.PaymentGateway, cfg.PaymentWebhookSecret)
+	if err != nil {
+		log.Fatal("Invalid payment gateway configuration: ", err)
+	}
+	if gateway.Name() == "fake" {
+		log.Println("Payments use the fake gateway: bookings are accepted without charging anyone")
+	}
+	routes.SetPaymentGateway(gateway)
+	routes.SetPaymentsRequired(cfg.PaymentsRequired)
+	routes.SetAPIBaseURL(cfg.APIBaseURL)
+	routes.SetWebBaseURL(cfg.WebBaseURL)
+	if cfg.ReviewWordListFile != "" {
+		words, err := moderation.LoadWordListFile(cfg.ReviewWordListFile)
+		if err != nil {
+			log.Fatal("Failed to read the review word list: ", err)
+		}
+		routes.SetReviewScreener(moderation.NewScreener(words))
+	}
+
 	// Setup router
 	gormDb, err := db.Connect()
 	if err != nil {
@@ -31,6 +58,9 @@ func main() {
 	}
 	db.MigrateDB(gormDb)
 
+	// Start background jobs before the router blocks serving requests
+	jobs.Start(gormDb)
+
 	// Pass db connection to the routes
 	routes.NewRouter(gormDb)
 }
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"roam.io/jobs"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestCanTransitionBooking(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{models.BookingStatusPending, models.BookingStatusConfirmed, true},
+		{models.BookingStatusPending, models.BookingStatusCancelled, true},
+		{models.BookingStatusPending, models.BookingStatusCompleted, false},
+		{models.BookingStatusConfirmed, models.BookingStatusCancelled, true},
+		{models.BookingStatusConfirmed, models.BookingStatusCompleted, true},
+		{models.BookingStatusConfirmed, models.BookingStatusNoShow, true},
+		{models.BookingStatusConfirmed, models.BookingStatusPending, false},
+		{models.BookingStatusCancelled, models.BookingStatusConfirmed, false},
+		{models.BookingStatusCompleted, models.BookingStatusCancelled, false},
+		{models.BookingStatusNoShow, models.BookingStatusCompleted, false},
+		// Bookings created before statuses existed behave as confirmed
+		{"", models.BookingStatusCancelled, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.from+"->"+tc.to, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, models.CanTransitionBooking(tc.from, tc.to))
+		})
+	}
+}
+
+func TestBookingTransitionStampsTime(t *testing.T) {
+	at := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	booking := models.Booking{Status: models.BookingStatusPending}
+
+	assert.NoError(t, booking.Transition(models.BookingStatusConfirmed, at))
+	assert.Equal(t, models.BookingStatusConfirmed, booking.Status)
+	assert.Equal(t, at, *booking.ConfirmedAt)
+
+	assert.NoError(t, booking.Transition(models.BookingStatusCancelled, at.Add(time.Hour)))
+	assert.Equal(t, at.Add(time.Hour), *booking.CancelledAt)
+
+	err := booking.Transition(models.BookingStatusCompleted, at)
+	assert.ErrorIs(t, err, models.ErrInvalidBookingTransition)
+	assert.Equal(t, models.BookingStatusCancelled, booking.Status)
+	assert.Nil(t, booking.CompletedAt)
+}
+
+func TestClassifyBooking(t *testing.T) {
+	now := time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		booking  models.Booking
+		expected string
+	}{
+		{
+			name:     "Future stay",
+			booking:  models.Booking{Status: models.BookingStatusConfirmed, CheckinDate: now.AddDate(0, 0, 5), CheckoutDate: now.AddDate(0, 0, 8)},
+			expected: routes.BookingGroupUpcoming,
+		},
+		{
+			name:     "Stay in progress",
+			booking:  models.Booking{Status: models.BookingStatusConfirmed, CheckinDate: now.AddDate(0, 0, -1), CheckoutDate: now.AddDate(0, 0, 2)},
+			expected: routes.BookingGroupUpcoming,
+		},
+		{
+			name:     "Checked out but not yet marked completed",
+			booking:  models.Booking{Status: models.BookingStatusConfirmed, CheckinDate: now.AddDate(0, 0, -5), CheckoutDate: now.AddDate(0, 0, -1)},
+			expected: routes.BookingGroupPast,
+		},
+		{
+			name:     "Completed stay",
+			booking:  models.Booking{Status: models.BookingStatusCompleted, CheckinDate: now.AddDate(0, 0, -5), CheckoutDate: now.AddDate(0, 0, -1)},
+			expected: routes.BookingGroupPast,
+		},
+		{
+			name:     "No-show",
+			booking:  models.Booking{Status: models.BookingStatusNoShow, CheckinDate: now.AddDate(0, 0, -5), CheckoutDate: now.AddDate(0, 0, -1)},
+			expected: routes.BookingGroupPast,
+		},
+		{
+			name:     "Cancelled future stay",
+			booking:  models.Booking{Status: models.BookingStatusCancelled, CheckinDate: now.AddDate(0, 0, 5), CheckoutDate: now.AddDate(0, 0, 8)},
+			expected: routes.BookingGroupCancelled,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, routes.ClassifyBooking(tc.booking, now))
+		})
+	}
+}
+
+func TestCompleteFinishedBookings(t *testing.T) {
+	db, mock := setupTestDB(t)
+	now := time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bookings" SET "completed_at"=$1,"status"=$2,"updated_at"=$3 WHERE status = $4 AND checkout_date <= $5`)).
+		WithArgs(now, models.BookingStatusCompleted, sqlmock.AnyArg(), models.BookingStatusConfirmed, now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "event_bookings" SET "completed_at"=$1,"status"=$2,"updated_at"=$3 WHERE status = $4 AND event_id IN (SELECT "id" FROM "events" WHERE date < $5)`)).
+		WithArgs(now, models.BookingStatusCompleted, sqlmock.AnyArg(), models.BookingStatusConfirmed, "2025-03-12").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, jobs.CompleteFinishedBookings(db, now))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -91,12 +91,6 @@ func TestFetchAccommodations(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone"}).
 			AddRow(1, "Owner Name", "owner@example.com", "123-456-7890"))
 
-	// Preload Reviews expectation
-	mock.ExpectQuery("^SELECT \\* FROM `reviews` WHERE `reviews`.`accommodation_id` = \\?").
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "user_name", "rating", "date", "comment"}).
-			AddRow(1, 1, 1, "User1", 4.5, "2023-01-01", "Great place!"))
-
 	// Call the function under test
 	handler := routes.FetchAccommodations(gormDB)
 	req, _ := http.NewRequest("GET", "/accommodations?location=New York", nil)
@@ -118,7 +112,7 @@ func TestFetchAccommodationById(t *testing.T) {
 
 	// First query to get accommodation with id=1
 	mock.ExpectQuery("^SELECT \\* FROM `accommodations` WHERE `accommodations`.`id` = \\? ORDER BY `accommodations`.`id` LIMIT \\?").
-		WithArgs("1", 1).
+		WithArgs(1, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "location", "description", "facilities", "image_urls", "owner_id", "price_per_night", "rating"}).
 			AddRow(1, "Hotel A", "New York", "A nice hotel", pq.StringArray{"WiFi", "Pool"}, pq.StringArray{"image1.jpg"}, 1, 149.99, 4.5))
 
@@ -128,12 +122,23 @@ func TestFetchAccommodationById(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone"}).
 			AddRow(1, "Owner Name", "owner@example.com", "123-456-7890"))
 
-	// Preload Reviews expectation
-	mock.ExpectQuery("^SELECT \\* FROM `reviews` WHERE `reviews`.`accommodation_id` = \\?").
-		WithArgs(1).
+	// Preload the published reviews
+	mock.ExpectQuery("^SELECT \\* FROM `reviews` WHERE `reviews`.`accommodation_id` = \\? AND moderation_status = \\?").
+		WithArgs(1, "approved").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "user_name", "rating", "date", "comment"}).
 			AddRow(1, 1, 1, "User1", 4.5, "2023-01-01", "Great place!"))
 
+	// Preload owner responses to the reviews
+	mock.ExpectQuery("^SELECT \\* FROM `review_responses` WHERE `review_responses`.`review_id` = \\?").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "comment"}).
+			AddRow(1, 1, "Thanks for staying with us!"))
+
+	// A single accommodation also comes with a star histogram
+	mock.ExpectQuery("^SELECT CAST\\(FLOOR\\(rating \\+ 0.5\\) AS integer\\) AS stars, COUNT\\(\\*\\) AS count FROM `reviews`").
+		WithArgs(1, "approved").
+		WillReturnRows(sqlmock.NewRows([]string{"stars", "count"}).AddRow(5, 2).AddRow(4, 1))
+
 	req, err := http.NewRequest("GET", "/accommodations/1", nil)
 	assert.NoError(t, err)
 
@@ -148,6 +153,14 @@ func TestFetchAccommodationById(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 
+	var accommodation models.Accommodation
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &accommodation))
+	assert.Len(t, accommodation.UserReviews, 1)
+	assert.Equal(t, "Thanks for staying with us!", accommodation.UserReviews[0].Response.Comment)
+	assert.Equal(t, int64(3), accommodation.ReviewSummary.ReviewCount)
+	assert.Equal(t, int64(2), accommodation.ReviewSummary.Histogram[5])
+	assert.Equal(t, int64(0), accommodation.ReviewSummary.Histogram[1])
+
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
@@ -164,6 +177,13 @@ func TestCreateAccommodation(t *testing.T) {
 			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // Added extra arg for coordinates
+			sqlmock.AnyArg(),                   // booking mode
+			sqlmock.AnyArg(), sqlmock.AnyArg(), // cancellation policy and tiers
+			sqlmock.AnyArg(),                                                                         // availability rules
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // guest policy
+			sqlmock.AnyArg(),                                     // pricing rules
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // price suggestion settings
+			0, 0.0, 0.0, 0.0, // review count and sub-ratings start empty
 		).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
@@ -207,6 +227,20 @@ func TestCreateAccommodation(t *testing.T) {
 	}
 }
 
+// expectNoRoomTypes expects the check for room types of an accommodation booked as a whole
+func expectNoRoomTypes(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "room_types" WHERE accommodation_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+}
+
+// expectBookingLock expects the transaction a booking is made in, which starts by locking the accommodation
+func expectBookingLock(mock sqlmock.Sqlmock, accommodationID int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "accommodations" WHERE "accommodations"."id" = \$1 ORDER BY "accommodations"."id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(accommodationID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(accommodationID))
+}
+
 func TestAddBooking(t *testing.T) {
 	// Mock database
 	db, mock, err := sqlmock.New()
@@ -231,14 +265,15 @@ func TestAddBooking(t *testing.T) {
 		queryParams    map[string]string
 		sessionUserID  uint
 		expectedStatus int
+		expectedCode   string
 		mockSetup      func()
 	}{
 		{
 			name: "Successful booking",
 			queryParams: map[string]string{
 				"accommodation_id": "1",
-				"check_in_date":    "2025-03-10",
-				"check_out_date":   "2025-03-15",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
 				"guests":           "2",
 				"total_cost":       "1000",
 			},
@@ -249,18 +284,259 @@ func TestAddBooking(t *testing.T) {
 					WithArgs(1).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
-				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode", "price_per_night"}).AddRow(1, "Hotel A", models.BookingModeInstant, 200))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings" WHERE \(accommodation_id = \$1 AND status IN \(\$2,\$3\) AND checkin_date < \$4 AND checkout_date > \$5\) AND \(expires_at IS NULL OR expires_at > \$6\)`).
+					WithArgs(1, models.BookingStatusPending, models.BookingStatusConfirmed, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges" WHERE accommodation_id = \$1 AND start_date < \$2 AND end_date > \$3`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
 				mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+					WithArgs(1, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), 2, 1000, models.BookingStatusPending, nil, 0, nil, nil, 2, 0, 0, 0,
+						sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectCommit()
+				expectPaymentCharge(mock, "bookings")
+			},
+		},
+		{
+			name: "Request to book creates a pending request",
+			queryParams: map[string]string{
+				"accommodation_id": "2",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusAccepted,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(2, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode", "price_per_night"}).AddRow(2, "Hotel B", models.BookingModeRequest, 200))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 2)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges" WHERE accommodation_id = \$1 AND start_date < \$2 AND end_date > \$3`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+				mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+					WithArgs(1, 2, sqlmock.AnyArg(), sqlmock.AnyArg(), 2, 1000, models.BookingStatusPending, sqlmock.AnyArg(), 0, nil, nil, 2, 0, 0, 0,
+						sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectCommit()
+				expectPaymentIntentInsert(mock)
+			},
+		},
+		{
+			name: "Dates already taken",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   models.StayErrorUnavailable,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode"}).AddRow(1, "Hotel A", models.BookingModeInstant))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges" WHERE accommodation_id = \$1 AND source_id = 0`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectRollback()
 			},
 		},
+		{
+			name: "Dates blocked by another platform",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   models.StayErrorUnavailable,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode"}).AddRow(1, "Hotel A", models.BookingModeInstant))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+					WithArgs(1, time.Date(2099, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2099, 3, 10, 0, 0, 0, 0, time.UTC)).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges" WHERE accommodation_id = \$1 AND source_id = 0`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "Dates closed by the owner",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   models.StayErrorBlackout,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode"}).AddRow(1, "Hotel A", models.BookingModeInstant))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges" WHERE accommodation_id = \$1 AND source_id = 0`).
+					WithArgs(1, time.Date(2099, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2099, 3, 10, 0, 0, 0, 0, time.UTC)).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "Price changed since the guest saw it",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   models.StayErrorPriceChanged,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode", "price_per_night", "pricing_rules"}).
+						AddRow(1, "Hotel A", models.BookingModeInstant, 200, `{"cleaning_fee": 50}`))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "Check-out before check-in",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-15",
+				"check_out_date":   "2099-03-10",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   models.StayErrorInvalidDates,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode"}).AddRow(1, "Hotel A", models.BookingModeInstant))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "Shorter than the minimum stay",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-12",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   models.StayErrorTooShort,
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode", "availability_rules"}).
+						AddRow(1, "Hotel A", models.BookingModeInstant, `{"min_nights": 3}`))
+				expectNoRoomTypes(mock)
+				expectBookingLock(mock, 1)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "Unreadable dates",
+			queryParams: map[string]string{
+				"accommodation_id": "1",
+				"check_in_date":    "10/03/2099",
+				"check_out_date":   "2099-03-15",
+				"guests":           "2",
+				"total_cost":       "1000",
+			},
+			sessionUserID:  1,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   models.StayErrorInvalidDates,
+			mockSetup:      func() {},
+		},
 		{
 			name: "Booking already exists",
 			queryParams: map[string]string{
 				"accommodation_id": "1",
-				"check_in_date":    "2025-03-10",
-				"check_out_date":   "2025-03-15",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
 				"guests":           "2",
 				"total_cost":       "1000",
 			},
@@ -277,8 +553,8 @@ func TestAddBooking(t *testing.T) {
 			name: "Unauthorized",
 			queryParams: map[string]string{
 				"accommodation_id": "1",
-				"check_in_date":    "2025-03-10",
-				"check_out_date":   "2025-03-15",
+				"check_in_date":    "2099-03-10",
+				"check_out_date":   "2099-03-15",
 				"guests":           "2",
 				"total_cost":       "1000",
 			},
@@ -302,6 +578,7 @@ func TestAddBooking(t *testing.T) {
 			for key, value := range tc.queryParams {
 				q.Add(key, value)
 			}
+			q.Add("payment_token", "tok_visa")
 			req.URL.RawQuery = q.Encode()
 
 			// Create a new recorder for each test case
@@ -328,6 +605,9 @@ func TestAddBooking(t *testing.T) {
 			if status := rr.Code; status != tc.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
 			}
+			if tc.expectedCode != "" {
+				assert.Contains(t, rr.Body.String(), `"code":"`+tc.expectedCode+`"`)
+			}
 
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
@@ -336,8 +616,8 @@ func TestAddBooking(t *testing.T) {
 	}
 }
 
-// TestRemoveBookingByBookingID tests the RemoveBookingByBookingID function
-func TestRemoveBookingByBookingID(t *testing.T) {
+// TestCancelBookingByID tests the CancelBookingByID function
+func TestCancelBookingByID(t *testing.T) {
 	sqlDB, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock: %v", err)
@@ -352,35 +632,59 @@ func TestRemoveBookingByBookingID(t *testing.T) {
 		t.Fatalf("Failed to open GORM DB: %v", err)
 	}
 
-	t.Run("SuccessfulRemoval", func(t *testing.T) {
+	selectBooking := "SELECT \\* FROM \"bookings\" WHERE user_id = \\$1 AND \"bookings\".\"id\" = \\$2 ORDER BY \"bookings\".\"id\" LIMIT \\$3 FOR UPDATE"
+
+	t.Run("SuccessfulCancellation", func(t *testing.T) {
 		bookingID := 1
 		mock.ExpectBegin()
-		mock.ExpectExec("DELETE FROM \"bookings\" WHERE id = \\$1").
-			WithArgs(bookingID).
+		mock.ExpectQuery(selectBooking).
+			WithArgs(1, bookingID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkin_date", "total_cost", "status"}).
+				AddRow(1, 1, 1, time.Now().AddDate(0, 0, 10), 1000, models.BookingStatusConfirmed))
+		mock.ExpectQuery("SELECT \\* FROM \"accommodations\" WHERE \"accommodations\".\"id\" = \\$1").
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "cancellation_policy"}).AddRow(1, models.CancellationPolicyStrict))
+		mock.ExpectExec("UPDATE \"bookings\" SET \"status\"=\\$1,\"refund_amount\"=\\$2,\"updated_at\"=\\$3,\"cancelled_at\"=\\$4 WHERE \"id\" = \\$5").
+			WithArgs(models.BookingStatusCancelled, 500, sqlmock.AnyArg(), sqlmock.AnyArg(), bookingID).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 		mock.ExpectCommit()
+		expectPaymentIntentLookup(mock, "booking_id", bookingID, nil)
 
-		err := routes.RemoveBookingByBookingID(bookingID, db)
+		booking, err := routes.CancelBookingByID(bookingID, 1, db)
 		if err != nil {
 			t.Errorf("Expected no error, got %v", err)
 		}
+		assert.Equal(t, models.BookingStatusCancelled, booking.Status)
+		assert.NotNil(t, booking.CancelledAt)
+		// Strict policy refunds half between 7 and 14 days out
+		assert.Equal(t, uint(500), booking.RefundAmount)
 	})
 
 	t.Run("BookingNotFound", func(t *testing.T) {
 		bookingID := 2
 		mock.ExpectBegin()
-		mock.ExpectExec("DELETE FROM \"bookings\" WHERE id = \\$1").
-			WithArgs(bookingID).
-			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(selectBooking).
+			WithArgs(1, bookingID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		mock.ExpectRollback()
 
-		err := routes.RemoveBookingByBookingID(bookingID, db)
-		if err == nil {
-			t.Error("Expected an error, got nil")
-		}
-		if err.Error() != "error removing booking" {
-			t.Errorf("Expected 'error removing booking', got '%v'", err)
-		}
+		_, err := routes.CancelBookingByID(bookingID, 1, db)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("AlreadyCancelled", func(t *testing.T) {
+		bookingID := 3
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectBooking).
+			WithArgs(1, bookingID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "status"}).AddRow(3, 1, 1, models.BookingStatusCancelled))
+		mock.ExpectQuery("SELECT \\* FROM \"accommodations\" WHERE \"accommodations\".\"id\" = \\$1").
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectRollback()
+
+		_, err := routes.CancelBookingByID(bookingID, 1, db)
+		assert.ErrorIs(t, err, models.ErrInvalidBookingTransition)
 	})
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
@@ -66,7 +67,10 @@ func TestCreateEvent(t *testing.T) {
 					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
-						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+						sqlmock.AnyArg()).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectCommit()
 			},
@@ -85,6 +89,9 @@ func TestCreateEvent(t *testing.T) {
 				TotalSeats:     100,
 				OfficialLink:   "https://test-event.com",
 				OrganizerID:    1,
+				Status:         models.EventStatusActive,
+
+				CancellationPolicy: models.CancellationPolicyFlexible,
 			},
 		},
 	}
@@ -119,6 +126,7 @@ func TestCreateEvent(t *testing.T) {
 
 				// ID might be auto-generated, so we can set it from the response
 				tc.expectedEvent.ID = responseEvent.ID
+				tc.expectedEvent.UpdatedAt = responseEvent.UpdatedAt
 				assert.Equal(t, tc.expectedEvent, responseEvent)
 			}
 
@@ -207,7 +215,7 @@ func TestFetchEventById(t *testing.T) {
 				)
 
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("1", 1).
+					WithArgs(1, 1).
 					WillReturnRows(eventRows)
 
 				organizerRows := sqlmock.NewRows([]string{"id", "name", "email", "phone"}).
@@ -216,6 +224,10 @@ func TestFetchEventById(t *testing.T) {
 				mock.ExpectQuery(`SELECT \* FROM "organizers" WHERE id = \$1 ORDER BY "organizers"."id" LIMIT \$2`).
 					WithArgs(1, 1).
 					WillReturnRows(organizerRows)
+
+				mock.ExpectQuery(`SELECT \* FROM "ticket_tiers" WHERE event_id IN \(\$1\) ORDER BY price, id`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
 			},
 			expectedStatus: http.StatusOK,
 			expectedEvent: &models.EventResponse{
@@ -231,6 +243,8 @@ func TestFetchEventById(t *testing.T) {
 				Coordinates:    "21.004, 32.003", // Ensure this matches the AddRow value
 				TotalSeats:     100,
 				OfficialLink:   "https://test-event.com",
+				// No policy was set, so the flexible default is reported
+				CancellationPolicy: models.CancellationPolicyFlexible,
 				Organizer: models.Organizer{
 					ID:    1,
 					Name:  "Test Organizer",
@@ -244,17 +258,24 @@ func TestFetchEventById(t *testing.T) {
 			eventID: "999",
 			mockSetup: func() {
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("999", 1).
+					WithArgs(999, 1).
 					WillReturnError(gorm.ErrRecordNotFound)
 			},
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			// The id never reaches the database
+			name:           "Id that isn't a number",
+			eventID:        "one",
+			mockSetup:      func() {},
+			expectedStatus: http.StatusNotFound,
+		},
 		{
 			name:    "Database error when fetching event",
 			eventID: "1",
 			mockSetup: func() {
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("1", 1).
+					WithArgs(1, 1).
 					WillReturnError(gorm.ErrInvalidData)
 			},
 			expectedStatus: http.StatusInternalServerError,
@@ -338,7 +359,7 @@ func TestCreateEventBooking(t *testing.T) {
 				// Expect the INSERT query
 				mock.ExpectBegin()
 				mock.ExpectQuery(`INSERT INTO "event_bookings"`).
-					WithArgs(1, 2, 3, 300).
+					WithArgs(1, 2, 3, 300, models.BookingStatusPending, 0, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectCommit()
 			},
@@ -355,7 +376,7 @@ func TestCreateEventBooking(t *testing.T) {
 				// Simulate a database error
 				mock.ExpectBegin()
 				mock.ExpectQuery(`INSERT INTO "event_bookings"`).
-					WithArgs(1, 2, 3, 300).
+					WithArgs(1, 2, 3, 300, models.BookingStatusPending, 0, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
 					WillReturnError(errors.New("database error"))
 				mock.ExpectRollback()
 			},
@@ -370,14 +391,15 @@ func TestCreateEventBooking(t *testing.T) {
 			tc.mockSetup()
 
 			// Call the function
-			id, err := routes.CreateEventBooking(tc.userID, tc.eventID, tc.guests, tc.totalCost, db)
+			booking, err := routes.CreateEventBooking(tc.userID, tc.eventID, nil, tc.guests, tc.totalCost, db)
 
 			// Check results
 			if tc.expectErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tc.expectedID, id)
+				assert.Equal(t, tc.expectedID, int(booking.ID))
+				assert.Equal(t, models.BookingStatusPending, booking.Status)
 			}
 
 			// Ensure all expectations were met
@@ -485,7 +507,7 @@ func TestAddEventBookingHandler(t *testing.T) {
 				return
 			}
 
-			bookingID, err := routes.CreateEventBooking(userID, uintValue, guestsUintValue, totalcostUintValue, db)
+			booking, err := routes.CreateEventBooking(userID, uintValue, nil, guestsUintValue, totalcostUintValue, db)
 			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
@@ -493,7 +515,7 @@ func TestAddEventBookingHandler(t *testing.T) {
 				return
 			}
 
-			err = routes.UpdateEventSeats(event_id, int(event.AvailableSeats)-int(guestsUintValue), db)
+			err = routes.UpdateEventSeats(event.ID, guestsUintValue, db)
 			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
@@ -503,7 +525,7 @@ func TestAddEventBookingHandler(t *testing.T) {
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(map[string]int{"id": bookingID})
+			json.NewEncoder(w).Encode(map[string]int{"id": int(booking.ID)})
 		}
 	}
 
@@ -534,20 +556,20 @@ func TestAddEventBookingHandler(t *testing.T) {
 					AddRow(1, "Test Event", "Test Location", "2025-04-15", "18:00", "Test Description", "100", 10, 100, "https://test-event.com", 1, pq.StringArray{"image1.jpg"})
 
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("1", 1).
+					WithArgs(1, 1).
 					WillReturnRows(eventRows)
 
 				// Mock CreateEventBooking
 				mock.ExpectBegin()
 				mock.ExpectQuery(`INSERT INTO "event_bookings"`).
-					WithArgs(1, 1, 2, 200).
+					WithArgs(1, 1, 2, 200, models.BookingStatusPending, 0, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectCommit()
 
 				// Mock UpdateEventSeats
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "events" SET "available_seats"=\$1 WHERE id = \$2`).
-					WithArgs(8, "1").
+				mock.ExpectExec(`UPDATE "events" SET "available_seats"=available_seats - \$1,"updated_at"=\$2 WHERE id = \$3 AND available_seats >= \$4`).
+					WithArgs(2, sqlmock.AnyArg(), 1, 2).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
@@ -608,7 +630,7 @@ func TestAddEventBookingHandler(t *testing.T) {
 					AddRow(1, "Test Event", "Test Location", "2025-04-15", "18:00", "Test Description", "100", 10, 100, "https://test-event.com", 1, pq.StringArray{"image1.jpg"})
 
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("1", 1).
+					WithArgs(1, 1).
 					WillReturnRows(eventRows)
 			},
 			expectedStatus: http.StatusInternalServerError,
@@ -632,7 +654,7 @@ func TestAddEventBookingHandler(t *testing.T) {
 
 				// Mock GetEventByID with error
 				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("999", 1).
+					WithArgs(999, 1).
 					WillReturnError(gorm.ErrRecordNotFound)
 			},
 			expectedStatus: http.StatusInternalServerError,
@@ -681,7 +703,31 @@ func TestAddEventBookingHandler(t *testing.T) {
 	}
 }
 
-func TestRemoveEventBookingByID(t *testing.T) {
+func TestUpdateEventSeats(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		affected int64
+		err      error
+	}{
+		{"Seats taken", 1, nil},
+		{"Sold out since the event was read", 0, models.ErrEventSoldOut},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "events" SET "available_seats"=available_seats - \$1,"updated_at"=\$2 WHERE id = \$3 AND available_seats >= \$4`).
+				WithArgs(2, sqlmock.AnyArg(), 1, 2).
+				WillReturnResult(sqlmock.NewResult(0, tc.affected))
+			mock.ExpectCommit()
+
+			err := routes.UpdateEventSeats(1, 2, db)
+			assert.Equal(t, tc.err, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCancelEventBookingByID(t *testing.T) {
 	// Setup
 	sqlDB, mock, err := sqlmock.New()
 	if err != nil {
@@ -701,49 +747,61 @@ func TestRemoveEventBookingByID(t *testing.T) {
 
 	// Test cases
 	tests := []struct {
-		name      string
-		bookingID int
-		mockSetup func()
-		expectErr bool
+		name        string
+		bookingID   int
+		mockSetup   func()
+		expectedErr error
 	}{
 		{
-			name:      "Successful booking removal",
+			name:      "Successful booking cancellation",
 			bookingID: 1,
 			mockSetup: func() {
-				// Expect transaction
 				mock.ExpectBegin()
-				mock.ExpectExec(`DELETE FROM "event_bookings" WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEventBookingLookup(mock, 1, models.BookingStatusConfirmed)
+				mock.ExpectExec(`UPDATE "event_bookings" SET "status"=\$1,"refund_amount"=\$2,"updated_at"=\$3,"cancelled_at"=\$4 WHERE "id" = \$5`).
+					WithArgs(models.BookingStatusCancelled, 300, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE "events" SET "available_seats"=available_seats \+ \$1,"updated_at"=\$2 WHERE id = \$3`).
+					WithArgs(3, sqlmock.AnyArg(), 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectCommit()
+				expectPaymentIntentLookup(mock, "event_booking_id", 1, nil)
 			},
-			expectErr: false,
 		},
 		{
 			name:      "Booking not found",
 			bookingID: 999,
 			mockSetup: func() {
-				// Expect transaction with no rows affected
 				mock.ExpectBegin()
-				mock.ExpectExec(`DELETE FROM "event_bookings" WHERE id = \$1`).
-					WithArgs(999).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1 AND "event_bookings"."id" = \$2`).
+					WithArgs(1, 999, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
 				mock.ExpectRollback()
 			},
-			expectErr: true,
+			expectedErr: gorm.ErrRecordNotFound,
+		},
+		{
+			name:      "Booking already cancelled",
+			bookingID: 1,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				expectEventBookingLookup(mock, 1, models.BookingStatusCancelled)
+				mock.ExpectRollback()
+			},
+			expectedErr: models.ErrInvalidBookingTransition,
 		},
 		{
 			name:      "Database error",
 			bookingID: 1,
 			mockSetup: func() {
-				// Expect transaction with error
 				mock.ExpectBegin()
-				mock.ExpectExec(`DELETE FROM "event_bookings" WHERE id = \$1`).
-					WithArgs(1).
+				expectEventBookingLookup(mock, 1, models.BookingStatusConfirmed)
+				mock.ExpectExec(`UPDATE "event_bookings" SET "status"=\$1,"refund_amount"=\$2,"updated_at"=\$3,"cancelled_at"=\$4 WHERE "id" = \$5`).
+					WithArgs(models.BookingStatusCancelled, 300, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
 					WillReturnError(errors.New("database error"))
 				mock.ExpectRollback()
 			},
-			expectErr: true,
+			expectedErr: errors.New("database error"),
 		},
 	}
 
@@ -753,13 +811,15 @@ func TestRemoveEventBookingByID(t *testing.T) {
 			tc.mockSetup()
 
 			// Call the function
-			err := routes.RemoveEventBookingByID(tc.bookingID, db)
+			booking, err := routes.CancelEventBookingByID(tc.bookingID, 1, db)
 
 			// Check results
-			if tc.expectErr {
+			if tc.expectedErr != nil {
 				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr.Error())
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, models.BookingStatusCancelled, booking.Status)
 			}
 
 			// Ensure all expectations were met
@@ -769,6 +829,22 @@ func TestRemoveEventBookingByID(t *testing.T) {
 		})
 	}
 }
+
+// expectEventBookingLookup mocks loading an event booking for 3 guests on event 2, which is months away
+func expectEventBookingLookup(mock sqlmock.Sqlmock, bookingID int, status string) {
+	bookingRows := sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "total_cost", "status"}).
+		AddRow(bookingID, 1, 2, 3, 300, status)
+	mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1 AND "event_bookings"."id" = \$2 ORDER BY "event_bookings"."id" LIMIT \$3 FOR UPDATE`).
+		WithArgs(1, bookingID, 1).
+		WillReturnRows(bookingRows)
+
+	eventRows := sqlmock.NewRows([]string{"id", "event_name", "date", "time", "available_seats", "total_seats", "cancellation_policy"}).
+		AddRow(2, "Test Event", time.Now().AddDate(0, 3, 0).Format("2006-01-02"), "18:00", 7, 10, models.CancellationPolicyStrict)
+	mock.ExpectQuery(`SELECT \* FROM "events" WHERE "events"."id" = \$1`).
+		WithArgs(2, 1).
+		WillReturnRows(eventRows)
+}
+
 func TestRemoveEventBooking(t *testing.T) {
 	// Setup
 	sqlDB, mock, err := sqlmock.New()
@@ -796,38 +872,19 @@ func TestRemoveEventBooking(t *testing.T) {
 		expectedBody   map[string]interface{}
 	}{
 		{
-			name:      "Successful booking removal",
+			name:      "Successful booking cancellation",
 			bookingID: "1",
 			mockSetup: func() {
-				// Mock GetEventBookingByID
-				bookingRows := sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "total_cost"}).
-					AddRow(1, 1, 2, 3, 300)
-
-				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE id = \$1`).
-					WithArgs("1").
-					WillReturnRows(bookingRows)
-
-				// Mock GetEventByID
-				eventRows := sqlmock.NewRows([]string{"id", "event_name", "location", "images", "description", "date", "time", "price", "available_seats", "total_seats", "official_link", "organizer_id"}).
-					AddRow(2, "Test Event", "Test Location", pq.StringArray{"image1.jpg"}, "Test Description", "2025-04-15", "18:00", "100", 7, 10, "https://test-event.com", 1)
-
-				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("2", 1).
-					WillReturnRows(eventRows)
-
-				// Mock UpdateEventSeats
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "events" SET "available_seats"=\$1 WHERE id = \$2`).
-					WithArgs(10, "2").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				// Mock RemoveEventBookingByID
-				mock.ExpectBegin()
-				mock.ExpectExec(`DELETE FROM "event_bookings" WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEventBookingLookup(mock, 1, models.BookingStatusConfirmed)
+				mock.ExpectExec(`UPDATE "event_bookings" SET "status"=\$1,"refund_amount"=\$2,"updated_at"=\$3,"cancelled_at"=\$4 WHERE "id" = \$5`).
+					WithArgs(models.BookingStatusCancelled, 300, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE "events" SET "available_seats"=available_seats \+ \$1,"updated_at"=\$2 WHERE id = \$3`).
+					WithArgs(3, sqlmock.AnyArg(), 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectCommit()
+				expectPaymentIntentLookup(mock, "event_booking_id", 1, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody:   nil, // The function returns a string, not a map
@@ -836,18 +893,11 @@ func TestRemoveEventBooking(t *testing.T) {
 			name:      "Booking not found",
 			bookingID: "999",
 			mockSetup: func() {
-				// Mock GetEventBookingByID
-				bookingRows := sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "total_cost"}).
-					AddRow(999, 1, 2, 3, 300)
-
-				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE id = \$1`).
-					WithArgs("999").
-					WillReturnRows(bookingRows)
-
-				// Mock GetEventByID with error
-				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("2", 1).
-					WillReturnError(gorm.ErrRecordNotFound)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1 AND "event_bookings"."id" = \$2`).
+					WithArgs(1, 999, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
 			},
 			expectedStatus: http.StatusNotFound,
 			expectedBody: map[string]interface{}{
@@ -855,73 +905,36 @@ func TestRemoveEventBooking(t *testing.T) {
 			},
 		},
 		{
-			name:      "Error updating seats",
+			name:      "Booking already cancelled",
 			bookingID: "1",
 			mockSetup: func() {
-				// Mock GetEventBookingByID
-				bookingRows := sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "total_cost"}).
-					AddRow(1, 1, 2, 3, 300)
-
-				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE id = \$1`).
-					WithArgs("1").
-					WillReturnRows(bookingRows)
-
-				// Mock GetEventByID
-				eventRows := sqlmock.NewRows([]string{"id", "event_name", "location", "date", "time", "description", "price", "available_seats", "total_seats", "official_link", "organizer_id", "images"}).
-					AddRow(2, "Test Event", "Test Location", "2025-04-15", "18:00", "Test Description", "100", 7, 10, "https://test-event.com", 1, pq.StringArray{"image1.jpg"})
-
-				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("2", 1).
-					WillReturnRows(eventRows)
-
-				// Mock UpdateEventSeats with error
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "events" SET "available_seats"=\$1 WHERE id = \$2`).
-					WithArgs(10, "2").
-					WillReturnError(errors.New("database error"))
+				expectEventBookingLookup(mock, 1, models.BookingStatusCancelled)
 				mock.ExpectRollback()
 			},
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusConflict,
 			expectedBody: map[string]interface{}{
-				"message": "Error updating avaliable seats",
+				"message": "Event booking can no longer be cancelled",
 			},
 		},
 		{
-			name:      "Error removing booking",
+			name:      "Error updating seats",
 			bookingID: "1",
 			mockSetup: func() {
-				// Mock GetEventBookingByID
-				bookingRows := sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "total_cost"}).
-					AddRow(1, 1, 2, 3, 300)
-
-				mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE id = \$1`).
-					WithArgs("1").
-					WillReturnRows(bookingRows)
-
-				// Mock GetEventByID
-				eventRows := sqlmock.NewRows([]string{"id", "event_name", "location", "date", "time", "description", "price", "available_seats", "total_seats", "official_link", "organizer_id", "images"}).
-					AddRow(2, "Test Event", "Test Location", "2025-04-15", "18:00", "Test Description", "100", 7, 10, "https://test-event.com", 1, pq.StringArray{"image1.jpg"})
-
-				mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1 ORDER BY "events"."id" LIMIT \$2`).
-					WithArgs("2", 1).
-					WillReturnRows(eventRows)
-
-				// Mock UpdateEventSeats
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE "events" SET "available_seats"=\$1 WHERE id = \$2`).
-					WithArgs(10, "2").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-
-				// Mock RemoveEventBookingByID with error
-				mock.ExpectBegin()
-				mock.ExpectExec(`DELETE FROM "event_bookings" WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+				expectEventBookingLookup(mock, 1, models.BookingStatusConfirmed)
+				mock.ExpectExec(`UPDATE "event_bookings" SET "status"=\$1,"refund_amount"=\$2,"updated_at"=\$3,"cancelled_at"=\$4 WHERE "id" = \$5`).
+					WithArgs(models.BookingStatusCancelled, 300, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE "events" SET "available_seats"=available_seats \+ \$1,"updated_at"=\$2 WHERE id = \$3`).
+					WithArgs(3, sqlmock.AnyArg(), 2).
+					WillReturnError(errors.New("database error"))
 				mock.ExpectRollback()
 			},
-			expectedStatus: http.StatusNotFound,
-			expectedBody:   nil, // The error is returned directly via http.Error
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"message": "Error cancelling event booking",
+			},
 		},
 	}
 
@@ -933,6 +946,7 @@ func TestRemoveEventBooking(t *testing.T) {
 			// Create request with query parameters
 			req, err := http.NewRequest("DELETE", "/bookings?event_booking_id="+tc.bookingID, nil)
 			assert.NoError(t, err)
+			req = addSessionToRequest(req, 1)
 
 			// Create response recorder
 			rr := httptest.NewRecorder()
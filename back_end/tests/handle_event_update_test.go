@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestAvailableSeatsForCapacity(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalSeats  uint
+		bookedSeats uint
+		expected    uint
+		expectErr   bool
+	}{
+		{name: "Grow capacity", totalSeats: 150, bookedSeats: 40, expected: 110},
+		{name: "Shrink capacity above bookings", totalSeats: 50, bookedSeats: 40, expected: 10},
+		{name: "Shrink capacity to bookings", totalSeats: 40, bookedSeats: 40, expected: 0},
+		{name: "Shrink capacity below bookings", totalSeats: 30, bookedSeats: 40, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			available, err := routes.AvailableSeatsForCapacity(tc.totalSeats, tc.bookedSeats)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, routes.ErrCapacityBelowBookings)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, available)
+		})
+	}
+}
+
+// expectEventOrganizer mocks loading event 2 and matching the session user to its organizer by email
+func expectEventOrganizer(mock sqlmock.Sqlmock, status string, userEmail string) {
+	eventRows := sqlmock.NewRows([]string{"id", "event_name", "location", "date", "time", "price", "available_seats", "total_seats", "organizer_id", "status"}).
+		AddRow(2, "Test Event", "Test Location", "2025-04-15", "18:00", "100", 7, 10, 1, status)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "events" WHERE id = $1 ORDER BY "events"."id" LIMIT $2`)).
+		WithArgs(2, 1).
+		WillReturnRows(eventRows)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1 ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs(42, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(42, userEmail))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizers" WHERE id = $1 ORDER BY "organizers"."id" LIMIT $2`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Organizer", "organizer@example.com"))
+}
+
+// expectEventLock mocks taking the row lock on event 2
+func expectEventLock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "events" WHERE "events"."id" = $1 ORDER BY "events"."id" LIMIT $2 FOR UPDATE`)).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+}
+
+func TestUpdateEvent(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         uint
+		payload        string
+		mockSetup      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedSeats  [2]uint // total and available seats of an updated event
+	}{
+		{
+			name:           "User not authenticated",
+			userID:         0,
+			payload:        `{"TotalSeats": 20}`,
+			mockSetup:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "User is not the organizer",
+			userID:  42,
+			payload: `{"TotalSeats": 20}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "someone@example.com")
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:    "Event already cancelled",
+			userID:  42,
+			payload: `{"TotalSeats": 20}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusCancelled, "organizer@example.com")
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:    "Date in the wrong layout",
+			userID:  42,
+			payload: `{"Date": "15/04/2025"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:    "Time that isn't a time",
+			userID:  42,
+			payload: `{"Time": "6pm"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:    "Capacity below booked seats",
+			userID:  42,
+			payload: `{"TotalSeats": 2}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+				mock.ExpectBegin()
+				expectEventLock(mock)
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(SUM(guests), 0) FROM "event_bookings" WHERE event_id = $1 AND status IN ($2,$3)`)).
+					WithArgs(2, models.BookingStatusPending, models.BookingStatusConfirmed).
+					WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(3))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:    "Capacity increased",
+			userID:  42,
+			payload: `{"TotalSeats": 20}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+				mock.ExpectBegin()
+				expectEventLock(mock)
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(SUM(guests), 0) FROM "event_bookings" WHERE event_id = $1 AND status IN ($2,$3)`)).
+					WithArgs(2, models.BookingStatusPending, models.BookingStatusConfirmed).
+					WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(3))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "available_seats"=$1,"total_seats"=$2,"updated_at"=$3 WHERE "id" = $4`)).
+					WithArgs(17, 20, sqlmock.AnyArg(), 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+			expectedSeats:  [2]uint{20, 17},
+		},
+		{
+			name:    "Details changed without touching seats",
+			userID:  42,
+			payload: `{"Description": "Bring a jacket"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "description"=$1,"updated_at"=$2 WHERE "id" = $3`)).
+					WithArgs("Bring a jacket", sqlmock.AnyArg(), 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+			expectedSeats:  [2]uint{10, 7},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			tc.mockSetup(mock)
+
+			req := httptest.NewRequest("PATCH", "/events/2", bytes.NewBufferString(tc.payload))
+			req = mux.SetURLVars(req, map[string]string{"id": "2"})
+			req = addSessionToRequest(req, tc.userID)
+
+			rr := httptest.NewRecorder()
+			routes.UpdateEvent(db).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var event models.Event
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &event))
+				assert.Equal(t, tc.expectedSeats, [2]uint{event.TotalSeats, event.AvailableSeats})
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// expectEventCancellationClaim mocks marking event 2 cancelled, unless someone else cancelled it first
+func expectEventCancellationClaim(mock sqlmock.Sqlmock, rowsAffected int64) {
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "available_seats"=total_seats,"cancelled_at"=$1,"status"=$2,"updated_at"=$3 WHERE id = $4 AND (status IS NULL OR status <> $5)`)).
+		WithArgs(sqlmock.AnyArg(), models.EventStatusCancelled, sqlmock.AnyArg(), 2, models.EventStatusCancelled).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+}
+
+func TestCancelEvent(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+	mock.ExpectBegin()
+	expectEventCancellationClaim(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT DISTINCT "user_id" FROM "event_bookings" WHERE event_id = $1 AND status IN ($2,$3)`)).
+		WithArgs(2, models.BookingStatusPending, models.BookingStatusConfirmed).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5).AddRow(6))
+	for _, userID := range []uint{5, 6} {
+		mock.ExpectQuery(`INSERT INTO "notifications" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(userID, "event_cancelled", "Event cancelled: Test Event", sqlmock.AnyArg(), models.NotificationStatusQueued, sqlmock.AnyArg(), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "event_bookings" SET "cancelled_at"=$1,"refund_amount"=CASE WHEN status = $2 THEN total_cost ELSE 0 END,"status"=$3,"updated_at"=$4 WHERE event_id = $5 AND status IN ($6,$7)`)).
+		WithArgs(sqlmock.AnyArg(), models.BookingStatusConfirmed, models.BookingStatusCancelled, sqlmock.AnyArg(), 2, models.BookingStatusPending, models.BookingStatusConfirmed).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ticket_tiers" SET "sold"=$1 WHERE event_id = $2`)).
+		WithArgs(0, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	// Bookings made before payments existed have nothing to refund
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "payment_intents" WHERE event_booking_id IN (SELECT "id" FROM "event_bookings" WHERE event_id = $1) AND status IN ($2,$3,$4)`)).
+		WithArgs(2, models.PaymentStatusRequiresCapture, models.PaymentStatusSucceeded, models.PaymentStatusPartiallyRefunded).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	req := httptest.NewRequest("POST", "/events/2/cancel", bytes.NewBufferString(`{"reason": "Venue unavailable"}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	req = addSessionToRequest(req, 42)
+
+	rr := httptest.NewRecorder()
+	routes.CancelEvent(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, float64(3), resp["cancelled_bookings"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelEventCancelledMeanwhile(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+	mock.ExpectBegin()
+	expectEventCancellationClaim(mock, 0)
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest("POST", "/events/2/cancel", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	req = addSessionToRequest(req, 42)
+	rr := httptest.NewRecorder()
+	routes.CancelEvent(db).ServeHTTP(rr, req)
+
+	// Nobody is notified or refunded twice
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// expectAccommodationOwner mocks loading booking 7 on accommodation 3 and matching the session user to its owner by email
+func expectAccommodationOwner(mock sqlmock.Sqlmock, status string, expiresAt time.Time, userEmail string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bookings" WHERE "bookings"."id" = $1 ORDER BY "bookings"."id" LIMIT $2`)).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "guests", "total_cost", "status", "expires_at"}).
+			AddRow(7, 5, 3, 2, 500, status, expiresAt))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1 ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs(42, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(42, userEmail))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1 ORDER BY "accommodations"."id" LIMIT $2`)).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(3, "Cabin", 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "hosts" WHERE "hosts"."id" = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Owner", "owner@example.com"))
+}
+
+func TestRespondToBookingRequest(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		handler        func(db *gorm.DB) http.HandlerFunc
+		mockSetup      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedState  string
+	}{
+		{
+			name:    "Owner approves request",
+			handler: routes.ApproveBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, future, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "bookings" SET (.+) WHERE status = \$6 AND "id" = \$7`).
+					WithArgs(models.BookingStatusConfirmed, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, models.BookingStatusPending, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`INSERT INTO "notifications" (.+) RETURNING "id"`).
+					WithArgs(5, "booking_confirmed", "Booking confirmed", sqlmock.AnyArg(), models.NotificationStatusQueued, sqlmock.AnyArg(), nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				expectPaymentIntentLookup(mock, "booking_id", 7, nil)
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+			expectedState:  models.BookingStatusConfirmed,
+		},
+		{
+			name:    "Owner declines request",
+			handler: routes.DeclineBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, future, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "bookings" SET (.+) WHERE status = \$6 AND "id" = \$7`).
+					WithArgs(models.BookingStatusDeclined, nil, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), models.BookingStatusPending, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`INSERT INTO "notifications" (.+) RETURNING "id"`).
+					WithArgs(5, "booking_declined", "Booking declined", sqlmock.AnyArg(), models.NotificationStatusQueued, sqlmock.AnyArg(), nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectCommit()
+				expectPaymentIntentLookup(mock, "booking_id", 7, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedState:  models.BookingStatusDeclined,
+		},
+		{
+			name:    "Guest cancels before the owner answers",
+			handler: routes.DeclineBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, future, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "bookings" SET (.+) WHERE status = \$6 AND "id" = \$7`).
+					WithArgs(models.BookingStatusDeclined, nil, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), models.BookingStatusPending, 7).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:    "Someone else tries to approve",
+			handler: routes.ApproveBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, future, "guest@example.com")
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:    "Request already answered",
+			handler: routes.ApproveBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusConfirmed, future, "owner@example.com")
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:    "Request hold has run out",
+			handler: routes.ApproveBookingRequest,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, past, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"refund_amount"=\$2,(.+) WHERE status = \$5 AND "id" = \$6`).
+					WithArgs(models.BookingStatusExpired, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), models.BookingStatusPending, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`INSERT INTO "notifications" (.+) RETURNING "id"`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectCommit()
+				expectPaymentIntentLookup(mock, "booking_id", 7, nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			tc.mockSetup(mock)
+
+			req := httptest.NewRequest("POST", "/owner/bookings/7/approve", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			req = addSessionToRequest(req, 42)
+
+			rr := httptest.NewRecorder()
+			tc.handler(db).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedState != "" {
+				var resp map[string]interface{}
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+				assert.Equal(t, tc.expectedState, resp["status"])
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMarkBookingNoShow(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockSetup      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Owner marks a confirmed stay",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusConfirmed, time.Time{}, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bookings" SET "status"=$1,"updated_at"=$2,"no_show_at"=$3 WHERE status = $4 AND "id" = $5`)).
+					WithArgs(models.BookingStatusNoShow, sqlmock.AnyArg(), sqlmock.AnyArg(), models.BookingStatusConfirmed, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Cancelled meanwhile",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusConfirmed, time.Time{}, "owner@example.com")
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "bookings" SET (.+) WHERE status = \$4 AND "id" = \$5`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Pending request",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusPending, time.Now().Add(time.Hour), "owner@example.com")
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Someone else's booking",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccommodationOwner(mock, models.BookingStatusConfirmed, time.Time{}, "guest@example.com")
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			tc.mockSetup(mock)
+
+			req := httptest.NewRequest("POST", "/owner/bookings/7/no-show", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			req = addSessionToRequest(req, 42)
+			rr := httptest.NewRecorder()
+			routes.MarkBookingNoShow(db).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRespondToBookingRequestInvalidID(t *testing.T) {
+	for _, id := range []string{"7 OR 1=1", "seven", "0", "-7"} {
+		t.Run(id, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+
+			req := httptest.NewRequest("POST", "/owner/bookings/x/approve", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": id})
+			req = addSessionToRequest(req, 42)
+			rr := httptest.NewRecorder()
+			routes.ApproveBookingRequest(db).ServeHTTP(rr, req)
+
+			// The id never reaches the database
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
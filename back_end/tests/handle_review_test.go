@@ -46,10 +46,16 @@ func TestAddReview(t *testing.T) {
 					WithArgs(1, 1).
 					WillReturnRows(userRows)
 
-				// Mock the insert query for the review
+				expectReviewableStay(mock, 1, 1, 3)
+
+				expectRecentReviews(mock, 1)
+
+				// Mock the insert query for the review and the rating update
 				mock.ExpectBegin()
+				expectAccommodationLock(mock, 1)
 				mock.ExpectQuery(`INSERT INTO "reviews" (.+) VALUES (.+) RETURNING "id"`).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				expectRatingRecalculation(mock, 1, routes.RatingAggregate{ReviewCount: 1, Rating: 4.5})
 				mock.ExpectCommit()
 			},
 			expectedStatus: http.StatusCreated,
@@ -60,6 +66,7 @@ func TestAddReview(t *testing.T) {
 				UserName:        "johndoe",
 				Rating:          4.5,
 				Comment:         "This place was wonderful! Very clean and great location.",
+				VerifiedStay:    true,
 			},
 		},
 		{
@@ -170,8 +177,12 @@ func TestAddReview(t *testing.T) {
 					WithArgs(1, 1).
 					WillReturnRows(userRows)
 
+				expectReviewableStay(mock, 1, 1, 3)
+				expectRecentReviews(mock, 1)
+
 				// Mock database error when creating review
 				mock.ExpectBegin()
+				expectAccommodationLock(mock, 1)
 				mock.ExpectQuery(`INSERT INTO "reviews" (.+) VALUES (.+) RETURNING "id"`).
 					WillReturnError(gorm.ErrInvalidDB)
 				mock.ExpectRollback()
@@ -243,6 +254,7 @@ func TestAddReview(t *testing.T) {
 				assert.Equal(t, tc.expectedResponse.UserName, response.UserName)
 				assert.Equal(t, tc.expectedResponse.Rating, response.Rating)
 				assert.Equal(t, tc.expectedResponse.Comment, response.Comment)
+				assert.Equal(t, tc.expectedResponse.VerifiedStay, response.VerifiedStay)
 			}
 
 			// Verify that all expectations were met
@@ -117,6 +117,7 @@ func TestCreateUser(t *testing.T) {
 	mock.ExpectExec("INSERT INTO `users`").WithArgs(
 		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // Added extra arg for avatar_id
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // avatar_key, is_admin, calendar_token_hash
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -0,0 +1,278 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/jobs"
+	"roam.io/media"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// testImage returns a width x height image with a gradient so it isn't trivially compressible
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegWithExif encodes img as a JPEG carrying an EXIF segment with the given text in it
+func jpegWithExif(t *testing.T, img image.Image, text string) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), text...)
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+	// APP1 goes straight after the start-of-image marker
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Run("EXIF is stripped and a thumbnail generated", func(t *testing.T) {
+		upload := jpegWithExif(t, testImage(800, 600), "GPS 29.6516 -82.3248")
+		require.Contains(t, string(upload), "GPS 29.6516")
+
+		processed, err := media.Process(bytes.NewReader(upload))
+		require.NoError(t, err)
+		assert.Equal(t, "image/jpeg", processed.Original.ContentType)
+		assert.NotContains(t, string(processed.Original.Data), "GPS 29.6516")
+		assert.Equal(t, 800, processed.Original.Width)
+		assert.Equal(t, 320, processed.Thumbnail.Width)
+		assert.Equal(t, 240, processed.Thumbnail.Height)
+	})
+
+	t.Run("Type comes from the content", func(t *testing.T) {
+		_, err := media.Process(bytes.NewReader([]byte("<html><script>alert(1)</script></html>")))
+		assert.ErrorIs(t, err, media.ErrUnsupportedType)
+	})
+
+	t.Run("Truncated image", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, testImage(50, 50)))
+		_, err := media.Process(bytes.NewReader(buf.Bytes()[:60]))
+		assert.ErrorIs(t, err, media.ErrInvalidImage)
+	})
+
+	t.Run("Oversized upload", func(t *testing.T) {
+		_, err := media.Process(io.LimitReader(neverEnding{}, media.MaxUploadSize+10))
+		assert.ErrorIs(t, err, media.ErrTooLarge)
+	})
+}
+
+// neverEnding reads as an endless stream of zero bytes
+type neverEnding struct{}
+
+func (neverEnding) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestFit(t *testing.T) {
+	testCases := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+	}{
+		{"Landscape", 1000, 500, 320, 160},
+		{"Portrait", 300, 900, 106, 320},
+		{"Already small", 200, 100, 200, 100},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bounds := media.Fit(testImage(tc.width, tc.height), 320, 320).Bounds()
+			assert.Equal(t, tc.wantW, bounds.Dx())
+			assert.Equal(t, tc.wantH, bounds.Dy())
+		})
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	require.NoError(t, store.Put("media/abc/original.jpg", bytes.NewReader([]byte("image"))))
+
+	blob, err := store.Open("media/abc/original.jpg")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	assert.Equal(t, "image", string(data))
+
+	require.NoError(t, store.Delete("media/abc/original.jpg"))
+	_, err = store.Open("media/abc/original.jpg")
+	assert.ErrorIs(t, err, media.ErrBlobNotFound)
+
+	for _, key := range []string{"../escape", "/etc/passwd", "media//x", ""} {
+		assert.ErrorIs(t, store.Put(key, bytes.NewReader(nil)), media.ErrInvalidKey, key)
+	}
+}
+
+func TestUploadMedia(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	routes.SetMediaStore(store)
+	routes.SetAPIBaseURL("https://api.roam.io/")
+	defer routes.SetAPIBaseURL("http://localhost:8080")
+
+	db, mock := setupTestDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "media" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(1, "image/png", sqlmock.AnyArg(), 640, 480, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectCommit()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "photo.png")
+	require.NoError(t, png.Encode(part, testImage(640, 480)))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/media", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.UploadMedia(db).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var upload models.Media
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &upload))
+	assert.Equal(t, "https://api.roam.io/media/5", upload.URL)
+	assert.Equal(t, "https://api.roam.io/media/5/thumbnail", upload.ThumbnailURL)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServeMedia(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	routes.SetMediaStore(store)
+	require.NoError(t, store.Put("media/abc/thumbnail.png", bytes.NewReader([]byte("thumb"))))
+
+	serve := func(userID uint, reviewID interface{}, published bool) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "media" WHERE "media"."id" = $1`)).
+			WithArgs(5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "content_type", "key", "thumbnail_key", "review_id"}).
+				AddRow(5, 1, "image/png", "media/abc/original.png", "media/abc/thumbnail.png", reviewID))
+		if reviewID != nil {
+			count := 0
+			if published {
+				count = 1
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews" WHERE (id = $1 AND moderation_status = $2) AND "reviews"."deleted_at" IS NULL`)).
+				WithArgs(reviewID, models.ReviewStatusApproved).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+		}
+
+		req := httptest.NewRequest("GET", "/media/5/thumbnail", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "5"})
+		req = addSessionToRequest(req, userID)
+		rr := httptest.NewRecorder()
+		routes.ServeMediaThumbnail(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	rr := serve(2, 4, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "thumb", rr.Body.String())
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "public")
+
+	// Unattached uploads are only visible to the uploader
+	assert.Equal(t, http.StatusNotFound, serve(2, nil, false).Code)
+	assert.Equal(t, http.StatusOK, serve(1, nil, false).Code)
+
+	// So are photos of reviews that are pending, rejected or hidden
+	assert.Equal(t, http.StatusNotFound, serve(2, 4, false).Code)
+	rr = serve(1, 4, false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "private, no-cache", rr.Header().Get("Cache-Control"))
+}
+
+func TestDeleteAbandonedUploads(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	routes.SetMediaStore(store)
+	for _, key := range []string{"media/a/original.png", "media/a/thumbnail.png", "media/b/original.png", "media/b/thumbnail.png"} {
+		require.NoError(t, store.Put(key, bytes.NewReader([]byte("image"))))
+	}
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "media" WHERE accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL AND created_at <= $1`)).
+		WithArgs(now.Add(-routes.UnattachedMediaTTL)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key", "thumbnail_key"}).
+			AddRow(5, "media/a/original.png", "media/a/thumbnail.png").
+			AddRow(6, "media/b/original.png", "media/b/thumbnail.png"))
+	deleteUpload := regexp.QuoteMeta(`DELETE FROM "media" WHERE (accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL) AND "media"."id" = $1`)
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteUpload).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Attached since it was loaded, so it is kept
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteUpload).WithArgs(6).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	require.NoError(t, jobs.DeleteAbandonedUploads(db, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err := store.Open("media/a/original.png")
+	assert.ErrorIs(t, err, media.ErrBlobNotFound)
+	_, err = store.Open("media/a/thumbnail.png")
+	assert.ErrorIs(t, err, media.ErrBlobNotFound)
+	blob, err := store.Open("media/b/original.png")
+	require.NoError(t, err)
+	blob.Close()
+}
+
+func TestAttachReviewMedia(t *testing.T) {
+	attach := regexp.QuoteMeta(`UPDATE "media" SET "review_id"=$1 WHERE id IN ($2,$3) AND user_id = $4 AND accommodation_id IS NULL AND event_id IS NULL AND review_id IS NULL`)
+
+	t.Run("Own uploads are attached", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(attach).WithArgs(4, 5, 6, 1).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "media" WHERE id IN ($1,$2) ORDER BY id`)).
+			WithArgs(5, 6).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "review_id"}).AddRow(5, 4).AddRow(6, 4))
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.AttachReviewMedia, db, "POST", `{"MediaIDs": [5, 6]}`, 1)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var attached []models.Media
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &attached))
+		assert.Equal(t, "http://localhost:8080/media/6", attached[1].URL)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Someone else's upload is refused", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(attach).WithArgs(4, 5, 6, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		rr := serveReviewRequest(routes.AttachReviewMedia, db, "POST", `{"MediaIDs": [5, 6]}`, 1)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,240 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/moderation"
+	"roam.io/routes"
+)
+
+// expectRecentReviews mocks the author's reviews from the burst window that a new comment is compared with
+func expectRecentReviews(mock sqlmock.Sqlmock, userID int, comments ...string) {
+	rows := sqlmock.NewRows([]string{"comment"})
+	for _, comment := range comments {
+		rows.AddRow(comment)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "comment" FROM "reviews" WHERE (user_id = $1 AND created_at > $2 AND id <> $3) AND "reviews"."deleted_at" IS NULL`)).
+		WithArgs(userID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(rows)
+}
+
+// expectUser mocks loading a user, admin or not
+func expectUser(mock sqlmock.Sqlmock, userID int, admin bool) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1 ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs(userID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "is_admin"}).AddRow(userID, "user", admin))
+}
+
+// expectModeratedReview mocks loading review 4 in the given moderation status
+func expectModeratedReview(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE "reviews"."id" = $1 AND "reviews"."deleted_at" IS NULL ORDER BY "reviews"."id" LIMIT $2`)).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "rating", "comment", "moderation_status"}).
+			AddRow(4, 1, 3, 4.0, "Lovely cabin", status))
+}
+
+// serveModeration sends a request to handler for review 4 as userID
+func serveModeration(handler func(*gorm.DB) http.HandlerFunc, db *gorm.DB, body string, userID uint) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/reviews/4", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	req = addSessionToRequest(req, userID)
+
+	rr := httptest.NewRecorder()
+	handler(db).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestScreener(t *testing.T) {
+	screener := moderation.NewScreener([]string{"darn"})
+
+	testCases := []struct {
+		name    string
+		sub     moderation.Submission
+		reasons []string
+	}{
+		{"Clean review", moderation.Submission{Text: "Great host, spotless kitchen."}, nil},
+		{"Word list is case-insensitive", moderation.Submission{Text: "DARN noisy neighbours"}, []string{moderation.ReasonProfanity}},
+		{"Words inside other words are fine", moderation.Submission{Text: "The darning kit was handy"}, nil},
+		{"Links", moderation.Submission{Text: "Book cheaper at www.example.com"}, []string{moderation.ReasonLinks}},
+		{"One word repeated", moderation.Submission{Text: "great great great great great nice"}, []string{moderation.ReasonRepeatedText}},
+		{"Held-down key", moderation.Submission{Text: "Amazing!!!!!!!!!!!!"}, []string{moderation.ReasonRepeatedText}},
+		{"Same comment posted again", moderation.Submission{Text: "Lovely stay.", Recent: []string{"lovely  stay"}}, []string{moderation.ReasonDuplicate}},
+		{"Too many reviews at once", moderation.Submission{Text: "Nice", Recent: []string{"a", "b", "c"}}, []string{moderation.ReasonBurst}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verdict := screener.Screen(tc.sub)
+			assert.Equal(t, tc.reasons != nil, verdict.Flagged)
+			assert.Equal(t, tc.reasons, verdict.Reasons)
+		})
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	words, err := moderation.LoadWordList(strings.NewReader("# comment\nDarn\n\n  heck  \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Darn", "heck"}, words)
+
+	path := filepath.Join(t.TempDir(), "words.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("blimey\n"), 0o600))
+	words, err = moderation.LoadWordListFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blimey"}, words)
+
+	_, err = moderation.LoadWordListFile(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestAddReviewHeldForModeration(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "johndoe"))
+	expectReviewableStay(mock, 1, 1, 9)
+	expectRecentReviews(mock, 1)
+	mock.ExpectBegin()
+	expectAccommodationLock(mock, 1)
+	mock.ExpectQuery(`INSERT INTO "reviews" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(1, 1, "johndoe", 4.0, sqlmock.AnyArg(), "Visit www.example.com", 9, true, 0.0, 0.0, 0.0, 0, nil, models.ReviewStatusPending, moderation.ReasonLinks, sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// A held review doesn't count yet
+	expectRatingRecalculation(mock, 1, routes.RatingAggregate{})
+	mock.ExpectCommit()
+
+	rr := serveAddReview(t, db, `{"Rating": 4, "Comment": "Visit www.example.com"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var review models.Review
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	assert.Equal(t, models.ReviewStatusPending, review.ModerationStatus)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReportReview(t *testing.T) {
+	t.Run("Enough reports hold the review", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectModeratedReview(mock, models.ReviewStatusApproved)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "review_reports" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(4, 2, "Fake", sqlmock.AnyArg(), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "review_reports" WHERE review_id = $1 AND resolved_at IS NULL`)).
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		expectAccommodationLock(mock, 3)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "moderation_status"=$1,"moderation_reason"=$2 WHERE "reviews"."deleted_at" IS NULL AND "id" = $3`)).
+			WithArgs(models.ReviewStatusPending, "reported", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRatingRecalculation(mock, 3, routes.RatingAggregate{})
+		mock.ExpectCommit()
+
+		rr := serveModeration(routes.ReportReview, db, `{"Reason": " Fake "}`, 2)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A user reports a review once", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectModeratedReview(mock, models.ReviewStatusApproved)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "review_reports" (.+) VALUES (.+) RETURNING "id"`).
+			WillReturnError(gorm.ErrDuplicatedKey)
+		mock.ExpectRollback()
+
+		rr := serveModeration(routes.ReportReview, db, `{}`, 2)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Authors cannot report themselves", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectModeratedReview(mock, models.ReviewStatusApproved)
+
+		rr := serveModeration(routes.ReportReview, db, `{}`, 1)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestModerateReview(t *testing.T) {
+	t.Run("Approving publishes the review and resolves reports", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectUser(mock, 9, true)
+		expectModeratedReview(mock, models.ReviewStatusPending)
+		mock.ExpectBegin()
+		expectAccommodationLock(mock, 3)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "moderation_status"=$1,"moderation_reason"=$2 WHERE "reviews"."deleted_at" IS NULL AND "id" = $3`)).
+			WithArgs(models.ReviewStatusApproved, "", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRatingRecalculation(mock, 3, routes.RatingAggregate{ReviewCount: 1, Rating: 4})
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "review_reports" SET "resolved_at"=$1 WHERE review_id = $2 AND resolved_at IS NULL`)).
+			WithArgs(sqlmock.AnyArg(), 4).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		rr := serveModeration(routes.ApproveReview, db, "", 9)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var review models.Review
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+		assert.Equal(t, models.ReviewStatusApproved, review.ModerationStatus)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Published reviews cannot be rejected", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectUser(mock, 9, true)
+		expectModeratedReview(mock, models.ReviewStatusApproved)
+
+		rr := serveModeration(routes.RejectReview, db, "", 9)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Only admins moderate", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectUser(mock, 2, false)
+
+		rr := serveModeration(routes.HideReview, db, "", 2)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListModerationQueue(t *testing.T) {
+	db, mock := setupTestDB(t)
+	expectUser(mock, 9, true)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE moderation_status = $1 AND "reviews"."deleted_at" IS NULL ORDER BY id`)).
+		WithArgs(models.ReviewStatusPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "comment", "moderation_status"}).
+			AddRow(4, "Visit www.example.com", models.ReviewStatusPending).
+			AddRow(5, "Fake listing", models.ReviewStatusPending))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT review_id, COUNT(*) AS count FROM "review_reports" WHERE review_id IN ($1,$2) AND resolved_at IS NULL GROUP BY "review_id"`)).
+		WithArgs(4, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"review_id", "count"}).AddRow(5, 3))
+
+	req := httptest.NewRequest("GET", "/admin/reviews", nil)
+	req = addSessionToRequest(req, 9)
+	rr := httptest.NewRecorder()
+	routes.ListModerationQueue(db).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var items []routes.ModerationQueueItem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &items))
+	assert.Len(t, items, 2)
+	assert.Equal(t, int64(0), items[0].OpenReports)
+	assert.Equal(t, int64(3), items[1].OpenReports)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
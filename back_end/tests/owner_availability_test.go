@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestCheckStay(t *testing.T) {
+	// A Monday evening; 2030-06-07 is a Friday
+	now := time.Date(2030, 6, 3, 22, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		rules    models.AvailabilityRules
+		checkIn  string
+		checkOut string
+		code     string // empty if the stay is allowed
+	}{
+		{"No rules allows same-day bookings", models.AvailabilityRules{}, "2030-06-03", "2030-06-04", ""},
+		{"Check-out on check-in day", models.AvailabilityRules{}, "2030-06-10", "2030-06-10", models.StayErrorInvalidDates},
+		{"Check-out before check-in", models.AvailabilityRules{}, "2030-06-10", "2030-06-08", models.StayErrorInvalidDates},
+		{"Check-in in the past", models.AvailabilityRules{}, "2030-06-02", "2030-06-05", models.StayErrorInvalidDates},
+
+		{"Less notice than the lead time", models.AvailabilityRules{LeadDays: 2}, "2030-06-04", "2030-06-06", models.StayErrorLeadTime},
+		{"Exactly the lead time", models.AvailabilityRules{LeadDays: 2}, "2030-06-05", "2030-06-06", ""},
+		{"Beyond the horizon", models.AvailabilityRules{HorizonDays: 10}, "2030-06-14", "2030-06-15", models.StayErrorHorizon},
+		{"Last day of the horizon", models.AvailabilityRules{HorizonDays: 10}, "2030-06-13", "2030-06-15", ""},
+
+		{"Shorter than the minimum", models.AvailabilityRules{MinNights: 3}, "2030-06-10", "2030-06-12", models.StayErrorTooShort},
+		{"Exactly the minimum", models.AvailabilityRules{MinNights: 3}, "2030-06-10", "2030-06-13", ""},
+		{"Longer than the maximum", models.AvailabilityRules{MaxNights: 7}, "2030-06-10", "2030-06-18", models.StayErrorTooLong},
+		{"Exactly the maximum", models.AvailabilityRules{MaxNights: 7}, "2030-06-10", "2030-06-17", ""},
+
+		{"Friday and Saturday nights below the weekend minimum", models.AvailabilityRules{WeekendMinNights: 3}, "2030-06-07", "2030-06-09", models.StayErrorTooShort},
+		{"Saturday night alone below the weekend minimum", models.AvailabilityRules{WeekendMinNights: 3}, "2030-06-08", "2030-06-09", models.StayErrorTooShort},
+		{"Sunday night isn't a weekend night", models.AvailabilityRules{WeekendMinNights: 3}, "2030-06-09", "2030-06-11", ""},
+		{"Weekday stay ignores the weekend minimum", models.AvailabilityRules{WeekendMinNights: 3}, "2030-06-10", "2030-06-12", ""},
+		{"Long weekend meets the weekend minimum", models.AvailabilityRules{WeekendMinNights: 3}, "2030-06-07", "2030-06-10", ""},
+
+		{"Check-in on a closed weekday", models.AvailabilityRules{CheckInDays: []string{"saturday"}}, "2030-06-10", "2030-06-15", models.StayErrorCheckInDay},
+		{"Check-in on an allowed weekday", models.AvailabilityRules{CheckInDays: []string{"saturday"}}, "2030-06-08", "2030-06-15", ""},
+		{"Check-out on a closed weekday", models.AvailabilityRules{CheckOutDays: []string{"saturday", "sunday"}}, "2030-06-08", "2030-06-12", models.StayErrorCheckOutDay},
+		{"Check-out on an allowed weekday", models.AvailabilityRules{CheckOutDays: []string{"saturday", "sunday"}}, "2030-06-08", "2030-06-09", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkIn, _ := time.Parse("2006-01-02", tc.checkIn)
+			checkOut, _ := time.Parse("2006-01-02", tc.checkOut)
+			err := tc.rules.CheckStay(checkIn, checkOut, now)
+			if tc.code == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var stayErr *models.StayError
+			if assert.True(t, errors.As(err, &stayErr), "expected a StayError, got %v", err) {
+				assert.Equal(t, tc.code, stayErr.Code)
+			}
+		})
+	}
+}
+
+func TestValidateAvailabilityRules(t *testing.T) {
+	rules := models.AvailabilityRules{MinNights: 2, CheckInDays: []string{" Friday", "SATURDAY"}}
+	assert.NoError(t, rules.Validate())
+	assert.Equal(t, []string{"friday", "saturday"}, rules.CheckInDays)
+
+	for _, invalid := range []models.AvailabilityRules{
+		{MinNights: -1},
+		{MinNights: 5, MaxNights: 2},
+		{WeekendMinNights: 5, MaxNights: 2},
+		{LeadDays: 30, HorizonDays: 10},
+		{CheckOutDays: []string{"funday"}},
+	} {
+		assert.Error(t, invalid.Validate(), "%+v", invalid)
+	}
+}
+
+func TestUpdateAvailabilityRules(t *testing.T) {
+	update := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/owner/accommodations/7/availability-rules", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Rules are saved", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "availability_rules"=$1 WHERE "id" = $2`)).
+			WithArgs(`{"min_nights":2,"max_nights":14,"weekend_min_nights":3,"check_in_days":["friday","saturday"],"lead_days":1,"horizon_days":365}`, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdateAvailabilityRules(db),
+			`{"min_nights": 2, "max_nights": 14, "weekend_min_nights": 3, "check_in_days": ["Friday", "Saturday"], "lead_days": 1, "horizon_days": 365}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := update(routes.UpdateAvailabilityRules(db), `{"min_nights": 5, "max_nights": 2}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestBlackouts(t *testing.T) {
+	request := func(method, path, body string, vars map[string]string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = mux.SetURLVars(req, vars)
+		return addSessionToRequest(req, 42)
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "blocked_date_ranges" ("accommodation_id","source_id","uid","summary","start_date","end_date") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`)).
+			WithArgs(7, 0, "", "Maintenance", day(2030, 6, 1), day(2030, 6, 8)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.AddBlackout(db).ServeHTTP(rr, request("POST", "/owner/accommodations/7/blackouts",
+			`{"StartDate": "2030-06-01", "EndDate": "2030-06-08", "Reason": "Maintenance"}`, map[string]string{"id": "7"}))
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("End before start", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+
+		rr := httptest.NewRecorder()
+		routes.AddBlackout(db).ServeHTTP(rr, request("POST", "/owner/accommodations/7/blackouts",
+			`{"StartDate": "2030-06-08", "EndDate": "2030-06-08"}`, map[string]string{"id": "7"}))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Synced dates can't be deleted as blackouts", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "blocked_date_ranges" WHERE (accommodation_id = $1 AND source_id = 0) AND "blocked_date_ranges"."id" = $2`)).
+			WithArgs(7, 9).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.DeleteBlackout(db).ServeHTTP(rr, request("DELETE", "/owner/accommodations/7/blackouts/9", "",
+			map[string]string{"id": "7", "blackoutId": "9"}))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,264 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/ical"
+	"roam.io/jobs"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// expectOwnedAccommodation expects loadOwnedAccommodation to find accommodation 7, owned by owner@example.com
+func expectOwnedAccommodation(mock sqlmock.Sqlmock, userEmail string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(7, "Cabin", 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(42, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(42, userEmail))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(7, "Cabin", 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "hosts" WHERE "hosts"."id" = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "Owner", "owner@example.com"))
+}
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseCalendar(t *testing.T) {
+	file, err := os.Open("testdata/external.ics")
+	require.NoError(t, err)
+	defer file.Close()
+
+	events, err := ical.Parse(file)
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+
+	assert.Equal(t, "1418fb94e984-a8c3c9d6b8f3@airbnb.com", events[0].UID)
+	assert.True(t, events[0].AllDay)
+	assert.Equal(t, day(2025, 6, 1), events[0].Start)
+	assert.Equal(t, day(2025, 6, 5), events[0].End)
+	assert.Contains(t, events[0].Description, "reservations/details/HMABCDEF\nPhone Number", "folded lines are joined")
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	assert.True(t, events[1].Start.Equal(time.Date(2025, 6, 10, 14, 0, 0, 0, time.UTC)))
+	assert.Equal(t, madrid, events[1].Start.Location())
+	assert.False(t, events[1].Floating)
+	assert.Equal(t, "Booking.com, guest Smith", events[1].Summary, "alarm properties don't replace the event's")
+
+	assert.Equal(t, time.Date(2025, 6, 23, 11, 0, 0, 0, time.UTC), events[2].End, "DURATION sets the end")
+	assert.Equal(t, ical.StatusCancelled, events[3].Status)
+	assert.Equal(t, day(2025, 8, 2), events[5].End, "a date without an end lasts the day")
+
+	_, err = ical.Parse(strings.NewReader("Not a calendar\n"))
+	assert.True(t, errors.Is(err, ical.ErrInvalidCalendar))
+	_, err = ical.Parse(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.True(t, errors.Is(err, ical.ErrInvalidCalendar), "events need a start")
+}
+
+func TestParseCalendarRoundTrip(t *testing.T) {
+	stamp := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+	original := ical.Event{
+		UID:      "booking-1@roam.io",
+		Summary:  "Stay at Rose; Thistle, " + strings.Repeat("Edinburgh ", 10),
+		Start:    day(2025, 6, 1),
+		End:      day(2025, 6, 4),
+		AllDay:   true,
+		Status:   ical.StatusConfirmed,
+		Sequence: 1,
+		Stamp:    stamp,
+	}
+	events, err := ical.Parse(bytes.NewReader(ical.Marshal(ical.Calendar{Events: []ical.Event{original}})))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, original, events[0])
+}
+
+func TestSyncExternalCalendars(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+	routes.SetCalendarClient(server.Client())
+	defer routes.SetCalendarClient(routes.NewCalendarClient())
+
+	db, mock := setupTestDB(t)
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "calendar_sources" WHERE url <> ''`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "name", "url"}).
+			AddRow(1, 7, "Airbnb", server.URL+"/external.ics").
+			AddRow(2, 7, "Old listing", server.URL+"/missing.ics"))
+
+	// The first calendar's upcoming stays replace its blocked dates; cancelled and past stays are left out
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "blocked_date_ranges" WHERE source_id = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "blocked_date_ranges" ("accommodation_id","source_id","uid","summary","start_date","end_date") VALUES`)).
+		WithArgs(
+			7, 1, "1418fb94e984-a8c3c9d6b8f3@airbnb.com", "Reserved", day(2025, 6, 1), day(2025, 6, 5),
+			7, 1, "bdc-77@booking.com", "Booking.com, guest Smith", day(2025, 6, 10), day(2025, 6, 12),
+			7, 1, "vrbo-3@vrbo.com", "Blocked", day(2025, 6, 20), day(2025, 6, 23),
+			7, 1, "single-6@airbnb.com", "Owner block", day(2025, 8, 1), day(2025, 8, 2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3).AddRow(4))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "calendar_sources" SET "last_synced_at"=$1,"last_sync_error"=$2 WHERE "id" = $3`)).
+		WithArgs(now, "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The second calendar can't be fetched, so its dates stay blocked and the error is recorded
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "calendar_sources" SET "last_sync_error"=$1 WHERE "id" = $2`)).
+		WithArgs("calendar could not be downloaded; check the URL is public and reachable", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, jobs.SyncExternalCalendars(db, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddCalendarSource(t *testing.T) {
+	add := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/owner/accommodations/7/calendars", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Not the owner", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "someone@example.com")
+		rr := add(routes.AddCalendarSource(db), `{"URL": "https://example.com/calendar.ics"}`)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := add(routes.AddCalendarSource(db), `{"URL": "file:///etc/passwd"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Private addresses are refused", func(t *testing.T) {
+		server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+		defer server.Close()
+
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := add(routes.AddCalendarSource(db), `{"URL": "`+server.URL+`/external.ics"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), "check the URL is public")
+		// The refusal reads like any other failed download, giving nothing away about the address
+		assert.NotContains(t, rr.Body.String(), "127.0.0.1")
+		assert.NotContains(t, rr.Body.String(), "dial")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unreadable calendar isn't saved", func(t *testing.T) {
+		server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+		defer server.Close()
+		routes.SetCalendarClient(server.Client())
+		defer routes.SetCalendarClient(routes.NewCalendarClient())
+
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := add(routes.AddCalendarSource(db), `{"URL": "`+server.URL+`/invalid.ics"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), "BEGIN:VCALENDAR")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUploadCalendarSource(t *testing.T) {
+	db, mock := setupTestDB(t)
+	expectOwnedAccommodation(mock, "owner@example.com")
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "vrbo.ics")
+	require.NoError(t, err)
+	part.Write([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:vrbo-9\r\nDTSTART;VALUE=DATE:20990105\r\nDTEND;VALUE=DATE:20990108\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	require.NoError(t, form.Close())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "calendar_sources" ("accommodation_id","name","url","last_synced_at","last_sync_error","created_at") VALUES`)).
+		WithArgs(7, "vrbo.ics", "", nil, "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "blocked_date_ranges" WHERE source_id = $1`)).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "blocked_date_ranges"`)).
+		WithArgs(7, 3, "vrbo-9", "", day(2099, 1, 5), day(2099, 1, 8)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "calendar_sources" SET "last_synced_at"=$1,"last_sync_error"=$2 WHERE "id" = $3`)).
+		WithArgs(sqlmock.AnyArg(), "", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("POST", "/owner/accommodations/7/calendars/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	req = addSessionToRequest(req, 42)
+	rr := httptest.NewRecorder()
+	routes.UploadCalendarSource(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"Name":"vrbo.ics"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAvailabilityCalendar(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(7, "Cabin"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bookings" WHERE (accommodation_id = $1 AND status IN ($2,$3) AND checkout_date >= $4) AND (expires_at IS NULL OR expires_at > $5) ORDER BY checkin_date`)).
+		WithArgs(7, models.BookingStatusPending, models.BookingStatusConfirmed, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkin_date", "checkout_date", "guests", "status"}).
+			AddRow(11, 5, 7, day(2099, 3, 10), day(2099, 3, 14), 2, models.BookingStatusConfirmed))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "blocked_date_ranges" WHERE accommodation_id = $1 AND source_id = 0 AND end_date > $2 ORDER BY start_date`)).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "source_id", "summary", "start_date", "end_date"}).
+			AddRow(4, 7, 0, "Maintenance", day(2099, 4, 1), day(2099, 4, 8)))
+
+	req := httptest.NewRequest("GET", "/accommodations/7/availability.ics", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	routes.GetAvailabilityCalendar(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, ical.ContentType, rr.Header().Get("Content-Type"))
+	events, err := ical.Parse(rr.Body)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "availability-booking-11@roam.io", events[0].UID)
+	assert.Equal(t, "Reserved", events[0].Summary)
+	assert.Equal(t, day(2099, 3, 10), events[0].Start)
+	assert.Equal(t, day(2099, 3, 14), events[0].End)
+	assert.Empty(t, events[0].Description, "guest details aren't shared")
+	assert.Equal(t, "availability-blackout-4@roam.io", events[1].UID)
+	assert.Equal(t, "Not available", events[1].Summary, "the owner's reason isn't shared")
+	assert.Equal(t, day(2099, 4, 8), events[1].End)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,525 @@
+package routes
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"roam.io/jobs"
+	"roam.io/models"
+	"roam.io/payments"
+	"roam.io/routes"
+)
+
+// TestMain charges bookings through the fake gateway with a known webhook secret
+func TestMain(m *testing.M) {
+	routes.SetPaymentGateway(payments.NewFakeGateway("test-secret"))
+	os.Exit(m.Run())
+}
+
+var paymentIntentColumns = []string{"id", "booking_id", "event_booking_id", "user_id", "amount", "currency", "status", "gateway", "authorization_id", "charge_id", "refunded_amount"}
+
+// expectPaymentIntentLookup mocks finding the latest payment intent for a booking.
+// A nil row means the booking was made before payments existed.
+func expectPaymentIntentLookup(mock sqlmock.Sqlmock, column string, bookingID int, row []interface{}) {
+	rows := sqlmock.NewRows(paymentIntentColumns)
+	if row != nil {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			values[i] = v
+		}
+		rows.AddRow(values...)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "payment_intents" WHERE `+column+` = $1 ORDER BY id desc,"payment_intents"."id" LIMIT $2`)).
+		WithArgs(bookingID, 1).
+		WillReturnRows(rows)
+}
+
+// expectPaymentIntentInsert mocks recording the outcome of an authorization
+func expectPaymentIntentInsert(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "payment_intents" (.+) VALUES (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+}
+
+// expectPaymentIntentSave mocks saving a payment intent after a capture, void or refund
+func expectPaymentIntentSave(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectPaymentCharge mocks authorizing a payment, then capturing it and confirming the booking in table together
+func expectPaymentCharge(mock sqlmock.Sqlmock, table string) {
+	expectPaymentIntentInsert(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "`+table+`" SET "status"=\$1,"updated_at"=\$2,"confirmed_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(models.BookingStatusConfirmed, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestFakeGateway(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+
+	t.Run("Authorize, capture and refund", func(t *testing.T) {
+		auth, err := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, Currency: payments.Currency, PaymentToken: "tok_visa"})
+		assert.NoError(t, err)
+
+		charge, err := gateway.Capture(auth.ID, 1000)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1000), charge.Amount)
+
+		_, err = gateway.Refund(charge.ID, 600)
+		assert.NoError(t, err)
+		_, err = gateway.Refund(charge.ID, 500)
+		assert.Error(t, err, "refunds can't exceed what was charged")
+
+		assert.Error(t, gateway.Void(auth.ID), "a captured authorization can't be voided")
+	})
+
+	t.Run("Declined token", func(t *testing.T) {
+		_, err := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, PaymentToken: payments.FakeTokenDecline})
+		assert.ErrorIs(t, err, payments.ErrPaymentDeclined)
+	})
+
+	t.Run("Capture declined", func(t *testing.T) {
+		auth, err := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, PaymentToken: payments.FakeTokenCaptureFail})
+		assert.NoError(t, err)
+		_, err = gateway.Capture(auth.ID, 1000)
+		assert.ErrorIs(t, err, payments.ErrPaymentDeclined)
+	})
+
+	t.Run("Voided authorization can't be captured", func(t *testing.T) {
+		auth, err := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, PaymentToken: "tok_visa"})
+		assert.NoError(t, err)
+		assert.NoError(t, gateway.Void(auth.ID))
+		_, err = gateway.Capture(auth.ID, 1000)
+		assert.Error(t, err)
+	})
+
+	t.Run("Webhook signatures", func(t *testing.T) {
+		payload := []byte(`{"type":"charge.refunded","charge_id":"ch_1","amount":500}`)
+
+		event, err := gateway.VerifyWebhook(payload, gateway.Sign(payload))
+		assert.NoError(t, err)
+		assert.Equal(t, payments.WebhookChargeRefunded, event.Type)
+		assert.Equal(t, uint(500), event.Amount)
+
+		_, err = gateway.VerifyWebhook(payload, "forged")
+		assert.ErrorIs(t, err, payments.ErrInvalidSignature)
+	})
+}
+
+func TestChargeBooking(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+	booking := &models.Booking{ID: 1, UserID: 5, TotalCost: 1000}
+
+	t.Run("Captured", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectPaymentIntentInsert(mock)
+		expectPaymentIntentSave(mock)
+
+		intent, err := payments.AuthorizeBooking(booking, "tok_visa", gateway, db)
+		assert.NoError(t, err)
+		assert.NoError(t, payments.CaptureIntent(intent, gateway, db))
+		assert.Equal(t, models.PaymentStatusSucceeded, intent.Status)
+		assert.NotEmpty(t, intent.ChargeID)
+		assert.Equal(t, uint(1000), intent.Amount)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Declined payment is recorded", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "payment_intents" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(sqlmock.AnyArg(), nil, 5, 1000, payments.Currency, models.PaymentStatusFailed, "fake", "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		intent, err := payments.AuthorizeBooking(booking, payments.FakeTokenDecline, gateway, db)
+		assert.ErrorIs(t, err, payments.ErrPaymentFailed)
+		assert.Equal(t, models.PaymentStatusFailed, intent.Status)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database errors are not payment failures", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "payment_intents"`).WillReturnError(errors.New("database error"))
+		mock.ExpectRollback()
+
+		_, err := payments.AuthorizeBooking(booking, "tok_visa", gateway, db)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, payments.ErrPaymentFailed))
+	})
+}
+
+// expectInstantBooking mocks booking instant accommodation 1 as booking 1, still pending payment
+func expectInstantBooking(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "booking_mode", "price_per_night"}).AddRow(1, models.BookingModeInstant, 200))
+	expectNoRoomTypes(mock)
+	expectBookingLock(mock, 1)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+}
+
+func TestAddBookingWithoutPaymentToken(t *testing.T) {
+	routes.SetPaymentGateway(payments.NewFakeGateway("test-secret"))
+
+	t.Run("Confirmed unpaid", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectInstantBooking(mock)
+		// No payment intent is recorded
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"updated_at"=\$2,"confirmed_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(models.BookingStatusConfirmed, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		req := httptest.NewRequest("PUT", "/accommodations?accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-15&guests=2&total_cost=1000", nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		routes.AddBooking(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rejected when payments are required", func(t *testing.T) {
+		routes.SetPaymentsRequired(true)
+		defer routes.SetPaymentsRequired(false)
+		db, mock := setupTestDB(t)
+		expectInstantBooking(mock)
+		expectPaymentIntentInsert(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"updated_at"=\$2,"cancelled_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(models.BookingStatusCancelled, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		req := httptest.NewRequest("PUT", "/accommodations?accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-15&guests=2&total_cost=1000", nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		routes.AddBooking(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusPaymentRequired, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddBookingPaymentDeclined(t *testing.T) {
+	routes.SetPaymentGateway(payments.NewFakeGateway("test-secret"))
+	db, mock := setupTestDB(t)
+
+	expectInstantBooking(mock)
+	expectPaymentIntentInsert(mock)
+	// The unpaid booking is cancelled so it stops holding the dates
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"updated_at"=\$2,"cancelled_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(models.BookingStatusCancelled, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("PUT", "/accommodations?accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-15&guests=2&total_cost=1000&payment_token="+payments.FakeTokenDecline, nil)
+	req = addSessionToRequest(req, 1)
+
+	rr := httptest.NewRecorder()
+	routes.AddBooking(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusPaymentRequired, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddBookingRefundsWhenConfirmFails(t *testing.T) {
+	routes.SetPaymentGateway(payments.NewFakeGateway("test-secret"))
+	db, mock := setupTestDB(t)
+
+	expectInstantBooking(mock)
+	expectPaymentIntentInsert(mock)
+	// The capture is recorded with the confirmation, which fails
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"updated_at"=\$2,"confirmed_at"=\$3 WHERE "id" = \$4`).
+		WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
+	// so the charge is refunded and the booking cancelled
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WithArgs(sqlmock.AnyArg(), nil, 1, 1000, payments.Currency, models.PaymentStatusRefunded, "fake", sqlmock.AnyArg(), sqlmock.AnyArg(), 1000, "", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"updated_at"=\$2,"cancelled_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(models.BookingStatusCancelled, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("PUT", "/accommodations?accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-15&guests=2&total_cost=1000&payment_token=tok_visa", nil)
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.AddBooking(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddEventBookingPaymentDeclined(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "available_seats", "total_seats", "status"}).
+			AddRow(1, "Concert", "2099-06-15", 50, 100, models.EventStatusActive))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "ticket_tiers"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	// The seats are taken with the booking, before the payment
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "event_bookings" (.+) VALUES (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "available_seats"=available_seats - $1,"updated_at"=$2 WHERE id = $3 AND available_seats >= $4`)).
+		WithArgs(2, sqlmock.AnyArg(), 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectPaymentIntentInsert(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "event_bookings" SET "status"=\$1,"updated_at"=\$2,"cancelled_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(models.BookingStatusCancelled, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// and given back when it is declined
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "available_seats"=available_seats + $1,"updated_at"=$2 WHERE id = $3`)).
+		WithArgs(2, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("PUT", "/events?event_id=1&guests=2&total_cost=60&payment_token="+payments.FakeTokenDecline, nil)
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.AddEventBooking(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusPaymentRequired, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectBookingRequestApproval mocks claiming pending booking 7 as confirmed and queueing the guest's notification
+func expectBookingRequestApproval(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "bookings" SET (.+) WHERE status = \$6 AND "id" = \$7`).
+		WithArgs(models.BookingStatusConfirmed, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, models.BookingStatusPending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "notifications" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestApproveBookingRequestCapturesPayment(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+	routes.SetPaymentGateway(gateway)
+	future := time.Now().Add(time.Hour)
+
+	t.Run("Capture succeeds", func(t *testing.T) {
+		auth, _ := gateway.Authorize(payments.AuthorizeRequest{Amount: 500, PaymentToken: "tok_visa"})
+		db, mock := setupTestDB(t)
+		expectAccommodationOwner(mock, models.BookingStatusPending, future, "owner@example.com")
+		expectBookingRequestApproval(mock)
+		expectPaymentIntentLookup(mock, "booking_id", 7, []interface{}{1, 7, nil, 5, 500, "USD", models.PaymentStatusRequiresCapture, "fake", auth.ID, "", 0})
+		expectPaymentIntentSave(mock)
+		mock.ExpectCommit()
+
+		req := httptest.NewRequest("POST", "/owner/bookings/7/approve", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		routes.ApproveBookingRequest(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Capture declined leaves the request pending", func(t *testing.T) {
+		auth, _ := gateway.Authorize(payments.AuthorizeRequest{Amount: 500, PaymentToken: payments.FakeTokenCaptureFail})
+		db, mock := setupTestDB(t)
+		expectAccommodationOwner(mock, models.BookingStatusPending, future, "owner@example.com")
+		expectBookingRequestApproval(mock)
+		expectPaymentIntentLookup(mock, "booking_id", 7, []interface{}{1, 7, nil, 5, 500, "USD", models.PaymentStatusRequiresCapture, "fake", auth.ID, "", 0})
+		// The failed capture is still recorded against the intent, while the approval rolls back
+		expectPaymentIntentSave(mock)
+		mock.ExpectRollback()
+
+		req := httptest.NewRequest("POST", "/owner/bookings/7/approve", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		routes.ApproveBookingRequest(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusPaymentRequired, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCancelBookingRefundsPayment(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+	routes.SetPaymentGateway(gateway)
+	auth, _ := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, PaymentToken: "tok_visa"})
+	charge, _ := gateway.Capture(auth.ID, 1000)
+
+	db, mock := setupTestDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "bookings" WHERE user_id = \$1 AND "bookings"."id" = \$2 (.+) FOR UPDATE`).
+		WithArgs(1, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkin_date", "total_cost", "status"}).
+			AddRow(1, 1, 1, time.Now().AddDate(0, 0, 10), 1000, models.BookingStatusConfirmed))
+	mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "cancellation_policy"}).AddRow(1, models.CancellationPolicyStrict))
+	mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1,"refund_amount"=\$2,"updated_at"=\$3,"cancelled_at"=\$4 WHERE "id" = \$5`).
+		WithArgs(models.BookingStatusCancelled, 500, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// The refund only goes out once the cancellation is committed
+	expectPaymentIntentLookup(mock, "booking_id", 1, []interface{}{1, 1, nil, 1, 1000, "USD", models.PaymentStatusSucceeded, "fake", auth.ID, charge.ID, 0})
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WithArgs(sqlmock.AnyArg(), nil, 1, 1000, "USD", models.PaymentStatusPartiallyRefunded, "fake", auth.ID, charge.ID, 500, "", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	booking, err := routes.CancelBookingByID(1, 1, db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(500), booking.RefundAmount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectPaidBookingSweep mocks confirming pending stays and event bookings that were paid for
+func expectPaidBookingSweep(mock sqlmock.Sqlmock, stays, events int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bookings" SET "confirmed_at"=$1,"expires_at"=$2,"status"=$3,"updated_at"=$4 WHERE status = $5 AND (expires_at IS NULL OR expires_at > $6) AND id IN (SELECT "booking_id" FROM "payment_intents" WHERE status = $7)`)).
+		WithArgs(sqlmock.AnyArg(), nil, models.BookingStatusConfirmed, sqlmock.AnyArg(), models.BookingStatusPending, sqlmock.AnyArg(), models.PaymentStatusSucceeded).
+		WillReturnResult(sqlmock.NewResult(0, stays))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "event_bookings" SET "confirmed_at"=$1,"status"=$2,"updated_at"=$3 WHERE status = $4 AND id IN (SELECT "event_booking_id" FROM "payment_intents" WHERE status = $5)`)).
+		WithArgs(sqlmock.AnyArg(), models.BookingStatusConfirmed, sqlmock.AnyArg(), models.BookingStatusPending, models.PaymentStatusSucceeded).
+		WillReturnResult(sqlmock.NewResult(0, events))
+	mock.ExpectCommit()
+}
+
+func TestSettleCancelledPayments(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+	routes.SetPaymentGateway(gateway)
+	auth, _ := gateway.Authorize(payments.AuthorizeRequest{Amount: 1000, PaymentToken: "tok_visa"})
+	charge, _ := gateway.Capture(auth.ID, 1000)
+
+	db, mock := setupTestDB(t)
+	// A booking whose charge went through but whose confirmation was lost is confirmed
+	expectPaidBookingSweep(mock, 1, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bookings" WHERE status IN ($1,$2,$3) AND id IN (SELECT payment_intents.booking_id FROM "payment_intents" JOIN bookings ON bookings.id = payment_intents.booking_id WHERE payment_intents.status = $4 OR (payment_intents.status IN ($5,$6) AND payment_intents.refunded_amount < bookings.refund_amount))`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "total_cost", "refund_amount", "status"}).
+			AddRow(1, 1, 1, 1000, 500, models.BookingStatusCancelled))
+	// 200 went back before the gateway failed, so only the rest is refunded
+	expectPaymentIntentLookup(mock, "booking_id", 1, []interface{}{1, 1, nil, 1, 1000, "USD", models.PaymentStatusPartiallyRefunded, "fake", auth.ID, charge.ID, 200})
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+		WithArgs(sqlmock.AnyArg(), nil, 1, 1000, "USD", models.PaymentStatusPartiallyRefunded, "fake", auth.ID, charge.ID, 500, "", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE status IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	assert.NoError(t, jobs.SettleCancelledPayments(db, time.Now()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	gateway := payments.NewFakeGateway("test-secret")
+	routes.SetPaymentGateway(gateway)
+	payload, _ := json.Marshal(payments.WebhookEvent{Type: payments.WebhookChargeRefunded, AuthorizationID: "auth_8", ChargeID: "ch_9", Amount: 1000})
+
+	t.Run("Signed event updates the intent", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "payment_intents" WHERE authorization_id = $1 ORDER BY "payment_intents"."id" LIMIT $2`)).
+			WithArgs("auth_8", 1).
+			WillReturnRows(sqlmock.NewRows(paymentIntentColumns).AddRow(3, 1, nil, 1, 1000, "USD", models.PaymentStatusSucceeded, "fake", "auth_8", "ch_9", 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "payment_intents" SET (.+) WHERE "id" = \$14`).
+			WithArgs(sqlmock.AnyArg(), nil, 1, 1000, "USD", models.PaymentStatusRefunded, "fake", "auth_8", "ch_9", 1000, "", sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set("X-Payment-Signature", gateway.Sign(payload))
+		rr := httptest.NewRecorder()
+		routes.PaymentWebhook(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Out of order event is ignored", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		// A late charge.failed must not undo the refund already recorded
+		payload, _ := json.Marshal(payments.WebhookEvent{Type: payments.WebhookChargeFailed, AuthorizationID: "auth_8"})
+		mock.ExpectQuery(`SELECT \* FROM "payment_intents" WHERE authorization_id = \$1`).
+			WithArgs("auth_8", 1).
+			WillReturnRows(sqlmock.NewRows(paymentIntentColumns).AddRow(3, 1, nil, 1, 1000, "USD", models.PaymentStatusRefunded, "fake", "auth_8", "ch_9", 1000))
+
+		req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set("X-Payment-Signature", gateway.Sign(payload))
+		rr := httptest.NewRecorder()
+		routes.PaymentWebhook(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Event without an authorization is rejected", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		// Declined payments are stored without an authorization, so this must not match them
+		payload, _ := json.Marshal(payments.WebhookEvent{Type: payments.WebhookChargeSucceeded})
+
+		req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set("X-Payment-Signature", gateway.Sign(payload))
+		rr := httptest.NewRecorder()
+		routes.PaymentWebhook(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Bad signature is rejected", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+
+		req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set("X-Payment-Signature", "forged")
+		rr := httptest.NewRecorder()
+		routes.PaymentWebhook(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
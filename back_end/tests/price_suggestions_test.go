@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/jobs"
+	"roam.io/models"
+	"roam.io/pricing"
+	"roam.io/routes"
+)
+
+func TestSuggestPrice(t *testing.T) {
+	testCases := []struct {
+		name      string
+		demand    pricing.Demand
+		daysAhead int
+		settings  pricing.SuggestionSettings
+		expected  float64
+	}{
+		{"Half booked keeps the price", pricing.Demand{Occupancy: 0.5}, 30, pricing.SuggestionSettings{}, 100},
+		{"Fully booked raises it 30%", pricing.Demand{Occupancy: 1}, 30, pricing.SuggestionSettings{}, 130},
+		{"Empty far ahead lowers it 10%", pricing.Demand{}, 30, pricing.SuggestionSettings{}, 90},
+		{"Empty two weeks ahead lowers it 20%", pricing.Demand{}, 14, pricing.SuggestionSettings{}, 80},
+		{"Busy market outweighs a quiet listing", pricing.Demand{Occupancy: 0, MarketOccupancy: 1, Comparables: 3}, 30, pricing.SuggestionSettings{}, 106},
+		{"Market ignored without comparables", pricing.Demand{Occupancy: 1, MarketOccupancy: 0}, 30, pricing.SuggestionSettings{}, 130},
+		{"Kept above the floor", pricing.Demand{}, 3, pricing.SuggestionSettings{Floor: 95}, 95},
+		{"Kept below the ceiling", pricing.Demand{Occupancy: 1}, 3, pricing.SuggestionSettings{Ceiling: 120}, 120},
+		{"Rounded to whole units", pricing.Demand{Occupancy: 0.75}, 30, pricing.SuggestionSettings{}, 115},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, pricing.SuggestPrice(100, tc.demand, tc.daysAhead, tc.settings))
+		})
+	}
+}
+
+func TestValidateSuggestionSettings(t *testing.T) {
+	assert.NoError(t, pricing.SuggestionSettings{Floor: 80, Ceiling: 200}.Validate())
+	assert.NoError(t, pricing.SuggestionSettings{Floor: 80}.Validate(), "no ceiling")
+	assert.Error(t, pricing.SuggestionSettings{Floor: 200, Ceiling: 80}.Validate())
+	assert.Error(t, pricing.SuggestionSettings{Floor: -1}.Validate())
+}
+
+func TestRulesSuggest(t *testing.T) {
+	rules := pricing.Rules{
+		WeekdayPercents: map[string]int{"friday": 20},
+		DatePrices:      map[string]float64{"2030-06-03": 150},
+	}
+	own := []pricing.Stay{{CheckIn: day(2030, 6, 4), CheckOut: day(2030, 6, 6)}}
+	market := map[uint][]pricing.Stay{
+		4: {{CheckIn: day(2030, 6, 1), CheckOut: day(2030, 6, 10)}},
+		5: {{CheckIn: day(2030, 6, 3), CheckOut: day(2030, 6, 4)}, {CheckIn: day(2030, 6, 7), CheckOut: day(2030, 6, 9)}},
+	}
+
+	suggestions := rules.Suggest(100, time.Date(2030, 6, 3, 18, 0, 0, 0, time.UTC), own, market, 4, pricing.SuggestionSettings{})
+	require.Len(t, suggestions, pricing.SuggestionDays)
+	assert.Equal(t, day(2030, 6, 3), suggestions[0].Date)
+	assert.Equal(t, day(2030, 8, 31), suggestions[pricing.SuggestionDays-1].Date)
+
+	first := suggestions[0]
+	assert.Equal(t, 150.0, first.CurrentPrice, "current price includes date prices")
+	assert.Equal(t, 0.29, first.Occupancy)
+	assert.Equal(t, 0.5, first.MarketOccupancy)
+	assert.Equal(t, 97.0, first.SuggestedPrice, "suggested from the listing's price, not an applied suggestion")
+	assert.False(t, first.Booked)
+
+	assert.True(t, suggestions[1].Booked)
+	assert.True(t, suggestions[2].Booked)
+	assert.False(t, suggestions[3].Booked, "check-out night is free")
+
+	friday := suggestions[4]
+	assert.Equal(t, 120.0, friday.CurrentPrice)
+	assert.Equal(t, 0.5, friday.MarketOccupancy)
+}
+
+// datePricesMatcher checks the pricing rules saved when suggestions are applied
+type datePricesMatcher struct {
+	t      *testing.T
+	prices map[string]float64 // expected prices; 0 for dates that must have none
+	count  int
+}
+
+func (m datePricesMatcher) Match(v driver.Value) bool {
+	value, ok := v.(string)
+	if !ok {
+		return false
+	}
+	var rules pricing.Rules
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return false
+	}
+	for date, price := range m.prices {
+		if rules.DatePrices[date] != price {
+			m.t.Logf("%s is %v, expected %v", date, rules.DatePrices[date], price)
+			return false
+		}
+	}
+	return len(rules.DatePrices) == m.count
+}
+
+// expectPricingRulesLock expects an accommodation's pricing rules to be reloaded under a row lock
+func expectPricingRulesLock(mock sqlmock.Sqlmock, accommodationID int, rules interface{}) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","pricing_rules" FROM "accommodations" WHERE "accommodations"."id" = $1 ORDER BY "accommodations"."id" LIMIT $2 FOR UPDATE`)).
+		WithArgs(accommodationID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "pricing_rules"}).AddRow(accommodationID, rules))
+}
+
+func TestApplyPriceSuggestionsJob(t *testing.T) {
+	db, mock := setupTestDB(t)
+	now := time.Date(2030, 6, 3, 4, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE price_suggestion_auto_apply = $1`)).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "location", "price_per_night", "pricing_rules",
+			"price_suggestion_floor", "price_suggestion_ceiling", "price_suggestion_auto_apply"}).
+			AddRow(3, "Lisbon", 100, `{"date_prices": {"2030-05-01": 70}}`, 95, 110, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "accommodations" WHERE location = $1 AND id <> $2`)).
+		WithArgs("Lisbon", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4).AddRow(5))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "accommodation_id","checkin_date","checkout_date" FROM "bookings" WHERE accommodation_id IN ($1,$2,$3) AND status IN ($4,$5) AND checkin_date < $6 AND checkout_date > $7`)).
+		WithArgs(4, 5, 3, models.BookingStatusPending, models.BookingStatusConfirmed, day(2030, 9, 8), day(2030, 5, 27)).
+		WillReturnRows(sqlmock.NewRows([]string{"accommodation_id", "checkin_date", "checkout_date"}).
+			AddRow(3, day(2030, 6, 4), day(2030, 6, 6)).
+			AddRow(4, day(2030, 6, 3), day(2030, 6, 10)).
+			AddRow(5, day(2030, 6, 3), day(2030, 6, 5)))
+	// The owner set a price for a date beyond the suggestions since the accommodation was read
+	mock.ExpectBegin()
+	expectPricingRulesLock(mock, 3, `{"date_prices": {"2030-05-01": 70, "2030-12-24": 150}}`)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "pricing_rules"=$1 WHERE "id" = $2`)).
+		WithArgs(datePricesMatcher{t: t, prices: map[string]float64{
+			"2030-06-03": 110, // busy, held at the ceiling
+			"2030-06-04": 0,   // already booked
+			"2030-06-20": 95,  // quiet, held at the floor
+			"2030-05-01": 0,   // past
+			"2030-12-24": 150, // kept
+		}, count: pricing.SuggestionDays - 1}, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, jobs.ApplyPriceSuggestions(db, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPriceSuggestions(t *testing.T) {
+	db, mock := setupTestDB(t)
+	expectOwnedAccommodation(mock, "owner@example.com")
+	today := models.CalendarDay(time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "accommodation_id","checkin_date","checkout_date" FROM "bookings"`)).
+		WithArgs(7, models.BookingStatusPending, models.BookingStatusConfirmed, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"accommodation_id", "checkin_date", "checkout_date"}).
+			AddRow(7, today.AddDate(0, 0, 1), today.AddDate(0, 0, 3)))
+
+	req := httptest.NewRequest("GET", "/owner/accommodations/7/price-suggestions", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	req = addSessionToRequest(req, 42)
+	rr := httptest.NewRecorder()
+	routes.GetPriceSuggestions(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response routes.PriceSuggestionsResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Suggestions, pricing.SuggestionDays)
+	assert.False(t, response.Suggestions[0].Booked)
+	assert.True(t, response.Suggestions[1].Booked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePriceSuggestionSettings(t *testing.T) {
+	update := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/owner/accommodations/7/price-suggestions/settings", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Turning on auto-apply applies the suggestions", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "price_suggestion_floor"=$1,"price_suggestion_ceiling"=$2,"price_suggestion_auto_apply"=$3 WHERE "id" = $4`)).
+			WithArgs(80.0, 0.0, true, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(`SELECT "accommodation_id","checkin_date","checkout_date" FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"accommodation_id", "checkin_date", "checkout_date"}))
+		mock.ExpectBegin()
+		expectPricingRulesLock(mock, 7, nil)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "pricing_rules"=$1 WHERE "id" = $2`)).
+			WithArgs(datePricesMatcher{t: t, count: pricing.SuggestionDays}, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdatePriceSuggestionSettings(db), `{"floor": 80, "auto_apply": true}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ceiling below the floor", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := update(routes.UpdatePriceSuggestionSettings(db), `{"floor": 80, "ceiling": 60}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
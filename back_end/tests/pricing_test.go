@@ -0,0 +1,249 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/models"
+	"roam.io/pricing"
+	"roam.io/routes"
+)
+
+func TestPricingQuote(t *testing.T) {
+	summer := pricing.Season{Name: "Summer", Start: "2030-06-01", End: "2030-06-30", PricePerNight: 150}
+	holidays := pricing.Season{Name: "Holidays", Start: "2000-12-20", End: "2000-01-05", Yearly: true, PricePerNight: 200}
+	weekend := map[string]int{"friday": 20, "saturday": 20}
+	discounts := []pricing.StayDiscount{{MinNights: 7, Percent: 10}, {MinNights: 28, Percent: 25}}
+
+	testCases := []struct {
+		name     string
+		base     float64
+		rules    pricing.Rules
+		checkIn  string
+		checkOut string
+		guests   int
+		nights   []float64 // price of each night
+		discount float64
+		total    float64
+	}{
+		{"No rules", 100, pricing.Rules{}, "2030-05-06", "2030-05-08", 2, []float64{100, 100}, 0, 200},
+		{"Stay running into a season", 100, pricing.Rules{Seasons: []pricing.Season{summer}}, "2030-05-30", "2030-06-02", 1, []float64{100, 100, 150}, 0, 350},
+		{"Season's last night is included", 100, pricing.Rules{Seasons: []pricing.Season{summer}}, "2030-06-30", "2030-07-02", 1, []float64{150, 100}, 0, 250},
+		{"First listed season wins", 100, pricing.Rules{Seasons: []pricing.Season{
+			{Name: "Festival", Start: "2030-06-10", End: "2030-06-11", PricePerNight: 300}, summer,
+		}}, "2030-06-10", "2030-06-13", 1, []float64{300, 300, 150}, 0, 750},
+		{"Yearly season over the new year", 100, pricing.Rules{Seasons: []pricing.Season{holidays}}, "2030-12-31", "2031-01-02", 1, []float64{200, 200}, 0, 400},
+		{"Yearly season ends", 100, pricing.Rules{Seasons: []pricing.Season{holidays}}, "2031-01-05", "2031-01-07", 1, []float64{200, 100}, 0, 300},
+		{"Yearly season from leap day in a common year", 100, pricing.Rules{Seasons: []pricing.Season{
+			{Name: "Leap", Start: "2024-02-29", End: "2024-03-01", Yearly: true, PricePerNight: 80},
+		}}, "2031-02-28", "2031-03-02", 1, []float64{100, 80}, 0, 180},
+		{"Weekend nights cost more", 100, pricing.Rules{WeekdayPercents: weekend}, "2030-06-06", "2030-06-10", 1, []float64{100, 120, 120, 100}, 0, 440},
+		{"Weekday adjustment applies to the season's price", 100, pricing.Rules{Seasons: []pricing.Season{summer}, WeekdayPercents: weekend}, "2030-06-07", "2030-06-08", 1, []float64{180}, 0, 180},
+		{"Date price wins over seasons and weekday adjustments", 100, pricing.Rules{Seasons: []pricing.Season{summer}, WeekdayPercents: weekend,
+			DatePrices: map[string]float64{"2030-06-07": 210}}, "2030-06-06", "2030-06-09", 1, []float64{150, 210, 180}, 0, 540},
+		{"Quiet weekday discount", 100, pricing.Rules{WeekdayPercents: map[string]int{"sunday": -10}}, "2030-06-09", "2030-06-10", 1, []float64{90}, 0, 90},
+		{"Adjusted prices round to cents", 99.99, pricing.Rules{WeekdayPercents: map[string]int{"monday": 15}}, "2030-06-03", "2030-06-04", 1, []float64{114.99}, 0, 114.99},
+		{"One night short of a weekly discount", 100, pricing.Rules{StayDiscounts: discounts}, "2030-05-06", "2030-05-12", 1, []float64{100, 100, 100, 100, 100, 100}, 0, 600},
+		{"Weekly discount", 100, pricing.Rules{StayDiscounts: discounts}, "2030-05-06", "2030-05-13", 1, []float64{100, 100, 100, 100, 100, 100, 100}, 70, 630},
+		{"Largest discount the stay qualifies for", 10, pricing.Rules{StayDiscounts: discounts}, "2030-05-01", "2030-05-29", 1, nil, 70, 210},
+		{"Extra guests pay per night", 100, pricing.Rules{IncludedGuests: 2, ExtraGuestFee: 15}, "2030-05-06", "2030-05-08", 4, []float64{130, 130}, 0, 260},
+		{"Guests within those included pay nothing extra", 100, pricing.Rules{IncludedGuests: 2, ExtraGuestFee: 15}, "2030-05-06", "2030-05-07", 2, []float64{100}, 0, 100},
+		{"No guests counts as one", 100, pricing.Rules{IncludedGuests: 1, ExtraGuestFee: 15}, "2030-05-06", "2030-05-07", 0, []float64{100}, 0, 100},
+		{"Cleaning fee isn't discounted", 100, pricing.Rules{StayDiscounts: discounts, CleaningFee: 50}, "2030-05-06", "2030-05-13", 1, nil, 70, 680},
+		{"Time of day is ignored", 100, pricing.Rules{}, "2030-05-06T23:00:00Z", "2030-05-07T01:00:00Z", 1, []float64{100}, 0, 100},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quote, err := tc.rules.Quote(tc.base, parseTestTime(t, tc.checkIn), parseTestTime(t, tc.checkOut), tc.guests)
+			require.NoError(t, err)
+			if tc.nights != nil {
+				prices := []float64{}
+				for _, night := range quote.Nights {
+					prices = append(prices, night.Price)
+				}
+				assert.Equal(t, tc.nights, prices)
+			}
+			assert.Equal(t, tc.discount, quote.Discount)
+			assert.Equal(t, tc.total, quote.Total)
+		})
+	}
+}
+
+func parseTestTime(t *testing.T, value string) time.Time {
+	if parsed, err := time.Parse("2006-01-02", value); err == nil {
+		return parsed
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestPricingQuoteBreakdown(t *testing.T) {
+	rules := pricing.Rules{
+		Seasons:         []pricing.Season{{Name: "Summer", Start: "2030-06-01", End: "2030-06-30", PricePerNight: 150}},
+		WeekdayPercents: map[string]int{"friday": 20},
+		IncludedGuests:  2,
+		ExtraGuestFee:   10,
+	}
+	quote, err := rules.Quote(100, time.Date(2030, 6, 7, 0, 0, 0, 0, time.UTC), time.Date(2030, 6, 8, 0, 0, 0, 0, time.UTC), 3)
+	require.NoError(t, err)
+	require.Len(t, quote.Nights, 1)
+	assert.Equal(t, pricing.Night{
+		Date:           time.Date(2030, 6, 7, 0, 0, 0, 0, time.UTC),
+		Season:         "Summer",
+		Rate:           150,
+		WeekdayPercent: 20,
+		ExtraGuestFee:  10,
+		Price:          190,
+	}, quote.Nights[0])
+
+	_, err = rules.Quote(100, time.Date(2030, 6, 7, 0, 0, 0, 0, time.UTC), time.Date(2030, 6, 7, 0, 0, 0, 0, time.UTC), 1)
+	assert.True(t, errors.Is(err, pricing.ErrInvalidStay))
+
+	rounded := pricing.Quote{Total: 100.5}
+	assert.Equal(t, uint(101), rounded.TotalCost())
+}
+
+func TestValidatePricingRules(t *testing.T) {
+	rules := pricing.Rules{
+		WeekdayPercents: map[string]int{"Friday ": 20},
+		StayDiscounts:   []pricing.StayDiscount{{MinNights: 28, Percent: 25}, {MinNights: 7, Percent: 10}},
+	}
+	require.NoError(t, rules.Validate())
+	assert.Equal(t, map[string]int{"friday": 20}, rules.WeekdayPercents)
+	assert.Equal(t, 7, rules.StayDiscounts[0].MinNights, "discounts are sorted by length")
+
+	for name, invalid := range map[string]pricing.Rules{
+		"unreadable season date":  {Seasons: []pricing.Season{{Start: "June", End: "2030-06-30", PricePerNight: 10}}},
+		"season ending too early": {Seasons: []pricing.Season{{Start: "2030-06-30", End: "2030-06-01", PricePerNight: 10}}},
+		"free season":             {Seasons: []pricing.Season{{Start: "2030-06-01", End: "2030-06-30"}}},
+		"unknown weekday":         {WeekdayPercents: map[string]int{"funday": 10}},
+		"weekday free":            {WeekdayPercents: map[string]int{"monday": -100}},
+		"one night discount":      {StayDiscounts: []pricing.StayDiscount{{MinNights: 1, Percent: 10}}},
+		"discount over 100%":      {StayDiscounts: []pricing.StayDiscount{{MinNights: 7, Percent: 110}}},
+		"duplicate discount":      {StayDiscounts: []pricing.StayDiscount{{MinNights: 7, Percent: 10}, {MinNights: 7, Percent: 15}}},
+		"negative cleaning fee":   {CleaningFee: -5},
+	} {
+		assert.Error(t, invalid.Validate(), name)
+	}
+}
+
+func TestGetStayQuote(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "price_per_night", "pricing_rules"}).
+			AddRow(7, 100, `{"weekday_percents": {"saturday": 50}, "cleaning_fee": 40}`))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	req := httptest.NewRequest("GET", "/accommodations/7/quote?check_in_date=2099-06-05&check_out_date=2099-06-07&guests=2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	routes.GetStayQuote(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var quote pricing.Quote
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &quote))
+	require.Len(t, quote.Nights, 2)
+	assert.Equal(t, 100.0, quote.Nights[0].Price, "2099-06-05 is a Friday")
+	assert.Equal(t, 150.0, quote.Nights[1].Price)
+	assert.Equal(t, 290.0, quote.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	req = httptest.NewRequest("GET", "/accommodations/7/quote?check_in_date=2099-06-07&check_out_date=2099-06-05", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr = httptest.NewRecorder()
+	routes.GetStayQuote(db).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.StayErrorInvalidDates)
+}
+
+func TestSearchAccommodationsWithDates(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE location = $1`)).
+		WithArgs("Lisbon").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id", "price_per_night", "pricing_rules"}).
+			AddRow(1, "Loft", 1, 80, nil).
+			AddRow(2, "Villa", 1, 200, `{"included_guests": 2, "extra_guest_fee": 25}`))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "hosts" WHERE "hosts"."id" = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Owner"))
+
+	req := httptest.NewRequest("GET", "/accommodations?location=Lisbon&check_in_date=2099-06-01&check_out_date=2099-06-04&guests=3", nil)
+	rr := httptest.NewRecorder()
+	routes.FetchAccommodations(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var results []models.Accommodation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	require.Len(t, results, 2)
+	require.NotNil(t, results[0].PriceQuote)
+	assert.Equal(t, 240.0, results[0].PriceQuote.Total)
+	assert.Equal(t, 675.0, results[1].PriceQuote.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePricingRules(t *testing.T) {
+	update := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/owner/accommodations/7/pricing-rules", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Rules are saved", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		// Applied price suggestions are kept when the rules leave date prices out
+		expectPricingRulesLock(mock, 7, `{"date_prices": {"2030-06-03": 110}, "cleaning_fee": 30}`)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "pricing_rules"=$1 WHERE "id" = $2`)).
+			WithArgs(`{"date_prices":{"2030-06-03":110},"weekday_percents":{"saturday":20},"stay_discounts":[{"min_nights":7,"percent":10}],"cleaning_fee":40}`, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdatePricingRules(db),
+			`{"weekday_percents": {"Saturday": 20}, "stay_discounts": [{"min_nights": 7, "percent": 10}], "cleaning_fee": 40}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Empty date prices clear them", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		expectPricingRulesLock(mock, 7, `{"date_prices": {"2030-06-03": 110}}`)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "pricing_rules"=$1 WHERE "id" = $2`)).
+			WithArgs(`{"cleaning_fee":40}`, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdatePricingRules(db), `{"date_prices": {}, "cleaning_fee": 40}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := update(routes.UpdatePricingRules(db), `{"stay_discounts": [{"min_nights": 1, "percent": 10}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+// expectAccommodationLock mocks the row lock taken before an accommodation's reviews change
+func expectAccommodationLock(mock sqlmock.Sqlmock, accommodationID int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "accommodations" WHERE "accommodations"."id" = $1 ORDER BY "accommodations"."id" LIMIT $2 FOR UPDATE`)).
+		WithArgs(accommodationID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(accommodationID))
+}
+
+// expectRatingRecalculation mocks averaging the reviews into aggregate and storing it on the accommodation
+func expectRatingRecalculation(mock sqlmock.Sqlmock, accommodationID int, aggregate routes.RatingAggregate) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS review_count, (.+) FROM "reviews" WHERE \(accommodation_id = \$1 AND moderation_status = \$2\) AND "reviews"."deleted_at" IS NULL`).
+		WithArgs(accommodationID, models.ReviewStatusApproved).
+		WillReturnRows(sqlmock.NewRows([]string{"review_count", "rating", "cleanliness_rating", "location_rating", "value_rating"}).
+			AddRow(aggregate.ReviewCount, aggregate.Rating, aggregate.CleanlinessRating, aggregate.LocationRating, aggregate.ValueRating))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "cleanliness_rating"=$1,"location_rating"=$2,"rating"=$3,"review_count"=$4,"value_rating"=$5 WHERE id = $6`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), aggregate.ReviewCount, sqlmock.AnyArg(), accommodationID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestRecalculateAccommodationRating(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS review_count, (.+) FROM "reviews" WHERE \(accommodation_id = \$1 AND moderation_status = \$2\) AND "reviews"."deleted_at" IS NULL`).
+		WithArgs(3, models.ReviewStatusApproved).
+		WillReturnRows(sqlmock.NewRows([]string{"review_count", "rating", "cleanliness_rating", "location_rating", "value_rating"}).
+			AddRow(3, 4.333333, 5, 0, 3.5))
+	// Averages are stored to two decimal places; no location ratings leaves it at 0
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "cleanliness_rating"=$1,"location_rating"=$2,"rating"=$3,"review_count"=$4,"value_rating"=$5 WHERE id = $6`)).
+		WithArgs(5.0, 0.0, 4.33, 3, 3.5, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	aggregate, err := routes.RecalculateAccommodationRating(3, db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), aggregate.ReviewCount)
+	assert.Equal(t, 4.33, aggregate.Rating)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecalculateAllRatings(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "accommodations" ORDER BY id`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	for _, id := range []int{1, 2} {
+		mock.ExpectBegin()
+		expectAccommodationLock(mock, id)
+		expectRatingRecalculation(mock, id, routes.RatingAggregate{ReviewCount: 2, Rating: 4})
+		mock.ExpectCommit()
+	}
+
+	updated, err := routes.RecalculateAllRatings(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddReviewUpdatesSubRatings(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "johndoe"))
+	expectReviewableStay(mock, 1, 1, 9)
+	expectRecentReviews(mock, 1)
+	mock.ExpectBegin()
+	expectAccommodationLock(mock, 1)
+	mock.ExpectQuery(`INSERT INTO "reviews" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(1, 1, "johndoe", 4.0, sqlmock.AnyArg(), "Spotless", 9, true, 5.0, 0.0, 3.0, 0, nil, models.ReviewStatusApproved, "", sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectRatingRecalculation(mock, 1, routes.RatingAggregate{ReviewCount: 1, Rating: 4, CleanlinessRating: 5, ValueRating: 3})
+	mock.ExpectCommit()
+
+	rr := serveAddReview(t, db, `{"Rating": 4, "Comment": "Spotless", "CleanlinessRating": 5, "ValueRating": 3}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddReviewRejectsBadSubRatings(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "johndoe"))
+
+	rr := serveAddReview(t, db, `{"Rating": 4, "Comment": "Nice", "CleanlinessRating": 7}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// serveAddReview posts body as a review of accommodation 1 by user 1
+func serveAddReview(t *testing.T, db *gorm.DB, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/accommodations/1/reviews", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = addSessionToRequest(req, 1)
+
+	rr := httptest.NewRecorder()
+	routes.AddReview(db).ServeHTTP(rr, req)
+	return rr
+}
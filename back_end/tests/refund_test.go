@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestCalculateRefund(t *testing.T) {
+	checkin := time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC)
+	custom := models.RefundTiers{
+		{DaysBefore: 3, RefundPercent: 40},
+		{DaysBefore: 30, RefundPercent: 90},
+	}
+
+	tests := []struct {
+		name            string
+		policy          string
+		tiers           models.RefundTiers
+		cancelledAt     time.Time
+		expectedPercent int
+		expectedRefund  uint
+	}{
+		{"Flexible two days out", models.CancellationPolicyFlexible, nil, checkin.AddDate(0, 0, -2), 100, 1000},
+		{"Flexible on the day", models.CancellationPolicyFlexible, nil, checkin.Add(-6 * time.Hour), 0, 0},
+		{"No policy behaves as flexible", "", nil, checkin.AddDate(0, 0, -1), 100, 1000},
+		{"Moderate five days out", models.CancellationPolicyModerate, nil, checkin.AddDate(0, 0, -5), 100, 1000},
+		{"Moderate just under five days out", models.CancellationPolicyModerate, nil, checkin.AddDate(0, 0, -5).Add(time.Minute), 50, 500},
+		{"Moderate on the day", models.CancellationPolicyModerate, nil, checkin.Add(-time.Hour), 0, 0},
+		{"Strict three weeks out", models.CancellationPolicyStrict, nil, checkin.AddDate(0, 0, -21), 100, 1000},
+		{"Strict ten days out", models.CancellationPolicyStrict, nil, checkin.AddDate(0, 0, -10), 50, 500},
+		{"Strict three days out", models.CancellationPolicyStrict, nil, checkin.AddDate(0, 0, -3), 0, 0},
+		{"Custom tiers are matched regardless of order", models.CancellationPolicyCustom, custom, checkin.AddDate(0, 0, -45), 90, 900},
+		{"Custom middle tier", models.CancellationPolicyCustom, custom, checkin.AddDate(0, 0, -10), 40, 400},
+		{"Custom below every tier", models.CancellationPolicyCustom, custom, checkin.AddDate(0, 0, -1), 0, 0},
+		{"After check-in", models.CancellationPolicyFlexible, nil, checkin.AddDate(0, 0, 1), 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			refund, percent := models.CalculateRefund(tc.policy, tc.tiers, 1000, checkin, tc.cancelledAt)
+			assert.Equal(t, tc.expectedPercent, percent)
+			assert.Equal(t, tc.expectedRefund, refund)
+		})
+	}
+}
+
+func TestValidateCancellationPolicy(t *testing.T) {
+	assert.NoError(t, models.ValidateCancellationPolicy("", nil))
+	assert.NoError(t, models.ValidateCancellationPolicy(models.CancellationPolicyStrict, nil))
+	assert.NoError(t, models.ValidateCancellationPolicy(models.CancellationPolicyCustom, models.RefundTiers{{DaysBefore: 7, RefundPercent: 100}}))
+
+	assert.Error(t, models.ValidateCancellationPolicy("lenient", nil))
+	assert.Error(t, models.ValidateCancellationPolicy(models.CancellationPolicyCustom, nil))
+	assert.Error(t, models.ValidateCancellationPolicy(models.CancellationPolicyCustom, models.RefundTiers{{DaysBefore: 7, RefundPercent: 120}}))
+	assert.Error(t, models.ValidateCancellationPolicy(models.CancellationPolicyCustom, models.RefundTiers{{DaysBefore: -1, RefundPercent: 50}}))
+	assert.Error(t, models.ValidateCancellationPolicy(models.CancellationPolicyCustom, models.RefundTiers{{DaysBefore: 7, RefundPercent: 50}, {DaysBefore: 7, RefundPercent: 80}}))
+}
+
+func TestQuoteEventBookingRefund(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	booking := models.EventBooking{ID: 4, TotalCost: 200, Status: models.BookingStatusConfirmed}
+
+	event := models.Event{Date: "2025-04-15", Time: "18:00", CancellationPolicy: models.CancellationPolicyModerate}
+	quote := routes.QuoteEventBookingRefund(booking, event, now)
+	assert.Equal(t, 100, quote.RefundPercent)
+	assert.Equal(t, uint(200), quote.RefundAmount)
+	assert.Equal(t, models.CancellationPolicyModerate, quote.CancellationPolicy)
+
+	// An unreadable date can't be held against the guest
+	event.Date = "next Friday"
+	quote = routes.QuoteEventBookingRefund(booking, event, now)
+	assert.Equal(t, uint(200), quote.RefundAmount)
+
+	// Pending bookings were never paid for
+	booking.Status = models.BookingStatusPending
+	quote = routes.QuoteEventBookingRefund(booking, event, now)
+	assert.Equal(t, uint(0), quote.RefundAmount)
+}
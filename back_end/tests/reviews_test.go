@@ -0,0 +1,425 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"roam.io/models"
+	"roam.io/moderation"
+	"roam.io/routes"
+)
+
+const stayQuery = `SELECT * FROM "bookings" WHERE user_id = $1 AND accommodation_id = $2 AND status IN ($3,$4) AND checkout_date <= $5 ORDER BY checkout_date desc`
+
+// expectStays mocks the user's finished stays at the accommodation and which of them have reviews
+func expectStays(mock sqlmock.Sqlmock, userID, accommodationID int, stays *sqlmock.Rows, reviewed *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta(stayQuery)).
+		WithArgs(userID, accommodationID, models.BookingStatusConfirmed, models.BookingStatusCompleted, sqlmock.AnyArg()).
+		WillReturnRows(stays)
+	if reviewed != nil {
+		// Deleted reviews count too, so no deleted_at filter
+		mock.ExpectQuery(`SELECT "booking_id" FROM "reviews" WHERE booking_id IN \((.+)\)$`).
+			WillReturnRows(reviewed)
+	}
+}
+
+// expectReviewableStay mocks a stay that checked out yesterday and hasn't been reviewed
+func expectReviewableStay(mock sqlmock.Sqlmock, userID, accommodationID, bookingID int) {
+	expectStays(mock, userID, accommodationID,
+		sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkout_date", "status"}).
+			AddRow(bookingID, userID, accommodationID, time.Now().AddDate(0, 0, -1), models.BookingStatusCompleted),
+		sqlmock.NewRows([]string{"booking_id"}))
+}
+
+func TestFindReviewableBooking(t *testing.T) {
+	now := time.Now()
+	stayColumns := []string{"id", "user_id", "accommodation_id", "checkout_date", "status"}
+
+	t.Run("Never stayed", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectStays(mock, 1, 2, sqlmock.NewRows(stayColumns), nil)
+
+		_, err := routes.FindReviewableBooking(1, 2, now, db)
+		assert.ErrorIs(t, err, routes.ErrNoCompletedStay)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Most recent unreviewed stay is picked", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectStays(mock, 1, 2,
+			sqlmock.NewRows(stayColumns).
+				AddRow(8, 1, 2, now.AddDate(0, 0, -2), models.BookingStatusCompleted).
+				AddRow(5, 1, 2, now.AddDate(0, 0, -5), models.BookingStatusConfirmed),
+			sqlmock.NewRows([]string{"booking_id"}).AddRow(8))
+
+		booking, err := routes.FindReviewableBooking(1, 2, now, db)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(5), booking.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Every stay reviewed", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectStays(mock, 1, 2,
+			sqlmock.NewRows(stayColumns).AddRow(8, 1, 2, now.AddDate(0, 0, -2), models.BookingStatusCompleted),
+			sqlmock.NewRows([]string{"booking_id"}).AddRow(8))
+
+		_, err := routes.FindReviewableBooking(1, 2, now, db)
+		assert.ErrorIs(t, err, routes.ErrStayAlreadyReviewed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Review window closed", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectStays(mock, 1, 2,
+			sqlmock.NewRows(stayColumns).AddRow(8, 1, 2, now.Add(-routes.ReviewWindow-time.Hour), models.BookingStatusCompleted),
+			sqlmock.NewRows([]string{"booking_id"}))
+
+		_, err := routes.FindReviewableBooking(1, 2, now, db)
+		assert.ErrorIs(t, err, routes.ErrReviewWindowClosed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Last day of the window", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectStays(mock, 1, 2,
+			sqlmock.NewRows(stayColumns).AddRow(8, 1, 2, now.Add(-routes.ReviewWindow+time.Hour), models.BookingStatusCompleted),
+			sqlmock.NewRows([]string{"booking_id"}))
+
+		booking, err := routes.FindReviewableBooking(1, 2, now, db)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(8), booking.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddReviewWithoutStay(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "johndoe"))
+	expectStays(mock, 1, 1, sqlmock.NewRows([]string{"id"}), nil)
+
+	rr := serveAddReview(t, db, `{"Rating": 4, "Comment": "Never been, looks nice"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectReviewLookup mocks loading review 4, written by user 1 about accommodation 3
+func expectReviewLookup(mock sqlmock.Sqlmock) {
+	expectReviewLookupWithStatus(mock, models.ReviewStatusApproved)
+}
+
+// expectReviewLookupWithStatus mocks loading review 4 in the given moderation status
+func expectReviewLookupWithStatus(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE "reviews"."id" = $1 AND "reviews"."deleted_at" IS NULL ORDER BY "reviews"."id" LIMIT $2`)).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "user_name", "rating", "comment", "cleanliness_rating", "moderation_status"}).
+			AddRow(4, 1, 3, "johndoe", 4.0, "Lovely cabin", 5.0, status))
+}
+
+// serveReviewRequest sends body to handler for review 4 as userID
+func serveReviewRequest(handler func(*gorm.DB) http.HandlerFunc, db *gorm.DB, method, body string, userID uint) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/reviews/4", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	req = addSessionToRequest(req, userID)
+
+	rr := httptest.NewRecorder()
+	handler(db).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUpdateReview(t *testing.T) {
+	t.Run("Author edits and the old version is kept", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		expectRecentReviews(mock, 1)
+		mock.ExpectBegin()
+		expectAccommodationLock(mock, 3)
+		mock.ExpectQuery(`INSERT INTO "review_revisions" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(4, 1, models.ReviewRevisionEdited, 4.0, 5.0, 0.0, 0.0, "Lovely cabin", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE "reviews" SET (.+) WHERE "reviews"."deleted_at" IS NULL AND "id" = \$7`).
+			WithArgs(2.0, "Lovely cabin, noisy road", 5.0, 0.0, 0.0, sqlmock.AnyArg(), 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRatingRecalculation(mock, 3, routes.RatingAggregate{ReviewCount: 1, Rating: 2, CleanlinessRating: 5})
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.UpdateReview, db, "PATCH", `{"Rating": 2, "Comment": "Lovely cabin, noisy road"}`, 1)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var review models.Review
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+		assert.Equal(t, 2.0, review.Rating)
+		assert.NotNil(t, review.EditedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reworded comment is held for a moderator", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		expectRecentReviews(mock, 1)
+		mock.ExpectBegin()
+		expectAccommodationLock(mock, 3)
+		mock.ExpectQuery(`INSERT INTO "review_revisions" (.+) VALUES (.+) RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE "reviews" SET (.+),"moderation_status"=\$7,"moderation_reason"=\$8 WHERE "reviews"."deleted_at" IS NULL AND "id" = \$9`).
+			WithArgs(4.0, "Book direct at www.example.com", 5.0, 0.0, 0.0, sqlmock.AnyArg(), models.ReviewStatusPending, moderation.ReasonLinks, 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRatingRecalculation(mock, 3, routes.RatingAggregate{})
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.UpdateReview, db, "PATCH", `{"Comment": "Book direct at www.example.com"}`, 1)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Hidden review stays hidden after an edit", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookupWithStatus(mock, models.ReviewStatusHidden)
+		mock.ExpectBegin()
+		expectAccommodationLock(mock, 3)
+		mock.ExpectQuery(`INSERT INTO "review_revisions" (.+) VALUES (.+) RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE "reviews" SET (.+) WHERE "reviews"."deleted_at" IS NULL AND "id" = \$7`).
+			WithArgs(4.0, "Book direct at www.example.com", 5.0, 0.0, 0.0, sqlmock.AnyArg(), 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRatingRecalculation(mock, 3, routes.RatingAggregate{})
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.UpdateReview, db, "PATCH", `{"Comment": "Book direct at www.example.com"}`, 1)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var review models.Review
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+		assert.Equal(t, models.ReviewStatusHidden, review.ModerationStatus)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Only the author can edit", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+
+		rr := serveReviewRequest(routes.UpdateReview, db, "PATCH", `{"Rating": 1}`, 2)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Edited rating is still validated", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+
+		rr := serveReviewRequest(routes.UpdateReview, db, "PATCH", `{"Rating": 9}`, 1)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteReview(t *testing.T) {
+	db, mock := setupTestDB(t)
+	expectReviewLookup(mock)
+	mock.ExpectBegin()
+	expectAccommodationLock(mock, 3)
+	mock.ExpectQuery(`INSERT INTO "review_revisions" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(4, 1, models.ReviewRevisionDeleted, 4.0, 5.0, 0.0, 0.0, "Lovely cabin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "review_responses" WHERE review_id = $1`)).
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The review is only soft deleted, so its stay stays reviewed
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "deleted_at"=$1 WHERE "reviews"."id" = $2 AND "reviews"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRatingRecalculation(mock, 3, routes.RatingAggregate{})
+	mock.ExpectCommit()
+
+	rr := serveReviewRequest(routes.DeleteReview, db, "DELETE", "", 1)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectReviewOwner mocks matching user 42 to the owner of accommodation 3 by email
+func expectReviewOwner(mock sqlmock.Sqlmock, userEmail string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(42, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(42, userEmail))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(3, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "hosts" WHERE "hosts"."id" = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "owner@example.com"))
+}
+
+func TestRespondToReview(t *testing.T) {
+	countResponses := regexp.QuoteMeta(`SELECT count(*) FROM "review_responses" WHERE review_id = $1`)
+
+	t.Run("Owner replies", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		expectReviewOwner(mock, "owner@example.com")
+		mock.ExpectQuery(countResponses).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "review_responses" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(4, "Thanks for staying!", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.RespondToReview, db, "POST", `{"Comment": " Thanks for staying! "}`, 42)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Only one reply per review", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		expectReviewOwner(mock, "owner@example.com")
+		mock.ExpectQuery(countResponses).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		rr := serveReviewRequest(routes.RespondToReview, db, "POST", `{"Comment": "Thanks again"}`, 42)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Guests can't reply as the owner", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectReviewLookup(mock)
+		expectReviewOwner(mock, "guest@example.com")
+
+		rr := serveReviewRequest(routes.RespondToReview, db, "POST", `{"Comment": "I agree"}`, 42)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// serveReviewListing lists the reviews of accommodation 3 with the given query string
+func serveReviewListing(db *gorm.DB, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/accommodations/3/reviews?"+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+
+	rr := httptest.NewRecorder()
+	routes.ListAccommodationReviews(db).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestListAccommodationReviews(t *testing.T) {
+	t.Run("Second page of five star reviews, most helpful first", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+			WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews" WHERE (accommodation_id = $1 AND moderation_status = $2) AND CAST(FLOOR(rating + 0.5) AS integer) IN ($3,$4) AND "reviews"."deleted_at" IS NULL`)).
+			WithArgs(3, models.ReviewStatusApproved, 4, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE (accommodation_id = $1 AND moderation_status = $2) AND CAST(FLOOR(rating + 0.5) AS integer) IN ($3,$4) AND "reviews"."deleted_at" IS NULL ORDER BY helpful_count desc, id desc LIMIT $5 OFFSET $6`)).
+			WithArgs(3, models.ReviewStatusApproved, 4, 5, 2, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "rating", "comment"}).AddRow(7, 3, 5.0, "Perfect"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "media" WHERE "media"."review_id" = $1`)).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "review_id"}).AddRow(12, 7))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "review_responses" WHERE "review_responses"."review_id" = $1`)).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "review_id"}))
+		mock.ExpectQuery(`SELECT CAST\(FLOOR\(rating \+ 0.5\) AS integer\) AS stars, COUNT\(\*\) AS count FROM "reviews" (.+) GROUP BY "stars"`).
+			WithArgs(3, models.ReviewStatusApproved).
+			WillReturnRows(sqlmock.NewRows([]string{"stars", "count"}).AddRow(5, 2).AddRow(4, 1).AddRow(2, 1))
+
+		rr := serveReviewListing(db, "page=2&page_size=2&sort=helpful&stars=4,5")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var page routes.ReviewPage
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Reviews, 1)
+		assert.Equal(t, "http://localhost:8080/media/12/thumbnail", page.Reviews[0].Photos[0].ThumbnailURL)
+		assert.Equal(t, int64(3), page.Total)
+		assert.Equal(t, int64(4), page.Summary.ReviewCount)
+		assert.Equal(t, int64(1), page.Summary.Histogram[2])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Bad parameters are rejected", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		for _, query := range []string{"page=0", "page_size=abc", "sort=oldest", "stars=6"} {
+			rr := serveReviewListing(db, query)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// expectPublishedReview mocks loading published review 4 by user 1
+func expectPublishedReview(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE moderation_status = $1 AND "reviews"."id" = $2 AND "reviews"."deleted_at" IS NULL ORDER BY "reviews"."id" LIMIT $3`)).
+		WithArgs(models.ReviewStatusApproved, 4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "helpful_count"}).AddRow(4, 1, 3, 2))
+}
+
+func TestVoteReviewHelpful(t *testing.T) {
+	deleteVote := regexp.QuoteMeta(`DELETE FROM "review_votes" WHERE review_id = $1 AND user_id = $2`)
+	countVote := func(mock sqlmock.Sqlmock, change string, count int) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "helpful_count"=helpful_count ` + change + ` WHERE id = $1`)).
+			WithArgs(4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "helpful_count" FROM "reviews" WHERE id = $1`)).
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"helpful_count"}).AddRow(count))
+	}
+
+	t.Run("First vote counts", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectPublishedReview(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteVote).WithArgs(4, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`INSERT INTO "review_votes" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(4, 2, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		countVote(mock, "+ 1", 3)
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.VoteReviewHelpful, db, "POST", "", 2)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result routes.HelpfulVoteResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, routes.HelpfulVoteResult{ReviewID: 4, HelpfulCount: 3, Voted: true}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Voting again takes the vote back", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectPublishedReview(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteVote).WithArgs(4, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		countVote(mock, "- 1", 1)
+		mock.ExpectCommit()
+
+		rr := serveReviewRequest(routes.VoteReviewHelpful, db, "POST", "", 2)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result routes.HelpfulVoteResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.False(t, result.Voted)
+		assert.Equal(t, uint(1), result.HelpfulCount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Authors cannot vote on their own review", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectPublishedReview(mock)
+
+		rr := serveReviewRequest(routes.VoteReviewHelpful, db, "POST", "", 1)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
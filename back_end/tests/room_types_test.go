@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestIsRoomTypeFull(t *testing.T) {
+	doubles := models.RoomType{ID: 3, AccommodationID: 1, Name: "Double", Quantity: 2}
+	roomType := uint(3)
+	now := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		bookings [][2]time.Time
+		wholeAcc bool // one of the bookings is of the whole accommodation
+		blocked  bool
+		full     bool
+	}{
+		{"No bookings", nil, false, false, false},
+		{"One room left", [][2]time.Time{{day(2030, 6, 10), day(2030, 6, 14)}}, false, false, false},
+		{"Back-to-back bookings share a room", [][2]time.Time{{day(2030, 6, 9), day(2030, 6, 12)}, {day(2030, 6, 12), day(2030, 6, 16)}}, false, false, false},
+		{"Every room taken one night", [][2]time.Time{{day(2030, 6, 9), day(2030, 6, 12)}, {day(2030, 6, 11), day(2030, 6, 13)}}, false, false, true},
+		{"Overlap outside the stay", [][2]time.Time{{day(2030, 6, 5), day(2030, 6, 11)}, {day(2030, 6, 6), day(2030, 6, 11)}}, false, false, true},
+		{"Whole accommodation booked", [][2]time.Time{{day(2030, 6, 12), day(2030, 6, 13)}}, true, false, true},
+		{"Dates blocked", nil, false, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			rows := sqlmock.NewRows([]string{"room_type_id", "checkin_date", "checkout_date"})
+			for _, booking := range tc.bookings {
+				if tc.wholeAcc {
+					rows.AddRow(nil, booking[0], booking[1])
+				} else {
+					rows.AddRow(roomType, booking[0], booking[1])
+				}
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT "room_type_id","checkin_date","checkout_date" FROM "bookings" WHERE (accommodation_id = $1 AND (room_type_id = $2 OR room_type_id IS NULL) AND status IN ($3,$4) AND checkin_date < $5 AND checkout_date > $6) AND (expires_at IS NULL OR expires_at > $7)`)).
+				WithArgs(1, 3, models.BookingStatusPending, models.BookingStatusConfirmed, day(2030, 6, 14), day(2030, 6, 10), now).
+				WillReturnRows(rows)
+			if !tc.full || tc.blocked {
+				count := 0
+				if tc.blocked {
+					count = 1
+				}
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+			}
+
+			full, err := routes.IsRoomTypeFull(doubles, day(2030, 6, 10), day(2030, 6, 14), now, db)
+			require.NoError(t, err)
+			assert.Equal(t, tc.full, full)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAddBookingRoomType(t *testing.T) {
+	book := func(handler http.Handler, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/add-booking?payment_token=tok_visa&accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-12&"+query, nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	expectAccommodation := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "booking_mode", "price_per_night", "pricing_rules"}).
+				AddRow(1, "Harbour Hotel", models.BookingModeInstant, 500, `{"seasons": [{"name": "Spring", "start": "2099-03-01", "end": "2099-03-31", "price_per_night": 900}]}`))
+	}
+	expectRoomType := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "room_types" WHERE accommodation_id = $1 AND "room_types"."id" = $2 ORDER BY "room_types"."id" LIMIT $3`)).
+			WithArgs(1, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "name", "quantity", "max_occupancy", "price_per_night"}).
+				AddRow(3, 1, "Double", 5, 2, 120))
+	}
+
+	t.Run("Booked at the room's price", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectAccommodation(mock)
+		expectRoomType(mock)
+		expectBookingLock(mock, 1)
+		mock.ExpectQuery(`SELECT "room_type_id","checkin_date","checkout_date" FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"room_type_id", "checkin_date", "checkout_date"}).
+				AddRow(3, day(2099, 3, 10), day(2099, 3, 12)))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(1, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), 2, 432, models.BookingStatusPending, nil, 0, nil, 3, 2, 0, 0, 0,
+				sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		expectPaymentCharge(mock, "bookings")
+
+		// The spring season is 80% over the listing's price, so the room's nights are too
+		rr := book(routes.AddBooking(db), "room_type_id=3&guests=2&total_cost=432")
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("More guests than the room takes", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectAccommodation(mock)
+		expectRoomType(mock)
+
+		rr := book(routes.AddBooking(db), "room_type_id=3&guests=3")
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), models.StayErrorTooManyGuests)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Room type required", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectAccommodation(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "room_types" WHERE accommodation_id = $1`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+		rr := book(routes.AddBooking(db), "guests=2")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Room type of another accommodation", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectAccommodation(mock)
+		mock.ExpectQuery(`SELECT \* FROM "room_types"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		rr := book(routes.AddBooking(db), "room_type_id=3&guests=2")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOwnerRoomTypes(t *testing.T) {
+	request := func(method, path, body string, vars map[string]string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = mux.SetURLVars(req, vars)
+		return addSessionToRequest(req, 42)
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "room_types" ("accommodation_id","name","description","quantity","max_occupancy","price_per_night","facilities","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).
+			WithArgs(7, "Double", "", 5, 2, 120.0, "{\"Sea view\"}", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.AddRoomType(db).ServeHTTP(rr, request("POST", "/owner/accommodations/7/room-types",
+			`{"Name": " Double ", "Quantity": 5, "MaxOccupancy": 2, "PricePerNight": 120, "Facilities": ["Sea view"]}`, map[string]string{"id": "7"}))
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No rooms", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+
+		rr := httptest.NewRecorder()
+		routes.AddRoomType(db).ServeHTTP(rr, request("POST", "/owner/accommodations/7/room-types",
+			`{"Name": "Double", "Quantity": 0, "MaxOccupancy": 2, "PricePerNight": 120}`, map[string]string{"id": "7"}))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Can't delete a room type with upcoming bookings", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectQuery(`SELECT \* FROM "room_types"`).
+			WithArgs(7, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "name"}).AddRow(3, 7, "Double"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "bookings" WHERE room_type_id = $1 AND status IN ($2,$3) AND checkout_date > $4`)).
+			WithArgs(3, models.BookingStatusPending, models.BookingStatusConfirmed, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		rr := httptest.NewRecorder()
+		routes.DeleteRoomType(db).ServeHTTP(rr, request("DELETE", "/owner/accommodations/7/room-types/3", "",
+			map[string]string{"id": "7", "roomTypeId": "3"}))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListRoomTypes(t *testing.T) {
+	list := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/accommodations/7/room-types", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Cheapest first", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "accommodations" WHERE "accommodations"."id" = $1`)).
+			WithArgs(7, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "room_types" WHERE accommodation_id = $1 ORDER BY price_per_night, id`)).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "accommodation_id", "name"}).AddRow(3, 7, "Double"))
+
+		rr := list(routes.ListRoomTypes(db))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Double")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Accommodation not found", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(`SELECT "id" FROM "accommodations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		rr := list(routes.ListRoomTypes(db))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
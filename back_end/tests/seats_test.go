@@ -0,0 +1,310 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/jobs"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestSeatStatus(t *testing.T) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	booking := uint(9)
+	later, earlier := now.Add(time.Minute), now.Add(-time.Minute)
+
+	testCases := []struct {
+		name     string
+		seat     models.Seat
+		expected string
+	}{
+		{"Free", models.Seat{}, models.SeatStatusAvailable},
+		{"Held", models.Seat{HeldUntil: &later}, models.SeatStatusHeld},
+		{"Hold ran out", models.Seat{HeldUntil: &earlier}, models.SeatStatusAvailable},
+		{"Hold runs out now", models.Seat{HeldUntil: &now}, models.SeatStatusAvailable},
+		{"Booked", models.Seat{EventBookingID: &booking}, models.SeatStatusBooked},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.seat.Status(now))
+		})
+	}
+}
+
+func TestUpdateSeatMap(t *testing.T) {
+	update := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/events/2/seat-map", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "2"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	expectSeatMapDelete := func(mock sqlmock.Sqlmock, total, deleted int) {
+		mock.ExpectBegin()
+		expectEventLock(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "seats" WHERE event_id = $1`)).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "seats" WHERE event_id = $1 AND event_booking_id IS NULL AND (held_until IS NULL OR held_until <= $2)`)).
+			WithArgs(2, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, int64(deleted)))
+	}
+
+	t.Run("Seats are saved", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		expectSeatMapDelete(mock, 4, 4)
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "seats" ("event_id","section","row","label","wheelchair","companion","event_booking_id","held_by","held_until") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9),($10,$11,$12,$13,$14,$15,$16,$17,$18) RETURNING "id"`)).
+			WithArgs(2, "Stalls", "A", "1", true, false, nil, nil, nil,
+				2, "Stalls", "A", "2", false, true, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "reserved_seating"=$1,"updated_at"=$2 WHERE "id" = $3`)).
+			WithArgs(true, sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdateSeatMap(db), `{"Sections": [{"Name": "Stalls", "Rows": [{"Name": "A", "Seats": [
+			{"Label": "1", "Wheelchair": true}, {"Label": "2", "Companion": true}]}]}]}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Seat listed twice", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+
+		rr := update(routes.UpdateSeatMap(db), `{"Sections": [{"Name": "Stalls", "Rows": [{"Name": "A", "Seats": [{"Label": "1"}, {"Label": " 1"}]}]}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("More seats than the event has", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		seats := strings.Repeat(`{"Label": "x"},`, 10)
+
+		rr := update(routes.UpdateSeatMap(db), `{"Sections": [{"Name": "Stalls", "Rows": [{"Name": "A", "Seats": [`+seats+`{"Label": "11"}]}]}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Seats already booked", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		expectSeatMapDelete(mock, 4, 1)
+		mock.ExpectRollback()
+
+		rr := update(routes.UpdateSeatMap(db), `{"Sections": []}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// expectSeatHold mocks holding seats 4 and 5 of event 1 for user 1, affecting rows of them,
+// after the other seats the user held are released
+func expectSeatHold(mock sqlmock.Sqlmock, rows int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "seats" SET "held_by"=$1,"held_until"=$2 WHERE event_id = $3 AND held_by = $4 AND event_booking_id IS NULL AND id NOT IN ($5,$6)`)).
+		WithArgs(nil, nil, 1, 1, 4, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "seats" SET "held_by"=$1,"held_until"=$2 WHERE (event_id = $3 AND id IN ($4,$5) AND event_booking_id IS NULL) AND (held_by IS NULL OR held_by = $6 OR held_until <= $7)`)).
+		WithArgs(1, sqlmock.AnyArg(), 1, 4, 5, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+	if rows == 2 {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+}
+
+func TestHoldEventSeats(t *testing.T) {
+	hold := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/events/1/seats/hold", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	expectEvent := func(mock sqlmock.Sqlmock, status string, reservedSeating bool) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "events" WHERE "events"."id" = $1`)).
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "reserved_seating"}).AddRow(1, status, reservedSeating))
+	}
+
+	t.Run("Seats held", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock, models.EventStatusActive, true)
+		expectSeatHold(mock, 2)
+
+		rr := hold(routes.HoldEventSeats(db), `{"SeatIDs": [4, 5, 4]}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Someone else holds a seat", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock, models.EventStatusActive, true)
+		expectSeatHold(mock, 1)
+
+		rr := hold(routes.HoldEventSeats(db), `{"SeatIDs": [4, 5]}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Event cancelled", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock, models.EventStatusCancelled, true)
+
+		rr := hold(routes.HoldEventSeats(db), `{"SeatIDs": [4, 5]}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No seat map", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock, models.EventStatusActive, false)
+
+		rr := hold(routes.HoldEventSeats(db), `{"SeatIDs": [4, 5]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListEventSeatsInvalidID(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	req := httptest.NewRequest("GET", "/events/x/seats", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1 OR 1=1"})
+	rr := httptest.NewRecorder()
+	routes.ListEventSeats(db).ServeHTTP(rr, req)
+
+	// The id never reaches the database
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddEventBookingSeats(t *testing.T) {
+	expectEvent := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "available_seats", "total_seats", "status", "reserved_seating"}).
+				AddRow(1, "Play", "2099-06-15", 50, 100, models.EventStatusActive, true))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "ticket_tiers"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	serve := func(handler http.Handler, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/events?payment_token=tok_visa&event_id=1&guests=2&total_cost=60&"+query, nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Seats go to the booking", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		expectSeatHold(mock, 2)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "event_bookings" (.+) VALUES (.+) RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "seats" SET "event_booking_id"=$1,"held_by"=$2,"held_until"=$3 WHERE event_id = $4 AND id IN ($5,$6) AND held_by = $7 AND event_booking_id IS NULL`)).
+			WithArgs(1, nil, nil, 1, 4, 5, 1).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`UPDATE "events" SET "available_seats"`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectPaymentCharge(mock, "event_bookings")
+
+		rr := serve(routes.AddEventBooking(db), "seat_ids=4,5")
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Seat taken by someone else", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		expectSeatHold(mock, 1)
+
+		rr := serve(routes.AddEventBooking(db), "seat_ids=4,5")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Held seats are released when the event sells out", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "available_seats", "total_seats", "status", "reserved_seating"}).
+				AddRow(1, "Play", "2099-06-15", 1, 100, models.EventStatusActive, true))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "ticket_tiers"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		expectSeatHold(mock, 2)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "seats" SET "held_by"=$1,"held_until"=$2 WHERE event_id = $3 AND held_by = $4 AND event_booking_id IS NULL`)).
+			WithArgs(nil, nil, 1, 1).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		rr := serve(routes.AddEventBooking(db), "seat_ids=4,5")
+		assert.NotEqual(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Tier for anywhere can't take another tier's seats", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "available_seats", "total_seats", "status", "reserved_seating"}).
+				AddRow(1, "Play", "2099-06-15", 50, 100, models.EventStatusActive, true))
+		mock.ExpectQuery(`SELECT \* FROM "ticket_tiers" WHERE event_id = \$1 AND id = \$2`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "name", "section", "price", "capacity"}).
+				AddRow(2, 1, "General admission", "", 30, 80))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "seats" WHERE (event_id = $1 AND id IN ($2,$3)) AND section IN (SELECT "section" FROM "ticket_tiers" WHERE event_id = $4 AND section <> '')`)).
+			WithArgs(1, 4, 5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		rr := serve(routes.AddEventBooking(db), "seat_ids=4,5&tier_id=2")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for _, seats := range []string{"", "4", "4,4", "4,five"} {
+		t.Run("One seat per guest: "+seats, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			expectEvent(mock)
+
+			rr := serve(routes.AddEventBooking(db), "seat_ids="+seats)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReleaseExpiredSeatHolds(t *testing.T) {
+	db, mock := setupTestDB(t)
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "seats" SET "held_by"=$1,"held_until"=$2 WHERE event_booking_id IS NULL AND held_until <= $3`)).
+		WithArgs(nil, nil, now).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	require.NoError(t, jobs.ReleaseExpiredSeatHolds(db, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestAgeOn(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data unavailable")
+	}
+	auckland, _ := time.LoadLocation("Pacific/Auckland")
+
+	testCases := []struct {
+		name     string
+		dob      time.Time
+		on       time.Time
+		expected int
+	}{
+		{"Day before the birthday", day(2000, 6, 15), day(2021, 6, 14), 20},
+		{"On the birthday", day(2000, 6, 15), day(2021, 6, 15), 21},
+		{"Later in the birthday month", day(2000, 6, 15), day(2021, 6, 30), 21},
+		{"Earlier month, later day", day(2000, 6, 15), day(2021, 5, 31), 20},
+		{"Leap day birthday on 28 February", day(2000, 2, 29), day(2021, 2, 28), 20},
+		{"Leap day birthday on 1 March", day(2000, 2, 29), day(2021, 3, 1), 21},
+		{"Leap day birthday in a leap year", day(2000, 2, 29), day(2024, 2, 29), 24},
+		// The database may hand back the stored midnight UTC in the server's zone, the day before
+		{"Birth date read in another zone", day(2000, 6, 15).In(newYork), day(2021, 6, 15), 21},
+		// The event's local date counts, though it's still the day before in UTC
+		{"Event date ahead of UTC", day(2000, 6, 15), time.Date(2021, 6, 15, 8, 0, 0, 0, auckland), 21},
+		{"Event date behind UTC", day(2000, 6, 15), time.Date(2021, 6, 14, 22, 0, 0, 0, newYork), 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, models.AgeOn(tc.dob, tc.on))
+		})
+	}
+}
+
+func TestCheckMinimumAge(t *testing.T) {
+	assert.NoError(t, models.CheckMinimumAge(0, day(2020, 1, 1), day(2021, 1, 1)), "no minimum")
+	assert.NoError(t, models.CheckMinimumAge(21, day(2000, 1, 1), day(2021, 1, 1)))
+	var stayErr *models.StayError
+	assert.ErrorAs(t, models.CheckMinimumAge(21, day(2000, 1, 2), day(2021, 1, 1)), &stayErr)
+	assert.Equal(t, models.StayErrorUnderage, stayErr.Code)
+	assert.Error(t, models.GuestPolicy{MinimumAge: 100}.Validate())
+}
+
+func TestAddBookingMinimumAge(t *testing.T) {
+	expectBooker := func(mock sqlmock.Sqlmock, dob time.Time) {
+		mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "booking_mode", "price_per_night", "minimum_age"}).
+				AddRow(1, models.BookingModeInstant, 100, 25))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "dob" FROM "users" WHERE "users"."id" = $1 ORDER BY "users"."id" LIMIT $2`)).
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"dob"}).AddRow(dob))
+	}
+	book := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/add-booking?payment_token=tok_visa&accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-12&guests=2", nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Turns 25 on check-in", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectBooker(mock, day(2074, 3, 10))
+		expectNoRoomTypes(mock)
+		expectBookingLock(mock, 1)
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		expectPaymentCharge(mock, "bookings")
+
+		rr := book(routes.AddBooking(db))
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Turns 25 the day after check-in", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectBooker(mock, day(2074, 3, 11))
+
+		rr := book(routes.AddBooking(db))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), models.StayErrorUnderage)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddEventBookingMinimumAge(t *testing.T) {
+	testCases := []struct {
+		name   string
+		dob    time.Time
+		status int
+	}{
+		// Seats are sold out so an allowed booking stops at the seat check
+		{"Turns 21 on the event date", day(2009, 6, 15), http.StatusInternalServerError},
+		{"Turns 21 the day after", day(2009, 6, 16), http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			mock.ExpectQuery(`SELECT (.+) FROM "event_bookings"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "time", "available_seats", "status", "minimum_age"}).
+					AddRow(1, "Late show", "2030-06-15", "23:30", 0, models.EventStatusActive, 21))
+			mock.ExpectQuery(`SELECT "dob" FROM "users"`).
+				WithArgs(1, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"dob"}).AddRow(tc.dob))
+
+			req := httptest.NewRequest("POST", "/events/book?payment_token=tok_visa&event_id=1&total_cost=10&guests=1", nil)
+			req = addSessionToRequest(req, 1)
+			rr := httptest.NewRecorder()
+			routes.AddEventBooking(db).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code, rr.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
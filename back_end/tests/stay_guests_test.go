@@ -0,0 +1,242 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/models"
+	"roam.io/pricing"
+	"roam.io/routes"
+)
+
+func TestCheckGuestPolicy(t *testing.T) {
+	family := models.GuestComposition{Adults: 2, Children: 2, Infants: 1}
+
+	testCases := []struct {
+		name   string
+		policy models.GuestPolicy
+		guests models.GuestComposition
+		code   string // empty if the guests can stay
+	}{
+		{"No policy takes anyone but pets", models.GuestPolicy{}, family, ""},
+		{"Infants don't count towards occupancy", models.GuestPolicy{MaxGuests: 4}, family, ""},
+		{"Over the maximum", models.GuestPolicy{MaxGuests: 3}, family, models.StayErrorTooManyGuests},
+		{"Adults only", models.GuestPolicy{ChildPolicy: models.ChildPolicyAdultsOnly}, models.GuestComposition{Adults: 2, Infants: 1}, models.StayErrorChildren},
+		{"Adults only without children", models.GuestPolicy{ChildPolicy: models.ChildPolicyAdultsOnly}, models.GuestComposition{Adults: 2}, ""},
+		{"No infants", models.GuestPolicy{ChildPolicy: models.ChildPolicyNoInfants}, family, models.StayErrorChildren},
+		{"No infants takes children", models.GuestPolicy{ChildPolicy: models.ChildPolicyNoInfants}, models.GuestComposition{Adults: 1, Children: 3}, ""},
+		{"Pets not allowed", models.GuestPolicy{}, models.GuestComposition{Adults: 1, Pets: 1}, models.StayErrorPets},
+		{"Pets allowed", models.GuestPolicy{PetsAllowed: true}, models.GuestComposition{Adults: 1, Pets: 3}, ""},
+		{"Too many pets", models.GuestPolicy{PetsAllowed: true, MaxPets: 2}, models.GuestComposition{Adults: 1, Pets: 3}, models.StayErrorPets},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.CheckGuests(tc.guests)
+			if tc.code == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var stayErr *models.StayError
+			if assert.True(t, errors.As(err, &stayErr), "expected a StayError, got %v", err) {
+				assert.Equal(t, tc.code, stayErr.Code)
+			}
+		})
+	}
+}
+
+func TestValidateGuests(t *testing.T) {
+	assert.NoError(t, models.GuestComposition{Adults: 1}.Validate())
+	assert.Error(t, models.GuestComposition{Children: 2}.Validate(), "no adults")
+	assert.Error(t, models.GuestComposition{Adults: 10000}.Validate(), "more than any booking")
+	assert.Error(t, models.GuestComposition{Adults: 40, Children: 10, Infants: 1}.Validate(), "infants count towards the booking cap")
+
+	assert.NoError(t, models.GuestPolicy{MaxGuests: 6, ChildPolicy: models.ChildPolicyWelcome, PetsAllowed: true, MaxPets: 2}.Validate())
+	assert.Error(t, models.GuestPolicy{ChildPolicy: "teens"}.Validate())
+	assert.Error(t, models.GuestPolicy{MaxPets: 2}.Validate(), "max pets without pets")
+}
+
+func TestQuoteParty(t *testing.T) {
+	rules := pricing.Rules{IncludedGuests: 2, ExtraGuestFee: 20, ChildDiscountPercent: 50, PetFee: 15, StayDiscounts: []pricing.StayDiscount{{MinNights: 2, Percent: 10}}}
+
+	testCases := []struct {
+		name     string
+		party    pricing.Party
+		extraFee float64 // a night
+		petFee   float64
+		total    float64
+	}{
+		{"Included guests", pricing.Party{Adults: 2}, 0, 0, 180},
+		{"Infants stay free", pricing.Party{Adults: 2, Infants: 2}, 0, 0, 180},
+		{"Extra child pays the child rate", pricing.Party{Adults: 2, Children: 1}, 10, 0, 198},
+		{"Adults take the included places first", pricing.Party{Adults: 1, Children: 2}, 10, 0, 198},
+		{"Extra adult and child", pricing.Party{Adults: 3, Children: 1}, 30, 0, 234},
+		{"Pet fee isn't discounted", pricing.Party{Adults: 2, Pets: 2}, 0, 30, 210},
+		{"No one counts as an adult", pricing.Party{}, 0, 0, 180},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quote, err := rules.QuoteParty(100, day(2030, 6, 3), day(2030, 6, 5), tc.party)
+			require.NoError(t, err)
+			assert.Equal(t, tc.extraFee, quote.Nights[0].ExtraGuestFee)
+			assert.Equal(t, tc.petFee, quote.PetFee)
+			assert.Equal(t, tc.total, quote.Total)
+		})
+	}
+}
+
+func TestAddBookingGuests(t *testing.T) {
+	book := func(handler http.Handler, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/add-booking?payment_token=tok_visa&accommodation_id=1&check_in_date=2099-03-10&check_out_date=2099-03-12&"+query, nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	expectAccommodation := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT (.+) FROM "bookings" WHERE user_id = ?`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "accommodations" WHERE "accommodations"."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "booking_mode", "price_per_night", "pricing_rules", "max_guests", "child_policy", "pets_allowed", "max_pets"}).
+				AddRow(1, models.BookingModeInstant, 100, `{"pet_fee": 25}`, 4, models.ChildPolicyWelcome, true, 1))
+	}
+
+	t.Run("Guests are saved and priced", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectAccommodation(mock)
+		expectNoRoomTypes(mock)
+		expectBookingLock(mock, 1)
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "blocked_date_ranges"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "bookings" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(1, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), 3, 225, models.BookingStatusPending, nil, 0, nil, nil, 2, 1, 1, 1,
+				sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		expectPaymentCharge(mock, "bookings")
+
+		rr := book(routes.AddBooking(db), "adults=2&children=1&infants=1&pets=1")
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		status int
+		code   string
+	}{
+		{"More guests than the accommodation takes", "adults=3&children=2", http.StatusUnprocessableEntity, models.StayErrorTooManyGuests},
+		{"Too many pets", "adults=2&pets=2", http.StatusUnprocessableEntity, models.StayErrorPets},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			expectAccommodation(mock)
+
+			rr := book(routes.AddBooking(db), tc.query)
+			assert.Equal(t, tc.status, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Body.String(), tc.code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{"No adults", "adults=0&children=2"},
+		{"Ten thousand guests", "guests=10000"},
+		{"Unreadable guests", "guests=lots"},
+		{"Negative children", "adults=2&children=-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+
+			rr := book(routes.AddBooking(db), tc.query)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Body.String(), models.StayErrorInvalidGuests)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSearchAccommodationsForGuests(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE location = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id", "price_per_night", "max_guests", "child_policy", "pets_allowed"}).
+			AddRow(1, "Studio", 1, 80, 2, "", false).
+			AddRow(2, "Farmhouse", 1, 200, 8, models.ChildPolicyWelcome, true).
+			AddRow(3, "Retreat", 1, 150, 0, models.ChildPolicyAdultsOnly, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "hosts" WHERE "hosts"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Owner"))
+
+	req := httptest.NewRequest("GET", "/accommodations?location=Lisbon&check_in_date=2099-06-01&check_out_date=2099-06-03&adults=2&children=1&pets=1", nil)
+	rr := httptest.NewRecorder()
+	routes.FetchAccommodations(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var results []models.Accommodation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "Farmhouse", results[0].Name)
+	assert.Equal(t, 3, results[0].PriceQuote.Guests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateGuestPolicy(t *testing.T) {
+	update := func(handler http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/owner/accommodations/7/guest-policy", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "7"})
+		req = addSessionToRequest(req, 42)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Policy is saved", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "accommodations" SET "max_guests"=$1,"child_policy"=$2,"pets_allowed"=$3,"max_pets"=$4,"minimum_age"=$5 WHERE "id" = $6`)).
+			WithArgs(6, models.ChildPolicyNoInfants, true, 2, 21, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := update(routes.UpdateGuestPolicy(db), `{"MaxGuests": 6, "ChildPolicy": "no_infants", "PetsAllowed": true, "MaxPets": 2, "MinimumAge": 21}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid policy", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectOwnedAccommodation(mock, "owner@example.com")
+		rr := update(routes.UpdateGuestPolicy(db), `{"ChildPolicy": "teens"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddEventBookingGuests(t *testing.T) {
+	for _, guests := range []string{"0", "51", "-2"} {
+		db, mock := setupTestDB(t)
+		req := httptest.NewRequest("POST", "/events/book?payment_token=tok_visa&event_id=1&total_cost=10&guests="+guests, nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		routes.AddEventBooking(db).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "guests=%s", guests)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
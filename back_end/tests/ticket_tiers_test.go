@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestTicketTierCheckSale(t *testing.T) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	testCases := []struct {
+		name     string
+		tier     models.TicketTier
+		guests   uint
+		expected error
+	}{
+		{"On sale", models.TicketTier{Capacity: 10, Sold: 4}, 2, nil},
+		{"Last tickets", models.TicketTier{Capacity: 10, Sold: 8}, 2, nil},
+		{"Too few left", models.TicketTier{Capacity: 10, Sold: 9}, 2, models.ErrTierSoldOut},
+		{"Inside the sale window", models.TicketTier{Capacity: 10, SalesStart: &before, SalesEnd: &after}, 1, nil},
+		{"Sale not started", models.TicketTier{Capacity: 10, SalesStart: &after}, 1, models.ErrTierNotOnSale},
+		{"Early bird ended", models.TicketTier{Capacity: 10, SalesEnd: &before}, 1, models.ErrTierNotOnSale},
+		{"Sale ends the moment it's checked", models.TicketTier{Capacity: 10, SalesEnd: &now}, 1, models.ErrTierNotOnSale},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.tier.CheckSale(tc.guests, now))
+		})
+	}
+}
+
+func TestValidateTicketTier(t *testing.T) {
+	start := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 7)
+
+	tier := models.TicketTier{Name: " VIP ", Price: 120, Capacity: 20}
+	assert.NoError(t, tier.Validate())
+	assert.Equal(t, "VIP", tier.Name)
+	assert.NoError(t, (&models.TicketTier{Name: "Free entry", Capacity: 20}).Validate(), "free tiers are allowed")
+	assert.Error(t, (&models.TicketTier{Name: "VIP", Capacity: 0}).Validate())
+	assert.Error(t, (&models.TicketTier{Name: "VIP", Capacity: 5, Sold: 6}).Validate(), "capacity below tickets sold")
+	assert.Error(t, (&models.TicketTier{Name: "Early Bird", Capacity: 5, SalesStart: &end, SalesEnd: &start}).Validate())
+}
+
+func TestAddEventBookingTier(t *testing.T) {
+	expectEvent := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "event_bookings" WHERE user_id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "date", "available_seats", "total_seats", "status"}).
+				AddRow(1, "Concert", "2099-06-15", 50, 100, models.EventStatusActive))
+	}
+	expectTier := func(mock sqlmock.Sqlmock, salesEnd *time.Time) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ticket_tiers" WHERE event_id = $1 AND id = $2 ORDER BY "ticket_tiers"."id" LIMIT $3`)).
+			WithArgs(1, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "name", "price", "capacity", "sold", "sales_end"}).
+				AddRow(3, 1, "Early Bird", 40, 10, 6, salesEnd))
+	}
+	expectReserve := func(mock sqlmock.Sqlmock, rows int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ticket_tiers" SET "sold"=sold + $1 WHERE id = $2 AND sold + $3 <= capacity`)).
+			WithArgs(2, 3, 2).
+			WillReturnResult(sqlmock.NewResult(0, rows))
+		mock.ExpectCommit()
+	}
+	serve := func(handler http.Handler, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/events?payment_token=tok_visa&event_id=1&guests=2&"+query, nil)
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Booked at the tier's price", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		expectTier(mock, nil)
+		expectReserve(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "event_bookings" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(1, 1, 2, 80, models.BookingStatusPending, 0, nil, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "events" SET "available_seats"=available_seats - $1,"updated_at"=$2 WHERE id = $3 AND available_seats >= $4`)).
+			WithArgs(2, sqlmock.AnyArg(), 1, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectPaymentCharge(mock, "event_bookings")
+
+		rr := serve(routes.AddEventBooking(db), "tier_id=3&total_cost=80")
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Total doesn't match the tier's price", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		expectTier(mock, nil)
+
+		rr := serve(routes.AddEventBooking(db), "tier_id=3&total_cost=60")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), "80")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Early bird sale has ended", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		ended := time.Now().Add(-time.Hour)
+		expectTier(mock, &ended)
+
+		rr := serve(routes.AddEventBooking(db), "tier_id=3&total_cost=80")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), models.ErrTierNotOnSale.Error())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Sold out by a concurrent booking", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		expectTier(mock, nil)
+		expectReserve(mock, 0)
+
+		rr := serve(routes.AddEventBooking(db), "tier_id=3&total_cost=80")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), models.ErrTierSoldOut.Error())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Tier id isn't a number", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+
+		rr := serve(routes.AddEventBooking(db), "tier_id=3)%20OR%20(1=1&total_cost=80")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Tier required", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEvent(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "ticket_tiers" WHERE event_id = $1`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+
+		rr := serve(routes.AddEventBooking(db), "total_cost=80")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrganizerTicketTiers(t *testing.T) {
+	request := func(method, path, body string, vars map[string]string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = mux.SetURLVars(req, vars)
+		return addSessionToRequest(req, 42)
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ticket_tiers" ("event_id","name","description","section","price","capacity","sold","sales_start","sales_end","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`)).
+			WithArgs(2, "Early Bird", "", "", 40, 100, 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.AddTicketTier(db).ServeHTTP(rr, request("POST", "/events/2/ticket-tiers",
+			`{"Name": "Early Bird", "Price": 40, "Capacity": 100, "SalesEnd": "2030-05-01T00:00:00Z"}`, map[string]string{"id": "2"}))
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Not the organizer", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "someone@example.com")
+
+		rr := httptest.NewRecorder()
+		routes.AddTicketTier(db).ServeHTTP(rr, request("POST", "/events/2/ticket-tiers",
+			`{"Name": "VIP", "Price": 120, "Capacity": 20}`, map[string]string{"id": "2"}))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Capacity cut below tickets sold meanwhile", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		mock.ExpectQuery(`SELECT \* FROM "ticket_tiers"`).
+			WithArgs(2, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "name", "capacity", "sold"}).AddRow(3, 2, "VIP", 20, 5))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ticket_tiers" SET "name"=$1,"description"=$2,"section"=$3,"price"=$4,"capacity"=$5,"sales_start"=$6,"sales_end"=$7 WHERE sold <= $8 AND "id" = $9`)).
+			WithArgs("VIP", "", "", 120, 10, nil, nil, 10, 3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.UpdateTicketTier(db).ServeHTTP(rr, request("PUT", "/events/2/ticket-tiers/3",
+			`{"Name": "VIP", "Price": 120, "Capacity": 10}`, map[string]string{"id": "2", "tierId": "3"}))
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ticket sold before the delete", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		mock.ExpectQuery(`SELECT \* FROM "ticket_tiers"`).
+			WithArgs(2, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "name", "capacity", "sold"}).AddRow(3, 2, "VIP", 20, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "ticket_tiers" WHERE sold = 0 AND "ticket_tiers"."id" = $1`)).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		routes.DeleteTicketTier(db).ServeHTTP(rr, request("DELETE", "/events/2/ticket-tiers/3", "",
+			map[string]string{"id": "2", "tierId": "3"}))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Can't delete a tier with tickets sold", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		expectEventOrganizer(mock, models.EventStatusActive, "organizer@example.com")
+		mock.ExpectQuery(`SELECT \* FROM "ticket_tiers"`).
+			WithArgs(2, 3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "name", "capacity", "sold"}).AddRow(3, 2, "VIP", 20, 1))
+
+		rr := httptest.NewRecorder()
+		routes.DeleteTicketTier(db).ServeHTTP(rr, request("DELETE", "/events/2/ticket-tiers/3", "",
+			map[string]string{"id": "2", "tierId": "3"}))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListTicketTiersInvalidID(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	req := httptest.NewRequest("GET", "/events/x/ticket-tiers", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1 OR 1=1"})
+	rr := httptest.NewRecorder()
+	routes.ListTicketTiers(db).ServeHTTP(rr, req)
+
+	// The id never reaches the database
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
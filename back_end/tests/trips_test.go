@@ -0,0 +1,381 @@
+package routes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func date(value string) time.Time {
+	t, _ := time.Parse("2006-01-02", value)
+	return t
+}
+
+func tripStay(id uint, name, checkIn, checkOut string) routes.TripStay {
+	return routes.TripStay{
+		Booking:       models.Booking{ID: id, AccommodationID: id, CheckinDate: date(checkIn), CheckoutDate: date(checkOut), Status: models.BookingStatusConfirmed},
+		Accommodation: models.Accommodation{ID: id, Name: name},
+	}
+}
+
+func tripEvent(id uint, name, day, at string) routes.TripEvent {
+	return routes.TripEvent{
+		Booking: models.EventBooking{ID: id, EventId: id, Status: models.BookingStatusConfirmed},
+		Event:   models.Event{ID: id, EventName: name, Date: day, Time: at},
+	}
+}
+
+func warningCodes(itinerary routes.Itinerary) []string {
+	codes := []string{}
+	for _, warning := range itinerary.Warnings {
+		codes = append(codes, warning.Code)
+	}
+	return codes
+}
+
+func TestBuildItinerary(t *testing.T) {
+	trip := models.Trip{ID: 1, StartDate: date("2025-06-01"), EndDate: date("2025-06-04")}
+
+	t.Run("Merges stays and events by day", func(t *testing.T) {
+		itinerary := routes.BuildItinerary(trip,
+			[]routes.TripStay{tripStay(1, "Lake House", "2025-06-01", "2025-06-03"), tripStay(2, "City Flat", "2025-06-03", "2025-06-04")},
+			[]routes.TripEvent{tripEvent(7, "Jazz Night", "2025-06-03", "20:00"), tripEvent(8, "Market", "2025-06-03", "09:00")})
+
+		assert.Empty(t, itinerary.Warnings)
+		require.Len(t, itinerary.Days, 4)
+		assert.Equal(t, "2025-06-01", itinerary.Days[0].Date)
+
+		var day3 []string
+		for _, item := range itinerary.Days[2].Items {
+			day3 = append(day3, item.Kind+" "+item.Title)
+		}
+		assert.Equal(t, []string{"event Market", "check_out Lake House", "check_in City Flat", "event Jazz Night"}, day3)
+		assert.Equal(t, routes.ItineraryStay, itinerary.Days[1].Items[0].Kind)
+	})
+
+	tests := []struct {
+		name     string
+		stays    []routes.TripStay
+		events   []routes.TripEvent
+		expected []string
+	}{
+		{
+			name:     "Event without a stay",
+			stays:    []routes.TripStay{tripStay(1, "Lake House", "2025-06-01", "2025-06-02")},
+			events:   []routes.TripEvent{tripEvent(7, "Jazz Night", "2025-06-04", "20:00")},
+			expected: []string{routes.WarningEventOutsideStay},
+		},
+		{
+			name:  "Overlapping events",
+			stays: []routes.TripStay{tripStay(1, "Lake House", "2025-06-01", "2025-06-04")},
+			events: []routes.TripEvent{
+				tripEvent(7, "Jazz Night", "2025-06-02", "20:00"),
+				tripEvent(8, "Comedy Show", "2025-06-02", "21:30"),
+				tripEvent(9, "Late Show", "2025-06-02", "23:30"),
+			},
+			expected: []string{routes.WarningEventOverlap, routes.WarningEventOverlap},
+		},
+		{
+			name:     "Overlapping stays",
+			stays:    []routes.TripStay{tripStay(1, "Lake House", "2025-06-01", "2025-06-03"), tripStay(2, "City Flat", "2025-06-02", "2025-06-04")},
+			expected: []string{routes.WarningStayOverlap},
+		},
+		{
+			name:     "Outside the trip dates",
+			stays:    []routes.TripStay{tripStay(1, "Lake House", "2025-06-03", "2025-06-06")},
+			events:   []routes.TripEvent{tripEvent(7, "Jazz Night", "2025-05-30", "")},
+			expected: []string{routes.WarningOutsideTrip, routes.WarningOutsideTrip},
+		},
+		{
+			name:     "Unreadable event date",
+			events:   []routes.TripEvent{tripEvent(7, "Jazz Night", "soon", "")},
+			expected: []string{routes.WarningUnscheduledEvent},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, warningCodes(routes.BuildItinerary(trip, tc.stays, tc.events)))
+		})
+	}
+
+	t.Run("Skips cancelled bookings and stretches to bookings outside the trip", func(t *testing.T) {
+		cancelled := tripEvent(7, "Jazz Night", "2025-06-02", "20:00")
+		cancelled.Booking.Status = models.BookingStatusCancelled
+		itinerary := routes.BuildItinerary(trip,
+			[]routes.TripStay{tripStay(1, "Lake House", "2025-06-04", "2025-06-06")},
+			[]routes.TripEvent{cancelled})
+
+		require.Len(t, itinerary.Days, 6)
+		assert.Equal(t, "2025-06-06", itinerary.Days[5].Date)
+		assert.Empty(t, itinerary.Days[1].Items)
+		assert.Equal(t, []string{routes.WarningOutsideTrip}, warningCodes(itinerary))
+	})
+}
+
+func TestCreateTrip(t *testing.T) {
+	create := func(body string, expect func(sqlmock.Sqlmock)) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		if expect != nil {
+			expect(mock)
+		}
+		req := httptest.NewRequest("POST", "/trips", strings.NewReader(body))
+		req = addSessionToRequest(req, 1)
+		rr := httptest.NewRecorder()
+		routes.CreateTrip(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	rr := create(`{"Destination": "Lisbon", "StartDate": "2025-06-01", "EndDate": "2025-06-05"}`, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "trips" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(1, "Lisbon", "Lisbon", date("2025-06-01"), date("2025-06-05"), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectCommit()
+	})
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var trip models.Trip
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &trip))
+	assert.Equal(t, uint(3), trip.ID)
+
+	assert.Equal(t, http.StatusBadRequest, create(`{"Destination": "Lisbon", "StartDate": "2025-06-05", "EndDate": "2025-06-01"}`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"StartDate": "2025-06-01", "EndDate": "2025-06-05"}`, nil).Code)
+}
+
+// expectTripRole expects the trip to be loaded for a user who isn't its owner, with their role on it
+func expectTripRole(mock sqlmock.Sqlmock, userID uint, role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "role" FROM "trip_members" WHERE trip_id = $1 AND user_id = $2 AND accepted_at IS NOT NULL LIMIT $3`)).
+		WithArgs(3, userID, 1).
+		WillReturnRows(rows)
+}
+
+func TestGetTripItinerary(t *testing.T) {
+	get := func(userID uint, role string, expect func(sqlmock.Sqlmock)) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trips" WHERE "trips"."id" = $1`)).
+			WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "start_date", "end_date"}).
+				AddRow(3, 1, date("2025-06-01"), date("2025-06-02")))
+		if userID != 1 {
+			expectTripRole(mock, userID, role)
+		}
+		if expect != nil {
+			expect(mock)
+		}
+		req := httptest.NewRequest("GET", "/trips/3/itinerary", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "3"})
+		req = addSessionToRequest(req, userID)
+		rr := httptest.NewRecorder()
+		routes.GetTripItinerary(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	// Trips the user hasn't joined are hidden
+	assert.Equal(t, http.StatusNotFound, get(2, "", nil).Code)
+
+	// Members see everyone's bookings
+	rr := get(2, models.TripRoleViewer, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bookings" WHERE trip_id = $1`)).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkin_date", "checkout_date", "status"}).
+				AddRow(4, 1, 9, date("2025-06-01"), date("2025-06-02"), models.BookingStatusConfirmed))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "event_bookings" WHERE trip_id = $1`)).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE id IN ($1)`)).
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(9, "Lake House"))
+	})
+	require.Equal(t, http.StatusOK, rr.Code)
+	var itinerary routes.Itinerary
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &itinerary))
+	require.Len(t, itinerary.Days, 2)
+	assert.Equal(t, "Lake House", itinerary.Days[0].Items[0].Title)
+	assert.Equal(t, uint(1), itinerary.Days[0].Items[0].UserID)
+	assert.Equal(t, routes.ItineraryCheckOut, itinerary.Days[1].Items[0].Kind)
+}
+
+func TestAddTripBooking(t *testing.T) {
+	add := func(userID uint, role string, rowsAffected int64) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trips" WHERE "trips"."id" = $1`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(3, 1))
+		if userID != 1 {
+			expectTripRole(mock, userID, role)
+		}
+		if rowsAffected >= 0 {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "event_bookings" SET "trip_id"=$1,"updated_at"=$2 WHERE id = $3 AND user_id = $4`)).
+				WithArgs(3, sqlmock.AnyArg(), 5, userID).
+				WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+			mock.ExpectCommit()
+		}
+
+		req := httptest.NewRequest("POST", "/trips/3/event-bookings", strings.NewReader(`{"BookingID": 5}`))
+		req = mux.SetURLVars(req, map[string]string{"id": "3"})
+		req = addSessionToRequest(req, userID)
+		rr := httptest.NewRecorder()
+		routes.AddTripEventBooking(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, add(1, "", 1).Code)
+	// Someone else's booking matches no rows
+	assert.Equal(t, http.StatusNotFound, add(1, "", 0).Code)
+	// Editors add their own bookings; viewers can't add any
+	assert.Equal(t, http.StatusOK, add(2, models.TripRoleEditor, 1).Code)
+	assert.Equal(t, http.StatusForbidden, add(2, models.TripRoleViewer, -1).Code)
+}
+
+// withoutToken matches messages that don't carry an invitation token
+type withoutToken struct{}
+
+func (withoutToken) Match(v driver.Value) bool {
+	message, ok := v.(string)
+	return ok && !regexp.MustCompile(`[0-9a-f]{64}`).MatchString(message)
+}
+
+func TestInviteTripMember(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trips" WHERE "trips"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "destination"}).AddRow(3, 1, "Summer", "Lisbon"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE LOWER(email) = $1 LIMIT $2`)).
+		WithArgs("ana@example.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "Ana@example.com"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "trip_members" WHERE trip_id = $1 AND email = $2 AND accepted_at IS NULL AND created_at <= $3`)).
+		WithArgs(3, "ana@example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "trip_members" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(3, "ana@example.com", nil, models.TripRoleEditor, sqlmock.AnyArg(), 1, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+	// The token is handed to the owner only, never put in the invitee's notification
+	mock.ExpectQuery(`INSERT INTO "notifications" (.+) VALUES (.+) RETURNING "id"`).
+		WithArgs(2, "trip_invitation", "Trip invitation: Summer", withoutToken{}, models.NotificationStatusQueued, sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("POST", "/trips/3/invitations", strings.NewReader(`{"Email": " Ana@Example.com ", "Role": "editor"}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.InviteTripMember(db).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var invitation routes.TripInvitation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &invitation))
+	assert.Len(t, invitation.Token, 64)
+	assert.Equal(t, uint(6), invitation.Member.ID)
+	assert.NotContains(t, rr.Body.String(), "TokenHash")
+}
+
+func TestAcceptTripInvitation(t *testing.T) {
+	accept := func(email string, invitedAt time.Time, acceptedAt interface{}, expectUpdate bool, rowsAffected int64) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trip_members" WHERE token_hash = $1`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "trip_id", "email", "role", "accepted_at", "created_at"}).
+				AddRow(6, 3, "ana@example.com", models.TripRoleEditor, acceptedAt, invitedAt))
+		if acceptedAt == nil && time.Since(invitedAt) < routes.TripInvitationTTL {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, email))
+		}
+		if expectUpdate {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "trip_members" SET "accepted_at"=$1,"user_id"=$2 WHERE id = $3 AND accepted_at IS NULL AND created_at > $4`)).
+				WithArgs(sqlmock.AnyArg(), 2, 6, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+			mock.ExpectCommit()
+		}
+
+		req := httptest.NewRequest("POST", "/trips/invitations/abc/accept", nil)
+		req = mux.SetURLVars(req, map[string]string{"token": "abc"})
+		req = addSessionToRequest(req, 2)
+		rr := httptest.NewRecorder()
+		routes.AcceptTripInvitation(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, accept("Ana@example.com", time.Now(), nil, true, 1).Code)
+	// Invitations only work for the address they were sent to
+	assert.Equal(t, http.StatusForbidden, accept("eve@example.com", time.Now(), nil, false, 0).Code)
+	assert.Equal(t, http.StatusConflict, accept("ana@example.com", time.Now(), time.Now(), false, 0).Code)
+	assert.Equal(t, http.StatusGone, accept("ana@example.com", time.Now().Add(-routes.TripInvitationTTL-time.Hour), nil, false, 0).Code)
+	// Accepted or revoked by another request after it was read
+	assert.Equal(t, http.StatusConflict, accept("ana@example.com", time.Now(), nil, true, 0).Code)
+}
+
+func TestRemoveTripMember(t *testing.T) {
+	remove := func(userID uint, role string, memberUserID uint, expectDelete bool) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trips" WHERE "trips"."id" = $1`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(3, 1))
+		if userID != 1 {
+			expectTripRole(mock, userID, role)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trip_members" WHERE trip_id = $1 AND "trip_members"."id" = $2`)).
+			WithArgs(3, 6, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "trip_id", "user_id"}).AddRow(6, 3, memberUserID))
+		if expectDelete {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bookings" SET "trip_id"=$1,"updated_at"=$2 WHERE trip_id = $3 AND user_id = $4`)).
+				WithArgs(nil, sqlmock.AnyArg(), 3, memberUserID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "event_bookings" SET "trip_id"=$1,"updated_at"=$2 WHERE trip_id = $3 AND user_id = $4`)).
+				WithArgs(nil, sqlmock.AnyArg(), 3, memberUserID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "trip_members" WHERE "trip_members"."id" = $1`)).
+				WithArgs(6).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		req := httptest.NewRequest("DELETE", "/trips/3/members/6", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "3", "memberId": "6"})
+		req = addSessionToRequest(req, userID)
+		rr := httptest.NewRecorder()
+		routes.RemoveTripMember(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	// The owner revokes; members can leave but not remove others
+	assert.Equal(t, http.StatusOK, remove(1, "", 2, true).Code)
+	assert.Equal(t, http.StatusOK, remove(2, models.TripRoleViewer, 2, true).Code)
+	assert.Equal(t, http.StatusForbidden, remove(4, models.TripRoleEditor, 2, false).Code)
+}
+
+func TestRemoveTripBookingInvalidID(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "trips" WHERE "trips"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(3, 1))
+
+	req := httptest.NewRequest("DELETE", "/trips/3/bookings/x", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "3", "bookingId": "1 OR 1=1"})
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.RemoveTripBooking(db).ServeHTTP(rr, req)
+
+	// The booking id never reaches the database
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
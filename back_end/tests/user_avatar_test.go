@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/media"
+	"roam.io/routes"
+)
+
+func expectAvatarUser(mock sqlmock.Sqlmock, avatarID, avatarKey string) {
+	mock.ExpectQuery(`SELECT (.+) FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "avatar_id", "avatar_key"}).AddRow(1, avatarID, avatarKey))
+}
+
+func TestUpdateUserAvatar_UnknownPreset(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	req := httptest.NewRequest("PUT", "/users/avatar", strings.NewReader(`{"avatar_id": "Godzilla"}`))
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.UpdateUserAvatarHandler(db).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUploadUserAvatar(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	routes.SetMediaStore(store)
+	require.NoError(t, store.Put("avatars/1/old/128.png", bytes.NewReader([]byte("old"))))
+
+	db, mock := setupTestDB(t)
+	expectAvatarUser(mock, "uploaded", "avatars/1/old")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "avatar_id"=$1,"avatar_key"=$2 WHERE "id" = $3`)).
+		WithArgs("uploaded", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "me.png")
+	require.NoError(t, png.Encode(part, testImage(400, 300)))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/users/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.UploadUserAvatarHandler(db).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.True(t, strings.HasPrefix(resp["avatar_url"], "http://localhost:8080/users/1/avatar?v="))
+	prefix := "avatars/1/" + strings.TrimPrefix(resp["avatar_url"], "http://localhost:8080/users/1/avatar?v=")
+
+	// Every size is a cropped square
+	for _, size := range []int{64, 128, 256} {
+		blob, err := store.Open(fmt.Sprintf("%s/%d.png", prefix, size))
+		require.NoError(t, err)
+		config, _, err := image.DecodeConfig(blob)
+		blob.Close()
+		require.NoError(t, err)
+		assert.Equal(t, size, config.Width)
+		assert.Equal(t, size, config.Height)
+	}
+
+	// The replaced avatar is removed
+	_, err := store.Open("avatars/1/old/128.png")
+	assert.ErrorIs(t, err, media.ErrBlobNotFound)
+}
+
+func TestGetUserAvatar(t *testing.T) {
+	store := media.NewLocalStore(t.TempDir())
+	routes.SetMediaStore(store)
+	routes.SetWebBaseURL("https://roam.io/")
+	defer routes.SetWebBaseURL("http://localhost:5173")
+	require.NoError(t, store.Put("avatars/1/abc/64.png", bytes.NewReader([]byte("small"))))
+
+	get := func(target, avatarID, avatarKey string) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		if avatarID != "" {
+			expectAvatarUser(mock, avatarID, avatarKey)
+		}
+		req := httptest.NewRequest("GET", target, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		rr := httptest.NewRecorder()
+		routes.GetUserAvatarHandler(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	rr := get("/users/1/avatar?size=64", "uploaded", "avatars/1/abc")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "small", rr.Body.String())
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+
+	rr = get("/users/1/avatar", "Pip", "")
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "https://roam.io/avatars/Pip.png", rr.Header().Get("Location"))
+
+	// A missing image falls back to the default preset
+	rr = get("/users/1/avatar?size=256", "uploaded", "avatars/1/abc")
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "https://roam.io/avatars/Marshmallow.png", rr.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusBadRequest, get("/users/1/avatar?size=100", "", "").Code)
+}
@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"roam.io/ical"
+	"roam.io/models"
+	"roam.io/routes"
+)
+
+func TestMarshalCalendar(t *testing.T) {
+	stamp := time.Date(2025, 5, 1, 9, 30, 0, 0, time.UTC)
+	out := string(ical.Marshal(ical.Calendar{Name: "Trips", Events: []ical.Event{
+		{
+			UID:         "booking-1@roam.io",
+			Summary:     "Stay at Rose; Thistle, Edinburgh",
+			Description: strings.Repeat("A long description é ", 10) + "\nSecond line",
+			Start:       time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			End:         time.Date(2025, 6, 4, 0, 0, 0, 0, time.UTC),
+			AllDay:      true,
+			Status:      ical.StatusCancelled,
+			Sequence:    2,
+			Stamp:       stamp,
+		},
+		{
+			UID:      "event-booking-2@roam.io",
+			Summary:  "Jazz Night",
+			Geo:      &ical.Geo{Lat: 41.40338, Lon: 2.17403},
+			Start:    time.Date(2025, 6, 2, 20, 0, 0, 0, time.UTC),
+			End:      time.Date(2025, 6, 2, 23, 0, 0, 0, time.UTC),
+			Floating: true,
+			Stamp:    stamp,
+		},
+	}}))
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75, line)
+	}
+
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	assert.Contains(t, unfolded, "SUMMARY:Stay at Rose\\; Thistle\\, Edinburgh\r\n")
+	assert.Contains(t, unfolded, "\\nSecond line\r\n")
+	assert.Contains(t, unfolded, "A long description é A long")
+	assert.Contains(t, unfolded, "DTSTART;VALUE=DATE:20250601\r\nDTEND;VALUE=DATE:20250604\r\n")
+	assert.Contains(t, unfolded, "STATUS:CANCELLED\r\nSEQUENCE:2\r\n")
+	assert.Contains(t, unfolded, "DTSTAMP:20250501T093000Z\r\n")
+	assert.Contains(t, unfolded, "DTSTART:20250602T200000\r\nDTEND:20250602T230000\r\n")
+	assert.Contains(t, unfolded, "GEO:41.403380;2.174030\r\n")
+}
+
+func TestParseGeo(t *testing.T) {
+	geo, ok := ical.ParseGeo("41.003, 32.002")
+	require.True(t, ok)
+	assert.Equal(t, ical.Geo{Lat: 41.003, Lon: 32.002}, *geo)
+
+	for _, coordinates := range []string{"", "Barcelona", "41.003", "91, 10", "10, 181"} {
+		_, ok := ical.ParseGeo(coordinates)
+		assert.False(t, ok, coordinates)
+	}
+}
+
+func TestGetEventBookingCalendar(t *testing.T) {
+	db, mock := setupTestDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "event_bookings" WHERE user_id = $1 AND "event_bookings"."id" = $2`)).
+		WithArgs(1, 4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "event_id", "guests", "status", "created_at", "updated_at"}).
+			AddRow(4, 1, 2, 3, models.BookingStatusConfirmed, time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC), time.Date(2025, 5, 1, 9, 0, 5, 0, time.UTC)))
+	// The organizer moved the event after it was booked
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "events" WHERE "events"."id" = $1`)).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_name", "location", "date", "time", "coordinates", "updated_at"}).
+			AddRow(2, "Jazz Night", "Blue Note, Main St", "2025-06-02", "20:00", "41.003, 32.002", time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)))
+
+	req := httptest.NewRequest("GET", "/events/bookings/4/calendar.ics", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.GetEventBookingCalendar(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, ical.ContentType, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "event-booking-4.ics")
+	body := rr.Body.String()
+	assert.Contains(t, body, "UID:event-booking-4@roam.io\r\n")
+	assert.Contains(t, body, "DTSTART:20250602T200000\r\nDTEND:20250602T230000\r\n")
+	assert.Contains(t, body, "LOCATION:Blue Note\\, Main St\r\n")
+	assert.Contains(t, body, "GEO:41.003000;32.002000\r\n")
+	assert.Contains(t, body, "STATUS:CONFIRMED\r\nSEQUENCE:3600\r\nLAST-MODIFIED:20250501T100000Z\r\n")
+}
+
+func TestUserCalendarFeed(t *testing.T) {
+	feed := func(token string, expect func(sqlmock.Sqlmock)) *httptest.ResponseRecorder {
+		db, mock := setupTestDB(t)
+		if expect != nil {
+			expect(mock)
+		}
+		req := httptest.NewRequest("GET", "/users/calendar.ics?token="+token, nil)
+		rr := httptest.NewRecorder()
+		routes.GetUserCalendarFeed(db).ServeHTTP(rr, req)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		return rr
+	}
+
+	assert.Equal(t, http.StatusNotFound, feed("", nil).Code)
+	assert.Equal(t, http.StatusNotFound, feed("stale", func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE calendar_token_hash = $1`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}).Code)
+
+	createdAt := time.Date(2025, 5, 10, 0, 0, 0, 0, time.UTC)
+	cancelledAt := time.Date(2025, 5, 20, 0, 0, 0, 0, time.UTC)
+	rr := feed("secret", func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE calendar_token_hash = $1`)).
+			WithArgs("2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bookings" WHERE user_id = $1 ORDER BY checkin_date`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "accommodation_id", "checkin_date", "checkout_date", "status", "created_at", "updated_at", "cancelled_at"}).
+				AddRow(7, 1, 9, date("2025-06-01"), date("2025-06-04"), models.BookingStatusCancelled, createdAt, cancelledAt, cancelledAt))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "event_bookings" WHERE user_id = $1 ORDER BY id`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "accommodations" WHERE id IN ($1)`)).
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(9, "Lake House"))
+	})
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Disposition"))
+	body := rr.Body.String()
+	assert.Contains(t, body, "X-WR-CALNAME:Roam.io trips\r\n")
+	assert.Contains(t, body, "SUMMARY:Stay at Lake House\r\n")
+	assert.Contains(t, body, "DTSTART;VALUE=DATE:20250601\r\nDTEND;VALUE=DATE:20250604\r\n")
+	// Ten days passed between booking and cancelling
+	assert.Contains(t, body, "STATUS:CANCELLED\r\nSEQUENCE:864000\r\nLAST-MODIFIED:20250520T000000Z\r\n")
+}
+
+func TestCreateCalendarFeed(t *testing.T) {
+	routes.SetAPIBaseURL("https://api.roam.io")
+	defer routes.SetAPIBaseURL("http://localhost:8080")
+
+	db, mock := setupTestDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "calendar_token_hash"=$1 WHERE id = $2`)).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("POST", "/users/calendar-feed", nil)
+	req = addSessionToRequest(req, 1)
+	rr := httptest.NewRecorder()
+	routes.CreateCalendarFeed(db).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	var resp routes.CalendarFeedResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Regexp(t, `^https://api\.roam\.io/users/calendar\.ics\?token=[0-9a-f]{64}$`, resp.URL)
+}